@@ -0,0 +1,205 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// candidates are Chromium based browsers supporting --app, in order of preference
+func candidates() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{
+			"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+			"/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge",
+			"/Applications/Chromium.app/Contents/MacOS/Chromium",
+			"/Applications/Brave Browser.app/Contents/MacOS/Brave Browser",
+		}
+	case "windows":
+		programFiles := os.Getenv("ProgramFiles")
+		programFilesX86 := os.Getenv("ProgramFiles(x86)")
+		localAppData := os.Getenv("LocalAppData")
+		return []string{
+			filepath.Join(programFiles, "Google", "Chrome", "Application", "chrome.exe"),
+			filepath.Join(programFilesX86, "Google", "Chrome", "Application", "chrome.exe"),
+			filepath.Join(localAppData, "Google", "Chrome", "Application", "chrome.exe"),
+			filepath.Join(programFilesX86, "Microsoft", "Edge", "Application", "msedge.exe"),
+			filepath.Join(programFiles, "Microsoft", "Edge", "Application", "msedge.exe"),
+		}
+	default:
+		return []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "microsoft-edge", "brave-browser"}
+	}
+}
+
+func findBrowser() (string, error) {
+	for _, c := range candidates() {
+		if filepath.IsAbs(c) {
+			if _, err := os.Stat(c); err == nil {
+				return c, nil
+			}
+			continue
+		}
+		if p, err := exec.LookPath(c); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("app mode requires Google Chrome, Microsoft Edge, Chromium or Brave")
+}
+
+// Run opens url in a chromeless app window and blocks until the window is closed.
+// A dedicated browser profile is used so the window runs in its own browser
+// process instead of becoming a tab of an already running browser.
+//
+// The window is followed through the DevTools endpoint of that browser
+// rather than by waiting for its process: on macOS the browser keeps
+// running without windows, and a launch while the profile is in use,
+// e.g. by a second --app, hands the window to the running browser and
+// exits at once.
+func Run(url string) error {
+	browser, err := findBrowser()
+	if err != nil {
+		return err
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return fmt.Errorf("failed to get user config dir: %v", err)
+	}
+	origin, err := originOf(url)
+	if err != nil {
+		return err
+	}
+	if err := waitForServer(origin); err != nil {
+		return err
+	}
+	profileDir := filepath.Join(configDir, "disk-usage-analyser", "app-profile")
+	if err := os.MkdirAll(profileDir, 0755); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(browser,
+		"--app="+url,
+		"--user-data-dir="+profileDir,
+		"--remote-debugging-port=0",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--window-size=1280,800",
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", browser, err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	portFile := filepath.Join(profileDir, "DevToolsActivePort")
+	handedOff := false
+	deadline := time.Now().Add(windowOpenTimeout)
+	for !windowOpen(portFile, origin) {
+		select {
+		case err := <-exited:
+			if err != nil {
+				return fmt.Errorf("app window exited: %v", err)
+			}
+			// the profile is in use, the running browser opens the window
+			handedOff = true
+			exited = nil
+		case <-time.After(pollInterval):
+		}
+		if time.Now().After(deadline) {
+			if handedOff {
+				return fmt.Errorf("the app profile %s is in use by a browser whose windows cannot be followed, close it and try again", profileDir)
+			}
+			return fmt.Errorf("app window did not open within %v", windowOpenTimeout)
+		}
+	}
+	// a listing that fails once, e.g. while the browser is busy, does not
+	// close the app
+	for misses := 0; misses < 2; {
+		select {
+		case err := <-exited:
+			if err != nil {
+				return fmt.Errorf("app window exited: %v", err)
+			}
+			exited = nil
+		case <-time.After(pollInterval):
+		}
+		if windowOpen(portFile, origin) {
+			misses = 0
+		} else {
+			misses++
+		}
+	}
+	return nil
+}
+
+const (
+	// windowOpenTimeout is how long the browser may take to open the window
+	windowOpenTimeout = 30 * time.Second
+	// pollInterval is how often the windows of the browser are listed
+	pollInterval = time.Second
+)
+
+// originOf returns the scheme and host of url
+func originOf(rawURL string) (string, error) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid app URL %s", rawURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// windowOpen reports whether the browser of the profile whose DevTools
+// port is written to portFile shows a page of origin. A browser that did
+// not start yet, or was not started with a DevTools port, shows none.
+func windowOpen(portFile string, origin string) bool {
+	data, err := os.ReadFile(portFile)
+	if err != nil {
+		return false
+	}
+	port, _, _ := strings.Cut(string(data), "\n")
+	if _, err := strconv.Atoi(strings.TrimSpace(port)); err != nil {
+		return false
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://127.0.0.1:" + strings.TrimSpace(port) + "/json/list")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var targets []struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return false
+	}
+	for _, t := range targets {
+		if t.Type == "page" && (t.URL == origin || strings.HasPrefix(t.URL, origin+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForServer polls the server's /ping until it answers
+func waitForServer(url string) error {
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get(url + "/ping")
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("server at %s did not start", url)
+}
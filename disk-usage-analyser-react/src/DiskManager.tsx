@@ -1,4 +1,4 @@
-import { useEffect, useState } from 'react';
+import { useEffect, useRef, useState } from 'react';
 import { Table, Button, Space, message, Tag, Tooltip, Modal, Input } from 'antd';
 import type { ColumnsType } from 'antd/es/table';
 import { FolderOpenOutlined } from '@ant-design/icons';
@@ -16,12 +16,37 @@ interface DiskInfo {
     key?: string;
 }
 
+// MountOptions are the options of a mount besides the device and password
+interface MountOptions {
+    readOnly?: boolean;
+    noExec?: boolean;
+    noSuid?: boolean;
+    mountPoint?: string;
+    filesystem?: string;
+}
+
+// FsckDone ends the watch of a disk check, mountPoint is set when the
+// system mounted the disk itself afterwards
+interface FsckDone {
+    deviceID: string;
+    checked: boolean;
+    elapsed: number;
+    mountPoint?: string;
+}
+
+// safeMount mounts a drive of unknown origin without letting it change
+// or run anything
+const safeMount: MountOptions = { readOnly: true, noExec: true, noSuid: true };
+
 export default function DiskManager() {
     const [disks, setDisks] = useState<DiskInfo[]>([]);
     const [loading, setLoading] = useState(false);
     const [passwordModalOpen, setPasswordModalOpen] = useState(false);
     const [password, setPassword] = useState('');
-    const [pendingMountDevice, setPendingMountDevice] = useState<string | null>(null);
+    const [pendingMount, setPendingMount] = useState<{ deviceID: string; options?: MountOptions } | null>(null);
+    // checks are the disk checks being waited for, by device
+    const [checks, setChecks] = useState<Record<string, number>>({});
+    const watches = useRef<Record<string, EventSource>>({});
 
     const processDisks = (data: any[]): DiskInfo[] => {
         return data.map((d: any) => ({
@@ -34,7 +59,7 @@ export default function DiskManager() {
     const fetchDisks = async () => {
         setLoading(true);
         try {
-            const res = await fetch('/api/disks/list');
+            const res = await fetch('/api/v1/disks/list');
             if (!res.ok) {
                 throw new Error(`Failed to fetch disks: ${res.statusText}`);
             }
@@ -49,47 +74,98 @@ export default function DiskManager() {
 
     useEffect(() => {
         fetchDisks();
+        return () => {
+            Object.values(watches.current).forEach(es => es.close());
+        };
     }, []);
 
-    const handleMount = async (deviceID: string, pwd?: string) => {
+    const handleMount = async (deviceID: string, options?: MountOptions, pwd?: string) => {
         try {
-            const res = await fetch('/api/disks/mount', {
+            const res = await fetch('/api/v1/disks/mount', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json'
                 },
-                body: JSON.stringify({ deviceID, password: pwd })
+                body: JSON.stringify({ ...options, deviceID, password: pwd })
             });
 
             if (res.status === 401) {
-                setPendingMountDevice(deviceID);
+                setPendingMount({ deviceID, options });
                 setPasswordModalOpen(true);
                 return;
             }
 
+            if (res.status === 409) {
+                // the system checks the disk first, mount once it is done
+                setPasswordModalOpen(false);
+                setPassword('');
+                setPendingMount(null);
+                message.info('The disk is being checked, it is mounted when the check finishes');
+                watchCheck(deviceID, options);
+                return;
+            }
+
             if (!res.ok) {
                 const text = await res.text();
                 throw new Error(text || res.statusText);
             }
-            message.success('Mounted successfully');
+            message.success(options?.readOnly ? 'Mounted read-only' : 'Mounted successfully');
             setPasswordModalOpen(false);
             setPassword('');
-            setPendingMountDevice(null);
+            setPendingMount(null);
             fetchDisks();
         } catch (err: any) {
             message.error(`Failed to mount: ${err.message}`);
         }
     };
 
+    // watchCheck waits for the check of deviceID to finish, then mounts it
+    // unless the system did
+    const watchCheck = (deviceID: string, options?: MountOptions) => {
+        watches.current[deviceID]?.close();
+        const es = new EventSource(`/api/v1/disks/fsck?watch=true&deviceID=${encodeURIComponent(deviceID)}`);
+        watches.current[deviceID] = es;
+        const stop = () => {
+            es.close();
+            delete watches.current[deviceID];
+            setChecks(c => {
+                const rest = { ...c };
+                delete rest[deviceID];
+                return rest;
+            });
+        };
+        setChecks(c => ({ ...c, [deviceID]: 0 }));
+        es.addEventListener('progress', (e) => {
+            const d = JSON.parse((e as MessageEvent).data);
+            const elapsed = Math.max(0, ...d.processes.map((p: { elapsed: number }) => p.elapsed));
+            setChecks(c => ({ ...c, [deviceID]: elapsed }));
+        });
+        es.addEventListener('done', (e) => {
+            const done: FsckDone = JSON.parse((e as MessageEvent).data);
+            stop();
+            if (done.mountPoint) {
+                message.success(`Checked and mounted at ${done.mountPoint}`);
+                fetchDisks();
+                return;
+            }
+            handleMount(deviceID, options);
+        });
+        es.onerror = () => {
+            if (es.readyState === EventSource.CLOSED) return;
+            stop();
+            message.error('Lost the connection while waiting for the disk check');
+        };
+    };
+
     const handlePasswordSubmit = () => {
-        if (pendingMountDevice) {
-            handleMount(pendingMountDevice, password);
+        if (pendingMount) {
+            handleMount(pendingMount.deviceID, pendingMount.options, password);
         }
     };
 
     const handleUnmount = async (deviceID: string) => {
         try {
-            const res = await fetch(`/api/disks/unmount?deviceID=${deviceID}`, { method: 'POST' });
+            const res = await fetch(`/api/v1/disks/unmount?deviceID=${deviceID}`, { method: 'POST' });
             if (!res.ok) {
                 const text = await res.text();
                 throw new Error(text || res.statusText);
@@ -103,7 +179,7 @@ export default function DiskManager() {
 
     const handleOpen = async (path: string) => {
         try {
-            const res = await fetch(`/api/disks/open?path=${encodeURIComponent(path)}`, { method: 'POST' });
+            const res = await fetch(`/api/v1/disks/open?path=${encodeURIComponent(path)}`, { method: 'POST' });
             if (!res.ok) {
                 const text = await res.text();
                 throw new Error(text || res.statusText);
@@ -195,14 +271,29 @@ export default function DiskManager() {
                         <Button onClick={() => handleUnmount(record.deviceID)} danger>
                             Unmount
                         </Button>
-                    ) : (
-                        <Button
-                            onClick={() => handleMount(record.deviceID)}
-                            type="primary"
-                            disabled={!!record.status} // Disable if status is set (e.g. Checking)
-                        >
-                            Mount
+                    ) : checks[record.deviceID] !== undefined ? (
+                        <Tag color="orange">Checking, {checks[record.deviceID]}s</Tag>
+                    ) : record.status ? (
+                        <Button onClick={() => watchCheck(record.deviceID)}>
+                            Mount after check
                         </Button>
+                    ) : (
+                        <>
+                            <Button
+                                onClick={() => handleMount(record.deviceID)}
+                                type="primary"
+                                disabled={!!record.status} // Disable if status is set (e.g. Checking)
+                            >
+                                Mount
+                            </Button>
+                            <Button
+                                onClick={() => handleMount(record.deviceID, safeMount)}
+                                disabled={!!record.status}
+                                title="Read-only, noexec and nosuid"
+                            >
+                                Mount read-only
+                            </Button>
+                        </>
                     )}
                 </Space>
             ),
@@ -235,7 +326,7 @@ export default function DiskManager() {
                 onOk={handlePasswordSubmit}
                 onCancel={() => {
                     setPasswordModalOpen(false);
-                    setPendingMountDevice(null);
+                    setPendingMount(null);
                     setPassword('');
                 }}
             >
@@ -5,7 +5,7 @@ import { Pie } from 'react-chartjs-2';
 import { Table, Input, Select, Button, Modal, Space, message, AutoComplete, Popover, Typography } from 'antd';
 import { DeleteOutlined, ReloadOutlined, FolderOpenOutlined, FileOutlined, CopyOutlined, QuestionCircleOutlined, StopOutlined } from '@ant-design/icons';
 import { DiskUsageAPI } from './api/disk_usage';
-import type { FileInfo } from './api/disk_usage';
+import type { FileInfo, UsageSummary } from './api/disk_usage';
 
 ChartJS.register(ArcElement, Tooltip, Legend);
 
@@ -138,6 +138,8 @@ export default function DiskUsage() {
     const [rootPath, setRootPath] = useState<string>(currentUrlPath || '');
     const [rootItems, setRootItems] = useState<FileNode[]>([]);
     const [loading, setLoading] = useState(false);
+    // summary is the exact total of the root once its scan is done
+    const [summary, setSummary] = useState<UsageSummary | null>(null);
     const [view, setView] = useState<'list' | 'pie'>('list');
     const activeSources = useRef<Map<string, EventSource>>(new Map());
     const [filterSizeStr, setFilterSizeStr] = useState('1MB');
@@ -181,6 +183,7 @@ export default function DiskUsage() {
                 activeSources.current.clear();
                 setLoading(true);
                 setRootItems([]);
+                setSummary(null);
             } else {
                 // Even if not clearing data, we should probably close the existing root source 
                 // (which we did above with activeSources.get(dirPath)?.close())
@@ -198,8 +201,8 @@ export default function DiskUsage() {
                     ...item,
                     path: itemPath,
                     key: itemPath,
-                    // Initialize children for dirs to make them expandable
-                    children: item.isDir ? [] : undefined
+                    // Initialize children for dirs to make them expandable, bundles stay leaves
+                    children: item.isDir && !item.leaf ? [] : undefined
                 };
 
                 if (isRoot) {
@@ -243,6 +246,9 @@ export default function DiskUsage() {
                     }));
                 }
             },
+            onSummary: (s) => {
+                if (isRoot) setSummary(s);
+            },
             onDone: () => {
                 if (isRoot) setLoading(false);
                 es.close();
@@ -510,6 +516,13 @@ export default function DiskUsage() {
                 </Space>
             </div>
 
+            {summary && !loading && (
+                <Typography.Text type="secondary" style={{ marginBottom: 8 }}>
+                    {formatBytes(summary.size)} in {summary.files.toLocaleString()} files and {summary.dirs.toLocaleString()} directories
+                    {summary.errors > 0 && <Typography.Text type="warning">, {summary.errors.toLocaleString()} could not be read</Typography.Text>}
+                </Typography.Text>
+            )}
+
             <div style={{ flex: 1, overflow: 'auto' }}>
                 {view === 'list' ? (
                     <Table
@@ -3,75 +3,1716 @@ export interface FileInfo {
     size: number;
     isDir: boolean;
     status: 'pending' | 'done';
+    bundleType?: string;
+    bundleVersion?: string;
+    leaf?: boolean;
+    backupExcluded?: boolean;
+    modTime?: string;
+    aggregated?: boolean;
+    count?: number;
+    uid?: number;
+    gid?: number;
+    owner?: string;
+    group?: string;
+    mode?: string;
+    othersSize?: number;
+    entries?: number;
+    diskSize?: number;
+    compressed?: boolean;
+    placeholder?: boolean;
+    cloudSize?: number;
+    // per-volume system directory, e.g. 'Spotlight index'
+    systemDir?: string;
+    unreadable?: boolean;
+    // the directory could not be read (error), or part of its subtree (partial, size is a lower bound)
+    error?: string;
+    partial?: boolean;
+    // size derived from the volume usage, e.g. for unreadable system data
+    estimated?: boolean;
+    diskImage?: 'dmg' | 'sparseimage' | 'sparsebundle';
+    // VM disk image or bundle, see vms()
+    vm?: 'qcow2' | 'vmdk' | 'vdi' | 'vhd' | 'vhdx' | 'hdd' | 'utm' | 'parallels' | 'vmware';
+    // app managed library the item belongs to, see stores()
+    store?: 'mail' | 'photos';
+    // directory holding a .git directory of gitSize bytes
+    gitRepo?: boolean;
+    gitSize?: number;
+    // archive format, the content can be listed with listArchive
+    archive?: 'zip' | 'tar' | 'tar.gz' | 'tar.bz2';
+    // bytes in extended attributes and resource forks, deep profile only
+    xattrSize?: number;
+    // formatted sizes, only sent with the units or locale view options
+    sizeText?: string;
+    diskSizeText?: string;
+    // S3 storage class holding most of the bytes, s3:// listings only
+    storageClass?: string;
+    // note and labels attached to the item, see DiskUsageAPI.annotate
+    annotation?: Annotation;
+    // entry of the ignore list, only listed with showIgnored, not scanned
+    ignored?: boolean;
+}
+
+// Aggregate of the items not sent when the stream is sorted/paginated
+export interface OtherInfo {
+    total: number;
+    count: number;
+    size: number;
+    entries: number;
+}
+
+export interface UsageViewOptions {
+    sort?: 'size' | 'name' | 'mtime';
+    order?: 'asc' | 'desc';
+    offset?: number;
+    limit?: number;
+    minSize?: number;
+    metric?: 'size' | 'count';
+    // minimum milliseconds between progress updates of one item, 0 sends every update
+    updateInterval?: number;
+    // 'delta' sends items by id with only the changed fields
+    encoding?: 'delta';
+    profile?: 'quick' | 'standard' | 'deep';
+    // adds sizeText to items: 'si' (kB, MB) or 'binary' (KiB, MiB)
+    units?: 'si' | 'binary';
+    // decimal and thousands separators of sizeText, e.g. 'de-DE'
+    locale?: string;
+    // 'count' counts entries in a pre-pass when no prior scan gives an estimate
+    estimate?: 'count';
+    // sends the content of each finished subdirectory as a child_detail event
+    prefetch?: boolean;
+    // lists the entries of the ignore list, greyed out, instead of leaving them out
+    showIgnored?: boolean;
+}
+
+// ChildDetail is the content of a finished subdirectory, the largest items first
+export interface ChildDetail {
+    name: string;
+    items: FileInfo[];
+    more?: number; // smaller items left out
+}
+
+// StorageClasses is the bytes per storage class of an s3:// listing, items
+// are its subdirectories, files carry their class in FileInfo.storageClass
+export interface StorageClasses {
+    total: Record<string, number>;
+    items: Record<string, Record<string, number>>;
+}
+
+export interface ScanProgress {
+    entries: number;
+    estimated: number; // 0 while unknown
+    percent: number;
+    source?: 'recent' | 'count';
+    done: boolean;
+}
+
+// UsageSummary is the exact total sent once a scan is done, it replaces
+// what the progress added up to
+export interface UsageSummary {
+    path: string;
+    size: number;
+    diskSize: number;
+    entries: number;
+    files: number;
+    dirs: number;
+    errors: number; // directories that could not be read
+    sizeText?: string;
+    diskSizeText?: string;
+    io?: IOStats; // of the listing and the jobs of its subdirectories
+    jobs?: number[];
+}
+
+export interface LevelIO {
+    depth: number;
+    dirs: number;
+    readDirMs: number;
+    start: string;
+    end: string;
+    wallMs: number;
+    slowest: string; // directory of the longest ReadDir
+    slowestMs: number;
+}
+
+export interface IOStats {
+    job?: number; // missing for the sum of several jobs
+    path: string;
+    startedAt: string;
+    done: boolean;
+    wallMs: number;
+    readDirs: number;
+    stats: number;
+    readDirMs: number; // summed over the workers
+    statMs: number;
+    levels: LevelIO[];
+}
+
+export interface UsageResponse {
+    path: string;
+    totalSize: number;
+    items: FileInfo[];
+    error?: string;
+    // set once the scan finished, only then the response has an ETag
+    done?: boolean;
+}
+
+export interface TrashItem {
+    name: string;
+    size: number;
+    isDir: boolean;
+    modTime: string;
+}
+
+export interface TrashLocation {
+    path: string;
+    volume: string;
+    contentDir: string;
+    size: number;
+    count: number;
+    items: TrashItem[];
+}
+
+export interface TrashResponse {
+    locations: TrashLocation[];
+    size: number;
+}
+
+export interface Trend {
+    bytesPerDay: number;
+    fullAt?: string;
+}
+
+export interface VolumeForecast {
+    filesystem: string;
+    mountPoint: string;
+    size: number;
+    used: number;
+    available: number;
+    samples: number;
+    linear?: Trend;
+    recent?: Trend;
+}
+
+// VolumeSpace is a sample of a volume, free is what users can still write
+export interface VolumeSpace {
+    time: string;
+    size: number;
+    used: number;
+    free: number;
+}
+
+// VolumeSpaceDrop is free space lost between two consecutive samples
+export interface VolumeSpaceDrop {
+    from: string;
+    to: string;
+    bytes: number;
+    before: number;
+    after: number;
+}
+
+export interface VolumeHistory {
+    mountPoint: string;
+    mounted: boolean;
+    samples: VolumeSpace[];
+    drops: VolumeSpaceDrop[];
+}
+
+export interface PreflightResult {
+    path: string;
+    sampled: number;
+    denied: number;
+    deniedPaths: string[];
+    deniedFraction: number;
+    volumeUsed: number;
+    estimatedInvisible: number;
+    needsFullDiskAccess: boolean;
+    needsPrivileged: boolean;
+    complete: boolean;
+}
+
+export interface Bookmark {
+    path: string;
+    name: string;
+    createdAt: string;
+    size: number;
+    scannedAt: string;
+    visitSize: number;
+    visitedAt: string;
+    delta: number;
+}
+
+export interface Pin {
+    path: string;
+    interval: string;
+    createdAt: string;
+    size: number;
+    count: number;
+    scannedAt: string;
+    partial: boolean;
+    error?: string;
+    scanning: boolean;
+}
+
+export interface Annotation {
+    path: string;
+    note?: string;
+    // e.g. "candidate for deletion" or "owner=alice"
+    labels?: string[];
+    updatedBy?: string;
+    updatedAt: string;
+}
+
+// IgnoreRule leaves an absolute path, or entries with a name like
+// node_modules or *.iso, out of every scan
+export interface IgnoreRule {
+    pattern: string;
+    createdAt: string;
+    createdBy?: string;
+}
+
+export interface RecentScan {
+    path: string;
+    scannedAt: string;
+    size: number;
+    entries: number;
+}
+
+export interface VersionInfo {
+    app: string;
+    version: string;
+    commit: string;
+    modified: boolean;
+    goVersion: string;
+    os: string;
+    arch: string;
+    apiVersion: string;
+    rpcVersion: string;
+    features: {
+        moveToTrash: boolean;
+        emptyTrash: boolean;
+        mount: boolean;
+        liveUpdates: boolean;
+        privilegedHelper: boolean;
+    };
+}
+
+// TrashPreview is what moveToTrash would move, complete is false when the
+// scan did not finish in time and the numbers are lower bounds
+export interface TrashPreview {
+    path: string;
+    isDir: boolean;
+    size: number;
+    entries: number;
+    files: number;
+    dirs: number;
+    newestModTime: string;
+    complete: boolean;
+    // set for items of Mail and Photos libraries, moving them needs force
+    store?: string;
+}
+
+export interface FileEntry {
+    path: string; // relative to the listed directory
+    size: number;
+    modTime: string;
+}
+
+export interface FilesDone {
+    count: number;
+    size: number;
+    truncated: boolean;
+    denied: number;
+}
+
+export interface HashResult {
+    path: string;
+    size: number;
+    hash?: string;
+    error?: string;
+}
+
+export interface HashDone {
+    algo: string;
+    results: HashResult[];
+    identical: boolean;
+}
+
+export interface XattrUsage {
+    name: string;
+    isDir: boolean;
+    size: number;
+    xattrSize: number;
+}
+
+export interface ByXattrResponse {
+    path: string;
+    size: number;
+    xattrSize: number;
+    children: XattrUsage[]; // heaviest first
+}
+
+export interface FilteredUsage {
+    name: string;
+    isDir: boolean;
+    size: number; // bytes of the matching files
+    files: number;
+}
+
+export interface FilteredUsageResponse {
+    path: string;
+    include: string[];
+    size: number;
+    files: number;
+    children: FilteredUsage[]; // largest first
+}
+
+export interface DiskImageInfo {
+    path: string;
+    kind: 'dmg' | 'sparseimage' | 'sparsebundle';
+    size: number;
+    diskSize: number;
+    // from hdiutil, missing when error is set
+    format?: string;
+    capacity?: number;
+    used?: number;
+    encrypted?: boolean;
+    reclaimable: number;
+    error?: string;
+}
+
+export interface SimulatedPath {
+    path: string;
+    size: number;
+    diskSize: number;
+    files: number;
+    coveredBy?: string; // selected ancestor already counting this path
+    error?: string;
+}
+
+export interface SimulateResult {
+    paths: SimulatedPath[];
+    reclaimable: number;
+    reclaimableDisk: number;
+    files: number;
+    hardLinkRetained: number;
+    denied: number;
+}
+
+export interface ArchiveChild {
+    name: string;
+    isDir: boolean;
+    size: number; // uncompressed
+    compressedSize?: number; // zip only
+    count: number;
+    modTime: string;
+}
+
+export interface ArchiveListing {
+    path: string;
+    kind: string;
+    dir: string;
+    size: number;
+    archiveSize: number;
+    entries: number;
+    children: ArchiveChild[]; // largest first
+}
+
+export interface GitInfo {
+    path: string;
+    size: number;
+    gitSize: number;
+    workTreeSize: number;
+    objectsSize: number;
+    lfsSize: number;
+}
+
+export interface GitRunDone {
+    action: string;
+    error?: string;
+    before: number;
+    after: number;
+    reclaimed: number;
+}
+
+export interface ArtifactUsage {
+    name: string;
+    size: number;
+}
+
+export interface ProjectUsage {
+    path: string;
+    name: string;
+    kinds: string[];
+    artifacts: string[];
+    size: number;
+    artifactsSize: number;
+    sourceSize: number;
+    artifactSizes: ArtifactUsage[];
+}
+
+export interface ProjectsResponse {
+    path: string;
+    projects: ProjectUsage[];
+    size: number;
+    artifactsSize: number;
+}
+
+export interface ProjectCleanResponse {
+    dryRun: boolean;
+    path: string;
+    size: number;
+    delete: ArtifactUsage[];
+}
+
+export interface VMAction {
+    label: string;
+    command: string[];
+}
+
+export interface VMUsage {
+    runtime: string;
+    name: string;
+    path: string;
+    actions: VMAction[];
+    size: number;
+    diskSize: number;
+}
+
+export interface VMRuntime {
+    id: string;
+    name: string;
+    dirs: string[];
+}
+
+export interface VMsResponse {
+    vms: VMUsage[];
+    runtimes: VMRuntime[];
+    size: number;
+    diskSize: number;
+}
+
+export interface StoreGroupUsage {
+    name: string;
+    path: string;
+    size: number;
+}
+
+export interface YearUsage {
+    year: number;
+    size: number;
+    count: number;
+}
+
+export interface StoreUsage {
+    id: 'mail' | 'photos';
+    name: string;
+    path: string;
+    warning: string;
+    cleanup: string[];
+    size: number;
+    groups: StoreGroupUsage[];
+    byYear?: YearUsage[];
+}
+
+export interface BrowserProfileUsage {
+    name: string;
+    cacheDirs: string[];
+    size: number;
+}
+
+export interface BrowserCacheInfo {
+    id: string;
+    name: string;
+    profiles: BrowserProfileUsage[];
+    size: number;
+}
+
+export interface BrowserCacheCleanResponse {
+    dryRun: boolean;
+    size: number;
+    delete: string[];
+}
+
+export interface LogFile {
+    path: string;
+    size: number;
+    modTime: string;
+    rotated: boolean;
+    bytesPerDay: number;
+    suggestion?: 'delete' | 'truncate' | 'watch';
+    watched: boolean;
+}
+
+export interface LogsResponse {
+    dirs: string[];
+    files: LogFile[];
+    size: number;
+    journal?: { size: number; error?: string };
+    denied: number;
+}
+
+export interface VacuumResponse {
+    before: number;
+    after: number;
+    reclaimed: number;
+    output: string;
+}
+
+export interface LogWatch {
+    path: string;
+    threshold: number;
+    size: number;
+    checkedAt: string;
+    exceeded: boolean;
+}
+
+export interface SearchResult {
+    path: string;
+    size: number;
+    isDir: boolean;
+    modTime: string;
+    // found by Spotlight and confirmed by stat
+    verified?: boolean;
+}
+
+export interface SearchResponse {
+    results: SearchResult[];
+    truncated: boolean;
+    indexed: boolean;
+    source: 'cache' | 'spotlight';
+    stale?: number;
+}
+
+export interface SearchOptions {
+    q?: string;
+    minSize?: string;
+    maxSize?: string;
+    type?: 'file' | 'dir';
+    limit?: number;
+    // spotlight also queries the macOS Spotlight index, auto only while path is not scanned yet
+    source?: 'cache' | 'spotlight' | 'auto';
+}
+
+// JobStatus is a running scan, it goes on when the client that started it leaves
+export interface JobStatus {
+    id: number; // see jobStats
+    path: string;
+    profile: 'quick' | 'standard' | 'deep';
+    startedAt: string;
+    size: number;
+    count: number;
+    priority: 'interactive' | 'background';
+}
+
+export interface AnalyzerInfo {
+    name: string;
+    detected: boolean; // something to analyze at or below the path
+}
+
+export interface AnalyzerItem {
+    name: string;
+    path: string;
+    size: number;
+    reclaimable?: boolean;
+    note?: string;
+}
+
+// AnalyzerReport is the result of running one analyzer, items largest first
+export interface AnalyzerReport {
+    analyzer: string;
+    path: string;
+    size: number;
+    reclaimable: number;
+    items: AnalyzerItem[];
+}
+
+export interface PluginStatus {
+    name: string;
+    path: string;
+    hash: string; // sha256 of the executable, approvals are bound to it
+    approved: boolean;
+}
+
+export interface PluginAction {
+    id: string;
+    label: string;
+    confirm?: string; // question to ask before running
+}
+
+export interface PluginAnnotations {
+    plugin: string;
+    annotations: { path: string; label: string; note?: string }[];
+    actions: PluginAction[];
+    error?: string;
+}
+
+export interface PluginRunResult {
+    message?: string;
+    changed?: boolean;
+}
+
+export interface CleanupRule {
+    name: string;
+    under: string;
+    match?: string; // glob of file names, e.g. *.log.gz
+    olderThan?: string; // e.g. 90d
+    largerThan?: string; // e.g. 100M
+    action: 'trash' | 'delete';
+    schedule?: string; // hourly, daily, weekly or a duration, empty for on demand
+    apply: boolean; // scheduled runs remove files, otherwise they only report
+    lastReport?: RuleReport;
+}
+
+export interface RuleReport {
+    rule: string;
+    ranAt: string;
+    dryRun: boolean;
+    scheduled?: boolean;
+    files: { path: string; size: number; modTime: string; error?: string }[];
+    count: number;
+    size: number;
+    failed: number;
+    truncated: boolean;
+    denied: number;
+    error?: string;
+}
+
+export interface BaselineInfo {
+    name: string;
+    root: string;
+    host?: string;
+    createdAt: string;
+    size: number;
 }
 
-export interface UsageResponse {
-    path: string;
-    totalSize: number;
-    items: FileInfo[];
-    error?: string;
-}
+export interface BaselineChange {
+    path: string;
+    kind: 'added' | 'removed' | 'grown';
+    isDir: boolean;
+    size: number;
+    baseSize: number;
+    delta: number;
+}
+
+export interface BaselineReport {
+    root: string;
+    baseline: BaselineInfo;
+    size: number;
+    baseSize: number;
+    delta: number;
+    added: number;
+    grown: number;
+    removed: number;
+    changes: BaselineChange[];
+    truncated: boolean;
+}
+
+export interface AuditEntry {
+    time: string;
+    action: string;
+    path: string;
+    size: number;
+    client: string;
+    user?: string;
+    userAgent?: string;
+    via?: string;
+    error?: string;
+    prev: string;
+}
+
+export interface AuditLog {
+    file: string;
+    entries: AuditEntry[];
+    total: number;
+    verified: boolean;
+    brokenAt?: number;
+    // what the log misses of its anchor, e.g. entries cut off its end
+    anchor?: string;
+}
+
+export type Role = 'viewer' | 'operator' | 'admin';
+
+export interface Whoami {
+    enabled: boolean;
+    user?: string;
+    role: Role | '';
+}
+
+export interface ClientSession {
+    id: string;
+    addr: string;
+    userAgent: string;
+    user?: string;
+    role: Role;
+    firstSeen: string;
+    lastSeen: string;
+    requests: number;
+    subscriptions: { endpoint: string; path?: string; since: string }[];
+}
+
+export interface SummaryVolume {
+    mountPoint: string;
+    size: number;
+    used: number;
+    available: number;
+    usedPercent: number;
+    growth24h?: number;
+    growth7d?: number;
+    text?: string;
+}
+
+export interface SummaryDir {
+    name: string;
+    size: number;
+    sizeText?: string;
+    children?: SummaryDir[]; // with depth above 1
+}
+
+export interface Summary {
+    path: string;
+    size: number;
+    sizeText?: string;
+    partial?: boolean;
+    volumes: SummaryVolume[];
+    top: SummaryDir[];
+}
+
+export class DiskUsageAPI {
+    static streamUsage(dirPath: string, callbacks: {
+        onPath: (path: string) => void;
+        onItem: (item: FileInfo) => void;
+        onDone: () => void;
+        onError: (error: string) => void;
+        onRemove?: (name: string) => void;
+        onOther?: (other: OtherInfo) => void;
+        onWatchers?: (count: number) => void;
+        onProgress?: (progress: ScanProgress) => void;
+        onChildDetail?: (detail: ChildDetail) => void;
+        onStorageClasses?: (classes: StorageClasses) => void;
+        onSummary?: (summary: UsageSummary) => void;
+    }, view?: UsageViewOptions): EventSource {
+        const params = new URLSearchParams();
+        if (dirPath) params.set('path', dirPath);
+        if (view?.sort) params.set('sort', view.sort);
+        if (view?.order) params.set('order', view.order);
+        if (view?.offset) params.set('offset', String(view.offset));
+        if (view?.limit) params.set('limit', String(view.limit));
+        if (view?.minSize) params.set('minSize', String(view.minSize));
+        if (view?.metric) params.set('metric', view.metric);
+        if (view?.updateInterval !== undefined) params.set('updateInterval', String(view.updateInterval));
+        if (view?.encoding) params.set('encoding', view.encoding);
+        if (view?.profile) params.set('profile', view.profile);
+        if (view?.units) params.set('units', view.units);
+        if (view?.locale) params.set('locale', view.locale);
+        if (view?.estimate) params.set('estimate', view.estimate);
+        if (view?.prefetch) params.set('prefetch', 'true');
+        if (view?.showIgnored) params.set('showIgnored', 'true');
+        const query = params.toString();
+        const url = query ? `/api/v1/usage?${query}` : '/api/v1/usage';
+        const es = new EventSource(url);
+
+        es.addEventListener('path', (e) => {
+            const d = JSON.parse((e as MessageEvent).data);
+            callbacks.onPath(d.path);
+        });
+
+        // delta encoding: items known by id, merged with their updates
+        const byId = new Map<number, FileInfo>();
+
+        es.addEventListener('item', (e) => {
+            const d = JSON.parse((e as MessageEvent).data);
+            if (d.id === undefined) {
+                callbacks.onItem(d as FileInfo);
+                return;
+            }
+            const { id, ...fields } = d;
+            const item: Record<string, unknown> = { ...byId.get(id) };
+            for (const [k, v] of Object.entries(fields)) {
+                if (v === null) delete item[k];
+                else item[k] = v;
+            }
+            byId.set(id, item as unknown as FileInfo);
+            callbacks.onItem(item as unknown as FileInfo);
+        });
+
+        es.addEventListener('remove', (e) => {
+            const d = JSON.parse((e as MessageEvent).data);
+            if (d.id !== undefined) {
+                const item = byId.get(d.id);
+                byId.delete(d.id);
+                if (item) callbacks.onRemove?.(item.name);
+                return;
+            }
+            callbacks.onRemove?.(d.name);
+        });
+
+        es.addEventListener('other', (e) => {
+            const other: OtherInfo = JSON.parse((e as MessageEvent).data);
+            callbacks.onOther?.(other);
+        });
+
+        es.addEventListener('watchers', (e) => {
+            const d = JSON.parse((e as MessageEvent).data);
+            callbacks.onWatchers?.(d.count);
+        });
+
+        es.addEventListener('progress', (e) => {
+            const progress: ScanProgress = JSON.parse((e as MessageEvent).data);
+            callbacks.onProgress?.(progress);
+        });
+
+        es.addEventListener('child_detail', (e) => {
+            const detail: ChildDetail = JSON.parse((e as MessageEvent).data);
+            callbacks.onChildDetail?.(detail);
+        });
+
+        es.addEventListener('storage_classes', (e) => {
+            const classes: StorageClasses = JSON.parse((e as MessageEvent).data);
+            callbacks.onStorageClasses?.(classes);
+        });
+
+        es.addEventListener('summary', (e) => {
+            const summary: UsageSummary = JSON.parse((e as MessageEvent).data);
+            callbacks.onSummary?.(summary);
+        });
+
+        es.addEventListener('done', () => {
+            callbacks.onDone();
+            es.close();
+        });
+
+        es.addEventListener('server_error', (e) => {
+            const d = JSON.parse((e as MessageEvent).data);
+            callbacks.onError(d.error);
+            es.close();
+        });
+
+        es.onerror = (e) => {
+            console.error('SSE Error', e);
+            if (es.readyState === EventSource.CLOSED) return;
+
+            // Close on error
+            es.close();
+            callbacks.onError('Connection error');
+        };
+
+        return es;
+    }
+
+    // moveToTrash refuses items inside Mail and Photos libraries unless force is set
+    static async moveToTrash(path: string, force?: boolean): Promise<void> {
+        const params = new URLSearchParams({ path });
+        if (force) params.set('force', 'true');
+        const res = await fetch(`/api/v1/moveToTrash?${params.toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    // previewTrash sizes path for the confirmation of moveToTrash
+    static async previewTrash(path: string): Promise<TrashPreview> {
+        const params = new URLSearchParams({ path, dryRun: 'true' });
+        const res = await fetch(`/api/v1/moveToTrash?${params.toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async refresh(path: string): Promise<void> {
+        const res = await fetch(`/api/v1/refresh?path=${encodeURIComponent(path)}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async addBackupExclusion(path: string): Promise<void> {
+        const res = await fetch(`/api/v1/backupExclusions/add?path=${encodeURIComponent(path)}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async listTrash(): Promise<TrashResponse> {
+        const res = await fetch('/api/v1/trash/list');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // emptyTrash permanently deletes the trash of every volume, returns the bytes freed
+    static async emptyTrash(): Promise<number> {
+        const res = await fetch('/api/v1/trash/empty?confirm=true', {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        const d = await res.json();
+        return d.size;
+    }
+
+    static async forecast(): Promise<VolumeForecast[]> {
+        const res = await fetch('/api/v1/forecast');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // volumeHistory is the free space of the volumes over the last days,
+    // a sample per hour or per day
+    static async volumeHistory(options: { mountPoint?: string; days?: number; interval?: 'hour' | 'day'; minDrop?: number } = {}): Promise<VolumeHistory[]> {
+        const params = new URLSearchParams();
+        if (options.mountPoint) params.set('mountPoint', options.mountPoint);
+        if (options.days) params.set('days', String(options.days));
+        if (options.interval) params.set('interval', options.interval);
+        if (options.minDrop) params.set('minDrop', String(options.minDrop));
+        const res = await fetch(`/api/v1/volume/history?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async preflight(path: string): Promise<PreflightResult> {
+        const res = await fetch(`/api/v1/preflight?path=${encodeURIComponent(path)}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async listBookmarks(): Promise<Bookmark[]> {
+        const res = await fetch('/api/v1/bookmarks/list');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // bookmarkAction is one of add, remove, visit and rescan
+    static async bookmarkAction(action: 'add' | 'remove' | 'visit' | 'rescan', path: string, name?: string): Promise<void> {
+        const params = new URLSearchParams({ path });
+        if (name) params.set('name', name);
+        const res = await fetch(`/api/v1/bookmarks/${action}?${params.toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async listPins(): Promise<Pin[]> {
+        const res = await fetch('/api/v1/pins');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // pin keeps path scanned, rescanning it every interval (e.g. 1h)
+    static async pin(path: string, interval?: string): Promise<Pin> {
+        const params = new URLSearchParams({ path });
+        if (interval) params.set('interval', interval);
+        const res = await fetch(`/api/v1/pins/add?${params.toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async unpin(path: string): Promise<void> {
+        const res = await fetch(`/api/v1/pins/remove?${new URLSearchParams({ path }).toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    // listAnnotations lists the annotated paths, below under and with label if given
+    static async listAnnotations(under?: string, label?: string): Promise<Annotation[]> {
+        const params = new URLSearchParams();
+        if (under) params.set('under', under);
+        if (label) params.set('label', label);
+        const res = await fetch(`/api/v1/annotations?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // annotate replaces the note and labels of path
+    static async annotate(path: string, note: string, labels: string[] = []): Promise<Annotation> {
+        const params = new URLSearchParams({ path });
+        if (note) params.set('note', note);
+        labels.forEach(label => params.append('label', label));
+        const res = await fetch(`/api/v1/annotations/set?${params.toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async removeAnnotation(path: string): Promise<void> {
+        const res = await fetch(`/api/v1/annotations/remove?${new URLSearchParams({ path }).toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async listIgnore(): Promise<IgnoreRule[]> {
+        const res = await fetch('/api/v1/ignore');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // ignore leaves pattern out of every scan, an absolute path or a name pattern
+    static async ignore(pattern: string): Promise<IgnoreRule> {
+        const res = await fetch(`/api/v1/ignore/add?${new URLSearchParams({ pattern }).toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async unignore(pattern: string): Promise<void> {
+        const res = await fetch(`/api/v1/ignore/remove?${new URLSearchParams({ pattern }).toString()}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async recent(): Promise<RecentScan[]> {
+        const res = await fetch('/api/v1/recent');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async version(): Promise<VersionInfo> {
+        const res = await fetch('/api/v1/version');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // listFiles streams the files below path in chunks, recursive includes subdirectories
+    static listFiles(path: string, recursive: boolean, callbacks: {
+        onFiles: (files: FileEntry[]) => void;
+        onDone: (done: FilesDone) => void;
+        onError: (error: string) => void;
+    }, limit?: number): EventSource {
+        const params = new URLSearchParams({ path });
+        if (recursive) params.set('recursive', 'true');
+        if (limit) params.set('limit', String(limit));
+        const es = new EventSource(`/api/v1/files?${params.toString()}`);
+        es.addEventListener('files', (e) => {
+            callbacks.onFiles(JSON.parse((e as MessageEvent).data));
+        });
+        es.addEventListener('done', (e) => {
+            callbacks.onDone(JSON.parse((e as MessageEvent).data));
+            es.close();
+        });
+        es.onerror = () => {
+            if (es.readyState === EventSource.CLOSED) return;
+            es.close();
+            callbacks.onError('Connection error');
+        };
+        return es;
+    }
+
+    // hash computes the checksums of paths, identical tells whether they all match
+    static hash(paths: string[], callbacks: {
+        onProgress?: (path: string, read: number, size: number) => void;
+        onResult?: (result: HashResult) => void;
+        onDone: (done: HashDone) => void;
+        onError: (error: string) => void;
+    }, algo: 'md5' | 'sha1' | 'sha256' | 'sha512' = 'sha256'): EventSource {
+        const params = new URLSearchParams({ algo });
+        for (const path of paths) params.append('path', path);
+        const es = new EventSource(`/api/v1/hash?${params.toString()}`);
+        es.addEventListener('progress', (e) => {
+            const d = JSON.parse((e as MessageEvent).data);
+            callbacks.onProgress?.(d.path, d.read, d.size);
+        });
+        es.addEventListener('result', (e) => {
+            callbacks.onResult?.(JSON.parse((e as MessageEvent).data));
+        });
+        es.addEventListener('done', (e) => {
+            callbacks.onDone(JSON.parse((e as MessageEvent).data));
+            es.close();
+        });
+        es.onerror = () => {
+            if (es.readyState === EventSource.CLOSED) return;
+            es.close();
+            callbacks.onError('Connection error');
+        };
+        return es;
+    }
+
+    static async usageByXattr(path: string): Promise<ByXattrResponse> {
+        const res = await fetch(`/api/v1/usage/by-xattr?path=${encodeURIComponent(path)}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // include is a list of name globs such as ['*.mp4', '*.mkv']
+    static async usageFiltered(path: string, include: string[]): Promise<FilteredUsageResponse> {
+        const res = await fetch(`/api/v1/usage/filtered?path=${encodeURIComponent(path)}&include=${encodeURIComponent(include.join(','))}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async diskImageInfo(path: string): Promise<DiskImageInfo> {
+        const res = await fetch(`/api/v1/diskImage/info?path=${encodeURIComponent(path)}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async compactDiskImage(path: string): Promise<{ path: string; before: number; after: number; reclaimed: number }> {
+        const res = await fetch(`/api/v1/diskImage/compact?path=${encodeURIComponent(path)}`, {
+            method: 'POST'
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // simulate tells how much deleting paths would free, nothing is deleted
+    static async simulate(paths: string[]): Promise<SimulateResult> {
+        const res = await fetch('/api/v1/simulate', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ paths })
+        });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // listArchive lists dir ('' for the top) inside a zip or tar archive
+    static async listArchive(path: string, dir = ''): Promise<ArchiveListing> {
+        const params = new URLSearchParams({ path, dir });
+        const res = await fetch(`/api/v1/archive?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async gitInfo(path: string): Promise<GitInfo> {
+        const res = await fetch(`/api/v1/git/info?path=${encodeURIComponent(path)}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
 
-export class DiskUsageAPI {
-    static streamUsage(dirPath: string, callbacks: {
-        onPath: (path: string) => void;
-        onItem: (item: FileInfo) => void;
-        onDone: () => void;
-        onError: (error: string) => void;
-    }): EventSource {
-        const url = dirPath ? `/api/usage?path=${encodeURIComponent(dirPath)}` : '/api/usage';
-        const es = new EventSource(url);
+    // gitRun runs git gc or git lfs prune, onLine receives the output as it is printed
+    static async gitRun(path: string, action: 'gc' | 'lfs-prune', onLine: (line: string) => void): Promise<GitRunDone> {
+        const params = new URLSearchParams({ path, action });
+        const res = await fetch(`/api/v1/git/run?${params.toString()}`, { method: 'POST' });
+        if (!res.ok || !res.body) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+        let buf = '';
+        for (;;) {
+            const { value, done } = await reader.read();
+            if (done) break;
+            buf += value;
+            let end;
+            while ((end = buf.indexOf('\n\n')) >= 0) {
+                const block = buf.slice(0, end);
+                buf = buf.slice(end + 2);
+                const event = /^event: (.*)$/m.exec(block)?.[1];
+                const data = JSON.parse(/^data: (.*)$/m.exec(block)?.[1] ?? 'null');
+                if (event === 'output') onLine(data.line);
+                if (event === 'done') return data as GitRunDone;
+            }
+        }
+        throw new Error('stream ended before done');
+    }
 
-        es.addEventListener('path', (e) => {
-            const d = JSON.parse((e as MessageEvent).data);
-            callbacks.onPath(d.path);
-        });
+    static async projects(path: string, depth?: number): Promise<ProjectsResponse> {
+        const params = new URLSearchParams({ path });
+        if (depth !== undefined) params.set('depth', String(depth));
+        const res = await fetch(`/api/v1/projects?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
 
-        es.addEventListener('item', (e) => {
-            const item: FileInfo = JSON.parse((e as MessageEvent).data);
-            callbacks.onItem(item);
-        });
+    // cleanProject deletes one artifact directory of a project, or all of them without artifact
+    static async cleanProject(path: string, artifact?: string, dryRun?: boolean): Promise<ProjectCleanResponse> {
+        const params = new URLSearchParams({ path });
+        if (artifact) params.set('artifact', artifact);
+        if (dryRun) params.set('dryRun', 'true');
+        else params.set('confirm', 'true');
+        const res = await fetch(`/api/v1/projects/clean?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
 
-        es.addEventListener('done', () => {
-            callbacks.onDone();
-            es.close();
+    static async vms(): Promise<VMsResponse> {
+        const res = await fetch('/api/v1/vms');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async stores(): Promise<StoreUsage[]> {
+        const res = await fetch('/api/v1/stores');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async browserCaches(): Promise<BrowserCacheInfo[]> {
+        const res = await fetch('/api/v1/browserCaches/list');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // cleanBrowserCache deletes only cache directories, of one profile if given
+    static async cleanBrowserCache(id: string, profile?: string, dryRun?: boolean): Promise<BrowserCacheCleanResponse> {
+        const params = new URLSearchParams({ id });
+        if (profile) params.set('profile', profile);
+        if (dryRun) params.set('dryRun', 'true');
+        const res = await fetch(`/api/v1/browserCaches/clean?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async logs(path?: string, minSize?: string): Promise<LogsResponse> {
+        const params = new URLSearchParams();
+        if (path) params.set('path', path);
+        if (minSize) params.set('minSize', minSize);
+        const res = await fetch(`/api/v1/logs?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // vacuumJournal shrinks the systemd journal to size, e.g. '500M'
+    static async vacuumJournal(size: string): Promise<VacuumResponse> {
+        const res = await fetch(`/api/v1/logs/vacuum?size=${encodeURIComponent(size)}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // truncateLog empties a log file, confirm is the size shown to the user
+    static async truncateLog(path: string, confirm: number): Promise<void> {
+        const params = new URLSearchParams({ path, confirm: String(confirm) });
+        const res = await fetch(`/api/v1/logs/truncate?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async logWatches(): Promise<LogWatch[]> {
+        const res = await fetch('/api/v1/logs/watches');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async watchLog(path: string, threshold: string): Promise<LogWatch> {
+        const params = new URLSearchParams({ path, threshold });
+        const res = await fetch(`/api/v1/logs/watch?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async unwatchLog(path: string): Promise<void> {
+        const res = await fetch(`/api/v1/logs/unwatch?path=${encodeURIComponent(path)}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async search(path: string, options: SearchOptions = {}): Promise<SearchResponse> {
+        const params = new URLSearchParams({ path });
+        for (const [key, value] of Object.entries(options)) {
+            if (value !== undefined && value !== '') params.set(key, String(value));
+        }
+        const res = await fetch(`/api/v1/search?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async jobs(): Promise<JobStatus[]> {
+        const res = await fetch('/api/v1/jobs');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async jobStats(id: number): Promise<IOStats> {
+        const res = await fetch(`/api/v1/jobs/stats?id=${id}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async cancelJob(path: string, profile?: string): Promise<void> {
+        const params = new URLSearchParams({ path });
+        if (profile) params.set('profile', profile);
+        const res = await fetch(`/api/v1/jobs/cancel?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    // usageCached returns the cached listing of path, or null while it
+    // is unchanged since the response with the given etag
+    static async usageCached(path: string, etag?: string, profile?: string): Promise<{ usage: UsageResponse; etag?: string } | null> {
+        const params = new URLSearchParams({ path });
+        if (profile) params.set('profile', profile);
+        const headers: Record<string, string> = {};
+        if (etag) headers['If-None-Match'] = etag;
+        const res = await fetch(`/api/v1/usage/cached?${params.toString()}`, { headers });
+        if (res.status === 304) return null;
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return { usage: await res.json(), etag: res.headers.get('ETag') ?? undefined };
+    }
+
+    static async analyzers(path: string): Promise<AnalyzerInfo[]> {
+        const res = await fetch(`/api/v1/analyzers?path=${encodeURIComponent(path)}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async runAnalyzer(name: string, path: string): Promise<AnalyzerReport> {
+        const params = new URLSearchParams({ name, path });
+        const res = await fetch(`/api/v1/analyzers/run?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    static async plugins(): Promise<{ dir: string; plugins: PluginStatus[] }> {
+        const res = await fetch('/api/v1/plugins');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // approvePlugin is called once the user agreed to run the plugin with this hash
+    static async approvePlugin(name: string, hash: string): Promise<void> {
+        const params = new URLSearchParams({ name, hash });
+        const res = await fetch(`/api/v1/plugins/approve?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async revokePlugin(name: string): Promise<void> {
+        const res = await fetch(`/api/v1/plugins/revoke?name=${encodeURIComponent(name)}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    static async annotateWithPlugins(paths: string[]): Promise<PluginAnnotations[]> {
+        const res = await fetch('/api/v1/plugins/annotate', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ paths }),
         });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
 
-        es.addEventListener('server_error', (e) => {
-            const d = JSON.parse((e as MessageEvent).data);
-            callbacks.onError(d.error);
-            es.close();
+    static async runPlugin(plugin: string, action: string, paths: string[]): Promise<PluginRunResult> {
+        const res = await fetch('/api/v1/plugins/run', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ plugin, action, paths }),
         });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
 
-        es.onerror = (e) => {
-            console.error('SSE Error', e);
-            if (es.readyState === EventSource.CLOSED) return;
+    // rules lists the rules of the rules file, error is set when it is invalid
+    static async rules(): Promise<{ file: string; rules: CleanupRule[]; error?: string }> {
+        const res = await fetch('/api/v1/rules');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
 
-            // Close on error
-            es.close();
-            callbacks.onError('Connection error');
-        };
+    // runRule reports what the rule would remove, apply removes it
+    static async runRule(name: string, apply = false): Promise<RuleReport> {
+        const params = new URLSearchParams({ name });
+        if (apply) params.set('apply', 'true');
+        const res = await fetch(`/api/v1/rules/run?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
 
-        return es;
+    // reportURL downloads a standalone HTML report of dirPath when opened
+    static reportURL(dirPath: string, depth?: number): string {
+        const params = new URLSearchParams({ path: dirPath });
+        if (depth) params.set('depth', String(depth));
+        return `/api/v1/report?${params.toString()}`;
     }
 
-    static async moveToTrash(path: string): Promise<void> {
-        const res = await fetch(`/api/moveToTrash?path=${encodeURIComponent(path)}`, {
-            method: 'POST'
-        });
+    // summaryReportURL opens the Markdown summary of dirPath for a weekly
+    // review, or downloads it as a PDF
+    static summaryReportURL(dirPath: string, format: 'markdown' | 'pdf' = 'markdown', top?: number): string {
+        const params = new URLSearchParams({ path: dirPath, format });
+        if (top) params.set('top', String(top));
+        return `/api/v1/report/summary?${params.toString()}`;
+    }
+
+    // baselines lists the saved baseline manifests, newest first
+    static async baselines(): Promise<BaselineInfo[]> {
+        const res = await fetch('/api/v1/baselines');
         if (!res.ok) {
             const text = await res.text();
             throw new Error(text);
         }
+        return res.json();
     }
 
-    static async refresh(path: string): Promise<void> {
-        const res = await fetch(`/api/refresh?path=${encodeURIComponent(path)}`, {
-            method: 'POST'
+    // saveBaseline scans dirPath and saves its manifest as name
+    static async saveBaseline(dirPath: string, name: string, minFileSize?: string): Promise<void> {
+        const params = new URLSearchParams({ path: dirPath, name });
+        if (minFileSize) params.set('minFileSize', minFileSize);
+        const res = await fetch(`/api/v1/baseline/export?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    // compareBaseline rescans the root of the saved baseline name, or
+    // dirPath, and reports what was added, removed or grew since
+    static async compareBaseline(name: string, dirPath?: string, minGrowth?: string): Promise<BaselineReport> {
+        const params = new URLSearchParams({ name });
+        if (dirPath) params.set('path', dirPath);
+        if (minGrowth) params.set('minGrowth', minGrowth);
+        const res = await fetch(`/api/v1/baseline/compare?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // audit lists the newest destructive actions, verified reports whether the log is untampered
+    static async audit(filter: { path?: string; action?: string; client?: string; user?: string; since?: string; limit?: number } = {}): Promise<AuditLog> {
+        const params = new URLSearchParams();
+        if (filter.path) params.set('path', filter.path);
+        if (filter.action) params.set('action', filter.action);
+        if (filter.client) params.set('client', filter.client);
+        if (filter.user) params.set('user', filter.user);
+        if (filter.since) params.set('since', filter.since);
+        if (filter.limit) params.set('limit', String(filter.limit));
+        const res = await fetch(`/api/v1/audit?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // whoami reports whether logins are enabled and the role of this client
+    static async whoami(): Promise<Whoami> {
+        const res = await fetch('/api/v1/whoami');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // login checks token and keeps it in a cookie, which streams send too
+    static async login(token: string): Promise<Whoami> {
+        const res = await fetch('/api/v1/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token }),
         });
         if (!res.ok) {
             const text = await res.text();
             throw new Error(text);
         }
+        return res.json();
+    }
+
+    static async logout(): Promise<void> {
+        const res = await fetch('/api/v1/logout', { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    // clientSessions lists the clients seen in the last hour with their open streams
+    static async clientSessions(): Promise<ClientSession[]> {
+        const res = await fetch('/api/v1/sessions');
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
+    }
+
+    // disconnectClient ends the requests and streams in flight of a client
+    static async disconnectClient(id: string): Promise<void> {
+        const res = await fetch(`/api/v1/sessions/disconnect?id=${encodeURIComponent(id)}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    // revokeToken stops accepting the token of a client or a user and disconnects it
+    static async revokeToken(target: { id?: string; user?: string }): Promise<void> {
+        const params = new URLSearchParams();
+        if (target.id) params.set('id', target.id);
+        if (target.user) params.set('user', target.user);
+        const res = await fetch(`/api/v1/sessions/revoke?${params.toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+    }
+
+    // summary returns the volumes, their recent growth and the top directories of dirPath in one call
+    static async summary(dirPath?: string, opts: { top?: number; depth?: number; units?: 'si' | 'binary' } = {}): Promise<Summary> {
+        const params = new URLSearchParams();
+        if (dirPath) params.set('path', dirPath);
+        if (opts.top !== undefined) params.set('top', String(opts.top));
+        if (opts.depth !== undefined) params.set('depth', String(opts.depth));
+        if (opts.units) params.set('units', opts.units);
+        const res = await fetch(`/api/v1/summary?${params.toString()}`);
+        if (!res.ok) {
+            const text = await res.text();
+            throw new Error(text);
+        }
+        return res.json();
     }
 }
@@ -3,7 +3,13 @@ module disk-usage-analyser
 go 1.24.1
 
 require (
+	fyne.io/systray v1.12.2
 	github.com/xhd2015/kool v0.0.99
 	github.com/xhd2015/less-gen v0.0.19
 	github.com/xhd2015/xgo v1.1.14
+	golang.org/x/sys v0.32.0
+	golang.org/x/text v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/godbus/dbus/v5 v5.1.0 // indirect
@@ -1,58 +1,320 @@
 package run
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
+	"disk-usage-analyser/app"
 	"disk-usage-analyser/server"
+	"disk-usage-analyser/server/privileged"
+	"disk-usage-analyser/service"
+	"disk-usage-analyser/tray"
+	"disk-usage-analyser/update"
 
 	"github.com/xhd2015/kool/pkgs/web"
 	"github.com/xhd2015/less-gen/flags"
 )
 
 const help = `
-Usage: disk-usage-analyser <subcommand>
+Usage: disk-usage-analyser [options] [dir]
 
 Subcommands:
-  create    Create a new presentation
+  service   Install the analyser as a background service
+  helper    Run the privileged helper (started automatically by --privileged)
+  update    Replace this executable with the latest release
+  openapi   Print the OpenAPI document of the HTTP API
+  report    Scan a directory and write a standalone HTML report or a summary of it
+
+Options:
+  --port <port>         port to listen on, the next free one is picked on conflict (default: 8080)
+  --strict-port         fail instead of picking another port on conflict
+  --new-instance        start a new server even if one is already running,
+                        by default the running one is opened
+  --host <host>         host to listen on (default: all interfaces)
+  --trusted-proxy <addr>
+                        address or CIDR range of a reverse proxy whose X-Forwarded-For
+                        names the client in the audit log, may be repeated
+  --initial-dir <dir>   directory to show first, same as the [dir] argument
+  --no-open-browser     do not open the browser
+  --rescan-interval <d> rescan the initial dir periodically, e.g. 6h
+  --startup-scan <dir>  scan dir in the background right at start, may be repeated,
+                        more are listed in startup-scans.json of the config directory
+  --profile <name>      default scan profile of the usage view: quick, standard or deep (default: standard)
+  --size-units <units>  include formatted sizes in usage streams: si (kB, MB) or binary (KiB, MiB)
+  --progress-interval <[min,]max>
+                        bounds of how often scans push partial sizes, more often at
+                        first and backing off for long scans (default: 50ms,1s)
+  --app                 open the UI in a desktop app window, quit when it is closed
+  --tray                show a menu bar / tray icon with free space per volume
+                        (on macOS not in builds with CGO_ENABLED=0)
+  --tray-alert-percent <n>
+                        with --tray, notify when a volume has less than n% free (default: 10)
+  --dev                 run with the frontend dev server
+  --component <name>    serve a single component
+  --privileged          start a root helper via sudo to read restricted directories
+  --mft                 on Windows, read NTFS volumes from their Master File Table (needs administrator)
+  --io-uring            experimental, on Linux, stat directory entries in batches through io_uring
+                        (directories are still listed with getdents), used only if a benchmark
+                        on the initial dir is faster
+  --version             print the version and exit
+`
+
+const serviceHelp = `
+Usage: disk-usage-analyser service <install|uninstall|start|stop> [options] [dir]
+
+Registers a launchd agent (macOS) or systemd user unit (Linux) that keeps
+the web UI available on a fixed port and rescans [dir] periodically.
+
+Options for install:
+  --host <host>         host to listen on (default: 127.0.0.1, only this machine)
+  --port <port>         port to listen on (default: 8080)
+  --rescan-interval <d> interval of scheduled scans (default: 6h)
+`
+
+const updateHelp = `
+Usage: disk-usage-analyser update [options]
+
+Downloads the release binary for this OS and architecture from GitHub,
+verifies the signature of the release checksums with the key built into
+this binary and its sha256 against them, and replaces the running
+executable.
+
+Options:
+  --check               only report whether an update is available
+  --version <tag>       install the given release instead of the latest
+  --force               reinstall the current version, or replace a dev build
+`
+
+const reportHelp = `
+Usage: disk-usage-analyser report [options] <dir>
+
+Scans <dir> and writes a single HTML file with a treemap of it and its
+largest files, to be mailed or attached to a ticket. The markdown and pdf
+formats write a short summary for a weekly review instead: the volumes,
+the largest directories and their growth since the previous summary, and
+cleanup recommendations.
+
+Options:
+  -o,--output <file>    file to write (default: stdout)
+  --format <format>     html, markdown or pdf (default: html)
+  --depth <n>           levels of directories of the treemap (default: 6)
+  --top <n>             directories of the summary (default: 20)
+  --size-units <units>  si (kB, MB) or binary (KiB, MiB), by default that of the OS
+`
+
+const helperHelp = `
+Usage: disk-usage-analyser helper --socket <path>
+
+Runs as root and serves ReadDir/Stat and mount requests over a unix socket
+in a directory of mode 0700, to the user who ran sudo only. Exits when stdin
+is closed.
 `
 
 func Run(args []string) error {
+	if len(args) > 0 && args[0] == "helper" {
+		return runHelper(args[1:])
+	}
+	if len(args) > 0 && args[0] == "service" {
+		return runService(args[1:])
+	}
+	if len(args) > 0 && args[0] == "update" {
+		return runUpdate(args[1:])
+	}
+	if len(args) > 0 && args[0] == "report" {
+		return runReport(args[1:])
+	}
+	if len(args) > 0 && args[0] == "openapi" {
+		if len(args) > 1 {
+			return fmt.Errorf("unrecognized extra args: %s", strings.Join(args[1:], " "))
+		}
+		_, err := os.Stdout.Write(server.OpenAPI())
+		return err
+	}
+
 	var devFlag bool
 	var component string
+	var privilegedFlag bool
+	var port int
+	var host string
+	var initialDir string
+	var noOpenBrowser bool
+	var strictPort bool
+	var newInstance bool
+	var rescanInterval time.Duration
+	var startupScans []string
+	var trustedProxies []string
+	var trayFlag bool
+	var appFlag bool
+	var profile string
+	var versionFlag bool
+	var sizeUnits string
+	var progressInterval string
+	var mftFlag bool
+	var ioURingFlag bool
+	trayAlertPercent := 10
 	args, err := flags.
+		Int("--port", &port).
+		String("--host", &host).
+		String("--initial-dir", &initialDir).
+		Bool("--no-open-browser", &noOpenBrowser).
+		Bool("--strict-port", &strictPort).
+		Bool("--new-instance", &newInstance).
+		Duration("--rescan-interval", &rescanInterval).
+		StringSlice("--startup-scan", &startupScans).
+		StringSlice("--trusted-proxy", &trustedProxies).
+		String("--profile", &profile).
+		String("--size-units", &sizeUnits).
+		String("--progress-interval", &progressInterval).
+		Bool("--app", &appFlag).
+		Bool("--tray", &trayFlag).
+		Int("--tray-alert-percent", &trayAlertPercent).
 		Bool("--dev", &devFlag).
 		String("--component", &component).
+		Bool("--privileged", &privilegedFlag).
+		Bool("--mft", &mftFlag).
+		Bool("--io-uring", &ioURingFlag).
+		Bool("--version", &versionFlag).
 		Help("-h,--help", help).
 		Parse(args)
 	if err != nil {
 		return err
 	}
+	if versionFlag {
+		fmt.Println(server.VersionString())
+		return nil
+	}
 
 	if len(args) > 0 {
-		absPath, err := filepath.Abs(args[0])
+		if initialDir != "" {
+			return fmt.Errorf("--initial-dir conflicts with dir argument: %s", args[0])
+		}
+		initialDir = args[0]
+		args = args[1:]
+	}
+	if initialDir != "" {
+		absPath, err := filepath.Abs(initialDir)
 		if err != nil {
-			return fmt.Errorf("invalid path %s: %v", args[0], err)
+			return fmt.Errorf("invalid path %s: %v", initialDir, err)
 		}
 		server.InitialDir = absPath
-		args = args[1:]
 	}
 
 	if len(args) > 0 {
 		return fmt.Errorf("unrecognized extra args: %s", strings.Join(args, " "))
 	}
+	server.StartupScanRoots = startupScans
+	if err := server.SetTrustedProxies(trustedProxies); err != nil {
+		return err
+	}
+	server.DefaultProfile, err = server.ParseProfile(profile)
+	if err != nil {
+		return err
+	}
+	if sizeUnits != "" {
+		server.DefaultSizeUnits, err = server.ParseSizeUnits(sizeUnits)
+		if err != nil {
+			return err
+		}
+	}
+
+	if progressInterval != "" {
+		shortest, longest, err := server.ParseProgressInterval(progressInterval)
+		if err != nil {
+			return err
+		}
+		server.SetProgressInterval(shortest, longest)
+	}
+
+	if mftFlag && runtime.GOOS != "windows" {
+		return fmt.Errorf("--mft is only supported on Windows")
+	}
+	server.UseMFT = mftFlag
+	if ioURingFlag {
+		root := server.InitialDir
+		if root == "" {
+			root, err = os.Getwd()
+			if err != nil {
+				return err
+			}
+		}
+		if err := server.EnableIOURing(root); err != nil {
+			return fmt.Errorf("--io-uring: %v", err)
+		}
+	}
 
 	if component == "list" {
 		fmt.Println("Available components: App")
 		return nil
 	}
 
-	// next port
-	port, err := web.FindAvailablePort(8080, 100)
+	if privilegedFlag {
+		client, helperCmd, err := privileged.Launch()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			client.Close()
+			helperCmd.Wait()
+		}()
+		server.PrivilegedClient = client
+	}
+
+	if port == 0 {
+		port = 8080
+	}
+	// next port free on the address the server listens on
+	requestedPort := port
+	port, err = server.FindAvailablePort(host, requestedPort, 100)
 	if err != nil {
 		return err
 	}
+	if port != requestedPort && !newInstance && !privilegedFlag && !trayFlag && !devFlag && component == "" {
+		// the ports before the free one are in use, an earlier
+		// launch may have picked any of them
+		for p := requestedPort; p < port; p++ {
+			instance := server.FindInstance(server.ServeURL(host, p))
+			if instance == nil {
+				continue
+			}
+			url := server.BrowseURL(instance.URL, server.InitialDir)
+			fmt.Printf("disk-usage-analyser %s is already running at %s (pid %d)\n", instance.Version, instance.URL, instance.PID)
+			if appFlag {
+				return app.Run(url)
+			}
+			if !noOpenBrowser {
+				return web.OpenBrowser(url)
+			}
+			return nil
+		}
+	}
+	if port != requestedPort {
+		if strictPort {
+			return fmt.Errorf("port %d is in use", requestedPort)
+		}
+		fmt.Printf("Port %d is in use, using %d\n", requestedPort, port)
+	}
+
+	if rescanInterval > 0 {
+		root := server.InitialDir
+		if root == "" {
+			root, err = os.Getwd()
+			if err != nil {
+				return err
+			}
+		}
+		server.StartPeriodicScan(root, rescanInterval)
+	}
+	server.StartSpaceHistory()
+	server.StartLogWatch()
+	server.StartRules()
+	server.StartVolumeScans()
+	server.StartPins()
+	server.StartStartupScans()
 
 	if component != "" {
 		var html string
@@ -65,7 +327,9 @@ func Run(args []string) error {
 			}
 		}
 		return server.ServeComponent(port, server.ServeOptions{
-			Dev: devFlag,
+			Dev:           devFlag,
+			Host:          host,
+			NoOpenBrowser: noOpenBrowser,
 			Static: server.StaticOptions{
 				IndexHtml: html,
 			},
@@ -78,5 +342,175 @@ func Run(args []string) error {
 		})
 	}
 
-	return server.Serve(port, devFlag)
+	serveOpts := server.ServeOptions{
+		Dev:           devFlag,
+		Host:          host,
+		NoOpenBrowser: noOpenBrowser || trayFlag || appFlag,
+	}
+	if appFlag && trayFlag {
+		return fmt.Errorf("--app conflicts with --tray")
+	}
+	if appFlag {
+		go func() {
+			err := server.Serve(port, serveOpts)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}()
+		return app.Run(server.ServeURL(host, port))
+	}
+	if trayFlag && !tray.Supported {
+		return fmt.Errorf("--tray is not available in a build without cgo")
+	}
+	if trayFlag {
+		go func() {
+			err := server.Serve(port, serveOpts)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}()
+		rescanRoot := server.InitialDir
+		if rescanRoot == "" {
+			rescanRoot, err = os.Getwd()
+			if err != nil {
+				return err
+			}
+		}
+		tray.Run(tray.Options{
+			URL:          server.ServeURL(host, port),
+			RescanRoot:   rescanRoot,
+			AlertPercent: float64(trayAlertPercent),
+		})
+		return nil
+	}
+
+	return server.Serve(port, serveOpts)
+}
+
+func runService(args []string) error {
+	opts := service.Options{
+		Host:           "127.0.0.1",
+		Port:           8080,
+		RescanInterval: "6h",
+	}
+	args, err := flags.
+		String("--host", &opts.Host).
+		Int("--port", &opts.Port).
+		String("--rescan-interval", &opts.RescanInterval).
+		Help("-h,--help", serviceHelp).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("requires action: install, uninstall, start or stop")
+	}
+	action := args[0]
+	args = args[1:]
+	if _, err := time.ParseDuration(opts.RescanInterval); err != nil {
+		return fmt.Errorf("invalid --rescan-interval: %v", err)
+	}
+	if len(args) > 0 {
+		absPath, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid path %s: %v", args[0], err)
+		}
+		opts.InitialDir = absPath
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unrecognized extra args: %s", strings.Join(args, " "))
+	}
+
+	switch action {
+	case "install":
+		return service.Install(opts)
+	case "uninstall":
+		return service.Uninstall()
+	case "start":
+		return service.Start()
+	case "stop":
+		return service.Stop()
+	default:
+		return fmt.Errorf("unrecognized service action: %s", action)
+	}
+}
+
+func runUpdate(args []string) error {
+	opts := update.Options{CurrentVersion: server.Version}
+	args, err := flags.
+		Bool("--check", &opts.Check).
+		String("--version", &opts.Version).
+		Bool("--force", &opts.Force).
+		Help("-h,--help", updateHelp).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unrecognized extra args: %s", strings.Join(args, " "))
+	}
+	return update.Run(opts)
+}
+
+func runReport(args []string) error {
+	var output string
+	var sizeUnits string
+	var format string
+	var top int
+	opts := server.ReportOptions{}
+	args, err := flags.
+		String("-o,--output", &output).
+		String("--format", &format).
+		Int("--depth", &opts.Depth).
+		Int("--top", &top).
+		String("--size-units", &sizeUnits).
+		Help("-h,--help", reportHelp).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("requires exactly one dir, see --help")
+	}
+	units, err := server.ParseSizeUnits(sizeUnits)
+	if err != nil {
+		return err
+	}
+	opts.Format = &server.SizeFormat{Binary: units == "binary", Decimal: ".", Group: ","}
+	switch format {
+	case "", "html", "markdown", "pdf":
+	default:
+		return fmt.Errorf("unknown format %q, must be html, markdown or pdf", format)
+	}
+
+	w := os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "markdown" || format == "pdf" {
+		return server.WriteSummaryReport(context.Background(), w, args[0], server.SummaryReportOptions{Top: top, Format: opts.Format, PDF: format == "pdf"})
+	}
+	return server.WriteReport(context.Background(), w, args[0], opts)
+}
+
+func runHelper(args []string) error {
+	var socketPath string
+	args, err := flags.
+		String("--socket", &socketPath).
+		Help("-h,--help", helperHelp).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unrecognized extra args: %s", strings.Join(args, " "))
+	}
+	if socketPath == "" {
+		return fmt.Errorf("requires --socket")
+	}
+	return privileged.Serve(socketPath)
 }
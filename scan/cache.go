@@ -0,0 +1,545 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unique"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Cache holds one Entry per scanned directory. Entries live in a tree of
+// name components below the volume roots, so a directory costs an interned
+// base name and a parent pointer instead of its full absolute path.
+// Scans of overlapping trees share the entries of common subdirectories.
+type Cache struct {
+	sync.RWMutex
+	roots map[string]*node // keyed by volume root, "/" or `C:\`
+	// caseFold is set for the mount points whose case sensitivity was
+	// detected, keyed by foldName of their path, see SetCaseInsensitive
+	caseFold map[string]bool
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		roots: make(map[string]*node),
+	}
+}
+
+// node is one path component. Directories passed through on the way to a
+// scanned one have no entry. name and parent never change once set, so
+// Entry.Path can walk them without holding the cache lock. The children are
+// keyed by their NFC names if nfc is set and case folded if fold is set, see
+// key.
+type node struct {
+	name     unique.Handle[string]
+	parent   *node
+	children map[unique.Handle[string]]*node
+	entry    *Entry
+	gen      atomic.Uint64 // of the last change in the subtree, see Generation
+	fold     bool          // the directory is on a case-insensitive volume
+	nfc      bool          // and on one that ignores Unicode normalization
+}
+
+// generation numbers the changes of all caches
+var generation atomic.Uint64
+
+// touch records a change of n's subtree in n and its ancestors
+func (n *node) touch() {
+	g := generation.Add(1)
+	for ; n != nil; n = n.parent {
+		n.gen.Store(g)
+	}
+}
+
+// Entry is the usage of one directory, it is updated while
+// the directory is scanned. Lock it to read the fields.
+type Entry struct {
+	sync.Mutex
+	node      *node
+	ctx       context.Context // of the scan filling the entry, nil once done
+	io        *ioStats        // of the job that scanned the entry, see IOStats
+	cancelled bool            // its scan was cancelled, the sizes are partial
+	Size      int64
+	Count     int64 // Number of entries (files and directories) below Path
+	Done      bool
+	doneAt    time.Time
+	Error     error // ReadDir of the directory failed, the entry is not cached
+	Partial   bool  // a directory of the subtree could not be read, Size is a lower bound
+	Dirs      int64 // Directories below Path, counted in Count
+	Errors    int64 // Directories below Path that could not be read
+	ModTime   time.Time
+	Files     []IndexedFile                            // Direct child files, used by search
+	Stats     Stats                                    // nil unless Options.NewStats is set
+	subs      map[uint64]func(size int64, count int64) // Progress subscribers
+	nextSubID uint64
+	onDone    []func()      // Called once done, see whenDone
+	doneCh    chan struct{} // Closed when done
+}
+
+// IndexedFile is a file remembered from a scan
+type IndexedFile struct {
+	Name    string    `json:"name"` // interned, see Intern
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Intern returns the canonical copy of s, so that the many files sharing
+// a name (index.js, README.md...) share its bytes
+func Intern(s string) string {
+	return unique.Make(s).Value()
+}
+
+// splitPath returns the volume root of path and its name components below it
+func splitPath(path string) (string, []string) {
+	sep := string(os.PathSeparator)
+	path = filepath.Clean(path)
+	root := filepath.VolumeName(path)
+	rest := path[len(root):]
+	if strings.HasPrefix(rest, sep) {
+		root, rest = root+sep, rest[len(sep):]
+	}
+	if rest == "" {
+		return root, nil
+	}
+	return root, strings.Split(rest, sep)
+}
+
+// lookup returns the node of path, creating the missing ones if create is set.
+// The caller holds the read lock, or the write lock if create is set.
+func (c *Cache) lookup(path string, create bool) *node {
+	root, names := splitPath(path)
+	n := c.roots[rootKey(root)]
+	if n == nil {
+		if !create {
+			return nil
+		}
+		n = &node{name: unique.Make(root), fold: caseFoldDefault, nfc: normalizeDefault}
+		c.setForms(n, root, nil)
+		c.roots[rootKey(root)] = n
+	}
+	for i, name := range names {
+		key := n.key(name)
+		child := n.children[key]
+		if child == nil {
+			if !create {
+				return nil
+			}
+			if n.children == nil {
+				n.children = make(map[unique.Handle[string]]*node)
+			}
+			child = &node{name: unique.Make(name), parent: n, fold: n.fold, nfc: n.nfc}
+			c.setForms(child, root, names[:i+1])
+			n.children[key] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// key is the key of a child of n named name, the same for the NFC and NFD
+// forms of the name if nfc is set and for any case of it if fold is set
+func (n *node) key(name string) unique.Handle[string] {
+	if n.nfc && !isASCII(name) {
+		name = norm.NFC.String(name)
+	}
+	if n.fold {
+		name = foldCase(name)
+	}
+	return unique.Make(name)
+}
+
+// foldName is the form of name on a case-insensitive volume
+func foldName(name string) string {
+	return foldCase(NormalizePath(name))
+}
+
+// setForms keys the children of the new node n of the directory
+// root/names as detected if it is a mount point, n has the forms of its
+// parent otherwise
+func (c *Cache) setForms(n *node, root string, names []string) {
+	if len(c.caseFold) == 0 && !nameForms.any.Load() {
+		return
+	}
+	dir := filepath.Join(append([]string{root}, names...)...)
+	if fold, ok := c.caseFold[foldName(dir)]; ok {
+		n.fold = fold
+	}
+	if nfc, ok := normalizedMount(dir); ok {
+		n.nfc = nfc
+	}
+}
+
+// SetCaseInsensitive records whether the volume mounted at mountPoint
+// ignores the case of names, so that the paths differing only in case
+// below it share one entry. Directories inherit it from their parent
+// otherwise, volume roots have the default of the system. The directories
+// of mountPoint cached the other way are dropped, it reports whether there
+// were any: another volume was mounted there, or the first check of the
+// volume found it differs from the default.
+func (c *Cache) SetCaseInsensitive(mountPoint string, insensitive bool) (dropped bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.caseFold == nil {
+		c.caseFold = make(map[string]bool)
+	}
+	mountPoint = filepath.Clean(mountPoint)
+	c.caseFold[foldName(mountPoint)] = insensitive
+	n := c.lookup(mountPoint, false)
+	if n == nil || n.fold == insensitive {
+		return false
+	}
+	c.prune(n)
+	return true
+}
+
+func (c *Cache) GetEntry(path string) *Entry {
+	c.RLock()
+	defer c.RUnlock()
+	if n := c.lookup(path, false); n != nil {
+		return n.entry
+	}
+	return nil
+}
+
+func (c *Cache) GetOrCreateEntry(path string) (*Entry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	n := c.lookup(path, true)
+	if n.entry != nil {
+		return n.entry, true
+	}
+	n.entry = &Entry{
+		node:   n,
+		subs:   make(map[uint64]func(int64, int64)),
+		doneCh: make(chan struct{}),
+	}
+	n.touch()
+	return n.entry, false
+}
+
+// renew puts a new entry in place of e, a finished one to scan again,
+// unless another caller did first. Like GetOrCreateEntry it returns the
+// entry of the path and whether it was there already.
+func (c *Cache) renew(e *Entry) (*Entry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	n := e.node
+	if n.entry != e {
+		if n.entry != nil {
+			return n.entry, true
+		}
+		// removed meanwhile, the next Start scans it
+		return e, true
+	}
+	n.entry = &Entry{
+		node:   n,
+		subs:   make(map[uint64]func(int64, int64)),
+		doneCh: make(chan struct{}),
+	}
+	n.touch()
+	return n.entry, false
+}
+
+// Generation returns a number that changes whenever an entry below path
+// is added, finishes or is removed, 0 if nothing below path is cached.
+// Sizes updated while scanning do not change it.
+func (c *Cache) Generation(path string) uint64 {
+	c.RLock()
+	defer c.RUnlock()
+	if n := c.lookup(path, false); n != nil {
+		return n.gen.Load()
+	}
+	return 0
+}
+
+// Children returns the cached entries whose parent directory is path
+func (c *Cache) Children(path string) []*Entry {
+	c.RLock()
+	defer c.RUnlock()
+	n := c.lookup(path, false)
+	if n == nil {
+		return nil
+	}
+	var children []*Entry
+	for _, child := range n.children {
+		if child.entry != nil {
+			children = append(children, child.entry)
+		}
+	}
+	return children
+}
+
+// Under returns the cached entries of path and all its subdirectories
+func (c *Cache) Under(path string) []*Entry {
+	c.RLock()
+	defer c.RUnlock()
+	n := c.lookup(path, false)
+	if n == nil {
+		return nil
+	}
+	var entries []*Entry
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.entry != nil {
+			entries = append(entries, n.entry)
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(n)
+	return entries
+}
+
+// Invalidate removes the entry for the given path and all its subdirectories
+func (c *Cache) Invalidate(path string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if n := c.lookup(path, false); n != nil {
+		c.prune(n)
+	}
+}
+
+// Clear removes every entry
+func (c *Cache) Clear() {
+	c.Lock()
+	defer c.Unlock()
+	for _, n := range c.roots {
+		c.prune(n)
+	}
+}
+
+// remove drops e from the cache, keeping the entries below it
+func (c *Cache) remove(e *Entry) {
+	c.Lock()
+	defer c.Unlock()
+	if e.node.entry != e {
+		return
+	}
+	e.node.entry = nil
+	e.node.touch()
+	if len(e.node.children) == 0 {
+		c.prune(e.node)
+	}
+}
+
+// prune detaches n and its subtree, then the ancestors that only led to it.
+// The caller holds the write lock.
+func (c *Cache) prune(n *node) {
+	n.touch()
+	for n != nil {
+		if n.parent == nil {
+			if key := rootKey(n.name.Value()); c.roots[key] == n {
+				delete(c.roots, key)
+			}
+			return
+		}
+		// n may have been detached already and its name reused
+		key := n.parent.key(n.name.Value())
+		if n.parent.children[key] != n {
+			return
+		}
+		delete(n.parent.children, key)
+		n = n.parent
+		if n.entry != nil || len(n.children) > 0 {
+			return
+		}
+	}
+}
+
+// scanningAncestor returns the context of the scan of the nearest cached
+// ancestor of e if it is still running, nil otherwise. Once the nearest
+// one is done, its subtree is complete and any scan further up reuses it.
+func (c *Cache) scanningAncestor(e *Entry) context.Context {
+	c.RLock()
+	defer c.RUnlock()
+	for n := e.node.parent; n != nil; n = n.parent {
+		if n.entry == nil {
+			continue
+		}
+		n.entry.Lock()
+		defer n.entry.Unlock()
+		if n.entry.Done {
+			return nil
+		}
+		return n.entry.ctx
+	}
+	return nil
+}
+
+// Path returns the absolute path of the entry's directory
+func (e *Entry) Path() string {
+	var names []string
+	n := e.node
+	for ; n.parent != nil; n = n.parent {
+		names = append(names, n.name.Value())
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return n.name.Value() + strings.Join(names, string(os.PathSeparator))
+}
+
+// Name returns the base name of the entry's directory
+func (e *Entry) Name() string {
+	if e.node.parent == nil {
+		return filepath.Base(e.node.name.Value())
+	}
+	return e.node.name.Value()
+}
+
+func (e *Entry) Subscribe(onProgress func(size int64, count int64)) (unsubscribe func()) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.Done {
+		onProgress(e.Size, e.Count)
+		return func() {}
+	}
+
+	id := e.nextSubID
+	e.nextSubID++
+	e.subs[id] = onProgress
+
+	// Send current size immediately
+	onProgress(e.Size, e.Count)
+
+	return func() {
+		e.Lock()
+		delete(e.subs, id)
+		e.Unlock()
+	}
+}
+
+func (e *Entry) UpdateSize(size int64, count int64) {
+	e.Lock()
+	defer e.Unlock()
+	e.Size = size
+	e.Count = count
+	for _, sub := range e.subs {
+		sub(size, count)
+	}
+}
+
+// SetIndex records the directory's own mtime and its direct child files
+func (e *Entry) SetIndex(modTime time.Time, files []IndexedFile) {
+	e.Lock()
+	defer e.Unlock()
+	e.ModTime = modTime
+	e.Files = files
+}
+
+func (e *Entry) SetStats(stats Stats) {
+	e.Lock()
+	defer e.Unlock()
+	e.Stats = stats
+}
+
+func (e *Entry) GetStats() Stats {
+	e.Lock()
+	defer e.Unlock()
+	return e.Stats
+}
+
+func (e *Entry) MarkDone() {
+	e.Lock()
+	e.Done = true
+	e.doneAt = time.Now()
+	// the context holds the progress and cancel of the whole job, which
+	// a cached entry must not keep alive
+	e.ctx = nil
+	// Final update
+	for _, sub := range e.subs {
+		sub(e.Size, e.Count)
+	}
+	e.subs = nil // Clear subscribers
+	close(e.doneCh)
+	e.node.touch()
+	onDone := e.onDone
+	e.onDone = nil
+	e.Unlock()
+	for _, f := range onDone {
+		f()
+	}
+}
+
+// whenDone calls f once the entry is done, right away if it is. f is
+// called without the entry locked, so that it may finish the parent scan.
+func (e *Entry) whenDone(f func()) {
+	e.Lock()
+	if !e.Done {
+		e.onDone = append(e.onDone, f)
+		e.Unlock()
+		return
+	}
+	e.Unlock()
+	f()
+}
+
+// Usage returns the current size and entry count
+func (e *Entry) Usage() (size int64, count int64) {
+	e.Lock()
+	defer e.Unlock()
+	return e.Size, e.Count
+}
+
+// Outcome returns whether part of the subtree could not be read,
+// and the error of reading the directory itself
+func (e *Entry) Outcome() (partial bool, err error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.Partial, e.Error
+}
+
+// DirCounts returns the number of directories below the entry, the files
+// are the rest of Count, and of those that could not be read
+func (e *Entry) DirCounts() (dirs int64, errors int64) {
+	e.Lock()
+	defer e.Unlock()
+	return e.Dirs, e.Errors
+}
+
+// retryable reports whether e is a finished directory of which a
+// subdirectory could not be read, done at least retryPartialAfter ago
+func (e *Entry) retryable() bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.Done && e.Partial && !e.cancelled && time.Since(e.doneAt) >= retryPartialAfter
+}
+
+// stale reports whether the scan of e was cancelled, even if it has not
+// stopped yet: its sizes are partial and it is dropped once it does
+func (e *Entry) stale() bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.cancelled || (e.ctx != nil && e.ctx.Err() != nil)
+}
+
+func (e *Entry) isCancelled() bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.cancelled
+}
+
+// IsDone reports whether the scan of the entry has finished
+func (e *Entry) IsDone() bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.Done
+}
+
+func (e *Entry) Wait() {
+	<-e.doneCh
+}
+
+// WaitChan is closed once the entry is done
+func (e *Entry) WaitChan() <-chan struct{} {
+	return e.doneCh
+}
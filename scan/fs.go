@@ -0,0 +1,54 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"os"
+)
+
+// FS is the file system a Scanner reads. Besides the local one it may be
+// a bucket, a remote host or an archive presented as a tree. Paths are
+// absolute and built with filepath, so the tree looks like a local one.
+type FS interface {
+	ReadDir(dir string) ([]fs.DirEntry, error)
+	Lstat(path string) (fs.FileInfo, error)
+}
+
+// ContextFS is an FS whose listings may be cancelled, e.g. the requests
+// of a bucket: the Scanner lists directories with the context of their
+// job, done once the job is cancelled.
+type ContextFS interface {
+	FS
+	ReadDirContext(ctx context.Context, dir string) ([]fs.DirEntry, error)
+}
+
+// ReadDirContext lists dir of fsys, with ctx if it is a ContextFS
+func ReadDirContext(ctx context.Context, fsys FS, dir string) ([]fs.DirEntry, error) {
+	if c, ok := fsys.(ContextFS); ok {
+		return c.ReadDirContext(ctx, dir)
+	}
+	return fsys.ReadDir(dir)
+}
+
+// readDirFS is the local file system listed by Options.ReadDir
+type readDirFS func(dir string) ([]fs.DirEntry, error)
+
+func (f readDirFS) ReadDir(dir string) ([]fs.DirEntry, error) { return f(dir) }
+
+func (readDirFS) Lstat(path string) (fs.FileInfo, error) { return OS.Lstat(path) }
+
+// OS is the local file system. Names Windows would not read as they are,
+// e.g. NUL.txt or beyond MAX_PATH, are read by their LocalPath.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) ReadDir(dir string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(localPath(dir))
+	return entries, localError(dir, err)
+}
+
+func (osFS) Lstat(path string) (fs.FileInfo, error) {
+	info, err := os.Lstat(localPath(path))
+	return info, localError(path, err)
+}
@@ -0,0 +1,255 @@
+package scan
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxFinishedJobs is how many finished jobs keep their IOStats for
+// Scanner.JobIOStats
+const maxFinishedJobs = 64
+
+// IOStats are the file system calls of a scan job and how long they took,
+// to tell a slow disk, network mount or virus scanner from a large tree.
+// Call times are summed over the workers, so they may exceed WallMs.
+type IOStats struct {
+	Job       uint64    `json:"job,omitempty"` // 0 for the sum of several
+	Path      string    `json:"path"`
+	StartedAt time.Time `json:"startedAt"`
+	Done      bool      `json:"done"`
+	// WallMs is the time from the start to the last directory read so far
+	WallMs   int64 `json:"wallMs"`
+	ReadDirs int64 `json:"readDirs"`
+	// Stats are the Lstat and Info calls, one per file and directory
+	Stats     int64 `json:"stats"`
+	ReadDirMs int64 `json:"readDirMs"`
+	StatMs    int64 `json:"statMs"`
+	// Levels are the directories of each depth below Path, Path first
+	Levels []LevelIO `json:"levels"`
+}
+
+// LevelIO are the directories at one depth of a scan
+type LevelIO struct {
+	Depth     int   `json:"depth"`
+	Dirs      int64 `json:"dirs"`
+	ReadDirMs int64 `json:"readDirMs"`
+	// Start and End are the first and the last ReadDir of the level,
+	// WallMs the time between them
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	WallMs int64     `json:"wallMs"`
+	// Slowest is the directory of the longest ReadDir, SlowestMs its time
+	Slowest   string `json:"slowest"`
+	SlowestMs int64  `json:"slowestMs"`
+}
+
+// Add counts o, the stats of a job whose path is depth levels below
+// Path, in s
+func (s *IOStats) Add(o IOStats, depth int) {
+	s.ReadDirs += o.ReadDirs
+	s.Stats += o.Stats
+	s.ReadDirMs += o.ReadDirMs
+	s.StatMs += o.StatMs
+	if s.StartedAt.IsZero() || o.StartedAt.Before(s.StartedAt) {
+		s.StartedAt = o.StartedAt
+	}
+	for _, l := range o.Levels {
+		l.Depth += depth
+		for len(s.Levels) <= l.Depth {
+			s.Levels = append(s.Levels, LevelIO{Depth: len(s.Levels)})
+		}
+		m := &s.Levels[l.Depth]
+		m.Dirs += l.Dirs
+		m.ReadDirMs += l.ReadDirMs
+		if m.Start.IsZero() || l.Start.Before(m.Start) {
+			m.Start = l.Start
+		}
+		if l.End.After(m.End) {
+			m.End = l.End
+		}
+		m.WallMs = m.End.Sub(m.Start).Milliseconds()
+		if l.SlowestMs > m.SlowestMs || m.Slowest == "" {
+			m.Slowest, m.SlowestMs = l.Slowest, l.SlowestMs
+		}
+	}
+	var end time.Time
+	for _, l := range s.Levels {
+		if l.End.After(end) {
+			end = l.End
+		}
+	}
+	if !end.IsZero() {
+		s.WallMs = end.Sub(s.StartedAt).Milliseconds()
+	}
+}
+
+// jobIDs numbers the jobs of all scanners
+var jobIDs atomic.Uint64
+
+// ioStats collects the IOStats of a job, its directories record their
+// calls in the one of their context
+type ioStats struct {
+	id    uint64
+	path  string
+	depth int // of path, levels are counted from it
+	start time.Time
+
+	mu          sync.Mutex
+	done        bool
+	end         time.Time
+	readDirs    int64
+	stats       int64
+	readDirTime time.Duration
+	statTime    time.Duration
+	levels      []levelIO
+}
+
+type levelIO struct {
+	dirs        int64
+	readDirTime time.Duration
+	start, end  time.Time
+	slowest     string
+	slowestTime time.Duration
+}
+
+type ioStatsKey struct{}
+
+func withIOStats(ctx context.Context, io *ioStats) context.Context {
+	return context.WithValue(ctx, ioStatsKey{}, io)
+}
+
+func ioStatsFrom(ctx context.Context) *ioStats {
+	if ctx == nil {
+		return nil
+	}
+	io, _ := ctx.Value(ioStatsKey{}).(*ioStats)
+	return io
+}
+
+func newIOStats(path string, start time.Time) *ioStats {
+	return &ioStats{id: jobIDs.Add(1), path: path, depth: pathDepth(path), start: start}
+}
+
+func pathDepth(path string) int {
+	return strings.Count(filepath.Clean(path), string(filepath.Separator))
+}
+
+// readDir records a ReadDir of dirPath that started at start and took d
+func (io *ioStats) readDir(dirPath string, start time.Time, d time.Duration) {
+	if io == nil {
+		return
+	}
+	depth := max(pathDepth(dirPath)-io.depth, 0)
+	end := start.Add(d)
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	io.readDirs++
+	io.readDirTime += d
+	for len(io.levels) <= depth {
+		io.levels = append(io.levels, levelIO{})
+	}
+	l := &io.levels[depth]
+	l.dirs++
+	l.readDirTime += d
+	if l.start.IsZero() || start.Before(l.start) {
+		l.start = start
+	}
+	if end.After(l.end) {
+		l.end = end
+	}
+	if end.After(io.end) {
+		io.end = end
+	}
+	if d > l.slowestTime || l.slowest == "" {
+		l.slowest, l.slowestTime = dirPath, d
+	}
+}
+
+// stat records an Lstat or Info call that took d
+func (io *ioStats) stat(d time.Duration) {
+	if io == nil {
+		return
+	}
+	io.mu.Lock()
+	io.stats++
+	io.statTime += d
+	io.mu.Unlock()
+}
+
+func (io *ioStats) finish() {
+	io.mu.Lock()
+	io.done = true
+	io.mu.Unlock()
+}
+
+func (io *ioStats) snapshot() IOStats {
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	s := IOStats{
+		Job:       io.id,
+		Path:      io.path,
+		StartedAt: io.start,
+		Done:      io.done,
+		ReadDirs:  io.readDirs,
+		Stats:     io.stats,
+		ReadDirMs: io.readDirTime.Milliseconds(),
+		StatMs:    io.statTime.Milliseconds(),
+		Levels:    make([]LevelIO, 0, len(io.levels)),
+	}
+	if !io.end.IsZero() {
+		s.WallMs = io.end.Sub(io.start).Milliseconds()
+	}
+	for depth, l := range io.levels {
+		s.Levels = append(s.Levels, LevelIO{
+			Depth:     depth,
+			Dirs:      l.dirs,
+			ReadDirMs: l.readDirTime.Milliseconds(),
+			Start:     l.start,
+			End:       l.end,
+			WallMs:    l.end.Sub(l.start).Milliseconds(),
+			Slowest:   l.slowest,
+			SlowestMs: l.slowestTime.Milliseconds(),
+		})
+	}
+	return s
+}
+
+// IOStats returns the statistics of the job, so far if it is running
+func (j *Job) IOStats() IOStats {
+	return j.io.snapshot()
+}
+
+// IOStats returns the statistics of the job that scanned e, which covers
+// more than e if it was started for an ancestor. ok is false for an entry
+// that was not scanned, e.g. restored from a snapshot.
+func (e *Entry) IOStats() (stats IOStats, ok bool) {
+	e.Lock()
+	io := e.io
+	e.Unlock()
+	if io == nil {
+		return IOStats{}, false
+	}
+	return io.snapshot(), true
+}
+
+// JobIOStats returns the statistics of the running or recently finished
+// job id
+func (s *Scanner) JobIOStats(id uint64) (IOStats, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job.io.snapshot(), true
+		}
+	}
+	for _, io := range s.finished {
+		if io.id == id {
+			return io.snapshot(), true
+		}
+	}
+	return IOStats{}, false
+}
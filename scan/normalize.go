@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeDefault is whether the volumes whose handling of Unicode
+// normalization is not known ignore it: APFS and HFS+ return decomposed
+// (NFD) names, while typed and pasted paths are mostly composed (NFC), and
+// both open the same file. Elsewhere the two forms are different names.
+const normalizeDefault = runtime.GOOS == "darwin"
+
+// caseFoldDefault is whether the volumes whose case sensitivity is not
+// known ignore the case of names, as the default file systems of macOS and
+// Windows do
+const caseFoldDefault = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// nameForms records the mount points whose handling of normalization was
+// detected, see SetNormalizationInsensitive
+var nameForms struct {
+	sync.RWMutex
+	nfc map[string]bool // keyed by the NFC form of the mount point
+	// any is set once nfc is not empty, checked without the lock
+	any atomic.Bool
+}
+
+// SetNormalizationInsensitive records whether the volume mounted at
+// mountPoint takes the NFC and NFD forms of a name for the same file. The
+// paths below it are compared and keyed accordingly, other paths by the
+// volume they are on, or the default of the system. It reports whether
+// that changed, the caches of mountPoint should be invalidated then as
+// their directories are keyed the previous way.
+func SetNormalizationInsensitive(mountPoint string, insensitive bool) (changed bool) {
+	key := norm.NFC.String(filepath.Clean(mountPoint))
+	nameForms.Lock()
+	defer nameForms.Unlock()
+	old, ok := nameForms.nfc[key]
+	if !ok {
+		old = normalizesAt(key)
+	}
+	if nameForms.nfc == nil {
+		nameForms.nfc = make(map[string]bool)
+	}
+	nameForms.nfc[key] = insensitive
+	nameForms.any.Store(true)
+	return old != insensitive
+}
+
+// normalizesAt reports whether the volume of path, in its NFC form, ignores
+// normalization. The caller holds nameForms.
+func normalizesAt(path string) bool {
+	normalizes, best := normalizeDefault, -1
+	sep := string(filepath.Separator)
+	for mountPoint, nfc := range nameForms.nfc {
+		if len(mountPoint) > best && (path == mountPoint || strings.HasPrefix(path, strings.TrimSuffix(mountPoint, sep)+sep)) {
+			normalizes, best = nfc, len(mountPoint)
+		}
+	}
+	return normalizes
+}
+
+// normalizedMount returns whether the mount point dir ignores normalization,
+// ok is false if dir is none or not detected
+func normalizedMount(dir string) (nfc bool, ok bool) {
+	if !nameForms.any.Load() {
+		return false, false
+	}
+	nameForms.RLock()
+	defer nameForms.RUnlock()
+	nfc, ok = nameForms.nfc[norm.NFC.String(dir)]
+	return nfc, ok
+}
+
+// NormalizePath returns the form path is compared and keyed by: NFC on
+// volumes that ignore normalization, path itself on the others. It is a
+// key only, the file system gets paths in the form they were found or
+// typed in, e.g. Entry.Path is the one first seen.
+func NormalizePath(path string) string {
+	if isASCII(path) {
+		return path
+	}
+	nfc := norm.NFC.String(path)
+	nameForms.RLock()
+	normalizes := normalizesAt(nfc)
+	nameForms.RUnlock()
+	if normalizes {
+		return nfc
+	}
+	return path
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// foldCase is the simple Unicode case folding of s: the same for the names
+// strings.EqualFold takes for equal, e.g. Straße and STRAẞE or a K and the
+// Kelvin sign, which lower-casing alone does not match
+func foldCase(s string) string {
+	if isASCII(s) {
+		return strings.ToLower(s)
+	}
+	return strings.Map(foldRune, s)
+}
+
+// foldRune is the lower case of the smallest rune of the case orbit of r,
+// see unicode.SimpleFold, which is the lower case of an ASCII letter
+func foldRune(r rune) rune {
+	smallest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		smallest = min(smallest, f)
+	}
+	return unicode.ToLower(smallest)
+}
+
+// rootKey is the key of a volume root, Windows drive letters and shares
+// are the same in any case
+func rootKey(root string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(root)
+	}
+	return root
+}
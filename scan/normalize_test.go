@@ -0,0 +1,116 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	cafeNFC = "Caf\u00e9"
+	cafeNFD = "Cafe\u0301"
+)
+
+// resetNameForms forgets the volumes recorded by the test
+func resetNameForms(t *testing.T) {
+	t.Cleanup(func() {
+		nameForms.Lock()
+		nameForms.nfc = nil
+		nameForms.Unlock()
+	})
+}
+
+func TestNormalizePathPerVolume(t *testing.T) {
+	resetNameForms(t)
+	SetNormalizationInsensitive("/apfs", true)
+	SetNormalizationInsensitive("/apfs/share", false)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/apfs/" + cafeNFD, "/apfs/" + cafeNFC},
+		{"/apfs/" + cafeNFC, "/apfs/" + cafeNFC},
+		{"/apfs/share/" + cafeNFD, "/apfs/share/" + cafeNFD},
+		{"/apfs/sharex/" + cafeNFD, "/apfs/sharex/" + cafeNFC},
+		{"/apfs/plain", "/apfs/plain"},
+	}
+	for _, tt := range tests {
+		if got := NormalizePath(tt.path); got != tt.want {
+			t.Errorf("NormalizePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSetNormalizationInsensitiveChanged(t *testing.T) {
+	resetNameForms(t)
+	if got := SetNormalizationInsensitive("/vol", !normalizeDefault); !got {
+		t.Errorf("first detection differing from the default: changed = false")
+	}
+	if got := SetNormalizationInsensitive("/vol", !normalizeDefault); got {
+		t.Errorf("same detection again: changed = true")
+	}
+}
+
+func TestCacheMixedNormalization(t *testing.T) {
+	resetNameForms(t)
+	SetNormalizationInsensitive("/apfs", true)
+	SetNormalizationInsensitive("/ext4", false)
+
+	c := NewCache()
+	nfd, _ := c.GetOrCreateEntry("/apfs/" + cafeNFD + "/x")
+	if got := c.GetEntry("/apfs/" + cafeNFC + "/x"); got != nfd {
+		t.Errorf("NFC path on a normalizing volume: got %v, want the entry of the NFD path", got)
+	}
+	if got, want := nfd.Path(), "/apfs/"+cafeNFD+"/x"; got != want {
+		t.Errorf("Path() = %q, want the form first seen %q", got, want)
+	}
+
+	a, _ := c.GetOrCreateEntry("/ext4/" + cafeNFD)
+	b, _ := c.GetOrCreateEntry("/ext4/" + cafeNFC)
+	if a == b {
+		t.Errorf("NFC and NFD names on a volume telling them apart share an entry")
+	}
+	if got := len(c.Children("/ext4")); got != 2 {
+		t.Errorf("children of /ext4 = %d, want 2", got)
+	}
+}
+
+// TestScanMixedNormalization scans a directory holding a name in both forms,
+// two directories wherever the file system tells them apart
+func TestScanMixedNormalization(t *testing.T) {
+	resetNameForms(t)
+	root := t.TempDir()
+	for _, name := range []string{cafeNFC, cafeNFD} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name, "f"), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Skip("the file system of the temp dir does not keep both forms")
+	}
+	SetNormalizationInsensitive(root, false)
+
+	s := New(NewCache(), Options{})
+	s.Scan(context.Background(), root, func(int64, int64) {})
+	for _, name := range []string{cafeNFC, cafeNFD} {
+		e := s.Cache().GetEntry(filepath.Join(root, name))
+		if e == nil {
+			t.Fatalf("%q is not cached", name)
+		}
+		if got := e.Path(); got != filepath.Join(root, name) {
+			t.Errorf("Path() = %q, want %q", got, filepath.Join(root, name))
+		}
+		if size, count := e.Usage(); size != 100 || count != 1 {
+			t.Errorf("usage of %q = %d bytes, %d files, want 100 bytes, 1 file", name, size, count)
+		}
+	}
+}
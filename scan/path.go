@@ -0,0 +1,19 @@
+package scan
+
+// CleanPath returns path without the \\?\ prefix of a Windows
+// extended-length path, \\?\UNC\server\share as \\server\share, so that a
+// directory has one entry in the cache whichever form a request used. The
+// OS adds the prefix back where a path needs it, see LocalPath. Other
+// paths, and all paths on other systems, are returned as they are.
+func CleanPath(path string) string {
+	return cleanPath(path)
+}
+
+// LocalPath returns the name the OS is asked for path by. On Windows an
+// absolute path gets the extended-length form, which Win32 takes as it is:
+// a name it would map to a device (CON, NUL, COM1.txt...) or trim (a
+// trailing dot or space) reaches the file, and no path is too long. On
+// other systems it is path.
+func LocalPath(path string) string {
+	return localPath(path)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package scan
+
+func cleanPath(path string) string {
+	return path
+}
+
+func localPath(path string) string {
+	return path
+}
+
+func localError(path string, err error) error {
+	return err
+}
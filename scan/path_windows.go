@@ -0,0 +1,58 @@
+package scan
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	extendedPrefix    = `\\?\`
+	extendedUNCPrefix = `\\?\UNC\`
+)
+
+func cleanPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, extendedUNCPrefix):
+		return `\\` + path[len(extendedUNCPrefix):]
+	case strings.HasPrefix(path, extendedPrefix) && driveLetterPath(path[len(extendedPrefix):]):
+		return path[len(extendedPrefix):]
+	}
+	// \\?\Volume{...}\ names have no other form
+	return path
+}
+
+// driveLetterPath reports whether path starts with a drive letter, C:
+func driveLetterPath(path string) bool {
+	if len(path) < 2 || path[1] != ':' {
+		return false
+	}
+	c := path[0] | 0x20
+	return 'a' <= c && c <= 'z'
+}
+
+func localPath(path string) string {
+	if strings.HasPrefix(path, extendedPrefix) || !filepath.IsAbs(path) {
+		return path
+	}
+	// the extended form is not cleaned by Win32, it must be here
+	path = filepath.Clean(path)
+	switch {
+	case driveLetterPath(path):
+		return extendedPrefix + path
+	case strings.HasPrefix(path, `\\`) && !strings.HasPrefix(path, `\\.\`):
+		return extendedUNCPrefix + path[2:]
+	}
+	return path
+}
+
+// localError names path in the errors of the OS rather than its
+// extended-length form
+func localError(path string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		pe.Path = path
+	}
+	return err
+}
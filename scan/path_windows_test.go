@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`\\?\C:\Users\me`, `C:\Users\me`},
+		{`\\?\c:\`, `c:\`},
+		{`\\?\UNC\server\share\dir`, `\\server\share\dir`},
+		// a volume GUID path has no other form
+		{`\\?\Volume{26a21bda-a627-11d7-9931-806e6f6e6963}\dir`, `\\?\Volume{26a21bda-a627-11d7-9931-806e6f6e6963}\dir`},
+		{`\\.\pipe\name`, `\\.\pipe\name`},
+		{`C:\Users\me`, `C:\Users\me`},
+		{`\\server\share`, `\\server\share`},
+		{`dir\file`, `dir\file`},
+	}
+	for _, tt := range tests {
+		if got := CleanPath(tt.path); got != tt.want {
+			t.Errorf("CleanPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`C:\Users\me`, `\\?\C:\Users\me`},
+		// names Win32 maps to devices or trims reach the file
+		{`C:\dir\NUL.txt`, `\\?\C:\dir\NUL.txt`},
+		{`C:\dir\CON`, `\\?\C:\dir\CON`},
+		{`C:\dir\COM1.log`, `\\?\C:\dir\COM1.log`},
+		{`C:\dir\trailing.`, `\\?\C:\dir\trailing.`},
+		{`C:\dir\trailing `, `\\?\C:\dir\trailing `},
+		// the extended form is not cleaned by Win32
+		{`C:\a\..\b\.\c\`, `\\?\C:\b\c`},
+		{`C:/a/b`, `\\?\C:\a\b`},
+		// a junction is named by its own path, the OS resolves it
+		{`C:\Users\me\Application Data`, `\\?\C:\Users\me\Application Data`},
+		{`C:\Documents and Settings\me`, `\\?\C:\Documents and Settings\me`},
+		{`\\server\share\dir`, `\\?\UNC\server\share\dir`},
+		{`\\?\C:\already`, `\\?\C:\already`},
+		{`\\?\UNC\server\share`, `\\?\UNC\server\share`},
+		{`\\.\pipe\name`, `\\.\pipe\name`},
+		{`dir\file`, `dir\file`},
+		{`\rooted`, `\rooted`},
+	}
+	for _, tt := range tests {
+		if got := LocalPath(tt.path); got != tt.want {
+			t.Errorf("LocalPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCleanPathOfLocalPath(t *testing.T) {
+	for _, path := range []string{`C:\Users\me`, `C:\dir\NUL.txt`, `D:\`, `\\server\share\dir`} {
+		if got := CleanPath(LocalPath(path)); got != filepath.Clean(path) {
+			t.Errorf("CleanPath(LocalPath(%q)) = %q, want %q", path, got, filepath.Clean(path))
+		}
+	}
+}
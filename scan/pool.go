@@ -0,0 +1,149 @@
+package scan
+
+import (
+	"context"
+	"sync"
+)
+
+// dirTask is a directory waiting to be read
+type dirTask struct {
+	scanner  *Scanner
+	ctx      context.Context
+	path     string
+	entry    *Entry
+	priority Priority
+	quota    chan struct{}
+}
+
+// taskGroup are the tasks of one quota, nil for the tasks without one. It
+// is a stack: the last directory found is read first, so a scan goes deep
+// before wide, finishes subtrees early and queues about depth times
+// fan-out directories rather than a whole level of the tree.
+type taskGroup struct {
+	quota chan struct{}
+	tasks []*dirTask
+}
+
+// pool reads the directories of a Scanner with at most size workers, and
+// no goroutine per directory: a worker takes a task, reads it, queues the
+// subdirectories found and takes the next one. Workers start when tasks
+// are queued and stop when none can be taken.
+//
+// An interactive task is taken first, and background tasks leave a quarter
+// of the workers free, so that a directory opened in the UI does not queue
+// behind a full volume scan. The tasks of a quota (see WithQuota) are
+// taken while fewer than its size run, those of other quotas meanwhile.
+type pool struct {
+	mu         sync.Mutex
+	size       int
+	reserved   int
+	workers    int
+	background int
+	groups     [2][]*taskGroup
+	// next rotates the groups a task is taken from first
+	next [2]int
+	run  func(*dirTask)
+}
+
+func newPool(size int, run func(*dirTask)) *pool {
+	return &pool{size: size, reserved: size / 4, run: run}
+}
+
+// push queues t and starts a worker if one may take it
+func (p *pool) push(t *dirTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	groups := p.groups[t.priority]
+	var g *taskGroup
+	for _, group := range groups {
+		if group.quota == t.quota {
+			g = group
+			break
+		}
+	}
+	if g == nil {
+		g = &taskGroup{quota: t.quota}
+		p.groups[t.priority] = append(groups, g)
+	}
+	g.tasks = append(g.tasks, t)
+	if p.workers < p.size && (t.priority == Interactive || p.background < p.size-p.reserved) {
+		p.workers++
+		go p.work()
+	}
+}
+
+func (p *pool) work() {
+	for {
+		p.mu.Lock()
+		t, read := p.take()
+		if t == nil {
+			p.workers--
+			p.mu.Unlock()
+			return
+		}
+		background := read && t.priority == Background
+		if background {
+			p.background++
+		}
+		p.mu.Unlock()
+
+		p.run(t)
+		if read && t.quota != nil {
+			<-t.quota
+		}
+		if background {
+			p.mu.Lock()
+			p.background--
+			p.mu.Unlock()
+		}
+	}
+}
+
+// take returns the next task, read is false for one whose scan was
+// cancelled meanwhile, which takes no worker time. Callers hold p.mu.
+func (p *pool) take() (t *dirTask, read bool) {
+	for priority := range p.groups {
+		for _, g := range p.groups[priority] {
+			if n := len(g.tasks); n > 0 && g.tasks[n-1].ctx.Err() != nil {
+				return p.pop(Priority(priority), g), false
+			}
+		}
+	}
+	for _, priority := range []Priority{Interactive, Background} {
+		if priority == Background && p.background >= p.size-p.reserved {
+			break
+		}
+		groups := p.groups[priority]
+		for i := range groups {
+			g := groups[(p.next[priority]+i)%len(groups)]
+			if g.quota != nil {
+				select {
+				case g.quota <- struct{}{}:
+				default:
+					continue
+				}
+			}
+			p.next[priority]++
+			return p.pop(priority, g), true
+		}
+	}
+	return nil, false
+}
+
+// pop takes the top task of g, dropping g once empty. Callers hold p.mu.
+func (p *pool) pop(priority Priority, g *taskGroup) *dirTask {
+	n := len(g.tasks)
+	t := g.tasks[n-1]
+	g.tasks[n-1] = nil
+	g.tasks = g.tasks[:n-1]
+	if len(g.tasks) == 0 {
+		groups := p.groups[priority]
+		for i, group := range groups {
+			if group == g {
+				p.groups[priority] = append(groups[:i], groups[i+1:]...)
+				break
+			}
+		}
+	}
+	return t
+}
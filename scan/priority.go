@@ -0,0 +1,87 @@
+package scan
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Priority orders the directories of scans waiting for a worker
+type Priority int
+
+const (
+	// Interactive scans are those a user waits for, the default
+	Interactive Priority = iota
+	// Background scans, e.g. scheduled rescans, get a worker only when no
+	// interactive scan waits for one, and never the reserved ones
+	Background
+)
+
+func (p Priority) String() string {
+	if p == Background {
+		return "background"
+	}
+	return "interactive"
+}
+
+// ParsePriority parses interactive or background, "" is Interactive
+func ParsePriority(s string) (Priority, bool) {
+	switch s {
+	case "", "interactive":
+		return Interactive, true
+	case "background":
+		return Background, true
+	}
+	return Interactive, false
+}
+
+type priorityKey struct{}
+
+// WithPriority sets the priority of the scans started under ctx
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityOf returns the priority set by WithPriority, Interactive by default
+func PriorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// boosts are the paths interactive callers wait for, refcounted.
+// Directories below them are read at interactive priority even when the
+// scan they belong to is a background one.
+type boosts struct {
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+func (b *boosts) add(path string) func() {
+	b.mu.Lock()
+	if b.paths == nil {
+		b.paths = make(map[string]int)
+	}
+	b.paths[path]++
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		if b.paths[path]--; b.paths[path] <= 0 {
+			delete(b.paths, path)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *boosts) covers(dir string) bool {
+	dir = NormalizePath(dir)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for p := range b.paths {
+		p = NormalizePath(p)
+		if dir == p || strings.HasPrefix(dir, strings.TrimSuffix(p, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
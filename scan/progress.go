@@ -0,0 +1,166 @@
+package scan
+
+import (
+	"container/heap"
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinProgressInterval and DefaultMaxProgressInterval bound how
+	// often the directories of a scan push their partial sizes
+	DefaultMinProgressInterval = 50 * time.Millisecond
+	DefaultMaxProgressInterval = time.Second
+	// progressBackoff relates the interval to how long the scan has run: it
+	// is a sixteenth of that, so a quick scan shows sizes right away and a
+	// long one is not flushed more often than anyone can follow
+	progressBackoff = 16
+)
+
+// progress coalesces the partial sizes of the directories of one job.
+// Instead of a ticker per directory, it flushes the changed directories
+// together, deepest first, so that a change reaches the root of the job
+// in one flush rather than one tick per level.
+type progress struct {
+	min, max time.Duration
+	start    time.Time
+
+	mu     sync.Mutex
+	active int
+	dirty  dirtyDirs
+	stop   chan struct{}
+}
+
+// dirProgress is a directory being scanned, flush pushes its partial size
+type dirProgress struct {
+	p     *progress
+	depth int
+	flush func()
+	// queued and done are guarded by p.mu
+	queued bool
+	done   bool
+}
+
+type progressKey struct{}
+
+func withProgress(ctx context.Context, p *progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+func progressFrom(ctx context.Context) *progress {
+	p, _ := ctx.Value(progressKey{}).(*progress)
+	return p
+}
+
+func (s *Scanner) newProgress() *progress {
+	return &progress{min: s.opts.MinProgressInterval, max: s.opts.MaxProgressInterval, start: time.Now()}
+}
+
+// SetProgressInterval changes the bounds of the progress interval of the
+// scans started afterwards, 0 keeps a bound. It is meant to be called
+// before scanning.
+func (s *Scanner) SetProgressInterval(shortest, longest time.Duration) {
+	if shortest > 0 {
+		s.opts.MinProgressInterval = shortest
+	}
+	if longest > 0 {
+		s.opts.MaxProgressInterval = longest
+	}
+	s.opts.MinProgressInterval = min(s.opts.MinProgressInterval, s.opts.MaxProgressInterval)
+}
+
+// interval is the time to the next flush: short at first, growing with
+// the time the job has run
+func (p *progress) interval() time.Duration {
+	d := time.Since(p.start) / progressBackoff
+	return max(p.min, min(d, p.max))
+}
+
+// add registers a directory of the job, flush is called with its partial
+// size while it is dirty until remove
+func (p *progress) add(dirPath string, flush func()) *dirProgress {
+	d := &dirProgress{p: p, depth: strings.Count(dirPath, string(filepath.Separator)), flush: flush}
+	p.mu.Lock()
+	p.active++
+	if p.active == 1 {
+		p.stop = make(chan struct{})
+		go p.run(p.stop)
+	}
+	p.mu.Unlock()
+	return d
+}
+
+// markDirty queues d for the next flush
+func (d *dirProgress) markDirty() {
+	p := d.p
+	p.mu.Lock()
+	if !d.queued && !d.done {
+		d.queued = true
+		heap.Push(&p.dirty, d)
+	}
+	p.mu.Unlock()
+}
+
+// remove unregisters d, its final size is pushed by its scan. The flushes
+// stop with the last directory of the job.
+func (d *dirProgress) remove() {
+	p := d.p
+	p.mu.Lock()
+	d.done = true
+	p.active--
+	if p.active == 0 {
+		close(p.stop)
+	}
+	p.mu.Unlock()
+}
+
+func (p *progress) run(stop chan struct{}) {
+	timer := time.NewTimer(p.interval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		p.flush()
+		timer.Reset(p.interval())
+	}
+}
+
+// flush pushes the sizes of the dirty directories, deepest first. The
+// parents they make dirty are shallower and pushed in the same flush.
+func (p *progress) flush() {
+	for {
+		p.mu.Lock()
+		if p.dirty.Len() == 0 {
+			p.mu.Unlock()
+			return
+		}
+		d := heap.Pop(&p.dirty).(*dirProgress)
+		d.queued = false
+		done := d.done
+		p.mu.Unlock()
+		if !done {
+			d.flush()
+		}
+	}
+}
+
+// dirtyDirs is a heap of directories, the deepest on top
+type dirtyDirs []*dirProgress
+
+func (h dirtyDirs) Len() int           { return len(h) }
+func (h dirtyDirs) Less(i, j int) bool { return h[i].depth > h[j].depth }
+func (h dirtyDirs) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *dirtyDirs) Push(x any)        { *h = append(*h, x.(*dirProgress)) }
+func (h *dirtyDirs) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return d
+}
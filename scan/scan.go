@@ -0,0 +1,501 @@
+// Package scan computes recursive directory sizes.
+//
+// A Scanner fills a Cache with one Entry per directory. Entries are
+// updated while their subtree is scanned, so callers can subscribe
+// to progress, and concurrent scans of overlapping trees share work.
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultConcurrency is the default limit of concurrent ReadDir calls
+const DefaultConcurrency = 20
+
+// retryPartialAfter is how long a directory that could not be read whole
+// stays cached before Start reads again what is missing
+const retryPartialAfter = 10 * time.Second
+
+// Stats is an aggregate computed over a directory's whole subtree
+// alongside its size, e.g. bytes per owner. It is complete once the
+// entry is done.
+type Stats interface {
+	// AddFile accounts a regular file directly in the directory
+	AddFile(info fs.FileInfo)
+	// Add merges the stats of a finished subdirectory
+	Add(child Stats)
+}
+
+// PathStats is implemented by Stats that need the path of a file, e.g. to
+// read its extended attributes. AddFileAt is called instead of AddFile.
+type PathStats interface {
+	Stats
+	// AddFileAt accounts a file directly in the directory, path is its full path
+	AddFileAt(path string, info fs.FileInfo)
+}
+
+type Options struct {
+	// Concurrency is the number of workers reading directories, which
+	// limits concurrent ReadDir calls, DefaultConcurrency when 0
+	Concurrency int
+	// FS is the file system scanned, OS when nil
+	FS FS
+	// ReadDir lists the directories of the local file system when FS is
+	// nil.
+	//
+	// Deprecated: set FS, which lists directories and stats them.
+	ReadDir func(dir string) ([]fs.DirEntry, error)
+	// OnError is called for directories that cannot be read
+	OnError func(dir string, err error)
+	// NewStats creates the Stats of each directory, no stats when nil
+	NewStats func() Stats
+	// Skip leaves an entry of dir out of the scan, nothing is skipped when nil
+	Skip func(dir string, e fs.DirEntry) bool
+	// CountFile decides whether a file adds to the sizes, e.g. to count
+	// hard linked files once, every file counts when nil
+	CountFile func(path string, info fs.FileInfo) bool
+	// MinProgressInterval and MaxProgressInterval bound how often the
+	// partial sizes of a scan are pushed, more often at first and backing
+	// off as it runs on. DefaultMinProgressInterval and
+	// DefaultMaxProgressInterval when 0.
+	MinProgressInterval time.Duration
+	MaxProgressInterval time.Duration
+}
+
+type Scanner struct {
+	cache *Cache
+	opts  Options
+	pool  *pool
+	// boosts are the paths interactive callers wait for
+	boosts boosts
+
+	mu   sync.Mutex
+	jobs map[*Entry]*Job
+	// finished are the IOStats of the last jobs done, oldest first
+	finished []*ioStats
+}
+
+// New creates a Scanner that stores its results in cache,
+// a new Cache is used when cache is nil
+func New(cache *Cache, opts Options) *Scanner {
+	if cache == nil {
+		cache = NewCache()
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.FS == nil && opts.ReadDir != nil {
+		opts.FS = readDirFS(opts.ReadDir)
+	}
+	if opts.FS == nil {
+		opts.FS = OS
+	}
+	if opts.MaxProgressInterval <= 0 {
+		opts.MaxProgressInterval = DefaultMaxProgressInterval
+	}
+	if opts.MinProgressInterval <= 0 {
+		opts.MinProgressInterval = min(DefaultMinProgressInterval, opts.MaxProgressInterval)
+	}
+	s := &Scanner{
+		cache: cache,
+		opts:  opts,
+		jobs:  make(map[*Entry]*Job),
+	}
+	s.pool = newPool(opts.Concurrency, func(t *dirTask) { t.scanner.readDir(t) })
+	return s
+}
+
+func (s *Scanner) Cache() *Cache {
+	return s.cache
+}
+
+// FS is the file system the Scanner reads
+func (s *Scanner) FS() FS {
+	return s.opts.FS
+}
+
+// Concurrency is the limit of concurrent ReadDir calls
+func (s *Scanner) Concurrency() int {
+	return s.pool.size
+}
+
+// Job is a scan that owns its context: the scan of a path that no
+// running scan of an ancestor covers. It runs until it completes or
+// is cancelled by Cancel, whoever started it.
+type Job struct {
+	// ID identifies the job among those of all scanners, see JobIOStats
+	ID        uint64
+	Path      string
+	StartedAt time.Time
+	Entry     *Entry
+	// Priority is that of the caller that started the job
+	Priority Priority
+
+	cancel context.CancelFunc
+	io     *ioStats
+}
+
+// Start returns the entry of path, starting a scan in the
+// background unless the entry is cached already.
+//
+// The scan does not stop with ctx, which only passes its values (e.g.
+// WithQuota) on: the entry is shared and other callers may wait for it.
+// It runs as a Job, or as part of the job of a running ancestor scan
+// that would reach path anyway. Once done the entry is complete, unless
+// its job was cancelled: then the unfinished entries are dropped from
+// the cache so the next Start scans them again, and ancestor scans of
+// other jobs start them over. A Start made while the cancelled job
+// still winds down does not get its entries either but a new scan.
+//
+// A directory that could not be read is not cached either, its ancestors
+// are Partial. Starting one of them again, retryPartialAfter after it was
+// done, lists it anew: its complete subdirectories are still cached, so
+// only the Partial ones down to the unreadable directory are read again.
+func (s *Scanner) Start(ctx context.Context, path string) *Entry {
+	entry, exists := s.cache.GetOrCreateEntry(path)
+	if exists && (entry.retryable() || entry.stale()) {
+		entry, exists = s.cache.renew(entry)
+	}
+	if exists {
+		return entry
+	}
+	if owner := s.cache.scanningAncestor(entry); owner != nil && owner.Err() == nil {
+		ctx = owner
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		// the directories of the job push their sizes together
+		ctx = withProgress(ctx, s.newProgress())
+		// and count their file system calls together
+		io := newIOStats(path, time.Now())
+		ctx = withIOStats(ctx, io)
+		job := &Job{ID: io.id, Path: path, StartedAt: io.start, Entry: entry, Priority: PriorityOf(ctx), cancel: cancel, io: io}
+		s.mu.Lock()
+		s.jobs[entry] = job
+		s.mu.Unlock()
+	}
+	entry.Lock()
+	entry.ctx = ctx
+	entry.io = ioStatsFrom(ctx)
+	entry.Unlock()
+	s.queue(ctx, path, entry)
+	return entry
+}
+
+// Jobs returns the running jobs, oldest first
+func (s *Scanner) Jobs() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+// Cancel stops the job scanning path, it reports whether there was one
+func (s *Scanner) Cancel(path string) bool {
+	entry := s.cache.GetEntry(path)
+	s.mu.Lock()
+	job := s.jobs[entry]
+	s.mu.Unlock()
+	if job == nil {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// finishJob forgets the job of entry, if it has one, keeping its IOStats
+// with those of the last maxFinishedJobs. Its context is not cancelled,
+// scans that joined it may outlive it.
+func (s *Scanner) finishJob(entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[entry]
+	if job == nil {
+		return
+	}
+	delete(s.jobs, entry)
+	job.io.finish()
+	s.finished = append(s.finished, job.io)
+	if len(s.finished) > maxFinishedJobs {
+		s.finished = s.finished[len(s.finished)-maxFinishedJobs:]
+	}
+}
+
+// Scan checks the cache first. If scanning is needed, it performs it.
+// If scanning is already in progress (by another caller), it subscribes to it.
+// It returns the size and the number of entries below path, partial
+// ones if ctx is done first. The scan itself goes on, see Start.
+func (s *Scanner) Scan(ctx context.Context, path string, onProgress func(size int64, count int64)) (int64, int64) {
+	return s.ScanEntry(ctx, path, onProgress).Usage()
+}
+
+// ScanEntry is Scan returning the entry, e.g. to check its Outcome.
+// A failed or cancelled entry is not in the cache anymore. While an
+// interactive caller waits, path is scanned at interactive priority even
+// if a background scan got to it first.
+func (s *Scanner) ScanEntry(ctx context.Context, path string, onProgress func(size int64, count int64)) *Entry {
+	entry := s.Start(ctx, path)
+	if PriorityOf(ctx) == Interactive {
+		defer s.boosts.add(path)()
+	}
+
+	// Subscribe to progress updates
+	unsubscribe := entry.Subscribe(onProgress)
+	defer unsubscribe()
+
+	// Wait until done or context cancelled
+	select {
+	case <-entry.doneCh:
+	case <-ctx.Done():
+	}
+	return entry
+}
+
+type quotaKey struct{}
+
+// WithQuota limits how many ReadDir calls the scans under ctx may run
+// concurrently, so that one root with a huge fan-out cannot starve the others
+func WithQuota(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, quotaKey{}, make(chan struct{}, n))
+}
+
+func quotaFrom(ctx context.Context) chan struct{} {
+	quota, _ := ctx.Value(quotaKey{}).(chan struct{})
+	return quota
+}
+
+// queue queues the scan of dirPath for the workers. A background
+// directory below one an interactive caller waits for is read first.
+func (s *Scanner) queue(ctx context.Context, dirPath string, entry *Entry) {
+	priority := PriorityOf(ctx)
+	if priority == Background && s.boosts.covers(dirPath) {
+		priority = Interactive
+	}
+	s.pool.push(&dirTask{scanner: s, ctx: ctx, path: dirPath, entry: entry, priority: priority, quota: quotaFrom(ctx)})
+}
+
+// dirScan is a directory read and waiting for its subdirectories. They
+// are queued rather than waited for: each one done releases the scan, and
+// the last one finishes it, which releases the parent in turn. No
+// goroutine waits, however many directories there are.
+type dirScan struct {
+	s     *Scanner
+	ctx   context.Context
+	path  string
+	entry *Entry
+	dp    *dirProgress
+
+	mu           sync.Mutex
+	files        []IndexedFile
+	filesSize    int64
+	filesCount   int64
+	stats        Stats
+	partial      bool
+	dirs         int64
+	errors       int64
+	subDirSizes  map[string]int64
+	subDirCounts map[string]int64
+	// pending are the subdirectories not done yet, plus one while the
+	// directory itself is listed
+	pending int
+}
+
+// readDir is how a worker scans a directory: it reads it and queues its
+// subdirectories, the entry is done once they are
+func (s *Scanner) readDir(t *dirTask) {
+	ctx, dirPath, entry := t.ctx, t.path, t.entry
+	if ctx.Err() != nil {
+		s.finishDir(ctx, entry)
+		return
+	}
+
+	io := ioStatsFrom(ctx)
+	start := time.Now()
+	entries, err := ReadDirContext(ctx, s.opts.FS, dirPath)
+	io.readDir(dirPath, start, time.Since(start))
+	if err != nil && ctx.Err() != nil {
+		// the job was cancelled while reading, not a failed directory
+		s.finishDir(ctx, entry)
+		return
+	}
+	if err != nil {
+		if s.opts.OnError != nil {
+			s.opts.OnError(dirPath, err)
+		}
+		// not cached, so that a retry e.g. after fixing permissions reads it again
+		entry.Lock()
+		entry.Error = err
+		entry.Partial = true
+		entry.Unlock()
+		s.cache.remove(entry)
+		s.finishDir(ctx, entry)
+		return
+	}
+
+	d := &dirScan{
+		s:            s,
+		ctx:          ctx,
+		path:         dirPath,
+		entry:        entry,
+		subDirSizes:  make(map[string]int64),
+		subDirCounts: make(map[string]int64),
+		pending:      1,
+	}
+	if s.opts.NewStats != nil {
+		d.stats = s.opts.NewStats()
+	}
+	// the progress of the job pushes the partial size while scanning
+	d.dp = progressFrom(ctx).add(dirPath, func() {
+		d.mu.Lock()
+		entry.UpdateSize(d.usage())
+		d.mu.Unlock()
+	})
+
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if s.opts.Skip != nil && s.opts.Skip(dirPath, e) {
+			continue
+		}
+
+		if !e.IsDir() {
+			filePath := filepath.Join(dirPath, e.Name())
+			start := time.Now()
+			info, err := e.Info()
+			io.stat(time.Since(start))
+			if err == nil && (s.opts.CountFile == nil || s.opts.CountFile(filePath, info)) {
+				d.mu.Lock()
+				d.filesSize += info.Size()
+				d.filesCount++
+				if ps, ok := d.stats.(PathStats); ok {
+					ps.AddFileAt(filePath, info)
+				} else if d.stats != nil {
+					d.stats.AddFile(info)
+				}
+				d.files = append(d.files, IndexedFile{
+					Name:    Intern(e.Name()),
+					Size:    info.Size(),
+					ModTime: info.ModTime(),
+				})
+				d.dp.markDirty()
+				d.mu.Unlock()
+			}
+		} else {
+			d.mu.Lock()
+			d.pending++
+			d.mu.Unlock()
+			d.watch(filepath.Join(dirPath, e.Name()), e.Name())
+		}
+	}
+	d.release()
+}
+
+// watch adds the size of the subdirectory subPath as it is scanned, by
+// this job or another one that got to it first
+func (d *dirScan) watch(subPath string, subName string) {
+	subEntry := d.s.Start(d.ctx, subPath)
+	unsub := subEntry.Subscribe(func(size int64, count int64) {
+		d.mu.Lock()
+		d.subDirSizes[subName] = size
+		d.subDirCounts[subName] = count + 1 // the subdirectory itself
+		d.dp.markDirty()
+		d.mu.Unlock()
+	})
+	subEntry.whenDone(func() {
+		unsub()
+		// the job of another scan that ran it was cancelled, start over
+		if subEntry.isCancelled() && d.ctx.Err() == nil {
+			d.watch(subPath, subName)
+			return
+		}
+		subPartial, subErr := subEntry.Outcome()
+		subDirs, subErrors := subEntry.DirCounts()
+		subStats := subEntry.GetStats()
+		d.mu.Lock()
+		d.partial = d.partial || subPartial
+		d.dirs += 1 + subDirs
+		if subErr != nil {
+			subErrors++
+		}
+		d.errors += subErrors
+		if d.stats != nil && subStats != nil {
+			d.stats.Add(subStats)
+		}
+		d.mu.Unlock()
+		d.release()
+	})
+}
+
+// release counts a pending subdirectory or the listing as done, the last
+// one finishes the directory
+func (d *dirScan) release() {
+	d.mu.Lock()
+	d.pending--
+	last := d.pending == 0
+	d.mu.Unlock()
+	if last {
+		d.finish()
+	}
+}
+
+// usage is the size and count of the directory so far, callers hold d.mu
+func (d *dirScan) usage() (int64, int64) {
+	total, count := d.filesSize, d.filesCount
+	for name, size := range d.subDirSizes {
+		total += size
+		count += d.subDirCounts[name]
+	}
+	return total, count
+}
+
+func (d *dirScan) finish() {
+	d.dp.remove()
+
+	var modTime time.Time
+	start := time.Now()
+	st, err := d.s.opts.FS.Lstat(d.path)
+	ioStatsFrom(d.ctx).stat(time.Since(start))
+	if err == nil {
+		modTime = st.ModTime()
+	}
+
+	// Final update
+	d.mu.Lock()
+	d.entry.SetIndex(modTime, d.files)
+	d.entry.SetStats(d.stats)
+	d.entry.Lock()
+	d.entry.Partial = d.partial
+	d.entry.Dirs = d.dirs
+	d.entry.Errors = d.errors
+	d.entry.Unlock()
+	d.entry.UpdateSize(d.usage())
+	d.mu.Unlock()
+
+	d.s.finishDir(d.ctx, d.entry)
+}
+
+// finishDir marks entry done, dropping it from the cache if its job was
+// cancelled
+func (s *Scanner) finishDir(ctx context.Context, entry *Entry) {
+	if ctx.Err() != nil {
+		entry.Lock()
+		entry.cancelled = true
+		entry.Partial = true
+		entry.Unlock()
+		s.cache.remove(entry)
+	}
+	entry.MarkDone()
+	s.finishJob(entry)
+}
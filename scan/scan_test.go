@@ -0,0 +1,206 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// makeTree creates fanout directories of files files of fileSize bytes
+// each below dir, depth levels deep, and returns the total size and the
+// number of entries below dir
+func makeTree(t *testing.T, dir string, depth, fanout, files int, fileSize int64) (int64, int64) {
+	t.Helper()
+	var size, count int64
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "f"+strconv.Itoa(i)), make([]byte, fileSize), 0644); err != nil {
+			t.Fatal(err)
+		}
+		size += fileSize
+		count++
+	}
+	if depth == 0 {
+		return size, count
+	}
+	for i := 0; i < fanout; i++ {
+		sub := filepath.Join(dir, "d"+strconv.Itoa(i))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		subSize, subCount := makeTree(t, sub, depth-1, fanout, files, fileSize)
+		size += subSize
+		count += subCount + 1
+	}
+	return size, count
+}
+
+// slowFS is the local file system taking delay for each listing, so that
+// a job can be cancelled while it runs
+type slowFS struct {
+	delay time.Duration
+}
+
+func (f slowFS) ReadDir(dir string) ([]fs.DirEntry, error) {
+	time.Sleep(f.delay)
+	return OS.ReadDir(dir)
+}
+
+func (slowFS) Lstat(path string) (fs.FileInfo, error) { return OS.Lstat(path) }
+
+// checkUsage fails unless e is done, complete and has size and count
+func checkUsage(t *testing.T, name string, e *Entry, size, count int64) {
+	t.Helper()
+	if !e.IsDone() {
+		t.Fatalf("%s: not done", name)
+	}
+	if partial, err := e.Outcome(); partial || err != nil {
+		t.Errorf("%s: partial = %v, err = %v, want a complete scan", name, partial, err)
+	}
+	if gotSize, gotCount := e.Usage(); gotSize != size || gotCount != count {
+		t.Errorf("%s: usage = %d bytes, %d entries, want %d bytes, %d entries", name, gotSize, gotCount, size, count)
+	}
+}
+
+// TestStartAfterCancel starts a path again right after cancelling its job,
+// before the job has wound down: the caller gets a complete new scan
+func TestStartAfterCancel(t *testing.T) {
+	root := t.TempDir()
+	size, count := makeTree(t, root, 3, 4, 5, 100)
+
+	for i := 0; i < 10; i++ {
+		s := New(NewCache(), Options{Concurrency: 4, FS: slowFS{delay: time.Millisecond}})
+		s.Start(context.Background(), root)
+		if !s.Cancel(root) {
+			t.Fatalf("run %d: no job to cancel", i)
+		}
+		checkUsage(t, "run "+strconv.Itoa(i), s.ScanEntry(context.Background(), root, func(int64, int64) {}), size, count)
+	}
+}
+
+// walkUsage returns the size of the files below dir and the number of
+// entries below it, as walked by filepath.WalkDir
+func walkUsage(t *testing.T, dir string) (int64, int64) {
+	t.Helper()
+	var size, count int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		count++
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size, count
+}
+
+// scanConcurrently scans paths at the same time, failing if they take
+// longer than a minute, and returns their entries
+func scanConcurrently(t *testing.T, s *Scanner, paths ...string) []*Entry {
+	t.Helper()
+	entries := make([]*Entry, len(paths))
+	done := make(chan int)
+	for i, path := range paths {
+		go func() {
+			entries[i] = s.ScanEntry(context.Background(), path, func(int64, int64) {})
+			done <- i
+		}()
+	}
+	timeout := time.After(time.Minute)
+	for range paths {
+		select {
+		case <-done:
+		case <-timeout:
+			t.Fatalf("scans of %v did not finish", paths)
+		}
+	}
+	return entries
+}
+
+// TestOverlappingScans scans a tree, one of its subdirectories and the
+// tree again at once: the jobs share the directories and all totals hold
+func TestOverlappingScans(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root, 3, 4, 5, 100)
+	paths := []string{root, filepath.Join(root, "d1"), root, filepath.Join(root, "d2", "d0")}
+
+	for i := 0; i < 5; i++ {
+		s := New(NewCache(), Options{Concurrency: 4, FS: slowFS{delay: 100 * time.Microsecond}})
+		for j, e := range scanConcurrently(t, s, paths...) {
+			size, count := walkUsage(t, paths[j])
+			checkUsage(t, paths[j], e, size, count)
+			if cached := s.Cache().GetEntry(paths[j]); cached != e {
+				t.Errorf("%s: the entry returned is not the cached one", paths[j])
+			}
+		}
+		if jobs := s.Jobs(); len(jobs) != 0 {
+			t.Errorf("run %d: %d jobs left running", i, len(jobs))
+		}
+	}
+}
+
+// TestRescanAfterCancel rescans a tree whose scan was just cancelled
+func TestRescanAfterCancel(t *testing.T) {
+	root := t.TempDir()
+	size, count := makeTree(t, root, 3, 4, 5, 100)
+
+	s := New(NewCache(), Options{Concurrency: 4, FS: slowFS{delay: time.Millisecond}})
+	s.Start(context.Background(), root)
+	s.Cancel(root)
+	checkUsage(t, "rescan", s.Rescan(context.Background(), root, func(int64, int64) {}), size, count)
+	checkUsage(t, "cached", s.Cache().GetEntry(root), size, count)
+}
+
+// TestRescanReplacesSubtree rescans a cached tree that changed: the new
+// sizes replace the cached ones and removed directories are forgotten
+func TestRescanReplacesSubtree(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root, 2, 3, 2, 100)
+
+	s := New(NewCache(), Options{Concurrency: 4})
+	s.Scan(context.Background(), root, func(int64, int64) {})
+	if err := os.RemoveAll(filepath.Join(root, "d1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "d0", "d2", "new"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	size, count := walkUsage(t, root)
+	checkUsage(t, "rescan", s.Rescan(context.Background(), root, func(int64, int64) {}), size, count)
+	checkUsage(t, "cached", s.Cache().GetEntry(root), size, count)
+	sub := filepath.Join(root, "d0", "d2")
+	subSize, subCount := walkUsage(t, sub)
+	checkUsage(t, sub, s.Cache().GetEntry(sub), subSize, subCount)
+	if e := s.Cache().GetEntry(filepath.Join(root, "d1")); e != nil {
+		t.Errorf("removed directory d1 is still cached")
+	}
+}
+
+// TestLowConcurrency runs overlapping scans of a deep tree with one and
+// two workers, which must not wait for each other forever
+func TestLowConcurrency(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root, 5, 3, 1, 10)
+	paths := []string{root, filepath.Join(root, "d0"), filepath.Join(root, "d0", "d1", "d2"), root}
+
+	for _, concurrency := range []int{1, 2} {
+		s := New(NewCache(), Options{Concurrency: concurrency})
+		for j, e := range scanConcurrently(t, s, paths...) {
+			size, count := walkUsage(t, paths[j])
+			checkUsage(t, strconv.Itoa(concurrency)+" workers, "+paths[j], e, size, count)
+		}
+	}
+}
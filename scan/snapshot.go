@@ -0,0 +1,124 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotDir is a finished directory of a Snapshot
+type SnapshotDir struct {
+	// Path is slash separated and relative to the root of the snapshot,
+	// "." for the root itself
+	Path    string          `json:"path"`
+	Size    int64           `json:"size"`
+	Count   int64           `json:"count"`
+	Partial bool            `json:"partial,omitempty"`
+	ModTime time.Time       `json:"modTime"`
+	Files   []IndexedFile   `json:"files,omitempty"`
+	Stats   json.RawMessage `json:"stats,omitempty"`
+	// Dirs and Errors are those of the Entry, 0 in the snapshots saved
+	// before they were counted
+	Dirs   int64 `json:"dirs,omitempty"`
+	Errors int64 `json:"errors,omitempty"`
+}
+
+// Snapshot returns the finished entries of root and below, relative to
+// root so that they can be restored below another path, nil unless the
+// scan of root is done
+func (s *Scanner) Snapshot(root string) []SnapshotDir {
+	if e := s.cache.GetEntry(root); e == nil || !e.IsDone() || e.isCancelled() {
+		return nil
+	}
+	var dirs []SnapshotDir
+	for _, e := range s.cache.Under(root) {
+		rel, err := filepath.Rel(root, e.Path())
+		if err != nil {
+			continue
+		}
+		e.Lock()
+		if e.Done && !e.cancelled && e.Error == nil {
+			dir := SnapshotDir{Path: filepath.ToSlash(rel), Size: e.Size, Count: e.Count, Partial: e.Partial, Dirs: e.Dirs, Errors: e.Errors, ModTime: e.ModTime, Files: e.Files}
+			if e.Stats != nil {
+				dir.Stats, _ = json.Marshal(e.Stats)
+			}
+			dirs = append(dirs, dir)
+		}
+		e.Unlock()
+	}
+	return dirs
+}
+
+// Restore fills the cache below root with the finished entries of a
+// Snapshot, as if root had just been scanned. It does nothing and
+// returns false when root is cached already.
+func (s *Scanner) Restore(root string, dirs []SnapshotDir) bool {
+	s.cache.Lock()
+	defer s.cache.Unlock()
+	if n := s.cache.lookup(root, false); n != nil && n.entry != nil {
+		return false
+	}
+	s.restoreLocked(root, dirs)
+	return true
+}
+
+// Rescan scans path again while its previous scan stays cached, then puts
+// the new one in its place, so that readers of path neither wait for the
+// scan nor see its sizes drop meanwhile. The scan runs in a cache of its
+// own with the workers of s, and is cancelled if ctx is done first. It
+// returns the entry now cached, or that of the new scan if it failed or
+// did not finish, the cache keeps the previous scan then.
+func (s *Scanner) Rescan(ctx context.Context, path string, onProgress func(size int64, count int64)) *Entry {
+	shadow := &Scanner{cache: NewCache(), opts: s.opts, pool: s.pool, jobs: make(map[*Entry]*Job)}
+	entry := shadow.ScanEntry(ctx, path, onProgress)
+	dirs := shadow.Snapshot(path)
+	if dirs == nil {
+		shadow.Cancel(path)
+		return entry
+	}
+	s.cache.Lock()
+	if n := s.cache.lookup(path, false); n != nil {
+		s.cache.prune(n)
+	}
+	s.restoreLocked(path, dirs)
+	s.cache.Unlock()
+	if e := s.cache.GetEntry(path); e != nil {
+		return e
+	}
+	return entry
+}
+
+// restoreLocked adds the entries of dirs below root that are not cached,
+// the caller holds the write lock of the cache
+func (s *Scanner) restoreLocked(root string, dirs []SnapshotDir) {
+	for _, dir := range dirs {
+		n := s.cache.lookup(filepath.Join(root, filepath.FromSlash(dir.Path)), true)
+		if n.entry != nil {
+			continue
+		}
+		e := &Entry{
+			node:    n,
+			Size:    dir.Size,
+			Count:   dir.Count,
+			Done:    true,
+			Partial: dir.Partial,
+			ModTime: dir.ModTime,
+			Files:   dir.Files,
+			doneCh:  make(chan struct{}),
+		}
+		e.Dirs, e.Errors = dir.Dirs, dir.Errors
+		close(e.doneCh)
+		for i := range e.Files {
+			e.Files[i].Name = Intern(e.Files[i].Name)
+		}
+		if s.opts.NewStats != nil {
+			e.Stats = s.opts.NewStats()
+			if len(dir.Stats) > 0 {
+				json.Unmarshal(dir.Stats, e.Stats)
+			}
+		}
+		n.entry = e
+		n.touch()
+	}
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role is what a user may do, each role may do everything of the ones before it
+type Role string
+
+const (
+	// RoleViewer may scan and browse
+	RoleViewer Role = "viewer"
+	// RoleOperator may also refresh, trash files and keep bookmarks
+	RoleOperator Role = "operator"
+	// RoleAdmin may also delete, mount and unmount disks and change settings
+	RoleAdmin Role = "admin"
+)
+
+// tokenCookie holds the token of a browser that logged in, EventSource
+// cannot send an Authorization header
+const tokenCookie = "dua_token"
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// AccessUser is a user of access.json
+type AccessUser struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  Role   `json:"role"`
+}
+
+// AccessConfig is access.json in the config directory. Without users
+// there is no authentication, clients on the loopback interface are
+// admins and all others viewers.
+type AccessConfig struct {
+	Users []AccessUser `json:"users"`
+}
+
+// Whoami is who the client of a request is
+type Whoami struct {
+	// Enabled is false when there are no users, local clients are admins then
+	Enabled bool   `json:"enabled"`
+	User    string `json:"user,omitempty"`
+	Role    Role   `json:"role"`
+}
+
+// access is access.json, read again once it changes
+var access = struct {
+	sync.Mutex
+	file    string
+	modTime time.Time
+	size    int64
+	config  AccessConfig
+}{}
+
+type accessUserKey struct{}
+
+// anonymousAdmin is the user of local requests while authentication is
+// off, anonymousViewer of the others: a server listening on all
+// interfaces must not let the network empty the trash
+var (
+	anonymousAdmin  = &AccessUser{Role: RoleAdmin}
+	anonymousViewer = &AccessUser{Role: RoleViewer}
+)
+
+// anonymous reports whether u is a user of requests without authentication
+func (u *AccessUser) anonymous() bool {
+	return u == anonymousAdmin || u == anonymousViewer
+}
+
+// anonymousUser is the user of r while authentication is off
+func anonymousUser(r *http.Request) *AccessUser {
+	if ip := net.ParseIP(auditClientOf(r).Addr); ip != nil && ip.IsLoopback() {
+		return anonymousAdmin
+	}
+	return anonymousViewer
+}
+
+// accessConfig returns the current access.json
+func accessConfig() AccessConfig {
+	access.Lock()
+	defer access.Unlock()
+	if access.file == "" {
+		access.file = configPath("access.json")
+	}
+	info, err := os.Stat(access.file)
+	if err != nil {
+		access.config, access.modTime, access.size = AccessConfig{}, time.Time{}, 0
+		return access.config
+	}
+	if info.ModTime().Equal(access.modTime) && info.Size() == access.size {
+		return access.config
+	}
+	var config AccessConfig
+	if err := loadJSON(access.file, &config); err != nil {
+		// a broken file must not open the server to everyone
+		log.Printf("Error loading %s, denying all requests: %v", access.file, err)
+		config = AccessConfig{Users: []AccessUser{{Name: "invalid " + access.file}}}
+	}
+	for i, u := range config.Users {
+		if u.Role.rank() == 0 {
+			log.Printf("Unknown role %q of user %s in %s, it may only scan", u.Role, u.Name, access.file)
+			config.Users[i].Role = RoleViewer
+		}
+	}
+	access.config, access.modTime, access.size = config, info.ModTime(), info.Size()
+	return config
+}
+
+// requestToken is the token of a request: the bearer of its Authorization
+// header, the token query parameter or the login cookie
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(tokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// userOfToken returns the user of token, nil for none or a revoked one
+func userOfToken(config AccessConfig, token string) *AccessUser {
+	if token == "" || tokenRevoked(token) {
+		return nil
+	}
+	for i, u := range config.Users {
+		if u.Token != "" && subtle.ConstantTimeCompare([]byte(u.Token), []byte(token)) == 1 {
+			return &config.Users[i]
+		}
+	}
+	return nil
+}
+
+// publicRoutes answer without a token: instance detection, health checks and logging in
+var publicRoutes = map[string]bool{
+	APIPrefix + "/instance":     true,
+	APIPrefix + "/health":       true,
+	APIPrefix + "/version":      true,
+	APIPrefix + "/openapi.json": true,
+	APIPrefix + "/login":        true,
+	APIPrefix + "/whoami":       true,
+}
+
+// withAccess authenticates the API requests of h once access.json has
+// users, the UI itself loads without a token so that it can log in
+func withAccess(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := accessConfig()
+		user := anonymousUser(r)
+		if len(config.Users) > 0 {
+			user = userOfToken(config, requestToken(r))
+			if user == nil && strings.HasPrefix(r.URL.Path, "/api/") && !publicRoutes[r.URL.Path] {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), accessUserKey{}, user))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// userOf returns the user of a request, nil when it did not authenticate
+func userOf(ctx context.Context) *AccessUser {
+	u, _ := ctx.Value(accessUserKey{}).(*AccessUser)
+	return u
+}
+
+// hasRole reports whether the user of ctx may do what role may
+func hasRole(ctx context.Context, role Role) bool {
+	u := userOf(ctx)
+	return u != nil && u.Role.rank() >= role.rank()
+}
+
+// requireRole lets only users with at least role through to h
+func requireRole(role Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasRole(r.Context(), role) {
+			http.Error(w, "Forbidden: requires the "+string(role)+" role", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleLogin checks the posted token and keeps it in a cookie
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := userOfToken(accessConfig(), req.Token)
+	if user == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    req.Token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Whoami{Enabled: true, User: user.Name, Role: user.Role})
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: tokenCookie, Path: "/", MaxAge: -1})
+	w.Write([]byte("ok"))
+}
+
+func handleWhoami(w http.ResponseWriter, r *http.Request) {
+	resp := Whoami{Enabled: len(accessConfig().Users) > 0}
+	if u := userOf(r.Context()); u != nil {
+		resp.User, resp.Role = u.Name, u.Role
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,107 @@
+// Package analyzer defines the plugin interface of storage analyzers: each
+// explains part of the disk usage below a path, e.g. git repositories,
+// toolchain caches or the trash, and registers itself by name.
+package analyzer
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Analyzer explains the usage of one kind of storage
+type Analyzer interface {
+	// Name identifies the analyzer, e.g. "git"
+	Name() string
+	// Detect reports whether there is something to analyze at or below path,
+	// it must be cheap: no scanning
+	Detect(path string) bool
+	// Analyze reports on the storage at or below path
+	Analyze(ctx context.Context, path string) (*Report, error)
+}
+
+// Report is the result of running an analyzer on a path
+type Report struct {
+	Analyzer string `json:"analyzer"`
+	Path     string `json:"path"`
+	// Size is the bytes the analyzer accounts for, Reclaimable the part
+	// of it that can be deleted without losing data, e.g. caches
+	Size        int64  `json:"size"`
+	Reclaimable int64  `json:"reclaimable"`
+	Items       []Item `json:"items"` // largest first
+}
+
+// Item is one thing found by an analyzer
+type Item struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Reclaimable is set when deleting the item loses no data
+	Reclaimable bool `json:"reclaimable,omitempty"`
+	// Note explains the item or how to clean it up
+	Note string `json:"note,omitempty"`
+}
+
+var registry = struct {
+	sync.Mutex
+	byName map[string]Analyzer
+}{
+	byName: make(map[string]Analyzer),
+}
+
+// Register adds a to the registry, replacing an analyzer of the same name
+func Register(a Analyzer) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.byName[a.Name()] = a
+}
+
+// All returns the registered analyzers sorted by name
+func All() []Analyzer {
+	registry.Lock()
+	defer registry.Unlock()
+	all := make([]Analyzer, 0, len(registry.byName))
+	for _, a := range registry.byName {
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+	return all
+}
+
+// Get returns the analyzer registered as name, nil if there is none
+func Get(name string) Analyzer {
+	registry.Lock()
+	defer registry.Unlock()
+	return registry.byName[name]
+}
+
+// NewReport starts the report of analyzer on path
+func NewReport(analyzer string, path string) *Report {
+	return &Report{Analyzer: analyzer, Path: path, Items: []Item{}}
+}
+
+// Add appends item and adds its size to the totals
+func (r *Report) Add(item Item) {
+	r.Items = append(r.Items, item)
+	r.Size += item.Size
+	if item.Reclaimable {
+		r.Reclaimable += item.Size
+	}
+}
+
+// Sort orders the items largest first
+func (r *Report) Sort() {
+	sort.SliceStable(r.Items, func(i, j int) bool {
+		return r.Items[i].Size > r.Items[j].Size
+	})
+}
+
+// Under reports whether path is root or inside it
+func Under(path string, root string) bool {
+	root = strings.TrimSuffix(root, string(os.PathSeparator))
+	return path == root || strings.HasPrefix(path, root+string(os.PathSeparator))
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/analyzer"
+	"disk-usage-analyser/server/devcache"
+	"disk-usage-analyser/server/trash"
+	"disk-usage-analyser/server/vm"
+)
+
+// AnalyzerInfo tells whether an analyzer applies to the requested path
+type AnalyzerInfo struct {
+	Name     string `json:"name"`
+	Detected bool   `json:"detected"`
+}
+
+// registerAnalyzers registers the built-in analyzers
+func registerAnalyzers() {
+	analyzer.Register(gitAnalyzer{})
+	analyzer.Register(devCacheAnalyzer{})
+	analyzer.Register(trashAnalyzer{})
+	analyzer.Register(vmAnalyzer{})
+}
+
+// pathSize is the size of a file or, scanned through the cache, a directory
+func pathSize(ctx context.Context, path string) int64 {
+	info, err := os.Lstat(scan.LocalPath(path))
+	if err != nil {
+		return 0
+	}
+	if !info.IsDir() {
+		return info.Size()
+	}
+	size, _ := getDirSizeWithCache(ctx, path, func(int64, int64) {})
+	return size
+}
+
+// gitAnalyzer splits a repository into its objects, LFS store and work tree
+type gitAnalyzer struct{}
+
+func (gitAnalyzer) Name() string { return "git" }
+
+func (gitAnalyzer) Detect(path string) bool { return gitDirOf(path) != "" }
+
+func (gitAnalyzer) Analyze(ctx context.Context, path string) (*analyzer.Report, error) {
+	report := analyzer.NewReport("git", path)
+	gitDir := gitDirOf(path)
+	if gitDir == "" {
+		return report, nil
+	}
+	size, _ := getDirSizeWithCache(ctx, path, func(int64, int64) {})
+	gitSize := cachedSize(gitDir)
+	objects := cachedSize(filepath.Join(gitDir, "objects"))
+	lfs := cachedSize(filepath.Join(gitDir, "lfs"))
+	report.Add(analyzer.Item{Name: "objects", Path: filepath.Join(gitDir, "objects"), Size: objects, Note: "git gc packs loose objects"})
+	if lfs > 0 {
+		report.Add(analyzer.Item{Name: "lfs", Path: filepath.Join(gitDir, "lfs"), Size: lfs, Note: "git lfs prune drops old LFS files"})
+	}
+	report.Add(analyzer.Item{Name: ".git (other)", Path: gitDir, Size: gitSize - objects - lfs})
+	report.Add(analyzer.Item{Name: "work tree", Path: path, Size: size - gitSize})
+	report.Sort()
+	return report, nil
+}
+
+// devCacheAnalyzer reports the toolchain caches below a path
+type devCacheAnalyzer struct{}
+
+func (devCacheAnalyzer) Name() string { return "devcaches" }
+
+func (devCacheAnalyzer) Detect(path string) bool {
+	caches, err := devcache.List()
+	if err != nil {
+		return false
+	}
+	for _, c := range caches {
+		for _, p := range c.Paths {
+			if analyzer.Under(p, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (devCacheAnalyzer) Analyze(ctx context.Context, path string) (*analyzer.Report, error) {
+	caches, err := devcache.List()
+	if err != nil {
+		return nil, err
+	}
+	report := analyzer.NewReport("devcaches", path)
+	for _, c := range caches {
+		note := "the contents can be deleted"
+		if len(c.CleanCommand) > 0 {
+			note = "clean with " + strings.Join(c.CleanCommand, " ")
+		}
+		for _, p := range c.Paths {
+			if analyzer.Under(p, path) {
+				report.Add(analyzer.Item{Name: c.Name, Path: p, Size: pathSize(ctx, p), Reclaimable: true, Note: note})
+			}
+		}
+	}
+	report.Sort()
+	return report, ctx.Err()
+}
+
+// trashAnalyzer reports the trash directories below a path
+type trashAnalyzer struct{}
+
+func (trashAnalyzer) Name() string { return "trash" }
+
+func (trashAnalyzer) Detect(path string) bool {
+	locations, err := trash.Locations()
+	if err != nil {
+		return false
+	}
+	for _, l := range locations {
+		if analyzer.Under(l.ContentDir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (trashAnalyzer) Analyze(ctx context.Context, path string) (*analyzer.Report, error) {
+	locations, err := trash.Locations()
+	if err != nil {
+		return nil, err
+	}
+	report := analyzer.NewReport("trash", path)
+	for _, l := range locations {
+		if analyzer.Under(l.ContentDir, path) {
+			report.Add(analyzer.Item{Name: "trash of " + l.Volume, Path: l.ContentDir, Size: pathSize(ctx, l.ContentDir), Reclaimable: true, Note: "emptied by /api/trash/empty"})
+		}
+	}
+	report.Sort()
+	return report, ctx.Err()
+}
+
+// vmAnalyzer reports the VM disks and container runtime VMs (Docker,
+// Podman, Lima...) below a path
+type vmAnalyzer struct{}
+
+func (vmAnalyzer) Name() string { return "vms" }
+
+func (vmAnalyzer) Detect(path string) bool {
+	vms, err := vm.List()
+	if err != nil {
+		return false
+	}
+	for _, v := range vms {
+		if analyzer.Under(v.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (vmAnalyzer) Analyze(ctx context.Context, path string) (*analyzer.Report, error) {
+	vms, err := vm.List()
+	if err != nil {
+		return nil, err
+	}
+	report := analyzer.NewReport("vms", path)
+	for _, v := range vms {
+		if !analyzer.Under(v.Path, path) {
+			continue
+		}
+		item := analyzer.Item{Name: v.Runtime + ": " + v.Name, Path: v.Path, Size: pathSize(ctx, v.Path)}
+		if len(v.Actions) > 0 {
+			item.Note = v.Actions[0].Label
+		}
+		report.Add(item)
+	}
+	report.Sort()
+	return report, ctx.Err()
+}
+
+// handleAnalyzers lists the registered analyzers and whether they detect
+// anything at or below path
+func handleAnalyzers(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	infos := []AnalyzerInfo{}
+	for _, a := range analyzer.All() {
+		infos = append(infos, AnalyzerInfo{Name: a.Name(), Detected: a.Detect(path)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// handleRunAnalyzer runs the analyzer name on path
+func handleRunAnalyzer(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	a := analyzer.Get(name)
+	if a == nil {
+		http.Error(w, "unknown analyzer: "+name, http.StatusNotFound)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := a.Analyze(r.Context(), path)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
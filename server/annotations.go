@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+const (
+	// maxAnnotationNote and maxAnnotationLabel keep annotations short
+	maxAnnotationNote   = 1000
+	maxAnnotationLabel  = 100
+	maxAnnotationLabels = 20
+)
+
+// Annotation is a note and labels attached to a path, e.g. "keep until
+// the 2025 audit" and "candidate for deletion" or "owner=alice", to record
+// cleanup decisions. Listings return it with the item of the path.
+type Annotation struct {
+	Path   string   `json:"path"`
+	Note   string   `json:"note,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	// UpdatedBy is the user who last changed it, empty without access.json users
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+var annotations = struct {
+	sync.Mutex
+	once  sync.Once
+	file  string
+	items map[string]*Annotation
+	// generation changes with every change, it is part of the ETag of
+	// cached listings
+	generation uint64
+}{}
+
+// loadAnnotations reads the annotations file once, callers hold annotations.Mutex
+func loadAnnotations() {
+	annotations.once.Do(func() {
+		annotations.file = configPath("annotations.json")
+		var list []*Annotation
+		if err := loadJSON(annotations.file, &list); err != nil {
+			log.Printf("Error loading annotations %s: %v", annotations.file, err)
+		}
+		annotations.items = make(map[string]*Annotation, len(list))
+		for _, a := range list {
+			annotations.items[scan.NormalizePath(a.Path)] = a
+		}
+	})
+}
+
+func saveAnnotations() {
+	annotations.generation++
+	list := make([]*Annotation, 0, len(annotations.items))
+	for _, a := range annotations.items {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	if err := saveJSON(annotations.file, list); err != nil {
+		log.Printf("Error saving annotations: %v", err)
+	}
+}
+
+// annotationsIn returns the annotations of the entries of dir by their
+// scan.NormalizePath name, nil when there are none. The annotations are
+// copies, a listing keeps them while it runs.
+func annotationsIn(dir string) map[string]*Annotation {
+	dir = scan.NormalizePath(dir)
+	annotations.Lock()
+	defer annotations.Unlock()
+	loadAnnotations()
+	var byName map[string]*Annotation
+	for path, a := range annotations.items {
+		if filepath.Dir(path) != dir || path == dir {
+			continue
+		}
+		if byName == nil {
+			byName = make(map[string]*Annotation)
+		}
+		c := *a
+		byName[filepath.Base(path)] = &c
+	}
+	return byName
+}
+
+// annotationsGeneration returns the generation of the annotations
+func annotationsGeneration() uint64 {
+	annotations.Lock()
+	defer annotations.Unlock()
+	loadAnnotations()
+	return annotations.generation
+}
+
+// annotationLabels trims and dedups labels, dropping empty ones
+func annotationLabels(labels []string) []string {
+	var list []string
+	seen := make(map[string]bool)
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l == "" || seen[l] {
+			continue
+		}
+		seen[l] = true
+		list = append(list, l)
+	}
+	return list
+}
+
+// handleListAnnotations lists the annotations, those of paths below under
+// and with label if given
+func handleListAnnotations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	under := query.Get("under")
+	if under != "" {
+		abs, err := filepath.Abs(under)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		under = abs
+	}
+	label := query.Get("label")
+
+	annotations.Lock()
+	loadAnnotations()
+	list := make([]Annotation, 0, len(annotations.items))
+	for _, a := range annotations.items {
+		if under != "" && !pathWithin(a.Path, under) {
+			continue
+		}
+		if label != "" && !slices.Contains(a.Labels, label) {
+			continue
+		}
+		list = append(list, *a)
+	}
+	annotations.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleSetAnnotation replaces the note and labels of a path, the label
+// parameter is repeated for several
+func handleSetAnnotation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	note := strings.TrimSpace(query.Get("note"))
+	labels := annotationLabels(query["label"])
+	if note == "" && len(labels) == 0 {
+		http.Error(w, "note or label is required", http.StatusBadRequest)
+		return
+	}
+	if len(note) > maxAnnotationNote {
+		http.Error(w, "note is too long", http.StatusBadRequest)
+		return
+	}
+	if len(labels) > maxAnnotationLabels {
+		http.Error(w, "too many labels", http.StatusBadRequest)
+		return
+	}
+	for _, l := range labels {
+		if len(l) > maxAnnotationLabel {
+			http.Error(w, "label is too long: "+l, http.StatusBadRequest)
+			return
+		}
+	}
+
+	annotations.Lock()
+	defer annotations.Unlock()
+	loadAnnotations()
+	a := &Annotation{Path: path, Note: note, Labels: labels, UpdatedAt: time.Now()}
+	if u := userOf(r.Context()); u != nil {
+		a.UpdatedBy = u.Name
+	}
+	annotations.items[path] = a
+	saveAnnotations()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+func handleRemoveAnnotation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+
+	annotations.Lock()
+	defer annotations.Unlock()
+	loadAnnotations()
+	if _, ok := annotations.items[path]; !ok {
+		http.Error(w, "annotation not found", http.StatusNotFound)
+		return
+	}
+	delete(annotations.items, path)
+	saveAnnotations()
+	w.Write([]byte("ok"))
+}
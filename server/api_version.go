@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIVersion is the version of the HTTP API. Within a version endpoints,
+// fields and events are only added; renaming or removing one, or changing
+// what a field means, makes the next version.
+const APIVersion = "v1"
+
+// APIPrefix is the path the endpoints of APIVersion are served below
+const APIPrefix = "/api/" + APIVersion
+
+// unversionedDeprecatedAt is when the paths without a version, e.g.
+// /api/usage, were deprecated in favor of APIPrefix
+var unversionedDeprecatedAt = time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+
+var apiVersionSegment = regexp.MustCompile(`^v[0-9]+$`)
+
+// withAPIVersion serves the unversioned paths of before APIPrefix as the
+// paths of v1, with the Deprecation and Link headers of RFC 9745 naming the
+// path to use instead. They keep working until the API has a v2.
+func withAPIVersion(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, "/api/")
+		if segment, _, _ := strings.Cut(rest, "/"); !ok || apiVersionSegment.MatchString(segment) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		successor := APIPrefix + "/" + rest
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(unversionedDeprecatedAt.Unix(), 10))
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+
+		u := *r.URL
+		u.Path = successor
+		if u.RawPath != "" {
+			u.RawPath = APIPrefix + strings.TrimPrefix(u.RawPath, "/api")
+		}
+		r = r.WithContext(r.Context())
+		r.URL = &u
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/archive"
+)
+
+// maxCachedArchives is the number of archive indexes kept in memory,
+// drilling into a big tarball must not read it again on every click
+const maxCachedArchives = 8
+
+type ArchiveListing struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	Dir  string `json:"dir"` // slash separated directory inside the archive, "" for the top
+	// Size is the uncompressed size of Dir, ArchiveSize the size of the archive file
+	Size        int64           `json:"size"`
+	ArchiveSize int64           `json:"archiveSize"`
+	Entries     int             `json:"entries"` // number of entries in the archive
+	Children    []archive.Child `json:"children"`
+}
+
+type archiveIndex struct {
+	size    int64
+	modTime time.Time
+	entries []archive.Entry
+	used    time.Time
+}
+
+var archiveIndexes = struct {
+	sync.Mutex
+	byPath map[string]*archiveIndex
+}{
+	byPath: make(map[string]*archiveIndex),
+}
+
+// readArchive returns the entries of an archive, from memory while the file
+// is unchanged. Reading it stops once ctx is done.
+func readArchive(ctx context.Context, path string, info os.FileInfo) ([]archive.Entry, error) {
+	archiveIndexes.Lock()
+	idx := archiveIndexes.byPath[path]
+	if idx != nil && idx.size == info.Size() && idx.modTime.Equal(info.ModTime()) {
+		idx.used = time.Now()
+		archiveIndexes.Unlock()
+		return idx.entries, nil
+	}
+	archiveIndexes.Unlock()
+
+	entries, err := archive.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveIndexes.Lock()
+	defer archiveIndexes.Unlock()
+	if len(archiveIndexes.byPath) >= maxCachedArchives {
+		var oldest string
+		for p, idx := range archiveIndexes.byPath {
+			if oldest == "" || idx.used.Before(archiveIndexes.byPath[oldest].used) {
+				oldest = p
+			}
+		}
+		delete(archiveIndexes.byPath, oldest)
+	}
+	archiveIndexes.byPath[path] = &archiveIndex{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		entries: entries,
+		used:    time.Now(),
+	}
+	return entries, nil
+}
+
+// handleArchive lists the direct children of dir inside a zip or tar
+// archive with their uncompressed sizes, like a directory listing
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	kind := archive.Kind(path)
+	if kind == "" {
+		http.Error(w, "not a zip or tar archive: "+path, http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(scan.LocalPath(path))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	entries, err := readArchive(r.Context(), path, info)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read archive: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	dir := r.URL.Query().Get("dir")
+	resp := ArchiveListing{
+		Path:        path,
+		Kind:        kind,
+		Dir:         dir,
+		ArchiveSize: info.Size(),
+		Entries:     len(entries),
+		Children:    archive.List(entries, dir),
+	}
+	for _, c := range resp.Children {
+		resp.Size += c.Size
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
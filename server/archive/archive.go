@@ -0,0 +1,191 @@
+// Package archive reads the table of contents of zip and tar archives
+// without extracting them.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// Entry is a file or directory stored in an archive
+type Entry struct {
+	Name string // slash separated, without leading or trailing slash
+	Size int64  // uncompressed
+	// CompressedSize is the stored size, zip only, 0 elsewhere
+	CompressedSize int64
+	ModTime        time.Time
+	IsDir          bool
+}
+
+// Child aggregates the entries below one direct child of a directory in the archive
+type Child struct {
+	Name           string    `json:"name"`
+	IsDir          bool      `json:"isDir"`
+	Size           int64     `json:"size"`
+	CompressedSize int64     `json:"compressedSize,omitempty"`
+	Count          int64     `json:"count"` // number of files below a directory, 1 for files
+	ModTime        time.Time `json:"modTime"`
+}
+
+// Kind returns the archive format of a file name: zip, tar, tar.gz or tar.bz2, or "" for none
+func Kind(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".jar"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar.bz2"), strings.HasSuffix(name, ".tbz2"):
+		return "tar.bz2"
+	}
+	return ""
+}
+
+// Read lists all entries of the archive at file. Compressed tarballs
+// are decompressed as a stream, nothing is written to disk. It stops with
+// the error of ctx once ctx is done, the next entry is not read then.
+func Read(ctx context.Context, file string) ([]Entry, error) {
+	kind := Kind(file)
+	if kind == "zip" {
+		return readZip(ctx, file)
+	}
+	if kind == "" {
+		return nil, fmt.Errorf("not an archive: %s", file)
+	}
+	f, err := os.Open(scan.LocalPath(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch kind {
+	case "tar.gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case "tar.bz2":
+		r = bzip2.NewReader(f)
+	}
+	return readTar(ctx, r)
+}
+
+func readZip(ctx context.Context, file string) ([]Entry, error) {
+	zr, err := zip.OpenReader(scan.LocalPath(file))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	entries := make([]Entry, 0, len(zr.File))
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Name:           cleanName(f.Name),
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			ModTime:        f.Modified,
+			IsDir:          f.FileInfo().IsDir(),
+		})
+	}
+	return entries, nil
+}
+
+func readTar(ctx context.Context, r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeGNUSparse, tar.TypeSymlink, tar.TypeLink:
+		default:
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:    cleanName(h.Name),
+			Size:    h.Size,
+			ModTime: h.ModTime,
+			IsDir:   h.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+func cleanName(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// List aggregates entries into the direct children of dir, largest first.
+// dir is slash separated, "" is the top of the archive.
+func List(entries []Entry, dir string) []Child {
+	dir = cleanName(dir)
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	children := make(map[string]*Child)
+	for _, e := range entries {
+		if e.Name == "" || !strings.HasPrefix(e.Name, prefix) {
+			continue
+		}
+		rest := e.Name[len(prefix):]
+		if rest == "" {
+			continue
+		}
+		name, _, nested := strings.Cut(rest, "/")
+		c := children[name]
+		if c == nil {
+			c = &Child{Name: name}
+			children[name] = c
+		}
+		if nested || e.IsDir {
+			c.IsDir = true
+		}
+		if e.ModTime.After(c.ModTime) {
+			c.ModTime = e.ModTime
+		}
+		if e.IsDir {
+			continue
+		}
+		c.Size += e.Size
+		c.CompressedSize += e.CompressedSize
+		c.Count++
+	}
+	list := make([]Child, 0, len(children))
+	for _, c := range children {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Size != list[j].Size {
+			return list[i].Size > list[j].Size
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
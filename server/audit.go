@@ -0,0 +1,399 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAuditLimit is the number of entries /api/audit returns by default
+const defaultAuditLimit = 200
+
+// AuditEntry is one destructive action, recorded whether or not it succeeded
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Action is e.g. trash, delete, truncate, mount or unmount
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	// Size is the size of the path before the action, 0 when unknown
+	Size int64 `json:"size"`
+	// Client is the address of the requesting client, "scheduler" for
+	// rules run by their schedule
+	Client string `json:"client"`
+	// User is the user of access.json the client logged in as
+	User      string `json:"user,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Via names what acted when it was not a plain request, e.g. a rule or a plugin
+	Via   string `json:"via,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Prev is the sha256 of the previous line of the log, so that editing
+	// or removing an entry breaks the chain
+	Prev string `json:"prev"`
+}
+
+// AuditClient is who asked for an action
+type AuditClient struct {
+	Addr      string
+	User      string
+	UserAgent string
+}
+
+type AuditResponse struct {
+	File    string       `json:"file"`
+	Entries []AuditEntry `json:"entries"`
+	// Total is the number of entries matching, Entries holds the newest
+	Total int `json:"total"`
+	// Verified reports whether every line links to the one before it and
+	// the log still holds the last line recorded in its anchor. BrokenAt is
+	// the first line that does not link, Anchor tells what the anchor misses.
+	Verified bool   `json:"verified"`
+	BrokenAt int    `json:"brokenAt,omitempty"`
+	Anchor   string `json:"anchor,omitempty"`
+}
+
+// auditAnchor is kept next to the log, in audit-anchor.json: the number of
+// lines written and the hash of the last one. The chain alone cannot tell
+// that entries were cut off its end, or the whole log replaced.
+type auditAnchor struct {
+	Lines int    `json:"lines"`
+	Last  string `json:"last"`
+}
+
+// auditLog appends to the audit log, it is never rewritten
+var auditLog = struct {
+	sync.Mutex
+	once   sync.Once
+	file   string
+	anchor string
+	lines  int
+	last   string // hash of the last line
+	// broken tells how the log differs from its anchor on load, "" if not
+	broken string
+}{}
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For names the
+// client, set by --trusted-proxy
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the addresses or CIDR ranges of the reverse
+// proxies in front of the server, e.g. 127.0.0.1 or 10.0.0.0/8
+func SetTrustedProxies(list []string) error {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("invalid proxy address: %s", s)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 128
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid proxy address: %s", s)
+		}
+		nets = append(nets, n)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type auditClientKey struct{}
+
+// auditClientOf returns the client of a request. Behind trusted proxies it
+// is the address they forwarded: the last one of X-Forwarded-For that is
+// not a trusted proxy itself, the ones before it anybody can make up.
+func auditClientOf(r *http.Request) AuditClient {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" && trustedProxy(addr) {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			if hop := strings.TrimSpace(hops[i]); hop != "" {
+				addr = hop
+				if !trustedProxy(hop) {
+					break
+				}
+			}
+		}
+	}
+	client := AuditClient{Addr: addr, UserAgent: r.UserAgent()}
+	if u := userOf(r.Context()); u != nil {
+		client.User = u.Name
+	}
+	return client
+}
+
+// withAuditClient passes the client of r to actions that only get a context
+func withAuditClient(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, auditClientKey{}, auditClientOf(r))
+}
+
+// auditClientFrom returns the client stored by withAuditClient, the
+// scheduler when there is none
+func auditClientFrom(ctx context.Context) AuditClient {
+	if c, ok := ctx.Value(auditClientKey{}).(AuditClient); ok {
+		return c
+	}
+	return AuditClient{Addr: "scheduler"}
+}
+
+// auditSize is the size of path before it is removed: that of a file, the
+// cached size of a directory
+func auditSize(path string) int64 {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0
+	}
+	if info.IsDir() {
+		return cachedSize(path)
+	}
+	return info.Size()
+}
+
+// loadAuditLog finds the end of the chain once and checks it against the
+// anchor, callers hold auditLog.Mutex
+func loadAuditLog() {
+	auditLog.once.Do(func() {
+		auditLog.file = configPath("audit.log")
+		auditLog.anchor = configPath("audit-anchor.json")
+		var anchor *auditAnchor
+		if data, err := os.ReadFile(auditLog.anchor); err == nil {
+			anchor = &auditAnchor{}
+			if err := json.Unmarshal(data, anchor); err != nil {
+				auditLog.broken = "unreadable anchor: " + err.Error()
+				anchor = nil
+			}
+		}
+		var anchored string
+		auditLog.lines, auditLog.last, anchored = scanAuditLog(auditLog.file, anchor)
+		if anchor != nil && anchored != anchor.Last {
+			if auditLog.lines < anchor.Lines {
+				auditLog.broken = fmt.Sprintf("the log has %d lines, %d were written", auditLog.lines, anchor.Lines)
+			} else {
+				auditLog.broken = fmt.Sprintf("line %d is not the one written", anchor.Lines)
+			}
+		}
+		if auditLog.broken != "" {
+			log.Printf("Audit log %s does not match its anchor: %s", auditLog.file, auditLog.broken)
+		}
+	})
+}
+
+// scanAuditLog returns the number of lines of file, the hash of the last
+// one and that of the line the anchor names
+func scanAuditLog(file string, anchor *auditAnchor) (lines int, last string, anchored string) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, "", ""
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lines++
+			last = lineHash(line)
+			if anchor != nil && lines == anchor.Lines {
+				anchored = last
+			}
+		}
+		if err != nil {
+			return lines, last, anchored
+		}
+	}
+}
+
+// saveAuditAnchor records the end of the log, callers hold auditLog.Mutex
+func saveAuditAnchor() {
+	data, _ := json.Marshal(auditAnchor{Lines: auditLog.lines, Last: auditLog.last})
+	tmp := auditLog.anchor + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Error writing audit anchor: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, auditLog.anchor); err != nil {
+		log.Printf("Error writing audit anchor: %v", err)
+	}
+}
+
+// audit appends an entry to the audit log, err is the outcome of the action
+func audit(client AuditClient, action, path string, size int64, via string, err error) {
+	e := AuditEntry{
+		Time:      time.Now(),
+		Action:    action,
+		Path:      path,
+		Size:      size,
+		Client:    client.Addr,
+		User:      client.User,
+		UserAgent: client.UserAgent,
+		Via:       via,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	loadAuditLog()
+	e.Prev = auditLog.last
+	line, _ := json.Marshal(e)
+	line = append(line, '\n')
+	if err := os.MkdirAll(filepath.Dir(auditLog.file), 0755); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	f, err := os.OpenFile(auditLog.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	auditLog.last = lineHash(line)
+	auditLog.lines++
+	saveAuditAnchor()
+}
+
+// auditRecorder keeps the status and error message of a response, for
+// handlers that report failures in many places
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (a *auditRecorder) WriteHeader(status int) {
+	a.status = status
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditRecorder) Write(p []byte) (int, error) {
+	if a.status >= 400 {
+		a.body = append(a.body, p...)
+	}
+	return a.ResponseWriter.Write(p)
+}
+
+// err is the error the handler responded with, nil on success
+func (a *auditRecorder) err() error {
+	if a.status < 400 {
+		return nil
+	}
+	return errors.New(strings.TrimSpace(string(a.body)))
+}
+
+func lineHash(line []byte) string {
+	h := sha256.Sum256(line)
+	return hex.EncodeToString(h[:])
+}
+
+// handleAudit lists the newest entries of the audit log, filtered by
+// action, client, user, since and path, which matches the path and below
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	action := query.Get("action")
+	client := query.Get("client")
+	user := query.Get("user")
+	path := query.Get("path")
+	if path != "" {
+		var err error
+		if path, err = filepath.Abs(path); err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	since, err := parseTime(query.Get("since"))
+	if err != nil {
+		http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultAuditLimit
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	auditLog.Lock()
+	loadAuditLog()
+	file, broken := auditLog.file, auditLog.broken
+	auditLog.Unlock()
+
+	resp := AuditResponse{File: file, Entries: []AuditEntry{}, Verified: broken == "", Anchor: broken}
+	f, err := os.Open(file)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f != nil {
+		defer f.Close()
+		reader := bufio.NewReader(f)
+		prev := ""
+		for n := 1; ; n++ {
+			line, err := reader.ReadBytes('\n')
+			if len(line) == 0 && err != nil {
+				break
+			}
+			var e AuditEntry
+			valid := json.Unmarshal(line, &e) == nil
+			if (!valid || e.Prev != prev) && resp.BrokenAt == 0 {
+				resp.Verified, resp.BrokenAt = false, n
+			}
+			prev = lineHash(line)
+			if !valid || action != "" && e.Action != action || client != "" && e.Client != client || user != "" && e.User != user ||
+				!since.IsZero() && e.Time.Before(since) ||
+				path != "" && e.Path != path && !strings.HasPrefix(e.Path, strings.TrimSuffix(path, string(filepath.Separator))+string(filepath.Separator)) {
+				continue
+			}
+			resp.Total++
+			resp.Entries = append(resp.Entries, e)
+			if len(resp.Entries) > limit {
+				resp.Entries = resp.Entries[1:]
+			}
+		}
+	}
+	// newest first
+	for i, j := 0, len(resp.Entries)-1; i < j; i, j = i+1, j-1 {
+		resp.Entries[i], resp.Entries[j] = resp.Entries[j], resp.Entries[i]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/server/baseline"
+)
+
+const (
+	// defaultBaselineMinFileSize is the size from which a manifest lists files
+	defaultBaselineMinFileSize = 1 << 20
+	// defaultBaselineMinGrowth is the growth from which a comparison lists a change
+	defaultBaselineMinGrowth = 1 << 20
+	// maxBaselineChanges caps the changes of a comparison, the totals count all
+	maxBaselineChanges = 1000
+)
+
+// baselineName is what a saved baseline may be called, it names its file
+var baselineName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// BaselineInfo describes a saved baseline
+type BaselineInfo struct {
+	Name      string    `json:"name"`
+	Root      string    `json:"root"`
+	Host      string    `json:"host,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+}
+
+// BaselineReport is how the live tree differs from a baseline
+type BaselineReport struct {
+	Root     string       `json:"root"`
+	Baseline BaselineInfo `json:"baseline"`
+	Size     int64        `json:"size"`
+	BaseSize int64        `json:"baseSize"`
+	Delta    int64        `json:"delta"`
+	// Added, Grown and Removed count the changes of each kind
+	Added     int               `json:"added"`
+	Grown     int               `json:"grown"`
+	Removed   int               `json:"removed"`
+	Changes   []baseline.Change `json:"changes"`
+	Truncated bool              `json:"truncated"`
+}
+
+func baselineFile(name string) (string, error) {
+	if !baselineName.MatchString(name) {
+		return "", fmt.Errorf("invalid baseline name: %q", name)
+	}
+	return filepath.Join(configPath("baselines"), name+".json"), nil
+}
+
+// buildManifest scans path, again unless fresh is false and it is cached,
+// and records its directories and the files from minFileSize
+func buildManifest(ctx context.Context, path string, minFileSize int64, fresh bool) (*baseline.Manifest, error) {
+	s, root, src, err := resolvePath(ctx, path, DefaultProfile)
+	if err != nil {
+		return nil, err
+	}
+	if fresh {
+		invalidateCaches(displayPath(src, root))
+	}
+	entry := s.ScanEntry(ctx, root, func(int64, int64) {})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if _, err := entry.Outcome(); err != nil {
+		return nil, err
+	}
+
+	m := &baseline.Manifest{
+		Version:     baseline.Version,
+		Root:        displayPath(src, root),
+		CreatedAt:   time.Now(),
+		MinFileSize: minFileSize,
+		Dirs:        []baseline.Dir{},
+		Files:       []baseline.File{},
+	}
+	if src == nil {
+		m.Host, _ = os.Hostname()
+	}
+	for _, e := range s.Cache().Under(root) {
+		rel, err := filepath.Rel(root, e.Path())
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		e.Lock()
+		m.Dirs = append(m.Dirs, baseline.Dir{Path: rel, Size: e.Size, Count: e.Count})
+		for _, f := range e.Files {
+			if f.Size >= minFileSize {
+				m.Files = append(m.Files, baseline.File{Path: strings.TrimPrefix(rel+"/"+f.Name, "/"), Size: f.Size, ModTime: f.ModTime})
+			}
+		}
+		e.Unlock()
+	}
+	m.Sort()
+	return m, nil
+}
+
+func readBaseline(file string) (*baseline.Manifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseBaseline(data)
+}
+
+func parseBaseline(data []byte) (*baseline.Manifest, error) {
+	var m baseline.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid baseline manifest: %v", err)
+	}
+	if m.Version != baseline.Version {
+		return nil, fmt.Errorf("unsupported baseline manifest version %d", m.Version)
+	}
+	return &m, nil
+}
+
+func infoOf(name string, m *baseline.Manifest) BaselineInfo {
+	return BaselineInfo{Name: name, Root: m.Root, Host: m.Host, CreatedAt: m.CreatedAt, Size: m.Size()}
+}
+
+// handleExportBaseline returns the manifest of path, scanned unless it is
+// cached. A POST, which needs the operator role, scans it again unless
+// cached=true and also saves the manifest under the baselines of the
+// config directory when name is given.
+func handleExportBaseline(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	minFileSize := int64(defaultBaselineMinFileSize)
+	if v := query.Get("minFileSize"); v != "" {
+		var err error
+		if minFileSize, err = parseSize(v); err != nil {
+			http.Error(w, "Invalid minFileSize: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	name := query.Get("name")
+	post := r.Method == http.MethodPost
+	if name != "" && !post {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if post && !hasRole(r.Context(), RoleOperator) {
+		http.Error(w, "Forbidden: rescanning or saving a baseline requires the operator role", http.StatusForbidden)
+		return
+	}
+	var file string
+	if name != "" {
+		var err error
+		if file, err = baselineFile(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	m, err := buildManifest(r.Context(), path, minFileSize, post && query.Get("cached") != "true")
+	if err != nil {
+		http.Error(w, "Failed to scan: "+err.Error(), scanErrorStatus(err))
+		return
+	}
+	if file != "" {
+		if err := saveJSON(file, m); err != nil {
+			http.Error(w, "Failed to save baseline: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="baseline.json"`)
+	json.NewEncoder(w).Encode(m)
+}
+
+// handleListBaselines lists the saved baselines, newest first
+func handleListBaselines(w http.ResponseWriter, r *http.Request) {
+	files, _ := filepath.Glob(filepath.Join(configPath("baselines"), "*.json"))
+	infos := []BaselineInfo{}
+	for _, file := range files {
+		m, err := readBaseline(file)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, infoOf(strings.TrimSuffix(filepath.Base(file), ".json"), m))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.After(infos[j].CreatedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// handleCompareBaseline compares the live tree with the saved baseline
+// name, or with the manifest posted as the body. path defaults to the
+// root of the baseline.
+func handleCompareBaseline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	name := query.Get("name")
+	var base *baseline.Manifest
+	if name != "" {
+		file, err := baselineFile(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if base, err = readBaseline(file); err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "Baseline not found: "+name, http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if base, err = parseBaseline(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	minGrowth := int64(defaultBaselineMinGrowth)
+	if v := query.Get("minGrowth"); v != "" {
+		var err error
+		if minGrowth, err = parseSize(v); err != nil {
+			http.Error(w, "Invalid minGrowth: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	path := query.Get("path")
+	if path == "" {
+		path = base.Root
+	}
+
+	live, err := buildManifest(r.Context(), path, base.MinFileSize, query.Get("cached") != "true")
+	if err != nil {
+		http.Error(w, "Failed to scan: "+err.Error(), scanErrorStatus(err))
+		return
+	}
+	report := BaselineReport{
+		Root:     live.Root,
+		Baseline: infoOf(name, base),
+		Size:     live.Size(),
+		BaseSize: base.Size(),
+		Changes:  baseline.Compare(base, live, minGrowth),
+	}
+	report.Delta = report.Size - report.BaseSize
+	for _, c := range report.Changes {
+		switch c.Kind {
+		case "added":
+			report.Added++
+		case "grown":
+			report.Grown++
+		case "removed":
+			report.Removed++
+		}
+	}
+	if len(report.Changes) > maxBaselineChanges {
+		report.Changes = report.Changes[:maxBaselineChanges]
+		report.Truncated = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
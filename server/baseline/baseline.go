@@ -0,0 +1,197 @@
+// Package baseline compares a directory tree against a manifest of how it
+// looked before, e.g. right after a build agent or a kiosk machine was
+// imaged. A manifest records the size of every directory and the files
+// above a size threshold, enough to tell what was added and what grew.
+package baseline
+
+import (
+	"path"
+	"sort"
+	"time"
+)
+
+// Version is the version of the manifest format
+const Version = 1
+
+// Manifest is the state of a tree, paths are slash separated and relative
+// to Root, "" is Root itself
+type Manifest struct {
+	Version   int       `json:"version"`
+	Root      string    `json:"root"`
+	Host      string    `json:"host,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// MinFileSize is the size from which files are listed, smaller ones
+	// only count in the size of their directory
+	MinFileSize int64  `json:"minFileSize"`
+	Dirs        []Dir  `json:"dirs"`
+	Files       []File `json:"files"`
+}
+
+type Dir struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Count int64  `json:"count"`
+}
+
+type File struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Sort orders the directories and files of m by path, so that manifests
+// of the same tree are identical
+func (m *Manifest) Sort() {
+	sort.Slice(m.Dirs, func(i, j int) bool { return m.Dirs[i].Path < m.Dirs[j].Path })
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+}
+
+// Size is the total size of the tree
+func (m *Manifest) Size() int64 {
+	for _, d := range m.Dirs {
+		if d.Path == "" {
+			return d.Size
+		}
+	}
+	return 0
+}
+
+// Change is a difference between the baseline and the live tree
+type Change struct {
+	Path string `json:"path"`
+	// Kind is "added", "removed" or "grown"
+	Kind     string `json:"kind"`
+	IsDir    bool   `json:"isDir"`
+	Size     int64  `json:"size"`
+	BaseSize int64  `json:"baseSize"`
+	Delta    int64  `json:"delta"`
+}
+
+// Compare lists how live differs from base. Only the topmost added or
+// removed directory of a subtree is listed, and a directory that grew by
+// at least minGrowth only when at least minGrowth of it is not explained
+// by a single change below it, so that the list points at where things grow
+// rather than at all their ancestors. Files are compared from the larger
+// of both MinFileSize, a file that crossed it since counts as added.
+// Changes are ordered by decreasing delta, removals last.
+func Compare(base, live *Manifest, minGrowth int64) []Change {
+	minFileSize := max(base.MinFileSize, live.MinFileSize)
+	baseDirs := make(map[string]Dir, len(base.Dirs))
+	for _, d := range base.Dirs {
+		baseDirs[d.Path] = d
+	}
+	liveDirs := make(map[string]Dir, len(live.Dirs))
+	for _, d := range live.Dirs {
+		liveDirs[d.Path] = d
+	}
+
+	changes := []Change{}
+	// largest is the largest delta of a change directly below each directory
+	largest := make(map[string]int64)
+	add := func(c Change) {
+		changes = append(changes, c)
+		if c.Path != "" {
+			parent := parentOf(c.Path)
+			largest[parent] = max(largest[parent], c.Delta)
+		}
+	}
+
+	for _, d := range live.Dirs {
+		if _, ok := baseDirs[d.Path]; ok || d.Path == "" {
+			continue
+		}
+		if _, ok := baseDirs[parentOf(d.Path)]; ok {
+			add(Change{Path: d.Path, Kind: "added", IsDir: true, Size: d.Size, Delta: d.Size})
+		}
+	}
+	for _, d := range base.Dirs {
+		if _, ok := liveDirs[d.Path]; ok || d.Path == "" {
+			continue
+		}
+		if _, ok := liveDirs[parentOf(d.Path)]; ok {
+			add(Change{Path: d.Path, Kind: "removed", IsDir: true, BaseSize: d.Size, Delta: -d.Size})
+		}
+	}
+
+	baseFiles := make(map[string]File, len(base.Files))
+	for _, f := range base.Files {
+		if f.Size >= minFileSize {
+			baseFiles[f.Path] = f
+		}
+	}
+	liveFiles := make(map[string]bool, len(live.Files))
+	for _, f := range live.Files {
+		if f.Size < minFileSize {
+			continue
+		}
+		liveFiles[f.Path] = true
+		b, ok := baseFiles[f.Path]
+		switch {
+		case !ok:
+			if _, ok := baseDirs[parentOf(f.Path)]; ok {
+				add(Change{Path: f.Path, Kind: "added", Size: f.Size, Delta: f.Size})
+			}
+		case f.Size-b.Size >= minGrowth && f.Size > b.Size:
+			add(Change{Path: f.Path, Kind: "grown", Size: f.Size, BaseSize: b.Size, Delta: f.Size - b.Size})
+		}
+	}
+	for _, f := range base.Files {
+		if f.Size < minFileSize || liveFiles[f.Path] {
+			continue
+		}
+		if _, ok := liveDirs[parentOf(f.Path)]; ok {
+			add(Change{Path: f.Path, Kind: "removed", BaseSize: f.Size, Delta: -f.Size})
+		}
+	}
+
+	// deepest directories first, their changes decide whether a parent is listed
+	grown := make([]Dir, 0, len(live.Dirs))
+	for _, d := range live.Dirs {
+		if b, ok := baseDirs[d.Path]; ok && d.Size-b.Size >= minGrowth && d.Size > b.Size {
+			grown = append(grown, d)
+		}
+	}
+	sort.Slice(grown, func(i, j int) bool { return depth(grown[i].Path) > depth(grown[j].Path) })
+	for _, d := range grown {
+		b := baseDirs[d.Path]
+		delta := d.Size - b.Size
+		c := Change{Path: d.Path, Kind: "grown", IsDir: true, Size: d.Size, BaseSize: b.Size, Delta: delta}
+		if delta-largest[d.Path] < minGrowth {
+			// explained by a change below, only carried up to the parent
+			if d.Path != "" {
+				parent := parentOf(d.Path)
+				largest[parent] = max(largest[parent], delta)
+			}
+			continue
+		}
+		add(c)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Delta != changes[j].Delta {
+			return changes[i].Delta > changes[j].Delta
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func parentOf(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+func depth(p string) int {
+	if p == "" {
+		return 0
+	}
+	n := 1
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// Bookmark is a pinned path. Size is the result of the last finished
+// scan, VisitSize the size when the user last looked at it.
+type Bookmark struct {
+	Path      string    `json:"path"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+	ScannedAt time.Time `json:"scannedAt"`
+	VisitSize int64     `json:"visitSize"`
+	VisitedAt time.Time `json:"visitedAt"`
+	// Delta is Size - VisitSize, how much the path grew since the last visit
+	Delta int64 `json:"delta"`
+}
+
+var bookmarks = struct {
+	sync.Mutex
+	once  sync.Once
+	file  string
+	items []*Bookmark
+}{}
+
+// loadBookmarks reads the bookmarks file once, callers hold bookmarks.Mutex
+func loadBookmarks() {
+	bookmarks.once.Do(func() {
+		bookmarks.file = configPath("bookmarks.json")
+		if err := loadJSON(bookmarks.file, &bookmarks.items); err != nil {
+			log.Printf("Error loading bookmarks %s: %v", bookmarks.file, err)
+		}
+	})
+}
+
+func saveBookmarks() {
+	if err := saveJSON(bookmarks.file, bookmarks.items); err != nil {
+		log.Printf("Error saving bookmarks: %v", err)
+	}
+}
+
+func findBookmark(path string) *Bookmark {
+	for _, b := range bookmarks.items {
+		if b.Path == path {
+			return b
+		}
+	}
+	return nil
+}
+
+// refreshBookmark takes the size of a finished scan of b from the cache
+func refreshBookmark(b *Bookmark) bool {
+	entry := GlobalCache.GetEntry(b.Path)
+	if entry == nil || !entry.IsDone() {
+		return false
+	}
+	size, _ := entry.Usage()
+	if size == b.Size && !b.ScannedAt.IsZero() {
+		return false
+	}
+	if b.ScannedAt.IsZero() {
+		// first scan, nothing to compare with yet
+		b.VisitSize = size
+	}
+	b.Size = size
+	b.ScannedAt = time.Now()
+	return true
+}
+
+// bookmarkPath resolves the path query parameter
+func bookmarkPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return "", false
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return path, true
+}
+
+func handleListBookmarks(w http.ResponseWriter, r *http.Request) {
+	bookmarks.Lock()
+	defer bookmarks.Unlock()
+	loadBookmarks()
+
+	changed := false
+	list := make([]Bookmark, 0, len(bookmarks.items))
+	for _, b := range bookmarks.items {
+		if refreshBookmark(b) {
+			changed = true
+		}
+		b.Delta = b.Size - b.VisitSize
+		list = append(list, *b)
+	}
+	if changed {
+		saveBookmarks()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func handleAddBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+	if info, err := os.Stat(scan.LocalPath(path)); err != nil || !info.IsDir() {
+		http.Error(w, "not a directory: "+path, http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = filepath.Base(path)
+	}
+
+	bookmarks.Lock()
+	defer bookmarks.Unlock()
+	loadBookmarks()
+	if b := findBookmark(path); b != nil {
+		b.Name = name
+	} else {
+		b := &Bookmark{Path: path, Name: name, CreatedAt: time.Now()}
+		refreshBookmark(b)
+		b.VisitedAt = b.CreatedAt
+		bookmarks.items = append(bookmarks.items, b)
+	}
+	saveBookmarks()
+	w.Write([]byte("ok"))
+}
+
+func handleRemoveBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+
+	bookmarks.Lock()
+	defer bookmarks.Unlock()
+	loadBookmarks()
+	for i, b := range bookmarks.items {
+		if b.Path == path {
+			bookmarks.items = append(bookmarks.items[:i], bookmarks.items[i+1:]...)
+			saveBookmarks()
+			w.Write([]byte("ok"))
+			return
+		}
+	}
+	http.Error(w, "bookmark not found", http.StatusNotFound)
+}
+
+// handleVisitBookmark marks a bookmark as seen, the delta restarts from its current size
+func handleVisitBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+
+	bookmarks.Lock()
+	defer bookmarks.Unlock()
+	loadBookmarks()
+	b := findBookmark(path)
+	if b == nil {
+		http.Error(w, "bookmark not found", http.StatusNotFound)
+		return
+	}
+	refreshBookmark(b)
+	b.VisitSize, b.VisitedAt = b.Size, time.Now()
+	saveBookmarks()
+	w.Write([]byte("ok"))
+}
+
+// handleRescanBookmark drops the cached results of a bookmark and scans it
+// again in the background, the new size shows up in the list once done
+func handleRescanBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+
+	bookmarks.Lock()
+	loadBookmarks()
+	found := findBookmark(path) != nil
+	bookmarks.Unlock()
+	if !found {
+		http.Error(w, "bookmark not found", http.StatusNotFound)
+		return
+	}
+
+	Rescan(path)
+	w.Write([]byte("ok"))
+}
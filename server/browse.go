@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"disk-usage-analyser/scan"
+)
+
+// handleBrowse deep-links to a directory: /browse/<path> opens the
+// usage view at path, e.g. /browse/Users/me/Downloads or /browse/%2Fvar%2Flog
+func handleBrowse(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/browse")
+	if runtime.GOOS == "windows" {
+		// /browse/C:/Users -> C:/Users
+		path = strings.TrimPrefix(path, "/")
+	}
+	if path == "" || path == "/" && r.URL.RawPath == "" {
+		// /browse and /browse/ open the initial dir, the root is /browse/%2F
+		http.Redirect(w, r, "/usage", http.StatusFound)
+		return
+	}
+	path = filepath.Clean(filepath.FromSlash(path))
+	if !filepath.IsAbs(path) {
+		http.Error(w, "Invalid path: "+path+" is not absolute", http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(scan.LocalPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Not found: "+path, http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !info.IsDir() {
+		http.Error(w, "Not a directory: "+path, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/usage?path="+url.QueryEscape(path), http.StatusFound)
+}
@@ -0,0 +1,257 @@
+// Package browser knows where web browsers keep their caches. Only
+// cache directories are ever cleaned, never cookies, history or bookmarks.
+package browser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"disk-usage-analyser/scan"
+)
+
+// Profile is a browser profile and its existing cache directories
+type Profile struct {
+	Name      string   `json:"name"`
+	CacheDirs []string `json:"cacheDirs"`
+}
+
+// Browser is an installed browser with at least one cache
+type Browser struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Profiles []Profile `json:"profiles"`
+}
+
+// chromiumCaches are the cache directories of a Chromium profile, found
+// both in the profile and, on macOS and Linux, in a separate cache root
+var chromiumCaches = []string{"Cache", "Code Cache", "GPUCache", "DawnCache", "DawnGraphiteCache", "DawnWebGPUCache", "GrShaderCache", "ShaderCache"}
+
+var firefoxCaches = []string{"cache2", "startupCache", "thumbnails", "shader-cache", "jumpListCache"}
+
+// browserDirs tells where a browser keeps its profiles and caches
+type browserDirs struct {
+	id   string
+	name string
+	// profileRoot holds the profiles, cacheRoot a copy of the profile
+	// layout with the caches only, both may be the same directory
+	profileRoot string
+	cacheRoot   string
+	// caches are the cache directory names inside a profile
+	caches []string
+	// flat means profileRoot holds the caches directly, like Safari
+	flat bool
+	// isProfile selects the profile directories inside profileRoot
+	isProfile func(name string) bool
+}
+
+func isChromiumProfile(name string) bool {
+	return name == "Default" || strings.HasPrefix(name, "Profile ") || name == "Guest Profile"
+}
+
+// Firefox profiles are named <random>.<name>
+func isFirefoxProfile(name string) bool {
+	return strings.Contains(name, ".")
+}
+
+func knownBrowsers() ([]browserDirs, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %v", err)
+	}
+	home := func(elem ...string) string {
+		return filepath.Join(append([]string{homeDir}, elem...)...)
+	}
+	chromium := func(id string, name string, profileRoot string, cacheRoot string) browserDirs {
+		return browserDirs{id: id, name: name, profileRoot: profileRoot, cacheRoot: cacheRoot, caches: chromiumCaches, isProfile: isChromiumProfile}
+	}
+	firefox := func(profileRoot string, cacheRoot string) browserDirs {
+		return browserDirs{id: "firefox", name: "Firefox", profileRoot: profileRoot, cacheRoot: cacheRoot, caches: firefoxCaches, isProfile: isFirefoxProfile}
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		support := func(elem ...string) string {
+			return home(append([]string{"Library", "Application Support"}, elem...)...)
+		}
+		caches := func(elem ...string) string {
+			return home(append([]string{"Library", "Caches"}, elem...)...)
+		}
+		return []browserDirs{
+			chromium("chrome", "Google Chrome", support("Google", "Chrome"), caches("Google", "Chrome")),
+			chromium("chromium", "Chromium", support("Chromium"), caches("Chromium")),
+			chromium("edge", "Microsoft Edge", support("Microsoft Edge"), caches("Microsoft Edge")),
+			chromium("brave", "Brave", support("BraveSoftware", "Brave-Browser"), caches("BraveSoftware", "Brave-Browser")),
+			firefox(support("Firefox", "Profiles"), caches("Firefox", "Profiles")),
+			{
+				id:          "safari",
+				name:        "Safari",
+				profileRoot: caches("com.apple.Safari"),
+				cacheRoot:   home("Library", "Containers", "com.apple.Safari", "Data", "Library", "Caches", "com.apple.Safari"),
+				flat:        true,
+			},
+		}, nil
+	case "windows":
+		local := os.Getenv("LOCALAPPDATA")
+		roaming := os.Getenv("APPDATA")
+		if local == "" || roaming == "" {
+			return nil, fmt.Errorf("LOCALAPPDATA or APPDATA is not set")
+		}
+		data := func(elem ...string) string {
+			return filepath.Join(append(append([]string{local}, elem...), "User Data")...)
+		}
+		return []browserDirs{
+			chromium("chrome", "Google Chrome", data("Google", "Chrome"), data("Google", "Chrome")),
+			chromium("chromium", "Chromium", data("Chromium"), data("Chromium")),
+			chromium("edge", "Microsoft Edge", data("Microsoft", "Edge"), data("Microsoft", "Edge")),
+			chromium("brave", "Brave", data("BraveSoftware", "Brave-Browser"), data("BraveSoftware", "Brave-Browser")),
+			firefox(filepath.Join(roaming, "Mozilla", "Firefox", "Profiles"), filepath.Join(local, "Mozilla", "Firefox", "Profiles")),
+		}, nil
+	default:
+		config := home(".config")
+		if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+			config = dir
+		}
+		cache := home(".cache")
+		if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+			cache = dir
+		}
+		return []browserDirs{
+			chromium("chrome", "Google Chrome", filepath.Join(config, "google-chrome"), filepath.Join(cache, "google-chrome")),
+			chromium("chromium", "Chromium", filepath.Join(config, "chromium"), filepath.Join(cache, "chromium")),
+			chromium("edge", "Microsoft Edge", filepath.Join(config, "microsoft-edge"), filepath.Join(cache, "microsoft-edge")),
+			chromium("brave", "Brave", filepath.Join(config, "BraveSoftware", "Brave-Browser"), filepath.Join(cache, "BraveSoftware", "Brave-Browser")),
+			firefox(home(".mozilla", "firefox"), filepath.Join(cache, "mozilla", "firefox")),
+		}, nil
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(scan.LocalPath(path))
+	return err == nil && info.IsDir()
+}
+
+// profiles returns the profiles with at least one existing cache directory
+func (b *browserDirs) profiles() []Profile {
+	if b.flat {
+		var dirs []string
+		for _, dir := range []string{b.profileRoot, b.cacheRoot} {
+			if isDir(dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) == 0 {
+			return nil
+		}
+		return []Profile{{Name: "Default", CacheDirs: dirs}}
+	}
+
+	names := make(map[string]bool)
+	for _, root := range []string{b.profileRoot, b.cacheRoot} {
+		entries, _ := os.ReadDir(scan.LocalPath(root))
+		for _, e := range entries {
+			if e.IsDir() && b.isProfile(e.Name()) {
+				names[e.Name()] = true
+			}
+		}
+	}
+	var profiles []Profile
+	for name := range names {
+		p := Profile{Name: name}
+		seen := make(map[string]bool)
+		for _, root := range []string{b.profileRoot, b.cacheRoot} {
+			for _, c := range b.caches {
+				dir := filepath.Join(root, name, c)
+				if !seen[dir] && isDir(dir) {
+					seen[dir] = true
+					p.CacheDirs = append(p.CacheDirs, dir)
+				}
+			}
+		}
+		if len(p.CacheDirs) > 0 {
+			profiles = append(profiles, p)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// List returns the browsers that have caches on this machine
+func List() ([]Browser, error) {
+	known, err := knownBrowsers()
+	if err != nil {
+		return nil, err
+	}
+	var result []Browser
+	for _, b := range known {
+		profiles := b.profiles()
+		if len(profiles) == 0 {
+			continue
+		}
+		result = append(result, Browser{ID: b.id, Name: b.name, Profiles: profiles})
+	}
+	return result, nil
+}
+
+// Find returns the browser with the given id
+func Find(id string) (*Browser, error) {
+	browsers, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range browsers {
+		if b.ID == id {
+			return &b, nil
+		}
+	}
+	return nil, fmt.Errorf("browser not found: %s", id)
+}
+
+// CacheDirs returns the cache directories of a profile, of all profiles if profile is ""
+func (b *Browser) CacheDirs(profile string) ([]string, error) {
+	var dirs []string
+	found := false
+	for _, p := range b.Profiles {
+		if profile == "" || p.Name == profile {
+			found = true
+			dirs = append(dirs, p.CacheDirs...)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("profile not found: %s", profile)
+	}
+	return dirs, nil
+}
+
+// Plan returns the paths Clean would delete: the contents of the
+// cache directories, which are kept themselves
+func Plan(cacheDirs []string) ([]string, error) {
+	var paths []string
+	for _, dir := range cacheDirs {
+		entries, err := os.ReadDir(scan.LocalPath(dir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+		}
+		for _, e := range entries {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// Clean deletes the contents of the cache directories
+func Clean(cacheDirs []string) error {
+	paths, err := Plan(cacheDirs)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := os.RemoveAll(scan.LocalPath(p)); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"disk-usage-analyser/server/browser"
+)
+
+type BrowserProfileUsage struct {
+	browser.Profile
+	Size int64 `json:"size"`
+}
+
+type BrowserCacheInfo struct {
+	ID       string                `json:"id"`
+	Name     string                `json:"name"`
+	Profiles []BrowserProfileUsage `json:"profiles"`
+	Size     int64                 `json:"size"`
+}
+
+type BrowserCacheCleanResponse struct {
+	DryRun bool     `json:"dryRun"`
+	Size   int64    `json:"size"` // bytes expected to be freed
+	Delete []string `json:"delete"`
+}
+
+func handleListBrowserCaches(w http.ResponseWriter, r *http.Request) {
+	browsers, err := browser.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]BrowserCacheInfo, 0, len(browsers))
+	for _, b := range browsers {
+		info := BrowserCacheInfo{ID: b.ID, Name: b.Name, Profiles: make([]BrowserProfileUsage, 0, len(b.Profiles))}
+		for _, p := range b.Profiles {
+			usage := BrowserProfileUsage{Profile: p, Size: getCacheDirsSize(r, p.CacheDirs)}
+			info.Size += usage.Size
+			info.Profiles = append(info.Profiles, usage)
+		}
+		infos = append(infos, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// handleCleanBrowserCache deletes the cache contents of a browser,
+// of one profile if profile is given
+func handleCleanBrowserCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	b, err := browser.Find(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	dirs, err := b.CacheDirs(r.URL.Query().Get("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	paths, err := browser.Plan(dirs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := BrowserCacheCleanResponse{
+		DryRun: dryRun,
+		Size:   getCacheDirsSize(r, dirs),
+		Delete: paths,
+	}
+	if resp.Delete == nil {
+		resp.Delete = []string{}
+	}
+
+	if !dryRun {
+		log.Printf("Cleaning browser cache: %s", b.ID)
+		sizes := make([]int64, len(dirs))
+		for i, dir := range dirs {
+			sizes[i] = cachedSize(dir)
+		}
+		err := browser.Clean(dirs)
+		for i, dir := range dirs {
+			audit(auditClientOf(r), "delete", dir, sizes[i], "browser cache "+b.ID, err)
+			invalidateCaches(dir)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getCacheDirsSize(r *http.Request, dirs []string) int64 {
+	var size int64
+	for _, dir := range dirs {
+		dirSize, _ := getDirSizeWithCache(r.Context(), dir, func(int64, int64) {})
+		size += dirSize
+	}
+	return size
+}
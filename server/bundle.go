@@ -0,0 +1,60 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"disk-usage-analyser/scan"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+// bundleTypes maps macOS bundle extensions to a human readable bundle type
+var bundleTypes = map[string]string{
+	".app":           "Application",
+	".framework":     "Framework",
+	".photoslibrary": "Photos Library",
+	".bundle":        "Bundle",
+	".plugin":        "Plug-in",
+	".kext":          "Kernel Extension",
+	".xcarchive":     "Xcode Archive",
+	".sparsebundle":  "Sparse Disk Image",
+	".utm":           "UTM Virtual Machine",
+	".pvm":           "Parallels Virtual Machine",
+	".vmwarevm":      "VMware Virtual Machine",
+}
+
+// getBundleType returns the bundle type of a directory name, or "" if it is not a bundle
+func getBundleType(name string) string {
+	if runtime.GOOS != "darwin" {
+		return ""
+	}
+	return bundleTypes[strings.ToLower(filepath.Ext(name))]
+}
+
+// getBundleVersion reads CFBundleShortVersionString (or CFBundleVersion)
+// from the bundle's Info.plist, returns "" if not found
+func getBundleVersion(bundlePath string) string {
+	candidates := []string{
+		filepath.Join(bundlePath, "Contents", "Info.plist"),
+		filepath.Join(bundlePath, "Resources", "Info.plist"),
+		filepath.Join(bundlePath, "Versions", "Current", "Resources", "Info.plist"),
+	}
+	for _, plist := range candidates {
+		if _, err := os.Stat(scan.LocalPath(plist)); err != nil {
+			continue
+		}
+		for _, key := range []string{"CFBundleShortVersionString", "CFBundleVersion"} {
+			// plutil handles both XML and binary plists
+			out, err := cmd.New().Stderr(io.Discard).Output("plutil", "-extract", key, "raw", "-o", "-", plist)
+			if err == nil && strings.TrimSpace(out) != "" {
+				return strings.TrimSpace(out)
+			}
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+)
+
+type AgeBucketUsage struct {
+	Label string `json:"label"`
+	Size  int64  `json:"size"`
+}
+
+type DirAgeUsage struct {
+	Name    string           `json:"name"`
+	Size    int64            `json:"size"`
+	Buckets []AgeBucketUsage `json:"buckets"`
+}
+
+type ByAgeResponse struct {
+	Path     string           `json:"path"`
+	Size     int64            `json:"size"`
+	Buckets  []AgeBucketUsage `json:"buckets"`
+	Children []DirAgeUsage    `json:"children"` // subdirectories, largest first
+}
+
+// handleUsageByAge buckets subtree bytes by file age for path and each of its subdirectories
+func handleUsageByAge(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	if dirPath == "" {
+		dirPath = InitialDir
+	}
+	if dirPath == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	dirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, _ := getDirSizeWithCache(r.Context(), dirPath, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+
+	resp := ByAgeResponse{
+		Path:     dirPath,
+		Size:     size,
+		Children: []DirAgeUsage{},
+	}
+	if entry := GlobalCache.GetEntry(dirPath); entry != nil {
+		resp.Buckets = toAgeBuckets(entryStats(entry))
+	}
+	for _, child := range GlobalCache.Children(dirPath) {
+		childSize, _ := child.Usage()
+		resp.Children = append(resp.Children, DirAgeUsage{
+			Name:    child.Name(),
+			Size:    childSize,
+			Buckets: toAgeBuckets(entryStats(child)),
+		})
+	}
+	sort.Slice(resp.Children, func(i, j int) bool {
+		return resp.Children[i].Size > resp.Children[j].Size
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func toAgeBuckets(stats SubtreeStats) []AgeBucketUsage {
+	buckets := make([]AgeBucketUsage, 0, numAgeBuckets)
+	for i, b := range ageBuckets {
+		buckets = append(buckets, AgeBucketUsage{
+			Label: b.Label,
+			Size:  stats.AgeSizes[i],
+		})
+	}
+	return buckets
+}
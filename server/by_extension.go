@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+)
+
+type ExtensionUsage struct {
+	Extension string `json:"extension"` // lower-cased with the dot, "" for files without one
+	Size      int64  `json:"size"`
+}
+
+type ByExtensionResponse struct {
+	Path       string           `json:"path"`
+	Size       int64            `json:"size"`
+	Extensions []ExtensionUsage `json:"extensions"` // largest first
+}
+
+// handleUsageByExtension breaks the subtree bytes of path down by file extension,
+// it always scans with the deep profile
+func handleUsageByExtension(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	if dirPath == "" {
+		dirPath = InitialDir
+	}
+	if dirPath == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	dirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, _ := deepScanner.Scan(r.Context(), dirPath, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+
+	resp := ByExtensionResponse{
+		Path:       dirPath,
+		Size:       size,
+		Extensions: []ExtensionUsage{},
+	}
+	if entry := deepScanner.Cache().GetEntry(dirPath); entry != nil {
+		for ext, extSize := range entryStats(entry).Extensions {
+			resp.Extensions = append(resp.Extensions, ExtensionUsage{Extension: ext, Size: extSize})
+		}
+	}
+	sort.Slice(resp.Extensions, func(i, j int) bool {
+		a, b := resp.Extensions[i], resp.Extensions[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Extension < b.Extension
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+)
+
+type OwnerUsage struct {
+	UID   uint32 `json:"uid"`
+	Owner string `json:"owner"`
+	Size  int64  `json:"size"`
+}
+
+type ByOwnerResponse struct {
+	Path   string       `json:"path"`
+	Size   int64        `json:"size"`
+	Owners []OwnerUsage `json:"owners"`
+}
+
+// handleUsageByOwner aggregates the subtree size of path by file owner.
+// It reuses the scan cache and waits for the scan to finish.
+func handleUsageByOwner(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	if dirPath == "" {
+		dirPath = InitialDir
+	}
+	if dirPath == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	dirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, _ := getDirSizeWithCache(r.Context(), dirPath, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+
+	resp := ByOwnerResponse{
+		Path:   dirPath,
+		Size:   size,
+		Owners: []OwnerUsage{},
+	}
+	if entry := GlobalCache.GetEntry(dirPath); entry != nil {
+		stats := entryStats(entry)
+		for uid, n := range stats.OwnerSizes {
+			resp.Owners = append(resp.Owners, OwnerUsage{
+				UID:   uid,
+				Owner: lookupUserName(uid),
+				Size:  n,
+			})
+		}
+	}
+	sort.Slice(resp.Owners, func(i, j int) bool {
+		return resp.Owners[i].Size > resp.Owners[j].Size
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"disk-usage-analyser/server/fsstat"
+)
+
+type XattrUsage struct {
+	Name      string `json:"name"`
+	IsDir     bool   `json:"isDir"`
+	Size      int64  `json:"size"`
+	XattrSize int64  `json:"xattrSize"`
+}
+
+type ByXattrResponse struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	XattrSize int64  `json:"xattrSize"`
+	// Children carrying extended attributes, heaviest first
+	Children []XattrUsage `json:"children"`
+}
+
+// handleUsageByXattr reports the bytes held in extended attributes and
+// resource forks below path, per child, so heavy directories can be
+// drilled into. It always scans with the deep profile.
+func handleUsageByXattr(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	if dirPath == "" {
+		dirPath = InitialDir
+	}
+	if dirPath == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	dirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, _ := deepScanner.Scan(r.Context(), dirPath, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+
+	resp := ByXattrResponse{Path: dirPath, Size: size, Children: []XattrUsage{}}
+	cache := deepScanner.Cache()
+	if entry := cache.GetEntry(dirPath); entry != nil {
+		resp.XattrSize = entryStats(entry).XattrSize
+		entry.Lock()
+		files := entry.Files
+		entry.Unlock()
+		for _, f := range files {
+			if n, _ := fsstat.XattrSize(filepath.Join(dirPath, f.Name)); n > 0 {
+				resp.Children = append(resp.Children, XattrUsage{Name: f.Name, Size: f.Size, XattrSize: n})
+			}
+		}
+	}
+	for _, child := range cache.Children(dirPath) {
+		if n := entryStats(child).XattrSize; n > 0 {
+			childSize, _ := child.Usage()
+			resp.Children = append(resp.Children, XattrUsage{Name: child.Name(), IsDir: true, Size: childSize, XattrSize: n})
+		}
+	}
+	sort.Slice(resp.Children, func(i, j int) bool {
+		a, b := resp.Children[i], resp.Children[j]
+		if a.XattrSize != b.XattrSize {
+			return a.XattrSize > b.XattrSize
+		}
+		return a.Name < b.Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
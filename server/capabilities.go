@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+// fullDiskAccessURL deep-links to Privacy & Security > Full Disk Access
+const fullDiskAccessURL = "x-apple.systempreferences:com.apple.preference.security?Privacy_AllFiles"
+
+// maxDeniedPaths caps how many TCC-denied paths are remembered
+const maxDeniedPaths = 20
+
+type Capabilities struct {
+	OS               string `json:"os"`
+	PrivilegedHelper bool   `json:"privilegedHelper"`
+	MoveToTrash      bool   `json:"moveToTrash"`
+	// FullDiskAccess is nil when not applicable (non-macOS) or unknown
+	FullDiskAccess *bool    `json:"fullDiskAccess"`
+	DeniedPaths    []string `json:"deniedPaths,omitempty"` // paths denied by TCC during scans
+}
+
+var deniedPaths = struct {
+	sync.Mutex
+	paths []string
+}{}
+
+// isTCCDenied reports whether err looks like a macOS TCC denial.
+// TCC denies with EPERM ("operation not permitted"), while plain
+// unix permission problems are EACCES ("permission denied").
+func isTCCDenied(err error) bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+	return errors.Is(err, syscall.EPERM)
+}
+
+// recordDenied remembers dirPath if err is a TCC denial
+func recordDenied(dirPath string, err error) {
+	if !isTCCDenied(err) {
+		return
+	}
+	deniedPaths.Lock()
+	defer deniedPaths.Unlock()
+	for _, p := range deniedPaths.paths {
+		if p == dirPath {
+			return
+		}
+	}
+	if len(deniedPaths.paths) < maxDeniedPaths {
+		deniedPaths.paths = append(deniedPaths.paths, dirPath)
+	}
+}
+
+// detectFullDiskAccess probes directories protected by TCC
+func detectFullDiskAccess() *bool {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	probes := []string{
+		filepath.Join(homeDir, "Library", "Mail"),
+		filepath.Join(homeDir, "Library", "Messages"),
+		filepath.Join(homeDir, "Library", "Safari"),
+		"/Library/Application Support/com.apple.TCC",
+	}
+	for _, probe := range probes {
+		_, err := os.ReadDir(probe)
+		if err == nil {
+			granted := true
+			return &granted
+		}
+		if isTCCDenied(err) {
+			granted := false
+			return &granted
+		}
+		// not exist or other error, try the next one
+	}
+	return nil
+}
+
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	deniedPaths.Lock()
+	denied := append([]string(nil), deniedPaths.paths...)
+	deniedPaths.Unlock()
+
+	caps := Capabilities{
+		OS:               runtime.GOOS,
+		PrivilegedHelper: PrivilegedClient != nil,
+		MoveToTrash:      runtime.GOOS == "darwin",
+		FullDiskAccess:   detectFullDiskAccess(),
+		DeniedPaths:      denied,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(caps)
+}
+
+func handleOpenFullDiskAccess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if runtime.GOOS != "darwin" {
+		http.Error(w, "Full Disk Access is only available on macOS", http.StatusNotImplemented)
+		return
+	}
+
+	var outBuf bytes.Buffer
+	err := cmd.Debug().Stdout(&outBuf).Stderr(&outBuf).Run("open", fullDiskAccessURL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to open settings: %v\nOutput: %s", err, outBuf.String()), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
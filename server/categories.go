@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/category"
+)
+
+type CategoryUsage struct {
+	category.Category
+	Size int64 `json:"size"`
+	Done bool  `json:"done"`
+}
+
+type CategoriesResponse struct {
+	Categories []CategoryUsage `json:"categories"`
+	// Used is the used space of the volume containing the home directory
+	Used int64 `json:"used"`
+	// SystemData is the used space not in any category
+	SystemData int64 `json:"systemData"`
+	Done       bool  `json:"done"`
+}
+
+// categoryScans are the categories a background job is scanning
+var categoryScans = struct {
+	sync.Mutex
+	running map[string]bool
+}{
+	running: make(map[string]bool),
+}
+
+// scanCategory starts a job scanning the paths of c one after the other,
+// unless one is running. It outlives the request so polling picks up its
+// progress, at background priority as nobody waits for it.
+func scanCategory(c category.Category) {
+	categoryScans.Lock()
+	defer categoryScans.Unlock()
+	if categoryScans.running[c.ID] {
+		return
+	}
+	categoryScans.running[c.ID] = true
+	go func() {
+		defer func() {
+			categoryScans.Lock()
+			delete(categoryScans.running, c.ID)
+			categoryScans.Unlock()
+		}()
+		ctx := scan.WithPriority(context.Background(), scan.Background)
+		for _, p := range c.Paths {
+			scanner.Scan(ctx, p, func(int64, int64) {})
+		}
+	}()
+}
+
+// handleCategories buckets used space into well-known categories from
+// the cache, scanning the paths that are not in it yet in the background.
+// It does not wait for scans, sizes grow until done is true.
+func handleCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := category.Known()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := CategoriesResponse{
+		Categories: make([]CategoryUsage, 0, len(categories)),
+		Done:       true,
+	}
+	var categorized int64
+	for _, c := range categories {
+		usage := CategoryUsage{Category: c, Done: true}
+		for _, p := range c.Paths {
+			entry := scanner.Cache().GetEntry(p)
+			if entry == nil {
+				usage.Done = false
+				scanCategory(c)
+				continue
+			}
+			size, _ := entry.Usage()
+			usage.Size += size
+			if !entry.IsDone() {
+				usage.Done = false
+			}
+		}
+		if !usage.Done {
+			resp.Done = false
+		}
+		categorized += usage.Size
+		resp.Categories = append(resp.Categories, usage)
+	}
+
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		if total, free, err := volumeSpace(homeDir); err == nil {
+			resp.Used = int64(total - free)
+			if resp.Used > categorized {
+				resp.SystemData = resp.Used - categorized
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
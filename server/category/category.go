@@ -0,0 +1,80 @@
+package category
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/vm"
+)
+
+// Category is a group of well-known locations, like the
+// categories of macOS Settings > Storage
+type Category struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+}
+
+// Known returns the categories of the current OS, Paths only
+// contains the locations that exist
+func Known() ([]Category, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %v", err)
+	}
+	home := func(elem ...string) string {
+		return filepath.Join(append([]string{homeDir}, elem...)...)
+	}
+
+	var categories []Category
+	if runtime.GOOS == "darwin" {
+		categories = []Category{
+			{ID: "applications", Name: "Applications", Paths: []string{"/Applications", home("Applications")}},
+			{ID: "documents", Name: "Documents", Paths: []string{home("Documents"), home("Desktop"), home("Downloads")}},
+			{ID: "icloud", Name: "iCloud Drive", Paths: []string{home("Library", "Mobile Documents")}},
+			{ID: "developer", Name: "Developer", Paths: []string{home("Library", "Developer"), "/Library/Developer"}},
+			{ID: "photos", Name: "Photos", Paths: []string{home("Pictures")}},
+			{ID: "music", Name: "Music", Paths: []string{home("Music")}},
+			{ID: "movies", Name: "Movies", Paths: []string{home("Movies")}},
+			{ID: "mail", Name: "Mail", Paths: []string{home("Library", "Mail")}},
+			{ID: "messages", Name: "Messages", Paths: []string{home("Library", "Messages")}},
+			{ID: "trash", Name: "Trash", Paths: []string{home(".Trash")}},
+		}
+	} else {
+		categories = []Category{
+			{ID: "applications", Name: "Applications", Paths: []string{"/opt", "/snap", "/var/lib/flatpak", home(".local", "share", "flatpak")}},
+			{ID: "documents", Name: "Documents", Paths: []string{home("Documents"), home("Desktop"), home("Downloads")}},
+			{ID: "developer", Name: "Developer", Paths: []string{home("go"), home(".cargo"), home(".rustup"), home(".m2"), home(".gradle")}},
+			{ID: "photos", Name: "Photos", Paths: []string{home("Pictures")}},
+			{ID: "music", Name: "Music", Paths: []string{home("Music")}},
+			{ID: "movies", Name: "Videos", Paths: []string{home("Videos")}},
+			{ID: "mail", Name: "Mail", Paths: []string{home(".thunderbird"), home(".local", "share", "evolution", "mail")}},
+			{ID: "trash", Name: "Trash", Paths: []string{home(".local", "share", "Trash")}},
+		}
+	}
+
+	// a VM disk is one opaque file of many GB, it gets a category of its own
+	vms := Category{ID: "vms", Name: "Virtual Machines"}
+	runtimes, err := vm.Runtimes()
+	if err != nil {
+		return nil, err
+	}
+	for _, rt := range runtimes {
+		vms.Paths = append(vms.Paths, rt.Dirs...)
+	}
+	categories = append(categories, vms)
+
+	for i := range categories {
+		paths := []string{}
+		for _, p := range categories[i].Paths {
+			if info, err := os.Stat(scan.LocalPath(p)); err == nil && info.IsDir() {
+				paths = append(paths, p)
+			}
+		}
+		categories[i].Paths = paths
+	}
+	return categories, nil
+}
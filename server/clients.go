@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientIdleTimeout is how long a client without requests stays listed
+const clientIdleTimeout = time.Hour
+
+// ClientSession is a client of the server: an address, user agent and
+// token seen together, e.g. one browser of one user
+type ClientSession struct {
+	ID        string    `json:"id"`
+	Addr      string    `json:"addr"`
+	UserAgent string    `json:"userAgent"`
+	User      string    `json:"user,omitempty"`
+	Role      Role      `json:"role"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Requests  int64     `json:"requests"`
+	// Subscriptions are the streams the client has open, e.g. of /api/usage
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+type Subscription struct {
+	Endpoint string    `json:"endpoint"`
+	Path     string    `json:"path,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+type clientState struct {
+	ClientSession
+	token  string
+	active map[*activeRequest]bool
+}
+
+// activeRequest is a request in flight, stream marks those of newStreamWriter
+type activeRequest struct {
+	endpoint string
+	path     string
+	since    time.Time
+	stream   bool
+	cancel   context.CancelFunc
+}
+
+var clients = struct {
+	sync.Mutex
+	byID map[string]*clientState
+}{
+	byID: make(map[string]*clientState),
+}
+
+// revokedTokens are the sha256 of tokens no longer accepted,
+// revoked-tokens.json in the config directory
+var revokedTokens = struct {
+	sync.Mutex
+	once   sync.Once
+	file   string
+	hashes map[string]time.Time
+}{}
+
+type activeRequestKey struct{}
+
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// loadRevokedTokens reads the revoked tokens once, callers hold revokedTokens.Mutex
+func loadRevokedTokens() {
+	revokedTokens.once.Do(func() {
+		revokedTokens.file = configPath("revoked-tokens.json")
+		revokedTokens.hashes = make(map[string]time.Time)
+		if err := loadJSON(revokedTokens.file, &revokedTokens.hashes); err != nil {
+			log.Printf("Error loading revoked tokens %s: %v", revokedTokens.file, err)
+		}
+	})
+}
+
+func tokenRevoked(token string) bool {
+	revokedTokens.Lock()
+	defer revokedTokens.Unlock()
+	loadRevokedTokens()
+	_, ok := revokedTokens.hashes[tokenHash(token)]
+	return ok
+}
+
+// trackClients records the clients of the API requests of h and their
+// requests in flight, which a disconnect cancels
+func trackClients(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		addr := auditClientOf(r).Addr
+		var token string
+		session := ClientSession{Addr: addr, UserAgent: r.UserAgent()}
+		if u := userOf(r.Context()); u != nil {
+			token, session.User, session.Role = u.Token, u.Name, u.Role
+		}
+		session.ID = tokenHash(session.Addr + "\n" + session.UserAgent + "\n" + token)[:16]
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		req := &activeRequest{endpoint: r.URL.Path, path: r.URL.Query().Get("path"), since: time.Now(), cancel: cancel}
+
+		clients.Lock()
+		now := time.Now()
+		for id, c := range clients.byID {
+			if len(c.active) == 0 && now.Sub(c.LastSeen) > clientIdleTimeout {
+				delete(clients.byID, id)
+			}
+		}
+		c := clients.byID[session.ID]
+		if c == nil {
+			session.FirstSeen = now
+			c = &clientState{ClientSession: session, token: token, active: make(map[*activeRequest]bool)}
+			clients.byID[session.ID] = c
+		}
+		c.LastSeen = now
+		c.Requests++
+		c.active[req] = true
+		clients.Unlock()
+
+		defer func() {
+			clients.Lock()
+			delete(c.active, req)
+			clients.Unlock()
+		}()
+		h.ServeHTTP(w, r.WithContext(context.WithValue(ctx, activeRequestKey{}, req)))
+	})
+}
+
+// markStream lists the request r as a subscription of its client
+func markStream(r *http.Request) {
+	if req, ok := r.Context().Value(activeRequestKey{}).(*activeRequest); ok {
+		clients.Lock()
+		req.stream = true
+		clients.Unlock()
+	}
+}
+
+// disconnectClients cancels the requests in flight of the clients
+// matching, it returns how many requests it cancelled
+func disconnectClients(match func(c *clientState) bool) int {
+	clients.Lock()
+	defer clients.Unlock()
+	n := 0
+	for _, c := range clients.byID {
+		if !match(c) {
+			continue
+		}
+		for req := range c.active {
+			req.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// handleListClientSessions lists the clients seen in the last hour, those
+// with open subscriptions first
+func handleListClientSessions(w http.ResponseWriter, r *http.Request) {
+	clients.Lock()
+	list := make([]ClientSession, 0, len(clients.byID))
+	for _, c := range clients.byID {
+		s := c.ClientSession
+		s.Subscriptions = []Subscription{}
+		for req := range c.active {
+			if req.stream {
+				s.Subscriptions = append(s.Subscriptions, Subscription{Endpoint: req.endpoint, Path: req.path, Since: req.since})
+			}
+		}
+		sort.Slice(s.Subscriptions, func(i, j int) bool {
+			return s.Subscriptions[i].Since.Before(s.Subscriptions[j].Since)
+		})
+		list = append(list, s)
+	}
+	clients.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if (len(list[i].Subscriptions) > 0) != (len(list[j].Subscriptions) > 0) {
+			return len(list[i].Subscriptions) > 0
+		}
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleDisconnectClient ends the requests and streams in flight of the
+// client id, a browser reconnects unless its token is revoked too
+func handleDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	clients.Lock()
+	_, ok := clients.byID[id]
+	clients.Unlock()
+	if !ok {
+		http.Error(w, "client not found: "+id, http.StatusNotFound)
+		return
+	}
+	log.Printf("Disconnecting client %s", id)
+	disconnectClients(func(c *clientState) bool { return c.ID == id })
+	w.Write([]byte("ok"))
+}
+
+// handleRevokeToken stops accepting the token of the client id, or of the
+// user of access.json, and disconnects every client using it. A new token
+// in access.json lets the user back in.
+func handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var token string
+	if id := r.URL.Query().Get("id"); id != "" {
+		clients.Lock()
+		if c := clients.byID[id]; c != nil {
+			token = c.token
+		}
+		clients.Unlock()
+	} else if name := r.URL.Query().Get("user"); name != "" {
+		for _, u := range accessConfig().Users {
+			if u.Name == name {
+				token = u.Token
+			}
+		}
+	} else {
+		http.Error(w, "id or user is required", http.StatusBadRequest)
+		return
+	}
+	if token == "" {
+		http.Error(w, "no token to revoke", http.StatusNotFound)
+		return
+	}
+	if u := userOf(r.Context()); u != nil && u.Token == token {
+		http.Error(w, "cannot revoke the token of this request", http.StatusConflict)
+		return
+	}
+
+	revokedTokens.Lock()
+	loadRevokedTokens()
+	revokedTokens.hashes[tokenHash(token)] = time.Now()
+	err := saveJSON(revokedTokens.file, revokedTokens.hashes)
+	revokedTokens.Unlock()
+	if err != nil {
+		http.Error(w, "Failed to save revoked tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Revoked a token")
+	disconnectClients(func(c *clientState) bool { return c.token == token })
+	w.Write([]byte("ok"))
+}
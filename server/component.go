@@ -2,7 +2,9 @@ package server
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 
 type ServeOptions struct {
 	Static         StaticOptions
+	Host           string // Host to listen on, empty means all interfaces
 	NoOpenBrowser  bool
 	OpenBrowserUrl func(port int, url string) string
 	Route          func(mux *http.ServeMux) error // Optional custom route registration
@@ -20,7 +23,7 @@ type ServeOptions struct {
 func ServeComponent(port int, opts ServeOptions) error {
 	if port == 0 {
 		var err error
-		port, err = FindAvailablePort(8080, 100)
+		port, err = FindAvailablePort(opts.Host, 8080, 100)
 		if err != nil {
 			return err
 		}
@@ -28,10 +31,10 @@ func ServeComponent(port int, opts ServeOptions) error {
 
 	mux := http.NewServeMux()
 	server := &http.Server{
-		Addr:        fmt.Sprintf(":%d", port),
+		Addr:        net.JoinHostPort(opts.Host, strconv.Itoa(port)),
 		ReadTimeout: 30 * time.Second,
 		// WriteTimeout: 30 * time.Second, // Disable write timeout for SSE
-		Handler: mux,
+		Handler: withMiddleware(mux),
 	}
 
 	if opts.Dev {
@@ -59,7 +62,7 @@ func ServeComponent(port int, opts ServeOptions) error {
 		}
 	}
 
-	url := fmt.Sprintf("http://localhost:%d", port)
+	url := ServeURL(opts.Host, port)
 
 	fmt.Printf("Serving at %s\n", url)
 
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// configPath returns the path of a file in the user's config directory,
+// falling back to the working directory
+func configPath(name string) string {
+	if configDir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(configDir, "disk-usage-analyser", name)
+	}
+	return name
+}
+
+// loadJSON decodes file into v, a missing file leaves v unchanged
+func loadJSON(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func saveJSON(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
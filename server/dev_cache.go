@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"disk-usage-analyser/server/devcache"
+)
+
+type DevCacheInfo struct {
+	devcache.Cache
+	Size int64 `json:"size"`
+}
+
+type DevCacheCleanResponse struct {
+	DryRun  bool              `json:"dryRun"`
+	Size    int64             `json:"size"` // bytes expected to be freed
+	Actions []devcache.Action `json:"actions"`
+}
+
+func handleListDevCaches(w http.ResponseWriter, r *http.Request) {
+	caches, err := devcache.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]DevCacheInfo, 0, len(caches))
+	for _, c := range caches {
+		infos = append(infos, DevCacheInfo{
+			Cache: c,
+			Size:  getDevCacheSize(r, &c),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+func handleCleanDevCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	c, err := devcache.Find(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	actions, err := c.Plan()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := DevCacheCleanResponse{
+		DryRun:  dryRun,
+		Size:    getDevCacheSize(r, c),
+		Actions: actions,
+	}
+
+	if !dryRun {
+		log.Printf("Cleaning dev cache: %s", c.ID)
+		sizes := make([]int64, len(c.Paths))
+		for i, p := range c.Paths {
+			sizes[i] = cachedSize(p)
+		}
+		err := c.Clean()
+		for i, p := range c.Paths {
+			audit(auditClientOf(r), "delete", p, sizes[i], "dev cache "+c.ID, err)
+			invalidateCaches(p)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getDevCacheSize(r *http.Request, c *devcache.Cache) int64 {
+	var size int64
+	for _, p := range c.Paths {
+		dirSize, _ := getDirSizeWithCache(r.Context(), p, func(int64, int64) {})
+		size += dirSize
+	}
+	return size
+}
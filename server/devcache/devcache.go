@@ -0,0 +1,181 @@
+package devcache
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"disk-usage-analyser/scan"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+// Cache is a well-known toolchain cache location
+type Cache struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+	// CleanCommand is the toolchain's own clean command,
+	// when empty the contents of Paths are deleted
+	CleanCommand []string `json:"cleanCommand,omitempty"`
+}
+
+// Action describes one step of cleaning a cache
+type Action struct {
+	Command []string `json:"command,omitempty"` // command to run
+	Delete  string   `json:"delete,omitempty"`  // path to delete
+}
+
+// userCacheDir returns ~/Library/Caches on macOS and ~/.cache elsewhere
+func userCacheDir(homeDir string) string {
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(homeDir, "Library", "Caches")
+	}
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return dir
+	}
+	return filepath.Join(homeDir, ".cache")
+}
+
+func knownCaches() ([]Cache, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %v", err)
+	}
+	cacheDir := userCacheDir(homeDir)
+
+	caches := []Cache{
+		{
+			ID:           "npm",
+			Name:         "npm",
+			Paths:        []string{filepath.Join(homeDir, ".npm", "_cacache")},
+			CleanCommand: []string{"npm", "cache", "clean", "--force"},
+		},
+		{
+			ID:           "yarn",
+			Name:         "Yarn",
+			Paths:        []string{filepath.Join(cacheDir, "Yarn"), filepath.Join(cacheDir, "yarn")},
+			CleanCommand: []string{"yarn", "cache", "clean"},
+		},
+		{
+			ID:   "cargo",
+			Name: "Cargo registry",
+			Paths: []string{
+				filepath.Join(homeDir, ".cargo", "registry", "cache"),
+				filepath.Join(homeDir, ".cargo", "registry", "src"),
+				filepath.Join(homeDir, ".cargo", "git", "checkouts"),
+			},
+		},
+		{
+			ID:           "pip",
+			Name:         "pip",
+			Paths:        []string{filepath.Join(cacheDir, "pip")},
+			CleanCommand: []string{"pip3", "cache", "purge"},
+		},
+		{
+			ID:           "go-build",
+			Name:         "Go build cache",
+			Paths:        []string{filepath.Join(cacheDir, "go-build")},
+			CleanCommand: []string{"go", "clean", "-cache"},
+		},
+	}
+	if runtime.GOOS == "darwin" {
+		caches = append(caches,
+			Cache{
+				ID:           "homebrew",
+				Name:         "Homebrew",
+				Paths:        []string{filepath.Join(cacheDir, "Homebrew")},
+				CleanCommand: []string{"brew", "cleanup", "--prune=all"},
+			},
+			Cache{
+				ID:    "xcode-derived-data",
+				Name:  "Xcode DerivedData",
+				Paths: []string{filepath.Join(homeDir, "Library", "Developer", "Xcode", "DerivedData")},
+			},
+		)
+	}
+	return caches, nil
+}
+
+// List returns the known caches that exist on this machine,
+// with Paths narrowed to the existing ones
+func List() ([]Cache, error) {
+	caches, err := knownCaches()
+	if err != nil {
+		return nil, err
+	}
+	var result []Cache
+	for _, c := range caches {
+		var paths []string
+		for _, p := range c.Paths {
+			if st, err := os.Stat(scan.LocalPath(p)); err == nil && st.IsDir() {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		c.Paths = paths
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// Find returns the existing cache with the given id
+func Find(id string) (*Cache, error) {
+	caches, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range caches {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("cache not found: %s", id)
+}
+
+// Plan returns the actions Clean would perform
+func (c *Cache) Plan() ([]Action, error) {
+	if len(c.CleanCommand) > 0 {
+		if _, err := exec.LookPath(c.CleanCommand[0]); err != nil {
+			return nil, fmt.Errorf("%s is not installed", c.CleanCommand[0])
+		}
+		return []Action{{Command: c.CleanCommand}}, nil
+	}
+	var actions []Action
+	for _, p := range c.Paths {
+		entries, err := os.ReadDir(scan.LocalPath(p))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", p, err)
+		}
+		// keep the cache directory itself, only delete its contents
+		for _, e := range entries {
+			actions = append(actions, Action{Delete: filepath.Join(p, e.Name())})
+		}
+	}
+	return actions, nil
+}
+
+// Clean performs the actions returned by Plan
+func (c *Cache) Clean() error {
+	actions, err := c.Plan()
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		if len(action.Command) > 0 {
+			if err := cmd.Debug().Run(action.Command[0], action.Command[1:]...); err != nil {
+				return fmt.Errorf("failed to run %s: %v", strings.Join(action.Command, " "), err)
+			}
+			continue
+		}
+		if err := os.RemoveAll(scan.LocalPath(action.Delete)); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", action.Delete, err)
+		}
+	}
+	return nil
+}
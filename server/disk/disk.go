@@ -50,6 +50,18 @@ type DetailInfo struct {
 	MountPoint                string `json:"MountPoint"`
 	Content                   string `json:"Content"`
 	FilesystemUserVisibleName string `json:"FilesystemUserVisibleName"`
+	VolumeUUID                string `json:"VolumeUUID"`
+	Internal                  bool   `json:"Internal"`
+	Removable                 bool   `json:"Removable"`
+	RemovableMedia            bool   `json:"RemovableMedia"`
+	Ejectable                 bool   `json:"Ejectable"`
+}
+
+// External reports whether the disk is attached externally or its media can
+// be removed, e.g. an SD card in a built-in reader: the only disks that are
+// mounted on request, never an internal or system volume
+func (d *DetailInfo) External() bool {
+	return !d.Internal || d.Removable || d.RemovableMedia || d.Ejectable
 }
 
 func GetDiskUsage() (map[string]int64, error) {
@@ -120,10 +132,10 @@ func ListDisks() ([]Info, error) {
 	usage, _ := GetDiskUsage()
 
 	// Check for running fsck processes
-	psOut, _ := cmd.Debug().Output("ps", "aux")
+	fsck, _ := FsckProcesses()
 
 	getStatus := func(deviceID string) string {
-		if strings.Contains(psOut, "fsck") && strings.Contains(psOut, deviceID) {
+		if len(FsckOf(fsck, deviceID)) > 0 {
 			return "Checking"
 		}
 		return ""
@@ -0,0 +1,117 @@
+package disk
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FsckProcess is a running fsck of a device, as ps shows it. The fsck_*
+// tools macOS runs before a mount report no percentage, how long one has
+// run and whether it is busy or waiting on the disk is what is known.
+type FsckProcess struct {
+	PID int `json:"pid"`
+	// Tool is e.g. fsck_exfat, fsck_apfs or fsck_hfs
+	Tool string `json:"tool"`
+	// Device is the argument naming the device, e.g. /dev/rdisk4s1
+	Device string   `json:"device"`
+	Args   []string `json:"args"`
+	// Quick is set for a check of the dirty flag only (-q), which is fast
+	Quick bool `json:"quick"`
+	// Repair is set when the tool may change the disk (-y or -r)
+	Repair bool `json:"repair"`
+	// Elapsed and CPU are the seconds since it started and on the CPU
+	Elapsed int64 `json:"elapsed"`
+	CPU     int64 `json:"cpu"`
+	// State is R when running, S or I when sleeping and U or D when
+	// waiting on the disk
+	State string `json:"state"`
+}
+
+// fsckDevice is an argument naming a device, with the disk captured
+var fsckDevice = regexp.MustCompile(`^(?:/dev/)?r?(disk[0-9]+(?:s[0-9]+)?)$`)
+
+// FsckProcesses lists the running fsck processes. ps is run directly
+// rather than logged, it is polled while a check runs.
+func FsckProcesses() ([]FsckProcess, error) {
+	out, err := exec.Command("ps", "axww", "-o", "pid=,etime=,time=,state=,command=").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ps: %v", err)
+	}
+	return parseFsckProcesses(string(out)), nil
+}
+
+func parseFsckProcesses(out string) []FsckProcess {
+	var list []FsckProcess
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(filepath.Base(fields[4]), "fsck") {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		p := FsckProcess{
+			PID:     pid,
+			Tool:    filepath.Base(fields[4]),
+			Args:    fields[5:],
+			Elapsed: psSeconds(fields[1]),
+			CPU:     psSeconds(fields[2]),
+			State:   fields[3][:1],
+		}
+		for _, arg := range p.Args {
+			switch {
+			case fsckDevice.MatchString(arg):
+				p.Device = arg
+			case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+				p.Quick = p.Quick || strings.Contains(arg, "q")
+				p.Repair = p.Repair || strings.ContainsAny(arg, "yr")
+			}
+		}
+		if p.Device != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// FsckOf are the processes of list checking deviceID, /dev/rdisk4s1 is the
+// raw device of disk4s1 and not one of disk4s10
+func FsckOf(list []FsckProcess, deviceID string) []FsckProcess {
+	var of []FsckProcess
+	for _, p := range list {
+		if m := fsckDevice.FindStringSubmatch(p.Device); m != nil && m[1] == deviceID {
+			of = append(of, p)
+		}
+	}
+	return of
+}
+
+// psSeconds parses the [[dd-]hh:]mm:ss[.cc] times of ps, 0 if invalid
+func psSeconds(s string) int64 {
+	var days int64
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		d, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0
+		}
+		days, s = d, s[i+1:]
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+	var secs int64
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0
+		}
+		secs = secs*60 + n
+	}
+	return days*int64(24*time.Hour/time.Second) + secs
+}
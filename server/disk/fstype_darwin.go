@@ -0,0 +1,13 @@
+package disk
+
+import "golang.org/x/sys/unix"
+
+// fsType is the type of the filesystem mounted at mountPoint, e.g. apfs,
+// "" if it cannot be told
+func fsType(mountPoint string) string {
+	var st unix.Statfs_t
+	if err := unix.Statfs(mountPoint, &st); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(st.Fstypename[:])
+}
@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package disk
+
+// fsType is the type of the filesystem mounted at mountPoint, only
+// known on macOS
+func fsType(mountPoint string) string {
+	return ""
+}
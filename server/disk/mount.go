@@ -0,0 +1,165 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// deviceName is what the device of a mount may be, a disk below /dev
+var deviceName = regexp.MustCompile(`^disk[0-9]+(s[0-9]+)?$`)
+
+// ValidDevice reports whether id names a disk or partition, e.g. disk4s1
+func ValidDevice(id string) bool {
+	return deviceName.MatchString(id)
+}
+
+// Filesystems are the types a mount may name, "" lets mount detect it
+var Filesystems = map[string]bool{
+	"exfat": true,
+	"msdos": true,
+	"ntfs":  true,
+	"hfs":   true,
+	"apfs":  true,
+}
+
+// MountOptions are how a device is mounted with mount(8)
+type MountOptions struct {
+	Device     string `json:"device"` // e.g. disk4s1
+	MountPoint string `json:"mountPoint"`
+	Filesystem string `json:"filesystem,omitempty"`
+	ReadOnly   bool   `json:"readOnly,omitempty"`
+	NoExec     bool   `json:"noExec,omitempty"`
+	// NoSuid is kept for old clients, every mount is nosuid,nodev
+	NoSuid bool `json:"noSuid,omitempty"`
+}
+
+// Validate checks the device, the filesystem and that the mount point, if
+// given, is an absolute, clean path
+func (o MountOptions) Validate() error {
+	if !ValidDevice(o.Device) {
+		return fmt.Errorf("invalid device: %s", o.Device)
+	}
+	if o.Filesystem != "" && !Filesystems[o.Filesystem] {
+		return fmt.Errorf("unsupported filesystem: %s", o.Filesystem)
+	}
+	if o.MountPoint != "" && (!filepath.IsAbs(o.MountPoint) || filepath.Clean(o.MountPoint) != o.MountPoint) {
+		return fmt.Errorf("mount point must be an absolute, clean path: %s", o.MountPoint)
+	}
+	return nil
+}
+
+// flags are the -o options of o. A foreign disk never brings setuid
+// binaries or device nodes along.
+func (o MountOptions) flags() []string {
+	flags := []string{"nosuid", "nodev"}
+	if o.NoExec {
+		flags = append(flags, "noexec")
+	}
+	return flags
+}
+
+// Args are the arguments of mount(8) for o, after validating it. mount
+// needs the mount point.
+func (o MountOptions) Args() ([]string, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if o.MountPoint == "" {
+		return nil, fmt.Errorf("mount point is required")
+	}
+	var args []string
+	if o.Filesystem != "" {
+		args = append(args, "-t", o.Filesystem)
+	}
+	if o.ReadOnly {
+		args = append(args, "-r")
+	}
+	args = append(args, "-o", strings.Join(o.flags(), ","))
+	return append(args, "/dev/"+o.Device, o.MountPoint), nil
+}
+
+// DiskutilArgs are the arguments of diskutil for o, after validating it.
+// diskutil detects the filesystem and cannot override it.
+func (o MountOptions) DiskutilArgs() ([]string, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if o.Filesystem != "" {
+		return nil, fmt.Errorf("diskutil cannot mount as %s", o.Filesystem)
+	}
+	args := []string{"mount"}
+	if o.ReadOnly {
+		args = append(args, "readOnly")
+	}
+	args = append(args, "-mountOptions", strings.Join(o.flags(), ","))
+	if o.MountPoint != "" {
+		args = append(args, "-mountPoint", o.MountPoint)
+	}
+	return append(args, o.Device), nil
+}
+
+// CheckMountPoint allows a custom mount point below home or /Volumes that
+// does not exist yet or is an empty directory, so that a mount neither
+// hides files nor lands on a system path. The server and the helper, which
+// must not trust the server, both check it.
+func CheckMountPoint(mountPoint string, home string) error {
+	allowed := within(mountPoint, "/Volumes") && mountPoint != "/Volumes"
+	if home != "" && within(mountPoint, home) && mountPoint != home {
+		allowed = true
+	}
+	if !allowed {
+		return fmt.Errorf("mount point must be below the home directory or /Volumes: %s", mountPoint)
+	}
+	info, err := os.Lstat(mountPoint)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("mount point is not a directory: %s", mountPoint)
+	}
+	entries, err := os.ReadDir(mountPoint)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("mount point is not empty: %s", mountPoint)
+	}
+	return nil
+}
+
+// ResolveMountPoint follows the symlinks of mountPoint, or of its nearest
+// existing ancestor, so that CheckMountPoint sees where a mount would land
+// and not a link below the home directory pointing elsewhere
+func ResolveMountPoint(mountPoint string) (string, error) {
+	dir, rest := filepath.Clean(mountPoint), ""
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+// within reports whether path is dir or below it
+func within(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,184 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xhd2015/xgo/support/cmd"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Volume is a mounted filesystem as reported by df
+type Volume struct {
+	Filesystem string `json:"filesystem"`
+	MountPoint string `json:"mountPoint"`
+	Size       int64  `json:"size"`
+	Used       int64  `json:"used"`
+	Available  int64  `json:"available"`
+}
+
+// ListVolumes lists mounted local volumes backed by a device,
+// using the POSIX df output format which is the same on macOS and Linux
+func ListVolumes() ([]Volume, error) {
+	output, err := cmd.Output("df", "-kP")
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []Volume
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		sizeKB, err1 := strconv.ParseInt(fields[1], 10, 64)
+		usedKB, err2 := strconv.ParseInt(fields[2], 10, 64)
+		availKB, err3 := strconv.ParseInt(fields[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		volumes = append(volumes, Volume{
+			Filesystem: fields[0],
+			// Mount point is the last column and may contain spaces
+			MountPoint: strings.Join(fields[5:], " "),
+			Size:       sizeKB * 1024,
+			Used:       usedKB * 1024,
+			Available:  availKB * 1024,
+		})
+	}
+	return volumes, nil
+}
+
+// byUUIDDir links the filesystem UUIDs of Linux to their devices
+const byUUIDDir = "/dev/disk/by-uuid"
+
+// UUID identifies the filesystem of v whatever its mount point, so that a
+// drive plugged in again is recognized
+func (v Volume) UUID() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		info, err := GetDiskInfo(v.MountPoint)
+		if err != nil {
+			return "", err
+		}
+		if info.VolumeUUID == "" {
+			return "", fmt.Errorf("%s has no volume UUID", v.MountPoint)
+		}
+		return info.VolumeUUID, nil
+	case "linux":
+		device, err := filepath.EvalSymlinks(v.Filesystem)
+		if err != nil {
+			return "", err
+		}
+		links, err := os.ReadDir(byUUIDDir)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		for _, link := range links {
+			if target, err := filepath.EvalSymlinks(filepath.Join(byUUIDDir, link.Name())); err == nil && target == device {
+				return link.Name(), nil
+			}
+		}
+		// no udev, e.g. in a container
+		uuid, err := cmd.Output("blkid", "-s", "UUID", "-o", "value", device)
+		if err != nil {
+			return "", err
+		}
+		if uuid = strings.TrimSpace(uuid); uuid == "" {
+			return "", fmt.Errorf("%s has no filesystem UUID", v.Filesystem)
+		}
+		return uuid, nil
+	}
+	return "", fmt.Errorf("volume UUIDs are not supported on %s", runtime.GOOS)
+}
+
+// caseProbeEntries is how many names of the mount point CaseInsensitive
+// looks at for one with letters
+const caseProbeEntries = 64
+
+// CaseInsensitive reports whether the filesystem of the volume ignores the
+// case of names, as APFS, HFS+, NTFS, FAT and exFAT do by default. It looks
+// up a name of the mount point in another case and compares the files,
+// nothing is written. ok is false if the mount point cannot be read or has
+// no name with letters.
+func (v Volume) CaseInsensitive() (insensitive bool, ok bool) {
+	f, err := os.Open(v.MountPoint)
+	if err != nil {
+		return false, false
+	}
+	names, _ := f.Readdirnames(caseProbeEntries)
+	f.Close()
+	for _, name := range names {
+		swapped := swapCase(name)
+		if swapped == name {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(v.MountPoint, name))
+		if err != nil {
+			continue
+		}
+		other, err := os.Lstat(filepath.Join(v.MountPoint, swapped))
+		if os.IsNotExist(err) {
+			return false, true
+		}
+		if err != nil {
+			continue
+		}
+		// both may exist on a case-sensitive volume
+		return os.SameFile(info, other), true
+	}
+	return false, false
+}
+
+// NormalizationInsensitive reports whether the filesystem of the volume
+// takes the NFC and NFD forms of a name for the same file, as APFS and HFS+
+// do. Other filesystems are probed like CaseInsensitive, with a name of the
+// mount point in its other form. ok is false if it cannot be told.
+func (v Volume) NormalizationInsensitive() (insensitive bool, ok bool) {
+	if t := fsType(v.MountPoint); t == "apfs" || t == "hfs" {
+		return true, true
+	}
+	f, err := os.Open(v.MountPoint)
+	if err != nil {
+		return false, false
+	}
+	names, _ := f.Readdirnames(caseProbeEntries)
+	f.Close()
+	for _, name := range names {
+		other := norm.NFD.String(name)
+		if other == name {
+			other = norm.NFC.String(name)
+		}
+		if other == name {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(v.MountPoint, name))
+		if err != nil {
+			continue
+		}
+		found, err := os.Lstat(filepath.Join(v.MountPoint, other))
+		if os.IsNotExist(err) {
+			return false, true
+		}
+		if err != nil {
+			continue
+		}
+		return os.SameFile(info, found), true
+	}
+	return false, false
+}
+
+// swapCase returns name with the case of its letters swapped
+func swapCase(name string) string {
+	return strings.Map(func(r rune) rune {
+		if u := unicode.ToUpper(r); u != r {
+			return u
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/diskimage"
+)
+
+type DiskImageInfo struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"` // dmg, sparseimage or sparsebundle
+	// Size and DiskSize are the logical and allocated bytes of the image on the host
+	Size     int64 `json:"size"`
+	DiskSize int64 `json:"diskSize"`
+	*diskimage.Info
+	// Reclaimable estimates what compacting frees, Size - Used for sparse images
+	Reclaimable int64 `json:"reclaimable"`
+	// Error is set when hdiutil could not inspect the image, e.g. when encrypted
+	Error string `json:"error,omitempty"`
+}
+
+type CompactResult struct {
+	Path      string `json:"path"`
+	Before    int64  `json:"before"`
+	After     int64  `json:"after"`
+	Reclaimed int64  `json:"reclaimed"`
+}
+
+// diskImagePath resolves the path parameter to a disk image
+func diskImagePath(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return "", "", false
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+	kind := diskimage.Kind(path)
+	if kind == "" {
+		http.Error(w, "not a disk image: "+path, http.StatusBadRequest)
+		return "", "", false
+	}
+	return path, kind, true
+}
+
+// diskImageSize measures an image on the host, sparse bundles are
+// directories of bands and are scanned
+func diskImageSize(r *http.Request, path string) (size int64, diskSize int64, err error) {
+	info, err := os.Stat(scan.LocalPath(path))
+	if err != nil {
+		return 0, 0, err
+	}
+	if !info.IsDir() {
+		return info.Size(), fileDiskSize(info), nil
+	}
+	size, _ = scanner.Scan(r.Context(), path, func(int64, int64) {})
+	if entry := GlobalCache.GetEntry(path); entry != nil {
+		diskSize = entryStats(entry).DiskSize
+	}
+	return size, diskSize, nil
+}
+
+func handleDiskImageInfo(w http.ResponseWriter, r *http.Request) {
+	path, kind, ok := diskImagePath(w, r)
+	if !ok {
+		return
+	}
+	resp := DiskImageInfo{Path: path, Kind: kind}
+	var err error
+	resp.Size, resp.DiskSize, err = diskImageSize(r, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+	resp.Info, err = diskimage.GetInfo(path)
+	if err != nil {
+		resp.Error = err.Error()
+	} else if diskimage.Compactable(kind) && resp.Used > 0 && resp.Size > resp.Used {
+		resp.Reclaimable = resp.Size - resp.Used
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCompactDiskImage reclaims unused space of a sparse image with hdiutil compact
+func handleCompactDiskImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, kind, ok := diskImagePath(w, r)
+	if !ok {
+		return
+	}
+	if !diskimage.Compactable(kind) {
+		http.Error(w, "only sparse images can be compacted", http.StatusBadRequest)
+		return
+	}
+	before, _, err := diskImageSize(r, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Compacting disk image %s", path)
+	if err := diskimage.Compact(path); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invalidateCaches(path)
+	after, _, err := diskImageSize(r, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CompactResult{Path: path, Before: before, After: after, Reclaimed: before - after})
+}
@@ -5,8 +5,10 @@ import (
 	"disk-usage-analyser/server/disk"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -26,9 +28,25 @@ func handleListDisks(w http.ResponseWriter, r *http.Request) {
 
 type MountRequest struct {
 	DeviceID string `json:"deviceID"`
-	Password string `json:"password"`
+	// Password is for sudo, not needed with the privileged helper
+	Password secret `json:"password"`
+	// ReadOnly and NoExec restrict the mount, e.g. to look at a drive of
+	// unknown origin. Every mount is nosuid,nodev, NoSuid is kept for old
+	// clients.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	NoExec   bool `json:"noExec,omitempty"`
+	NoSuid   bool `json:"noSuid,omitempty"`
+	// MountPoint replaces the default mount point, it must be below the
+	// home directory or /Volumes and not exist or be empty
+	MountPoint string `json:"mountPoint,omitempty"`
+	// Filesystem overrides the detected type, one of disk.Filesystems,
+	// and mounts with mount(8)
+	Filesystem string `json:"filesystem,omitempty"`
 }
 
+// maxMountRequest bounds the body of a mount request
+const maxMountRequest = 64 << 10
+
 func handleMountDisk(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -36,10 +54,16 @@ func handleMountDisk(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req MountRequest
-	// Try to decode body
+	// the body is read into a buffer that is cleared, as is the password,
+	// so that neither stays in memory
 	if r.Body != nil {
-		json.NewDecoder(r.Body).Decode(&req)
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxMountRequest))
+		if len(body) > 0 {
+			json.Unmarshal(body, &req)
+		}
+		clear(body)
 	}
+	defer clear(req.Password)
 	// Fallback to query param for deviceID
 	if req.DeviceID == "" {
 		req.DeviceID = r.URL.Query().Get("deviceID")
@@ -50,6 +74,25 @@ func handleMountDisk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts := disk.MountOptions{
+		Device:     req.DeviceID,
+		MountPoint: req.MountPoint,
+		Filesystem: req.Filesystem,
+		ReadOnly:   req.ReadOnly,
+		NoExec:     req.NoExec,
+		NoSuid:     req.NoSuid,
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.MountPoint != "" {
+		if err := checkMountPoint(opts.MountPoint); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Fetch disk info
 	info, err := disk.GetDiskInfo(req.DeviceID)
 	if err != nil {
@@ -62,13 +105,26 @@ func handleMountDisk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rec := &auditRecorder{ResponseWriter: w}
+	w = rec
+	defer func() { audit(auditClientOf(r), "mount", req.DeviceID, 0, "", rec.err()) }()
+
+	if !info.External() {
+		http.Error(w, "only external or removable disks can be mounted: "+req.DeviceID, http.StatusForbidden)
+		return
+	}
+
 	// Check if it's ExFAT or Windows_NTFS (sometimes mislabeled)
 	isExFAT := strings.EqualFold(info.FilesystemType, "exfat") ||
 		strings.Contains(strings.ToLower(info.Content), "exfat") ||
 		(strings.Contains(strings.ToLower(info.Content), "windows_ntfs") && strings.Contains(strings.ToLower(info.Content), "exfat")) ||
 		strings.EqualFold(info.FilesystemType, "exfat")
 
-	if isExFAT {
+	if isExFAT && opts.Filesystem == "" {
+		opts.Filesystem = "exfat"
+	}
+
+	if opts.MountPoint == "" && opts.Filesystem != "" {
 		volName := info.VolumeName
 		if volName == "" {
 			volName = req.DeviceID
@@ -80,51 +136,56 @@ func handleMountDisk(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("failed to get user home dir: %v", err), http.StatusInternalServerError)
 			return
 		}
-		mountPoint := filepath.Join(homeDir, "Volumes", volName)
+		opts.MountPoint = filepath.Join(homeDir, "Volumes", volName)
+	}
 
+	if opts.MountPoint != "" {
 		// Create mount point as user
-		if err := cmd.Debug().Run("mkdir", "-p", mountPoint); err != nil {
+		if err := cmd.Debug().Run("mkdir", "-p", opts.MountPoint); err != nil {
 			http.Error(w, fmt.Sprintf("failed to create mount point: %v", err), http.StatusInternalServerError)
 			return
 		}
+	}
+
+	// diskutil cannot override the filesystem, nor mount exFAT on
+	// ~/Volumes, mount(8) needs root
+	if opts.Filesystem != "" {
+		args, err := opts.Args()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the helper is root already and needs no password
+		if PrivilegedClient != nil {
+			if err := PrivilegedClient.Mount(opts); err != nil {
+				http.Error(w, fmt.Sprintf("failed to mount %s disk (privileged helper): %v", opts.Filesystem, err), http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte("ok"))
+			return
+		}
 
-		// If password is not provided, try sudo -n first
-		var outBuf bytes.Buffer
-		var mountErr error
-
-		if req.Password == "" {
-			// Try non-interactive first
-			mountErr = cmd.Debug().Stdout(&outBuf).Stderr(&outBuf).Run("sudo", "-n", "mount", "-t", "exfat", "/dev/"+req.DeviceID, mountPoint)
-			if mountErr != nil {
-				// Check if it failed due to missing password
-				// sudo -n exits with 1 and usually prints something
-				// But simpler is to assume if it fails we might need password
-				// We can return 401 to prompt user
-				// However, check output content to be sure?
-				// "sudo: a password is required" is typical output
-				outputStr := outBuf.String()
-				if strings.Contains(outputStr, "password is required") || strings.Contains(outputStr, "sudo:") {
+		output, mountErr := sudoMount(req.Password, args)
+		if mountErr != nil {
+			if len(req.Password) == 0 {
+				// "sudo: a password is required" is typical output, the
+				// client then asks for the password
+				if strings.Contains(output, "password is required") || strings.Contains(output, "sudo:") {
 					w.WriteHeader(http.StatusUnauthorized)
 					w.Write([]byte("Sudo password required"))
 					return
 				}
-				// Other error
-				http.Error(w, fmt.Sprintf("failed to mount exfat disk (sudo -n): %v\nOutput: %s", mountErr, outputStr), http.StatusInternalServerError)
+				http.Error(w, fmt.Sprintf("failed to mount %s disk (sudo -n): %v\nOutput: %s", opts.Filesystem, mountErr, output), http.StatusInternalServerError)
 				return
 			}
-		} else {
-			// Use sudo -S with password
-			mountErr = cmd.Debug().Stdin(strings.NewReader(req.Password+"\n")).Stdout(&outBuf).Stderr(&outBuf).Run("sudo", "-S", "mount", "-t", "exfat", "/dev/"+req.DeviceID, mountPoint)
-			if mountErr != nil {
-				outputStr := outBuf.String()
-				if strings.Contains(outputStr, "incorrect password") || strings.Contains(outputStr, "try again") {
-					w.WriteHeader(http.StatusUnauthorized)
-					w.Write([]byte("Incorrect password"))
-					return
-				}
-				http.Error(w, fmt.Sprintf("failed to mount exfat disk (sudo -S): %v\nOutput: %s", mountErr, outputStr), http.StatusInternalServerError)
+			if strings.Contains(output, "incorrect password") || strings.Contains(output, "try again") {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Incorrect password"))
 				return
 			}
+			http.Error(w, fmt.Sprintf("failed to mount %s disk (sudo -S): %v\nOutput: %s", opts.Filesystem, mountErr, output), http.StatusInternalServerError)
+			return
 		}
 
 		w.Write([]byte("ok"))
@@ -132,12 +193,17 @@ func handleMountDisk(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Default behavior
+	args, err := opts.DiskutilArgs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	var outBuf bytes.Buffer
-	err = cmd.Debug().Stdout(&outBuf).Stderr(&outBuf).Run("diskutil", "mount", req.DeviceID)
+	err = cmd.Debug().Stdout(&outBuf).Stderr(&outBuf).Run("diskutil", args...)
 	if err != nil {
 		outputStr := outBuf.String()
 		if strings.Contains(outputStr, "SUIS premount dissented") {
-			http.Error(w, "System is verifying the disk (fsck). Please wait until it finishes.", http.StatusConflict)
+			http.Error(w, "System is verifying the disk (fsck). Please wait until it finishes, "+APIPrefix+"/disks/fsck?watch=true&deviceID="+req.DeviceID+" tells when.", http.StatusConflict)
 			return
 		}
 		http.Error(w, fmt.Sprintf("failed to mount disk: %v\nOutput: %s", err, outputStr), http.StatusInternalServerError)
@@ -147,6 +213,54 @@ func handleMountDisk(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("ok"))
 }
 
+// checkMountPoint applies disk.CheckMountPoint to where mountPoint resolves,
+// with the home directory of the user running the server
+func checkMountPoint(mountPoint string) error {
+	resolved, err := disk.ResolveMountPoint(mountPoint)
+	if err != nil {
+		return err
+	}
+	home, _ := os.UserHomeDir()
+	if h, err := filepath.EvalSymlinks(home); err == nil {
+		home = h
+	}
+	return disk.CheckMountPoint(resolved, home)
+}
+
+// sudoMount runs mount(8) with mountArgs with sudo, which must not prompt
+// without a password. The password goes to the stdin of sudo only, it is
+// neither logged nor on a command line, and the copy written is cleared.
+func sudoMount(password secret, mountArgs []string) (string, error) {
+	args := []string{"-n"}
+	if len(password) > 0 {
+		// -p "" keeps the prompt out of the output
+		args = []string{"-S", "-p", ""}
+	}
+	args = append(append(args, "mount"), mountArgs...)
+	c := exec.Command("sudo", args...)
+	var out bytes.Buffer
+	c.Stdout, c.Stderr = &out, &out
+	if len(password) == 0 {
+		err := c.Run()
+		return out.String(), err
+	}
+
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := c.Start(); err != nil {
+		return "", err
+	}
+	input := make([]byte, 0, len(password)+1)
+	input = append(append(input, password...), '\n')
+	stdin.Write(input)
+	clear(input)
+	stdin.Close()
+	err = c.Wait()
+	return out.String(), err
+}
+
 func handleUnmountDisk(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -161,6 +275,7 @@ func handleUnmountDisk(w http.ResponseWriter, r *http.Request) {
 
 	var outBuf bytes.Buffer
 	err := cmd.Debug().Stdout(&outBuf).Stderr(&outBuf).Run("diskutil", "unmount", deviceID)
+	audit(auditClientOf(r), "unmount", deviceID, 0, "", err)
 	if err != nil {
 		outputStr := outBuf.String()
 		http.Error(w, fmt.Sprintf("failed to unmount disk: %v\nOutput: %s", err, outputStr), http.StatusInternalServerError)
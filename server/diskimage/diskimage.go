@@ -0,0 +1,100 @@
+// Package diskimage inspects macOS disk images through hdiutil.
+package diskimage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+// kinds maps disk image extensions to their kind, sparse bundles are directories
+var kinds = map[string]string{
+	".dmg":          "dmg",
+	".sparseimage":  "sparseimage",
+	".sparsebundle": "sparsebundle",
+}
+
+// Info describes the content of a disk image
+type Info struct {
+	// Format is the hdiutil format, e.g. UDZO (compressed) or UDSB (sparse bundle)
+	Format string `json:"format"`
+	// Capacity is the size of the volume inside the image
+	Capacity int64 `json:"capacity"`
+	// Used is the number of bytes of the volume holding data,
+	// 0 when hdiutil does not report it
+	Used      int64 `json:"used"`
+	Encrypted bool  `json:"encrypted"`
+}
+
+func Supported() bool {
+	return runtime.GOOS == "darwin"
+}
+
+// Kind returns the kind of disk image by name, "" if it is none
+func Kind(name string) string {
+	return kinds[strings.ToLower(filepath.Ext(name))]
+}
+
+// Compactable reports whether hdiutil compact can reclaim space of the kind,
+// only sparse images grow and keep unused bands
+func Compactable(kind string) bool {
+	return kind == "sparseimage" || kind == "sparsebundle"
+}
+
+// imageInfo is the part of `hdiutil imageinfo -plist` used here
+type imageInfo struct {
+	Format          string `json:"Format"`
+	SizeInformation struct {
+		TotalBytes         int64 `json:"Total Bytes"`
+		TotalNonEmptyBytes int64 `json:"Total Non-Empty Bytes"`
+	} `json:"Size Information"`
+	Properties struct {
+		Encrypted bool `json:"Encrypted"`
+	} `json:"Properties"`
+}
+
+// GetInfo runs hdiutil imageinfo. Encrypted images fail since
+// no passphrase is given.
+func GetInfo(path string) (*Info, error) {
+	if !Supported() {
+		return nil, fmt.Errorf("disk images can only be inspected on macOS")
+	}
+	var stderr bytes.Buffer
+	plist, err := cmd.New().Stderr(&stderr).Output("hdiutil", "imageinfo", "-plist", path)
+	if err != nil {
+		return nil, fmt.Errorf("hdiutil imageinfo: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	jsonOutput, err := cmd.New().Stdin(strings.NewReader(plist)).Stderr(io.Discard).Output("plutil", "-convert", "json", "-o", "-", "-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert imageinfo: %v", err)
+	}
+	var info imageInfo
+	if err := json.Unmarshal([]byte(jsonOutput), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse imageinfo: %v", err)
+	}
+	return &Info{
+		Format:    info.Format,
+		Capacity:  info.SizeInformation.TotalBytes,
+		Used:      info.SizeInformation.TotalNonEmptyBytes,
+		Encrypted: info.Properties.Encrypted,
+	}, nil
+}
+
+// Compact reclaims the unused bands of a sparse image, it fails while the image is attached
+func Compact(path string) error {
+	if !Supported() {
+		return fmt.Errorf("disk images can only be compacted on macOS")
+	}
+	var out bytes.Buffer
+	err := cmd.Debug().Stdout(&out).Stderr(&out).Run("hdiutil", "compact", path)
+	if err != nil {
+		return fmt.Errorf("hdiutil compact: %v %s", err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
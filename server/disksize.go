@@ -0,0 +1,39 @@
+package server
+
+import (
+	"io/fs"
+
+	"disk-usage-analyser/server/fsstat"
+	"disk-usage-analyser/server/vfs"
+)
+
+// fileDiskSize returns the bytes allocated on disk for info,
+// falling back to the logical size when unknown
+func fileDiskSize(info fs.FileInfo) int64 {
+	if meta := vfs.MetaOf(info); meta != nil && meta.DiskSize > 0 {
+		return meta.DiskSize
+	}
+	st, ok := fsstat.Of(info)
+	if !ok {
+		return info.Size()
+	}
+	return st.DiskSize()
+}
+
+// isPlaceholder reports whether info is a cloud placeholder (iCloud, Dropbox, OneDrive)
+func isPlaceholder(info fs.FileInfo) bool {
+	st, ok := fsstat.Of(info)
+	return ok && st.Dataless()
+}
+
+// setDiskSize fills the physical size fields of a file item
+func setDiskSize(item *FileInfo, info fs.FileInfo) {
+	item.DiskSize = fileDiskSize(info)
+	if st, ok := fsstat.Of(info); ok {
+		item.Compressed = st.Compressed()
+		if st.Dataless() {
+			item.Placeholder = true
+			item.CloudSize = info.Size()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+)
+
+// countConcurrency limits the ReadDir calls of one counting pre-pass
+const countConcurrency = 8
+
+// Progress relates the entries scanned so far to an estimate of the total
+type Progress struct {
+	Entries int64 `json:"entries"`
+	// Estimated is the expected number of entries, 0 while unknown
+	Estimated int64 `json:"estimated"`
+	// Percent is Entries / Estimated, capped at 99 until the scan is done
+	Percent float64 `json:"percent"`
+	// Source is "recent" for the size of a prior scan, "count" for a pre-pass
+	Source string `json:"source,omitempty"`
+	Done   bool   `json:"done"`
+}
+
+// recentEntries returns the entries of the last finished scan of path
+func recentEntries(path string) int64 {
+	recentScans.Lock()
+	defer recentScans.Unlock()
+	loadRecentScans()
+	for _, scan := range recentScans.items {
+		if scan.Path == path {
+			return scan.Entries
+		}
+	}
+	return 0
+}
+
+// startCount counts the entries below the listing's directory without
+// sizes, unless a prior scan already gave an estimate. It runs once per listing.
+func (l *listing) startCount() {
+	l.countOnce.Do(func() {
+		l.mu.Lock()
+		known := l.estimate > 0
+		l.mu.Unlock()
+		if known {
+			return
+		}
+		go func() {
+			n, ok := countEntries(l.ctx, l.key.path)
+			if !ok {
+				return
+			}
+			l.mu.Lock()
+			if l.estimate == 0 {
+				l.estimate, l.estimateSource = n, "count"
+			}
+			l.mu.Unlock()
+		}()
+	})
+}
+
+func (l *listing) progress() Progress {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p := Progress{Estimated: l.estimate, Source: l.estimateSource, Done: l.done}
+	for _, item := range l.items {
+		p.Entries += item.Entries
+	}
+	switch {
+	case p.Done:
+		p.Percent = 100
+	case p.Estimated > 0:
+		p.Percent = min(99, float64(p.Entries)*100/float64(p.Estimated))
+	}
+	return p
+}
+
+// countEntries counts the entries below dir, listing directories
+// only. ok is false if ctx was cancelled before the count finished.
+func countEntries(ctx context.Context, dir string) (n int64, ok bool) {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, countConcurrency)
+	)
+	var walk func(dir string)
+	walk = func(dir string) {
+		defer wg.Done()
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		entries, err := readDir(dir)
+		<-sem
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		n += int64(len(entries))
+		mu.Unlock()
+		for _, e := range entries {
+			if e.IsDir() {
+				wg.Add(1)
+				go walk(filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	wg.Add(1)
+	walk(dir)
+	wg.Wait()
+	return n, ctx.Err() == nil
+}
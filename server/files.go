@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+const (
+	// filesChunkSize is the number of files per "files" event
+	filesChunkSize = 500
+	// defaultFilesLimit keeps the flat view to moderately sized subtrees
+	defaultFilesLimit = 100000
+)
+
+// FileEntry is a file found by /api/files
+type FileEntry struct {
+	Path    string    `json:"path"` // relative to the requested directory
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type FilesDone struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+	// Truncated is set when the listing stopped at the limit
+	Truncated bool `json:"truncated"`
+	// Denied is the number of directories that could not be read
+	Denied int `json:"denied"`
+}
+
+var errFilesLimit = errors.New("files limit reached")
+
+// handleFiles streams every file below path as SSE "files" events of
+// up to filesChunkSize files, followed by a "done" event with totals.
+// Without recursive=true only the direct children are listed.
+func handleFiles(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(scan.LocalPath(path)); err != nil || !info.IsDir() {
+		http.Error(w, "not a directory: "+path, http.StatusBadRequest)
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "true"
+	limit := defaultFilesLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	sw := newStreamWriter(w, r)
+	defer sw.Close()
+
+	var done FilesDone
+	chunk := make([]FileEntry, 0, filesChunkSize)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := sendEvent(sw, "files", chunk); err != nil {
+			return err
+		}
+		sw.Flush()
+		chunk = chunk[:0]
+		return nil
+	}
+
+	err = walkFiles(r.Context(), path, "", recursive, &done.Denied, func(f FileEntry) error {
+		if done.Count >= limit {
+			return errFilesLimit
+		}
+		done.Count++
+		done.Size += f.Size
+		chunk = append(chunk, f)
+		if len(chunk) == filesChunkSize {
+			return flush()
+		}
+		return nil
+	})
+	if errors.Is(err, errFilesLimit) {
+		done.Truncated = true
+	} else if err != nil {
+		// the client is gone
+		return
+	}
+	if flush() != nil {
+		return
+	}
+	sendEvent(sw, "done", done)
+	sw.Flush()
+}
+
+// walkFiles calls fn for each file in dir, depth first. rel is dir relative
+// to the root of the walk, unreadable directories are counted in denied.
+func walkFiles(ctx context.Context, dir string, rel string, recursive bool, denied *int, fn func(FileEntry) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := readDir(dir)
+	if err != nil {
+		*denied++
+		return nil
+	}
+	for _, e := range entries {
+		name := filepath.ToSlash(filepath.Join(rel, e.Name()))
+		if e.IsDir() {
+			if !recursive {
+				continue
+			}
+			if err := walkFiles(ctx, filepath.Join(dir, e.Name()), name, recursive, denied, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if err := fn(FileEntry{Path: name, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
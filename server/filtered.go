@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"disk-usage-analyser/scan"
+)
+
+type FilteredUsage struct {
+	Name  string `json:"name"`
+	IsDir bool   `json:"isDir"`
+	Size  int64  `json:"size"`  // bytes of the matching files
+	Files int64  `json:"files"` // number of matching files
+}
+
+type FilteredUsageResponse struct {
+	Path    string   `json:"path"`
+	Include []string `json:"include"`
+	Size    int64    `json:"size"`
+	Files   int64    `json:"files"`
+	// Children are the subdirectories holding matching files and the
+	// matching files of path, largest first
+	Children []FilteredUsage `json:"children"`
+}
+
+// parseInclude splits a comma separated list of name globs such as
+// *.mp4,*.mkv, lower-cased since names are matched in any case
+func parseInclude(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("include is required")
+	}
+	return patterns, nil
+}
+
+func includeMatch(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredUsage sums the cached files of the subtree of dir matching patterns
+func filteredUsage(c *scan.Cache, dir string, patterns []string) (size int64, files int64) {
+	for _, entry := range c.Under(dir) {
+		entry.Lock()
+		for _, f := range entry.Files {
+			if includeMatch(patterns, f.Name) {
+				size += f.Size
+				files++
+			}
+		}
+		entry.Unlock()
+	}
+	return size, files
+}
+
+// handleUsageFiltered scans path like /usage, descending every directory,
+// and answers with the bytes of the files whose name matches one of the
+// include globs, for path and each of its subdirectories: how much video
+// is below ~ is include=*.mp4,*.mkv.
+func handleUsageFiltered(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dirPath := query.Get("path")
+	if dirPath == "" {
+		dirPath = InitialDir
+	}
+	if dirPath == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	patterns, err := parseInclude(query.Get("include"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile, err := ParseProfile(query.Get("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, scanPath, src, err := resolvePath(r.Context(), dirPath, profile)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), pathErrorStatus(err))
+		return
+	}
+
+	s.Scan(r.Context(), scanPath, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+
+	cache := s.Cache()
+	resp := FilteredUsageResponse{Path: displayPath(src, scanPath), Include: patterns, Children: []FilteredUsage{}}
+	resp.Size, resp.Files = filteredUsage(cache, scanPath, patterns)
+	if entry := cache.GetEntry(scanPath); entry != nil {
+		entry.Lock()
+		files := entry.Files
+		entry.Unlock()
+		for _, f := range files {
+			if includeMatch(patterns, f.Name) {
+				resp.Children = append(resp.Children, FilteredUsage{Name: f.Name, Size: f.Size, Files: 1})
+			}
+		}
+	}
+	for _, child := range cache.Children(scanPath) {
+		if size, files := filteredUsage(cache, filepath.Join(scanPath, child.Name()), patterns); files > 0 {
+			resp.Children = append(resp.Children, FilteredUsage{Name: child.Name(), IsDir: true, Size: size, Files: files})
+		}
+	}
+	sort.Slice(resp.Children, func(i, j int) bool {
+		a, b := resp.Children[i], resp.Children[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Name < b.Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
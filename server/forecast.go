@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/server/disk"
+	"disk-usage-analyser/server/forecast"
+)
+
+// spaceSampleInterval is how often the used space of each volume is recorded
+const spaceSampleInterval = time.Hour
+
+var spaceHistory struct {
+	once    sync.Once
+	history *forecast.History
+}
+
+type VolumeForecast struct {
+	disk.Volume
+	Samples int `json:"samples"`
+	forecast.Estimate
+}
+
+// StartSpaceHistory records the used space of every volume
+// periodically, it is the input of the free space forecast
+func StartSpaceHistory() {
+	spaceHistory.once.Do(func() {
+		file := configPath("space-history.json")
+		history, err := forecast.Load(file)
+		if err != nil {
+			log.Printf("Error loading space history %s: %v", file, err)
+			return
+		}
+		spaceHistory.history = history
+		go func() {
+			for {
+				recordSpace(history)
+				time.Sleep(spaceSampleInterval)
+			}
+		}()
+	})
+}
+
+func recordSpace(history *forecast.History) {
+	volumes, err := disk.ListVolumes()
+	if err != nil {
+		log.Printf("Error listing volumes: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, v := range volumes {
+		history.Record(v.MountPoint, forecast.Sample{Time: now, Used: v.Used, Size: v.Size, Available: v.Available})
+	}
+	if err := history.Save(); err != nil {
+		log.Printf("Error saving space history: %v", err)
+	}
+}
+
+// handleForecast projects when each volume runs out of space
+func handleForecast(w http.ResponseWriter, r *http.Request) {
+	history := spaceHistory.history
+	if history == nil {
+		http.Error(w, "space history is not recorded", http.StatusServiceUnavailable)
+		return
+	}
+	volumes, err := disk.ListVolumes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	forecasts := make([]VolumeForecast, 0, len(volumes))
+	for _, v := range volumes {
+		samples := history.Samples(v.MountPoint)
+		// the current usage counts even between recorded samples
+		samples = append(samples, forecast.Sample{Time: now, Used: v.Used, Size: v.Size})
+		forecasts = append(forecasts, VolumeForecast{
+			Volume:   v,
+			Samples:  len(samples),
+			Estimate: forecast.Forecast(samples, v.Available, now),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(forecasts)
+}
@@ -0,0 +1,186 @@
+package forecast
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAge is how long samples are kept
+const maxAge = 180 * 24 * time.Hour
+
+// recentWindow is the period of the recent-trend estimate
+const recentWindow = 7 * 24 * time.Hour
+
+// Sample is the used space of a volume at one point in time
+type Sample struct {
+	Time time.Time `json:"time"`
+	Used int64     `json:"used"`
+	Size int64     `json:"size"`
+	// Available is the free space for users, 0 in samples recorded
+	// before it was
+	Available int64 `json:"available,omitempty"`
+}
+
+// History holds samples per mount point, persisted to a JSON file
+type History struct {
+	mu      sync.Mutex
+	file    string
+	Volumes map[string][]Sample `json:"volumes"`
+}
+
+// Load reads the history from file, a missing file is an empty history
+func Load(file string) (*History, error) {
+	h := &History{file: file, Volumes: make(map[string][]Sample)}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, err
+	}
+	if h.Volumes == nil {
+		h.Volumes = make(map[string][]Sample)
+	}
+	return h, nil
+}
+
+// Record appends a sample of mountPoint and drops expired ones
+func (h *History) Record(mountPoint string, s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := append(h.Volumes[mountPoint], s)
+	i := 0
+	for i < len(samples) && s.Time.Sub(samples[i].Time) > maxAge {
+		i++
+	}
+	h.Volumes[mountPoint] = samples[i:]
+}
+
+// Prune drops the series whose last sample is older than idle, e.g. of a
+// file that was deleted, then all but the maxSeries sampled last, and keeps
+// the last maxSamples samples of each
+func (h *History) Prune(now time.Time, idle time.Duration, maxSeries, maxSamples int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	type series struct {
+		key  string
+		last time.Time
+	}
+	var kept []series
+	for key, samples := range h.Volumes {
+		if len(samples) == 0 || now.Sub(samples[len(samples)-1].Time) > idle {
+			delete(h.Volumes, key)
+			continue
+		}
+		if len(samples) > maxSamples {
+			h.Volumes[key] = append([]Sample(nil), samples[len(samples)-maxSamples:]...)
+		}
+		kept = append(kept, series{key, samples[len(samples)-1].Time})
+	}
+	if len(kept) <= maxSeries {
+		return
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].last.After(kept[j].last) })
+	for _, s := range kept[maxSeries:] {
+		delete(h.Volumes, s.key)
+	}
+}
+
+// MountPoints returns the mount points with samples
+func (h *History) MountPoints() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := make([]string, 0, len(h.Volumes))
+	for mountPoint := range h.Volumes {
+		list = append(list, mountPoint)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Samples returns a copy of the samples of mountPoint, oldest first
+func (h *History) Samples(mountPoint string) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Sample(nil), h.Volumes[mountPoint]...)
+}
+
+func (h *History) Save() error {
+	h.mu.Lock()
+	data, err := json.Marshal(h)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(h.file, data, 0644)
+}
+
+// Trend is a growth rate with the time the volume would be full,
+// FullAt is nil when usage is not growing
+type Trend struct {
+	BytesPerDay float64    `json:"bytesPerDay"`
+	FullAt      *time.Time `json:"fullAt,omitempty"`
+}
+
+// Estimate projects when a volume with available free bytes runs full,
+// Linear fits all samples, Recent only the last week.
+// They are nil until there are two samples spanning an hour.
+type Estimate struct {
+	Linear *Trend `json:"linear,omitempty"`
+	Recent *Trend `json:"recent,omitempty"`
+}
+
+func Forecast(samples []Sample, available int64, now time.Time) Estimate {
+	var recent []Sample
+	for _, s := range samples {
+		if now.Sub(s.Time) <= recentWindow {
+			recent = append(recent, s)
+		}
+	}
+	return Estimate{
+		Linear: trend(samples, available, now),
+		Recent: trend(recent, available, now),
+	}
+}
+
+// trend fits used space over time with least squares
+func trend(samples []Sample, available int64, now time.Time) *Trend {
+	if len(samples) < 2 || samples[len(samples)-1].Time.Sub(samples[0].Time) < time.Hour {
+		return nil
+	}
+	t0 := samples[0].Time
+	var sumX, sumY, sumXX, sumXY float64
+	for _, s := range samples {
+		x := s.Time.Sub(t0).Hours() / 24
+		y := float64(s.Used)
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	n := float64(len(samples))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return nil
+	}
+	t := &Trend{BytesPerDay: (n*sumXY - sumX*sumY) / denom}
+	if t.BytesPerDay > 0 {
+		days := float64(available) / t.BytesPerDay
+		// beyond a century is as good as never
+		if days < 365*100 {
+			fullAt := now.Add(time.Duration(days * 24 * float64(time.Hour)))
+			t.FullAt = &fullAt
+		}
+	}
+	return t
+}
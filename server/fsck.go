@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"disk-usage-analyser/server/disk"
+)
+
+// fsckPollInterval is how often a watched check is looked at
+const fsckPollInterval = 2 * time.Second
+
+// FsckStatus is whether a device is being checked, e.g. by the fsck macOS
+// runs before mounting a disk that was not ejected, which makes
+// diskutil mount fail with "SUIS premount dissented"
+type FsckStatus struct {
+	DeviceID  string             `json:"deviceID"`
+	Checking  bool               `json:"checking"`
+	Processes []disk.FsckProcess `json:"processes"`
+}
+
+// FsckDone ends a watch once no check of the device runs
+type FsckDone struct {
+	DeviceID string `json:"deviceID"`
+	// Checked is set when a check ran, Elapsed is how long as far as seen,
+	// in seconds
+	Checked bool  `json:"checked"`
+	Elapsed int64 `json:"elapsed"`
+	// MountPoint is set when the system mounted the disk after the check,
+	// otherwise the client can mount it now
+	MountPoint string `json:"mountPoint,omitempty"`
+}
+
+func fsckStatus(deviceID string) (FsckStatus, error) {
+	list, err := disk.FsckProcesses()
+	if err != nil {
+		return FsckStatus{}, err
+	}
+	procs := disk.FsckOf(list, deviceID)
+	if procs == nil {
+		procs = []disk.FsckProcess{}
+	}
+	return FsckStatus{DeviceID: deviceID, Checking: len(procs) > 0, Processes: procs}, nil
+}
+
+// handleFsck reports the checks of a device. With watch=true it streams a
+// "progress" event every fsckPollInterval while one runs and a "done"
+// event when none runs anymore, right away if none did.
+func handleFsck(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("deviceID")
+	if deviceID == "" {
+		http.Error(w, "deviceID is required", http.StatusBadRequest)
+		return
+	}
+	if !disk.ValidDevice(deviceID) {
+		http.Error(w, "invalid device: "+deviceID, http.StatusBadRequest)
+		return
+	}
+	status, err := fsckStatus(deviceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("watch") != "true" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	sw := newStreamWriter(w, r)
+	defer sw.Close()
+
+	done := FsckDone{DeviceID: deviceID}
+	ticker := time.NewTicker(fsckPollInterval)
+	defer ticker.Stop()
+	for status.Checking {
+		done.Checked = true
+		for _, p := range status.Processes {
+			done.Elapsed = max(done.Elapsed, p.Elapsed)
+		}
+		if err := sendEvent(sw, "progress", status); err != nil {
+			return
+		}
+		sw.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if status, err = fsckStatus(deviceID); err != nil {
+			log.Printf("Error watching fsck of %s: %v", deviceID, err)
+			return
+		}
+	}
+	if done.Checked {
+		if info, err := disk.GetDiskInfo(deviceID); err == nil {
+			done.MountPoint = info.MountPoint
+		}
+	}
+	sendEvent(sw, "done", done)
+	sw.Flush()
+}
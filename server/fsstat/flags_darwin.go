@@ -0,0 +1,26 @@
+package fsstat
+
+import "syscall"
+
+// from <sys/stat.h>
+const (
+	// UF_COMPRESSED is set on files with transparent (decmpfs) compression
+	ufCompressed = 0x00000020
+	// SF_DATALESS is set on cloud placeholders (iCloud, FileProvider based
+	// Dropbox/OneDrive) whose content is not stored locally
+	sfDataless = 0x40000000
+)
+
+func statFlags(st *syscall.Stat_t) uint32 {
+	return st.Flags
+}
+
+// Compressed reports whether the file is transparently compressed
+func (s Stat) Compressed() bool {
+	return s.Flags&ufCompressed != 0
+}
+
+// Dataless reports whether the file is a cloud placeholder
+func (s Stat) Dataless() bool {
+	return s.Flags&sfDataless != 0
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !windows
+
+package fsstat
+
+func (s Stat) Compressed() bool {
+	return false
+}
+
+func (s Stat) Dataless() bool {
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build unix && !darwin
+
+package fsstat
+
+import "syscall"
+
+func statFlags(st *syscall.Stat_t) uint32 {
+	return 0
+}
@@ -0,0 +1,19 @@
+package fsstat
+
+// file attributes from winnt.h, Flags holds FileAttributes on Windows
+const (
+	fileAttributeOffline            = 0x00001000
+	fileAttributeCompressed         = 0x00000800
+	fileAttributeRecallOnOpen       = 0x00040000
+	fileAttributeRecallOnDataAccess = 0x00400000
+)
+
+// Compressed reports whether the file is NTFS compressed
+func (s Stat) Compressed() bool {
+	return s.Flags&fileAttributeCompressed != 0
+}
+
+// Dataless reports whether the file is a cloud placeholder (e.g. OneDrive online-only)
+func (s Stat) Dataless() bool {
+	return s.Flags&(fileAttributeOffline|fileAttributeRecallOnOpen|fileAttributeRecallOnDataAccess) != 0
+}
@@ -0,0 +1,34 @@
+package fsstat
+
+import "io/fs"
+
+// Stat holds the platform specific file attributes the scanner cares about
+type Stat struct {
+	UID    uint32 `json:"uid"`
+	GID    uint32 `json:"gid"`
+	Blocks int64  `json:"blocks"` // 512-byte blocks allocated
+	Flags  uint32 `json:"flags"`  // BSD file flags (st_flags), 0 elsewhere
+	// Dev and Ino identify the file, Nlink is its number of hard links.
+	// They are 0 when unknown.
+	Dev   uint64 `json:"dev,omitempty"`
+	Ino   uint64 `json:"ino,omitempty"`
+	Nlink uint64 `json:"nlink,omitempty"`
+}
+
+// Of extracts Stat from info, ok is false when the platform provides none.
+// info.Sys() may also be a *Stat, e.g. for files read through the privileged helper.
+func Of(info fs.FileInfo) (Stat, bool) {
+	switch sys := info.Sys().(type) {
+	case *Stat:
+		if sys == nil {
+			return Stat{}, false
+		}
+		return *sys, true
+	}
+	return platformStat(info.Sys())
+}
+
+// DiskSize is the number of bytes actually allocated on disk
+func (s Stat) DiskSize() int64 {
+	return s.Blocks * 512
+}
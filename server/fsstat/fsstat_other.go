@@ -0,0 +1,7 @@
+//go:build !unix && !windows
+
+package fsstat
+
+func platformStat(sys any) (Stat, bool) {
+	return Stat{}, false
+}
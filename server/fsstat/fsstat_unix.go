@@ -0,0 +1,21 @@
+//go:build unix
+
+package fsstat
+
+import "syscall"
+
+func platformStat(sys any) (Stat, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return Stat{}, false
+	}
+	return Stat{
+		UID:    st.Uid,
+		GID:    st.Gid,
+		Blocks: int64(st.Blocks),
+		Flags:  statFlags(st),
+		Dev:    uint64(st.Dev),
+		Ino:    uint64(st.Ino),
+		Nlink:  uint64(st.Nlink),
+	}, true
+}
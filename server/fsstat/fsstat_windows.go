@@ -0,0 +1,24 @@
+package fsstat
+
+import "syscall"
+
+func platformStat(sys any) (Stat, bool) {
+	attrs, ok := sys.(*syscall.Win32FileAttributeData)
+	if !ok {
+		return Stat{}, false
+	}
+	size := int64(attrs.FileSizeHigh)<<32 | int64(attrs.FileSizeLow)
+	return Stat{
+		// allocation size is not available here, assume fully allocated
+		// unless the content lives in the cloud
+		Blocks: blocksOf(size, attrs.FileAttributes),
+		Flags:  attrs.FileAttributes,
+	}, true
+}
+
+func blocksOf(size int64, attributes uint32) int64 {
+	if (Stat{Flags: attributes}).Dataless() {
+		return 0
+	}
+	return (size + 511) / 512
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !linux
+
+package fsstat
+
+// XattrSize is not supported on this platform
+func XattrSize(path string) (int64, error) {
+	return 0, nil
+}
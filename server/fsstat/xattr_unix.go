@@ -0,0 +1,40 @@
+//go:build darwin || linux
+
+package fsstat
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// XattrSize is the number of bytes held in the extended attributes of
+// path, on macOS this includes the resource fork. Symlinks are not followed.
+func XattrSize(path string) (int64, error) {
+	n, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, n)
+	n, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:n]), "\x00"), "\x00") {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			// removed in between, or not readable
+			continue
+		}
+		total += int64(size)
+	}
+	return total, nil
+}
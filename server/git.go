@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"disk-usage-analyser/scan"
+)
+
+// gitActions are the maintenance commands /api/git/run can stream. gc
+// keeps the unreachable objects of the last two weeks, which a rebase gone
+// wrong may still need, unless prune=now is asked for.
+var gitActions = map[string][]string{
+	"gc":        {"git", "gc"},
+	"lfs-prune": {"git", "lfs", "prune"},
+}
+
+type GitInfo struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// GitSize is the size of .git, WorkTreeSize the rest of the repository
+	GitSize      int64 `json:"gitSize"`
+	WorkTreeSize int64 `json:"workTreeSize"`
+	// ObjectsSize is .git/objects, LFSSize the git-lfs store in .git/lfs
+	ObjectsSize int64 `json:"objectsSize"`
+	LFSSize     int64 `json:"lfsSize"`
+}
+
+type GitRunDone struct {
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+	Before    int64  `json:"before"` // size of .git before running
+	After     int64  `json:"after"`
+	Reclaimed int64  `json:"reclaimed"`
+}
+
+// gitDirOf returns the .git directory of the repository at path, "" if it is none.
+// Worktrees and submodules with a .git file are not counted, their objects live elsewhere.
+func gitDirOf(path string) string {
+	gitDir := filepath.Join(path, ".git")
+	if info, err := os.Stat(scan.LocalPath(gitDir)); err == nil && info.IsDir() {
+		return gitDir
+	}
+	return ""
+}
+
+// repoPath resolves the path parameter to a git repository
+func repoPath(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return "", "", false
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+	gitDir := gitDirOf(path)
+	if gitDir == "" {
+		http.Error(w, "not a git repository: "+path, http.StatusBadRequest)
+		return "", "", false
+	}
+	return path, gitDir, true
+}
+
+func cachedSize(path string) int64 {
+	if entry := GlobalCache.GetEntry(path); entry != nil {
+		size, _ := entry.Usage()
+		return size
+	}
+	return 0
+}
+
+func handleGitInfo(w http.ResponseWriter, r *http.Request) {
+	path, gitDir, ok := repoPath(w, r)
+	if !ok {
+		return
+	}
+	resp := GitInfo{Path: path}
+	resp.Size, _ = getDirSizeWithCache(r.Context(), path, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+	// the scan of path filled the entries below it
+	resp.GitSize = cachedSize(gitDir)
+	resp.WorkTreeSize = resp.Size - resp.GitSize
+	resp.ObjectsSize = cachedSize(filepath.Join(gitDir, "objects"))
+	resp.LFSSize = cachedSize(filepath.Join(gitDir, "lfs"))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGitRun runs git gc or git lfs prune in a repository and streams
+// its output as SSE "output" events, the "done" event tells how much .git shrank
+func handleGitRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, gitDir, ok := repoPath(w, r)
+	if !ok {
+		return
+	}
+	action := r.URL.Query().Get("action")
+	args, ok := gitActions[action]
+	if !ok {
+		http.Error(w, "Invalid action: "+action+", must be gc or lfs-prune", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("prune") == "now" {
+		if action != "gc" {
+			http.Error(w, "prune=now is only valid for gc", http.StatusBadRequest)
+			return
+		}
+		args = append(args[:len(args):len(args)], "--prune=now")
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	done := GitRunDone{Action: action}
+	done.Before, _ = getDirSizeWithCache(r.Context(), gitDir, func(int64, int64) {})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	sw := newStreamWriter(w, r)
+	defer sw.Close()
+
+	log.Printf("Running %v in %s", args, path)
+	client := auditClientOf(r)
+	cmd := exec.CommandContext(r.Context(), args[0], args[1:]...)
+	cmd.Dir = path
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		audit(client, "git "+action, gitDir, done.Before, "", err)
+		done.Error = err.Error()
+		sendEvent(sw, "done", done)
+		return
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+	lines := bufio.NewScanner(pr)
+	// git rewrites progress lines with \r
+	lines.Split(scanTerminalLines)
+	for lines.Scan() {
+		if lines.Text() == "" {
+			continue
+		}
+		sendEvent(sw, "output", map[string]string{"line": lines.Text()})
+		sw.Flush()
+	}
+	// drain what is left if the scanner stopped early
+	io.Copy(io.Discard, pr)
+	err := <-waitErr
+	audit(client, "git "+action, gitDir, done.Before, "", err)
+	if err != nil {
+		done.Error = err.Error()
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+
+	invalidateCaches(gitDir)
+	done.After, _ = getDirSizeWithCache(r.Context(), gitDir, func(int64, int64) {})
+	done.Reclaimed = done.Before - done.After
+	sendEvent(sw, "done", done)
+	sw.Flush()
+}
+
+// scanTerminalLines splits at \n and \r, so progress updates are separate lines
+func scanTerminalLines(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		if b == '\n' || b == '\r' {
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// hashProgressInterval is how often progress of a running hash is sent
+const hashProgressInterval = 200 * time.Millisecond
+
+var hashAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+type HashProgress struct {
+	Path string `json:"path"`
+	Read int64  `json:"read"`
+	Size int64  `json:"size"`
+}
+
+type HashResult struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Hash  string `json:"hash,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type HashDone struct {
+	Algo    string       `json:"algo"`
+	Results []HashResult `json:"results"`
+	// Identical is set when more than one path was given and all hashes match
+	Identical bool `json:"identical"`
+}
+
+// handleHash streams the checksum of one or more files (path may be
+// repeated): "progress" events while reading, a "result" event per
+// file and a "done" event telling whether all files are identical
+func handleHash(w http.ResponseWriter, r *http.Request) {
+	paths := r.URL.Query()["path"]
+	if len(paths) == 0 {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	algo := r.URL.Query().Get("algo")
+	if algo == "" {
+		algo = "sha256"
+	}
+	newHash, ok := hashAlgos[algo]
+	if !ok {
+		http.Error(w, "Invalid algo: "+algo+", must be md5, sha1, sha256 or sha512", http.StatusBadRequest)
+		return
+	}
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		paths[i] = absPath
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	sw := newStreamWriter(w, r)
+	defer sw.Close()
+
+	done := HashDone{Algo: algo, Results: []HashResult{}}
+	for _, path := range paths {
+		result := hashFile(r.Context(), path, newHash(), func(p HashProgress) {
+			sendEvent(sw, "progress", p)
+			sw.Flush()
+		})
+		if r.Context().Err() != nil {
+			return
+		}
+		if err := sendEvent(sw, "result", result); err != nil {
+			return
+		}
+		sw.Flush()
+		done.Results = append(done.Results, result)
+	}
+
+	done.Identical = len(done.Results) > 1
+	for _, result := range done.Results {
+		if result.Hash == "" || result.Hash != done.Results[0].Hash {
+			done.Identical = false
+		}
+	}
+	sendEvent(sw, "done", done)
+	sw.Flush()
+}
+
+// hashFile reads path into h, calling onProgress periodically from the calling goroutine
+func hashFile(ctx context.Context, path string, h hash.Hash, onProgress func(HashProgress)) HashResult {
+	result := HashResult{Path: path}
+	f, err := os.Open(scan.LocalPath(path))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !info.Mode().IsRegular() {
+		result.Error = "not a regular file"
+		return result
+	}
+	result.Size = info.Size()
+
+	var read atomic.Int64
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(h, &ctxReader{ctx: ctx, r: f, n: &read})
+		copyDone <- err
+	}()
+
+	ticker := time.NewTicker(hashProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-copyDone:
+			if err != nil {
+				result.Error = fmt.Sprintf("read %s: %v", path, err)
+				return result
+			}
+			result.Hash = hex.EncodeToString(h.Sum(nil))
+			return result
+		case <-ticker.C:
+			onProgress(HashProgress{Path: path, Read: read.Load(), Size: result.Size})
+		}
+	}
+}
+
+// ctxReader stops reading once ctx is done and counts the bytes read
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+	n   *atomic.Int64
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// IgnoreRule leaves paths out of every scan, as if they did not exist.
+// Pattern is either an absolute path, ignored with everything below it,
+// or a name like node_modules or *.iso, matched with filepath.Match
+// against the entries of every directory.
+type IgnoreRule struct {
+	Pattern   string    `json:"pattern"`
+	CreatedAt time.Time `json:"createdAt"`
+	// CreatedBy is the user who added it, empty without access.json users
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+// isPath reports whether the rule names a path rather than a name pattern
+func (r IgnoreRule) isPath() bool {
+	return filepath.IsAbs(r.Pattern)
+}
+
+var ignoreRules = struct {
+	sync.Mutex
+	once  sync.Once
+	file  string
+	items []IgnoreRule
+}{}
+
+// ignoreMatcher is the compiled ignore list, scans read it for every
+// entry without a lock
+type ignoreMatcher struct {
+	paths map[string]bool
+	names map[string]bool
+	globs []string
+	// fingerprint identifies the list, a saved scan made with another
+	// list is not restored
+	fingerprint string
+}
+
+var currentIgnore atomic.Pointer[ignoreMatcher]
+
+// loadIgnoreRules reads the ignore list once, callers hold ignoreRules.Mutex
+func loadIgnoreRules() {
+	ignoreRules.once.Do(func() {
+		ignoreRules.file = configPath("ignore.json")
+		if err := loadJSON(ignoreRules.file, &ignoreRules.items); err != nil {
+			log.Printf("Error loading ignore list %s: %v", ignoreRules.file, err)
+		}
+		compileIgnoreRules()
+	})
+}
+
+func saveIgnoreRules() {
+	compileIgnoreRules()
+	if err := saveJSON(ignoreRules.file, ignoreRules.items); err != nil {
+		log.Printf("Error saving ignore list: %v", err)
+	}
+}
+
+// compileIgnoreRules publishes the matcher of the list, callers hold ignoreRules.Mutex
+func compileIgnoreRules() {
+	m := &ignoreMatcher{paths: make(map[string]bool), names: make(map[string]bool)}
+	patterns := make([]string, 0, len(ignoreRules.items))
+	for _, r := range ignoreRules.items {
+		patterns = append(patterns, r.Pattern)
+		// names read from the disk are normalized to match, see match
+		pattern := scan.NormalizePath(r.Pattern)
+		switch {
+		case r.isPath():
+			m.paths[pattern] = true
+		case strings.ContainsAny(pattern, `*?[`):
+			m.globs = append(m.globs, pattern)
+		default:
+			m.names[pattern] = true
+		}
+	}
+	sort.Strings(patterns)
+	m.fingerprint = strings.Join(patterns, "\n")
+	currentIgnore.Store(m)
+}
+
+func ignoreList() *ignoreMatcher {
+	if m := currentIgnore.Load(); m != nil {
+		return m
+	}
+	ignoreRules.Lock()
+	defer ignoreRules.Unlock()
+	loadIgnoreRules()
+	return currentIgnore.Load()
+}
+
+// skipIgnored is the Skip of the local scanners
+func skipIgnored(dir string, e fs.DirEntry) bool {
+	return ignoreList().match(dir, e.Name())
+}
+
+func (m *ignoreMatcher) match(dir string, name string) bool {
+	if len(m.names) == 0 && len(m.globs) == 0 && len(m.paths) == 0 {
+		return false
+	}
+	name = scan.NormalizePath(name)
+	if m.names[name] {
+		return true
+	}
+	for _, glob := range m.globs {
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return len(m.paths) > 0 && m.paths[scan.NormalizePath(filepath.Join(dir, name))]
+}
+
+// ignoreFingerprint identifies the current ignore list
+func ignoreFingerprint() string {
+	return ignoreList().fingerprint
+}
+
+// parseIgnorePattern validates the pattern query parameter: one with a
+// separator is a path and made absolute, a name pattern must be valid
+func parseIgnorePattern(w http.ResponseWriter, r *http.Request) (string, bool) {
+	pattern := strings.TrimSpace(r.URL.Query().Get("pattern"))
+	if pattern == "" {
+		http.Error(w, "pattern is required", http.StatusBadRequest)
+		return "", false
+	}
+	if filepath.IsAbs(pattern) || strings.ContainsRune(pattern, filepath.Separator) || strings.ContainsRune(pattern, '/') {
+		abs, err := filepath.Abs(pattern)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return "", false
+		}
+		return abs, true
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid pattern %q: %v", pattern, err), http.StatusBadRequest)
+		return "", false
+	}
+	return pattern, true
+}
+
+// invalidateIgnored drops what a changed rule affects from the caches: the
+// parent of a path, whose size changes, or everything for a name pattern
+func invalidateIgnored(rule IgnoreRule) {
+	if rule.isPath() {
+		invalidateCaches(filepath.Dir(rule.Pattern))
+		return
+	}
+	invalidateAllCaches()
+}
+
+func handleListIgnore(w http.ResponseWriter, r *http.Request) {
+	ignoreRules.Lock()
+	loadIgnoreRules()
+	list := append([]IgnoreRule{}, ignoreRules.items...)
+	ignoreRules.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func handleAddIgnore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pattern, ok := parseIgnorePattern(w, r)
+	if !ok {
+		return
+	}
+
+	ignoreRules.Lock()
+	loadIgnoreRules()
+	for _, rule := range ignoreRules.items {
+		if rule.Pattern == pattern {
+			ignoreRules.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rule)
+			return
+		}
+	}
+	rule := IgnoreRule{Pattern: pattern, CreatedAt: time.Now()}
+	if u := userOf(r.Context()); u != nil {
+		rule.CreatedBy = u.Name
+	}
+	ignoreRules.items = append(ignoreRules.items, rule)
+	saveIgnoreRules()
+	ignoreRules.Unlock()
+
+	invalidateIgnored(rule)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func handleRemoveIgnore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pattern, ok := parseIgnorePattern(w, r)
+	if !ok {
+		return
+	}
+
+	ignoreRules.Lock()
+	loadIgnoreRules()
+	for i, rule := range ignoreRules.items {
+		if rule.Pattern == pattern {
+			ignoreRules.items = append(ignoreRules.items[:i], ignoreRules.items[i+1:]...)
+			saveIgnoreRules()
+			ignoreRules.Unlock()
+			invalidateIgnored(rule)
+			w.Write([]byte("ok"))
+			return
+		}
+	}
+	ignoreRules.Unlock()
+	http.Error(w, "ignore rule not found", http.StatusNotFound)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AppName identifies this server in /api/instance, so that a second
+// launch can tell a running instance from another server on the port
+const AppName = "disk-usage-analyser"
+
+// Version is the build version, set with
+// -ldflags "-X disk-usage-analyser/server.Version=v1.2.3"
+var Version = "dev"
+
+var startedAt = time.Now()
+
+// instanceProbeTimeout bounds each probe, ports held by other
+// programs may never answer
+const instanceProbeTimeout = 500 * time.Millisecond
+
+type InstanceInfo struct {
+	App        string    `json:"app"`
+	Version    string    `json:"version"`
+	PID        int       `json:"pid"`
+	InitialDir string    `json:"initialDir"`
+	StartedAt  time.Time `json:"startedAt"`
+	// URL is where the instance was found, filled by FindInstance
+	URL string `json:"-"`
+}
+
+func handleInstance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InstanceInfo{
+		App:        AppName,
+		Version:    Version,
+		PID:        os.Getpid(),
+		InitialDir: InitialDir,
+		StartedAt:  startedAt,
+	})
+}
+
+// FindInstance asks the server at baseURL whether it is an instance of
+// this app, it returns nil when nothing or another server answers
+func FindInstance(baseURL string) *InstanceInfo {
+	client := &http.Client{Timeout: instanceProbeTimeout}
+	// unversioned, servers from before /api/v1 answer it too
+	resp, err := client.Get(baseURL + "/api/instance")
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var info InstanceInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil || info.App != AppName {
+		return nil
+	}
+	info.URL = baseURL
+	return &info
+}
+
+// BrowseURL links to dir on the server at baseURL, see handleBrowse
+func BrowseURL(baseURL string, dir string) string {
+	if dir == "" {
+		return baseURL
+	}
+	path := strings.TrimPrefix(filepath.ToSlash(dir), "/")
+	if path == "" {
+		// the root, /browse/ alone opens the initial dir
+		return baseURL + "/browse/%2F"
+	}
+	u := url.URL{Path: "/browse/" + path}
+	return baseURL + u.EscapedPath()
+}
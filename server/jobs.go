@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// JobStatus is a running scan, see scan.Job. A job outlives the request
+// that started it and runs until it completes or is cancelled.
+type JobStatus struct {
+	// ID is that of /api/v1/jobs/stats
+	ID        uint64    `json:"id"`
+	Path      string    `json:"path"`
+	Profile   Profile   `json:"profile"`
+	StartedAt time.Time `json:"startedAt"`
+	Size      int64     `json:"size"`
+	Count     int64     `json:"count"`
+	// Priority is interactive or background, see scan.Priority
+	Priority string `json:"priority"`
+}
+
+// profileScanners are the scanners of all profiles, each with its own jobs
+var profileScanners = []struct {
+	profile Profile
+	scanner *scan.Scanner
+}{
+	{ProfileQuick, quickScanner},
+	{ProfileStandard, scanner},
+	{ProfileDeep, deepScanner},
+}
+
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := []JobStatus{}
+	for _, ps := range profileScanners {
+		for _, job := range ps.scanner.Jobs() {
+			status := JobStatus{ID: job.ID, Path: job.Path, Profile: ps.profile, StartedAt: job.StartedAt, Priority: job.Priority.String()}
+			status.Size, status.Count = job.Entry.Usage()
+			jobs = append(jobs, status)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// handleCancelJob stops the scan job of path, of one profile or all of them.
+// Its unfinished directories are dropped from the cache and rescanned on
+// the next request.
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile := Profile(r.URL.Query().Get("profile"))
+	if profile != "" {
+		if _, err := ParseProfile(string(profile)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	cancelled := false
+	for _, ps := range profileScanners {
+		if profile == "" || profile == ps.profile {
+			cancelled = ps.scanner.Cancel(path) || cancelled
+		}
+	}
+	if !cancelled {
+		http.Error(w, "no scan job for "+path, http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// handleJobStats responds with the file system calls of a running or
+// recently finished scan job and their time per depth level, which tells
+// a slow mount or a virus scanner from a large tree. The id is the {id}
+// of /scan/{id}/stats, or the id parameter of /jobs/stats.
+func handleJobStats(w http.ResponseWriter, r *http.Request) {
+	idParam := r.PathValue("id")
+	if idParam == "" {
+		idParam = r.URL.Query().Get("id")
+	}
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	for _, ps := range profileScanners {
+		if stats, ok := ps.scanner.JobIOStats(id); ok {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stats)
+			return
+		}
+	}
+	http.Error(w, "no scan job "+strconv.FormatUint(id, 10), http.StatusNotFound)
+}
@@ -0,0 +1,662 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/archive"
+	"disk-usage-analyser/server/diskimage"
+	"disk-usage-analyser/server/fsstat"
+	"disk-usage-analyser/server/timemachine"
+	"disk-usage-analyser/server/vfs"
+	"disk-usage-analyser/server/vm"
+)
+
+// maxPendingProgress bounds the progress updates queued for one watcher.
+// Past it the oldest are dropped, final updates are always kept.
+const maxPendingProgress = 1000
+
+// listingKey identifies a directory listing shared by all clients requesting it
+type listingKey struct {
+	path           string
+	descendBundles bool
+	profile        Profile
+	// showIgnored lists the entries of the ignore list instead of leaving them out
+	showIgnored bool
+}
+
+// listing scans the direct children of one directory once and
+// broadcasts item updates to every client watching it
+type listing struct {
+	key    listingKey
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	items    map[string]FileInfo
+	order    []string // item names in the order they were first seen
+	watchers map[uint64]*watcher
+	nextID   uint64
+	done     bool
+	err      error
+	// closed is set once the last watcher left, the listing takes no more
+	closed bool
+	// estimate is the expected number of entries, see Progress
+	estimate       int64
+	estimateSource string
+	countOnce      sync.Once
+	// dirCounts are the directories below each scanned subdirectory
+	dirCounts map[string]dirCounts
+	// io are the IOStats of the listing's own ReadDir at depth 0 and of
+	// the jobs that scanned the subdirectories, by job
+	io     scan.IOStats
+	jobsIO map[uint64]scan.IOStats
+}
+
+// dirCounts are the directories below a directory and those of them that
+// could not be read, see scan.Entry
+type dirCounts struct {
+	dirs   int64
+	errors int64
+}
+
+// watcher is one client's view of a listing. Updates are coalesced
+// per item and progress beyond maxPendingProgress is dropped, so a slow
+// client never blocks the scan and always ends up with the latest
+// final state of each item.
+type watcher struct {
+	notify chan struct{} // signaled (capacity 1) when there is something to drain
+
+	// the fields below are guarded by listing.mu
+	pending      map[string]FileInfo
+	pendingOrder []string
+	progress     int                  // queued items whose status is "pending"
+	lastSent     map[string]time.Time // when each item was last drained, for rate limiting
+	watchers     int
+	watchersSent int
+	done         bool
+	err          error
+}
+
+var listings = struct {
+	sync.Mutex
+	byKey map[listingKey]*listing
+}{
+	byKey: make(map[listingKey]*listing),
+}
+
+// watchListing joins the listing for key, starting it if nobody is watching yet.
+// The returned watcher is primed with every item known so far.
+func watchListing(key listingKey) (*listing, *watcher, func()) {
+	listings.Lock()
+	l := listings.byKey[key]
+	var w *watcher
+	if l != nil {
+		w = l.addWatcher()
+	}
+	if w == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		l = &listing{
+			key:       key,
+			ctx:       ctx,
+			cancel:    cancel,
+			items:     make(map[string]FileInfo),
+			watchers:  make(map[uint64]*watcher),
+			dirCounts: make(map[string]dirCounts),
+			jobsIO:    make(map[uint64]scan.IOStats),
+		}
+		if n := recentEntries(key.path); n > 0 {
+			l.estimate, l.estimateSource = n, "recent"
+		}
+		listings.byKey[key] = l
+		w = l.addWatcher()
+		go l.run(ctx)
+	}
+	listings.Unlock()
+
+	return l, w, func() { l.removeWatcher(w) }
+}
+
+// watcherCount returns how many clients are watching the listing of path
+func watcherCount(path string) int {
+	listings.Lock()
+	defer listings.Unlock()
+	n := 0
+	for key, l := range listings.byKey {
+		if key.path != path {
+			continue
+		}
+		l.mu.Lock()
+		n += len(l.watchers)
+		l.mu.Unlock()
+	}
+	return n
+}
+
+// addWatcher returns nil if the listing was closed
+func (l *listing) addWatcher() *watcher {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	w := &watcher{
+		notify:   make(chan struct{}, 1),
+		pending:  make(map[string]FileInfo, len(l.items)),
+		lastSent: make(map[string]time.Time),
+		done:     l.done,
+		err:      l.err,
+	}
+	for _, name := range l.order {
+		w.queue(l.items[name])
+	}
+	l.watchers[l.nextID] = w
+	l.nextID++
+	l.watchersChangedLocked()
+	w.signal()
+	return w
+}
+
+// removeWatcher drops w, closing the listing once nobody is interested
+// anymore. The scans go on as jobs, the cache entries are shared. It holds
+// listings.Mutex so that watchListing does not join a listing closed
+// meanwhile.
+func (l *listing) removeWatcher(w *watcher) {
+	listings.Lock()
+	l.mu.Lock()
+	for id, other := range l.watchers {
+		if other == w {
+			delete(l.watchers, id)
+		}
+	}
+	l.watchersChangedLocked()
+	if len(l.watchers) == 0 && !l.closed {
+		l.closed = true
+		if listings.byKey[l.key] == l {
+			delete(listings.byKey, l.key)
+		}
+	}
+	closed := l.closed
+	l.mu.Unlock()
+	listings.Unlock()
+
+	if closed {
+		l.cancel()
+	}
+}
+
+func (l *listing) watchersChangedLocked() {
+	for _, w := range l.watchers {
+		w.watchers = len(l.watchers)
+		w.signal()
+	}
+}
+
+func (l *listing) publish(item FileInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.items[item.Name]; !ok {
+		l.order = append(l.order, item.Name)
+	}
+	l.items[item.Name] = item
+	for _, w := range l.watchers {
+		w.queue(item)
+		w.signal()
+	}
+}
+
+// queue replaces the pending update of item, dropping the oldest
+// progress update once more than maxPendingProgress are queued
+func (w *watcher) queue(item FileInfo) {
+	if prev, ok := w.pending[item.Name]; !ok {
+		w.pendingOrder = append(w.pendingOrder, item.Name)
+	} else if prev.Status == "pending" {
+		w.progress--
+	}
+	w.pending[item.Name] = item
+	if item.Status != "pending" {
+		return
+	}
+	w.progress++
+	if w.progress <= maxPendingProgress {
+		return
+	}
+	for i, name := range w.pendingOrder {
+		if w.pending[name].Status == "pending" {
+			delete(w.pending, name)
+			w.pendingOrder = append(w.pendingOrder[:i], w.pendingOrder[i+1:]...)
+			w.progress--
+			return
+		}
+	}
+}
+
+func (l *listing) finish(err error) {
+	l.mu.Lock()
+	l.done = true
+	l.err = err
+	for _, w := range l.watchers {
+		w.done = true
+		w.err = err
+		w.signal()
+	}
+	l.mu.Unlock()
+
+	// late clients start a fresh listing, which is served from the cache
+	listings.Lock()
+	if listings.byKey[l.key] == l {
+		delete(listings.byKey, l.key)
+	}
+	listings.Unlock()
+}
+
+func (w *watcher) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// drain takes the pending updates of w. watchers is -1 when unchanged.
+// Progress of an item drained less than minInterval ago is held back
+// for a later drain, final ("done") updates are never held back.
+// done is only reported once nothing is held back.
+func (l *listing) drain(w *watcher, minInterval time.Duration) (items []FileInfo, watchers int, done bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	items = make([]FileInfo, 0, len(w.pendingOrder))
+	var held []string
+	for _, name := range w.pendingOrder {
+		item := w.pending[name]
+		if minInterval > 0 && !w.done && item.Status == "pending" {
+			if last, ok := w.lastSent[name]; ok && now.Sub(last) < minInterval {
+				held = append(held, name)
+				continue
+			}
+		}
+		items = append(items, item)
+		w.lastSent[name] = now
+		delete(w.pending, name)
+		if item.Status == "pending" {
+			w.progress--
+		}
+	}
+	w.pendingOrder = held
+
+	watchers = -1
+	if w.watchers != w.watchersSent {
+		watchers = w.watchers
+		w.watchersSent = w.watchers
+	}
+	return items, watchers, w.done && len(held) == 0, w.err
+}
+
+func handleUsageWatchers(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":     path,
+		"watchers": watcherCount(path),
+	})
+}
+
+// run lists the directory and scans each subdirectory through the cache.
+// The directory may be in a source (a bucket, a remote host...), the
+// checks of local files such as bundles or Time Machine exclusions are
+// then left out.
+func (l *listing) run(ctx context.Context) {
+	s, dirPath, src, err := resolvePath(ctx, l.key.path, l.key.profile)
+	if err != nil {
+		l.finish(err)
+		return
+	}
+	local := src == nil
+	start := time.Now()
+	entries, err := scan.ReadDirContext(ctx, s.FS(), dirPath)
+	elapsed := time.Since(start).Milliseconds()
+	l.mu.Lock()
+	l.io = scan.IOStats{Path: dirPath, StartedAt: start, ReadDirs: 1, ReadDirMs: elapsed, Levels: []scan.LevelIO{{
+		Dirs: 1, ReadDirMs: elapsed, Start: start, End: time.Now(), WallMs: elapsed, Slowest: dirPath, SlowestMs: elapsed,
+	}}}
+	l.mu.Unlock()
+	if err != nil {
+		log.Printf("Error reading directory %s: %v", l.key.path, err)
+		if local {
+			recordDenied(dirPath, err)
+		}
+		l.finish(err)
+		return
+	}
+
+	// Identify subdirectories and files
+	var subDirs []fs.DirEntry
+	var files []fs.DirEntry
+	var ignored []fs.DirEntry
+
+	// system directories at the root of a volume are always listed,
+	// unreadable ones are accounted for by an estimate
+	volumeRoot := local && isVolumeRoot(dirPath)
+	var dirStore string
+	var dirAnnotations map[string]*Annotation
+	if local {
+		dirStore = storeOf(dirPath)
+		dirAnnotations = annotationsIn(dirPath)
+	}
+	for _, entry := range entries {
+		if local && l.key.profile == ProfileQuick && skipQuick(dirPath, entry) && !(volumeRoot && volumeSystemDirs[entry.Name()] != "") {
+			continue
+		}
+		if local && skipIgnored(dirPath, entry) {
+			if l.key.showIgnored {
+				ignored = append(ignored, entry)
+			}
+			continue
+		}
+		if entry.IsDir() {
+			subDirs = append(subDirs, entry)
+		} else {
+			files = append(files, entry)
+		}
+	}
+
+	// ignored entries are not scanned, they are listed to be greyed out
+	for _, entry := range ignored {
+		item := FileInfo{Name: entry.Name(), IsDir: entry.IsDir(), Status: "done", Ignored: true}
+		if info, err := entry.Info(); err == nil {
+			item.ModTime = info.ModTime()
+		}
+		l.publish(item)
+	}
+
+	// Publish all files immediately
+	for _, entry := range files {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		item := FileInfo{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			IsDir:   false,
+			Status:  "done",
+			ModTime: info.ModTime(),
+			Entries: 1,
+		}
+		item.DiskImage = diskimage.Kind(entry.Name())
+		item.VM = vm.Kind(entry.Name())
+		setDiskSize(&item, info)
+		if meta := vfs.MetaOf(info); meta != nil {
+			item.StorageClass = meta.StorageClass
+		}
+		if local {
+			item.Archive = archive.Kind(entry.Name())
+			item.Store = dirStore
+			item.Annotation = dirAnnotations[scan.NormalizePath(entry.Name())]
+			setOwner(&item, info)
+			if l.key.profile == ProfileDeep {
+				item.XattrSize, _ = fsstat.XattrSize(filepath.Join(dirPath, entry.Name()))
+			}
+		}
+		l.publish(item)
+	}
+	// Check Time Machine exclusions in one batch
+	var backupExcluded map[string]bool
+	if local && timemachine.Supported() && len(subDirs) > 0 {
+		subDirPaths := make([]string, 0, len(subDirs))
+		for _, entry := range subDirs {
+			subDirPaths = append(subDirPaths, filepath.Join(dirPath, entry.Name()))
+		}
+		backupExcluded, err = timemachine.IsExcluded(subDirPaths)
+		if err != nil {
+			log.Printf("Error checking backup exclusions: %v", err)
+		}
+	}
+
+	// Publish all directories immediately with pending status
+	dirItems := make(map[string]FileInfo, len(subDirs))
+	for _, entry := range subDirs {
+		item := FileInfo{
+			Name:   entry.Name(),
+			Size:   0,
+			IsDir:  true,
+			Status: "pending",
+		}
+		if info, err := entry.Info(); err == nil {
+			item.ModTime = info.ModTime()
+			if local {
+				setOwner(&item, info)
+			}
+		}
+		if !local {
+			dirItems[entry.Name()] = item
+			l.publish(item)
+			continue
+		}
+		if bundleType := getBundleType(entry.Name()); bundleType != "" {
+			item.BundleType = bundleType
+			item.BundleVersion = getBundleVersion(filepath.Join(dirPath, entry.Name()))
+			item.Leaf = !l.key.descendBundles
+		}
+		item.BackupExcluded = backupExcluded[filepath.Join(dirPath, entry.Name())]
+		item.DiskImage = diskimage.Kind(entry.Name())
+		item.VM = vm.Kind(entry.Name())
+		item.Store = dirStore
+		if item.Store == "" {
+			item.Store = storeOf(filepath.Join(dirPath, entry.Name()))
+		}
+		if volumeRoot {
+			markVolumeSystemDir(&item, dirPath)
+		}
+		item.GitRepo = gitDirOf(filepath.Join(dirPath, entry.Name())) != ""
+		item.Annotation = dirAnnotations[scan.NormalizePath(entry.Name())]
+		dirItems[entry.Name()] = item
+		l.publish(item)
+	}
+
+	var wg sync.WaitGroup
+	// Limit concurrency for top level response handling
+	// Note: the scanner handles its own concurrency,
+	// but we still want to limit how many scans we invoke concurrently from here
+	// to avoid overwhelming the system if a folder has 10k subfolders.
+	sem := make(chan struct{}, 20)
+
+	// Start workers for directories
+	for _, dir := range subDirs {
+		wg.Add(1)
+		go func(d fs.DirEntry) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Panic in worker for %s: %v", d.Name(), r)
+				}
+			}()
+
+			// Acquire semaphore
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			fullPath := filepath.Join(dirPath, d.Name())
+
+			onProgress := func(currentSize int64, currentCount int64) {
+				item := dirItems[d.Name()]
+				item.Size = currentSize
+				item.Entries = currentCount
+				item.Status = "pending"
+				l.publish(item)
+			}
+
+			// Use the smart cache-aware scanner
+			e := s.ScanEntry(ctx, fullPath, onProgress)
+			if ctx.Err() != nil {
+				return
+			}
+
+			item := dirItems[d.Name()]
+			item.Size, item.Entries = e.Usage()
+			item.Status = "done"
+			dirs, errors := e.DirCounts()
+			l.mu.Lock()
+			l.dirCounts[d.Name()] = dirCounts{dirs: dirs, errors: errors}
+			// a directory sized by the scan of an ancestor has its job's
+			// calls elsewhere
+			if io, ok := e.IOStats(); ok && io.Path == fullPath {
+				l.jobsIO[io.Job] = io
+			}
+			l.mu.Unlock()
+			partial, err := e.Outcome()
+			item.Partial = partial
+			if err != nil {
+				item.Error = err.Error()
+			}
+			stats := entryStats(e)
+			item.OthersSize = stats.OthersSize()
+			item.DiskSize = stats.DiskSize
+			item.CloudSize = stats.CloudSize
+			item.XattrSize = stats.XattrSize
+			item.StorageClass = stats.StorageClass()
+			if item.GitRepo {
+				if e := s.Cache().GetEntry(filepath.Join(fullPath, ".git")); e != nil {
+					item.GitSize, _ = e.Usage()
+				}
+			}
+			l.publish(item)
+		}(dir)
+	}
+
+	wg.Wait()
+	if ctx.Err() == nil && volumeRoot {
+		l.publishUnreadableEstimate()
+	}
+	if ctx.Err() == nil {
+		recordRecentScan(l.summary())
+	}
+	l.finish(nil)
+}
+
+// publishUnreadableEstimate adds an item for the bytes of unreadable system
+// directories of a volume root. The root of the file system is left out,
+// other volumes mounted below it make its numbers meaningless.
+func (l *listing) publishUnreadableEstimate() {
+	if filepath.Dir(l.key.path) == l.key.path {
+		return
+	}
+	l.mu.Lock()
+	items := make([]FileInfo, 0, len(l.items))
+	unreadable := false
+	for _, item := range l.items {
+		items = append(items, item)
+		unreadable = unreadable || item.Unreadable
+	}
+	l.mu.Unlock()
+	if !unreadable {
+		return
+	}
+	if size, ok := estimateUnreadable(l.key.path, items); ok {
+		l.publish(FileInfo{
+			Name:      unreadableSystemName,
+			Size:      size,
+			DiskSize:  size,
+			IsDir:     true,
+			Status:    "done",
+			Leaf:      true,
+			Estimated: true,
+		})
+	}
+}
+
+// summary totals the items of a finished listing
+func (l *listing) summary() RecentScan {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	scan := RecentScan{Path: l.key.path, ScannedAt: time.Now()}
+	for _, item := range l.items {
+		scan.Size += item.Size
+		scan.Entries += item.Entries
+	}
+	return scan
+}
+
+// UsageSummary is the exact total of a finished listing, sent last so
+// that a client can replace the sum of the progress it was sent
+type UsageSummary struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	DiskSize int64  `json:"diskSize"`
+	// Files and Dirs are all those below the path, Entries their sum
+	Entries int64 `json:"entries"`
+	Files   int64 `json:"files"`
+	Dirs    int64 `json:"dirs"`
+	// Errors are the directories that could not be read, Size misses what
+	// they hold
+	Errors       int64  `json:"errors"`
+	SizeText     string `json:"sizeText,omitempty"`
+	DiskSizeText string `json:"diskSizeText,omitempty"`
+	// IO are the file system calls of the listing and of the scan jobs of
+	// its subdirectories, levels counted from Path. Jobs are their ids for
+	// /api/v1/jobs/stats, subdirectories that were cached already count
+	// the calls of the scan that cached them.
+	IO   *scan.IOStats `json:"io,omitempty"`
+	Jobs []uint64      `json:"jobs,omitempty"`
+}
+
+// usageSummary totals the final items of the listing. Ignored entries are
+// not scanned and the estimate of unreadable system directories is no
+// directory, they only add to the sizes.
+func (l *listing) usageSummary() UsageSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	summary := UsageSummary{Path: l.key.path}
+	for _, item := range l.items {
+		summary.Size += item.Size
+		summary.DiskSize += item.DiskSize
+		if item.Ignored || item.Estimated {
+			continue
+		}
+		summary.Entries += item.Entries
+		if !item.IsDir {
+			summary.Files++
+			continue
+		}
+		counts := l.dirCounts[item.Name]
+		summary.Entries++
+		summary.Dirs += 1 + counts.dirs
+		summary.Files += item.Entries - counts.dirs
+		summary.Errors += counts.errors
+		if item.Error != "" {
+			summary.Errors++
+		}
+	}
+	if l.io.ReadDirs > 0 {
+		io := scan.IOStats{Path: l.key.path, Done: l.done}
+		io.Add(l.io, 0)
+		for id, jobIO := range l.jobsIO {
+			io.Add(jobIO, 1)
+			summary.Jobs = append(summary.Jobs, id)
+		}
+		slices.Sort(summary.Jobs)
+		summary.IO = &io
+	}
+	return summary
+}
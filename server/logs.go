@@ -0,0 +1,477 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/forecast"
+	"disk-usage-analyser/server/logs"
+)
+
+const (
+	// logSampleInterval is how often log sizes are recorded and watches checked
+	logSampleInterval = time.Hour
+	// defaultLogMinSize hides small logs from /api/logs
+	defaultLogMinSize = 1 << 20
+	// maxLogFiles caps the files of one /api/logs response
+	maxLogFiles = 200
+	// logs above truncateLogSize or growing faster than watchLogRate get a suggestion
+	truncateLogSize = 100 << 20
+	watchLogRate    = 10 << 20
+	// the log history keeps the files seen within logHistoryIdle, at most
+	// maxLogHistoryFiles of them with their last maxLogSamples samples
+	logHistoryIdle     = 7 * 24 * time.Hour
+	maxLogHistoryFiles = 1000
+	maxLogSamples      = 14 * 24
+)
+
+type LogFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Rotated marks compressed or numbered logs nothing writes to anymore
+	Rotated bool `json:"rotated"`
+	// BytesPerDay is the growth of the file since it was first seen, 0 until
+	// two samples an hour apart are recorded
+	BytesPerDay float64 `json:"bytesPerDay"`
+	// Suggestion is "delete" for rotated logs, "truncate" for large active
+	// logs, "watch" for fast growing ones and "" otherwise
+	Suggestion string `json:"suggestion,omitempty"`
+	Watched    bool   `json:"watched"`
+}
+
+type JournalInfo struct {
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+type LogsResponse struct {
+	Dirs  []string  `json:"dirs"`
+	Files []LogFile `json:"files"` // largest first
+	Size  int64     `json:"size"`
+	// Journal is the systemd journal usage, nil without journalctl
+	Journal *JournalInfo `json:"journal,omitempty"`
+	Denied  int          `json:"denied"`
+}
+
+type VacuumResponse struct {
+	Before    int64  `json:"before"`
+	After     int64  `json:"after"`
+	Reclaimed int64  `json:"reclaimed"`
+	Output    string `json:"output"`
+}
+
+// LogWatch is a path checked against a size threshold every logSampleInterval
+type LogWatch struct {
+	Path      string    `json:"path"`
+	Threshold int64     `json:"threshold"`
+	Size      int64     `json:"size"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Exceeded  bool      `json:"exceeded"`
+}
+
+var logHistory struct {
+	once    sync.Once
+	history *forecast.History
+}
+
+var logWatches = struct {
+	sync.Mutex
+	once  sync.Once
+	file  string
+	items []*LogWatch
+}{}
+
+// loadLogWatches reads the watches file once, callers hold logWatches.Mutex
+func loadLogWatches() {
+	logWatches.once.Do(func() {
+		logWatches.file = configPath("log-watches.json")
+		if err := loadJSON(logWatches.file, &logWatches.items); err != nil {
+			log.Printf("Error loading log watches %s: %v", logWatches.file, err)
+		}
+	})
+}
+
+func saveLogWatches() {
+	if err := saveJSON(logWatches.file, logWatches.items); err != nil {
+		log.Printf("Error saving log watches: %v", err)
+	}
+}
+
+// logSizeHistory returns the recorded sizes of log files, nil if they cannot be loaded
+func logSizeHistory() *forecast.History {
+	logHistory.once.Do(func() {
+		file := configPath("log-history.json")
+		history, err := forecast.Load(file)
+		if err != nil {
+			log.Printf("Error loading log history %s: %v", file, err)
+			return
+		}
+		logHistory.history = history
+	})
+	return logHistory.history
+}
+
+// StartLogWatch records the size of log files and checks the registered
+// watches periodically, growth of logs is derived from the records
+func StartLogWatch() {
+	ctx := scan.WithPriority(context.Background(), scan.Background)
+	go func() {
+		for {
+			recordLogSizes(ctx)
+			checkLogWatches(ctx)
+			time.Sleep(logSampleInterval)
+		}
+	}()
+}
+
+func recordLogSizes(ctx context.Context) {
+	history := logSizeHistory()
+	if history == nil {
+		return
+	}
+	var denied int
+	for _, dir := range logs.Dirs() {
+		findLogFiles(ctx, dir, defaultLogMinSize, &denied, func(f LogFile) {
+			recordLogSize(history, f.Path, f.Size, time.Now())
+		})
+	}
+	history.Prune(time.Now(), logHistoryIdle, maxLogHistoryFiles, maxLogSamples)
+	if err := history.Save(); err != nil {
+		log.Printf("Error saving log history: %v", err)
+	}
+}
+
+// recordLogSize adds a sample unless the last one is recent
+func recordLogSize(history *forecast.History, path string, size int64, now time.Time) bool {
+	samples := history.Samples(path)
+	if len(samples) > 0 && now.Sub(samples[len(samples)-1].Time) < logSampleInterval/2 {
+		return false
+	}
+	history.Record(path, forecast.Sample{Time: now, Used: size})
+	return true
+}
+
+func checkLogWatches(ctx context.Context) {
+	logWatches.Lock()
+	loadLogWatches()
+	watches := append([]*LogWatch(nil), logWatches.items...)
+	logWatches.Unlock()
+	if len(watches) == 0 {
+		return
+	}
+	for _, watch := range watches {
+		size, err := logPathSize(ctx, watch.Path)
+		if err != nil {
+			log.Printf("Error checking log watch %s: %v", watch.Path, err)
+			continue
+		}
+		logWatches.Lock()
+		exceeded := size > watch.Threshold
+		if exceeded && !watch.Exceeded {
+			log.Printf("Warning: %s is %d bytes, above its threshold of %d bytes", watch.Path, size, watch.Threshold)
+		}
+		watch.Size, watch.Exceeded, watch.CheckedAt = size, exceeded, time.Now()
+		logWatches.Unlock()
+	}
+	logWatches.Lock()
+	saveLogWatches()
+	logWatches.Unlock()
+}
+
+// logPathSize is the size of a log file or directory. A directory of the
+// logs is walked anew, one elsewhere, e.g. a spool, is sized by its cached
+// scan like in the UI: the scans of watched paths are not dropped from the
+// cache every logSampleInterval.
+func logPathSize(ctx context.Context, path string) (int64, error) {
+	info, err := os.Stat(scan.LocalPath(path))
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return info.Size(), nil
+	}
+	if slices.Contains(logs.Dirs(), path) || logs.InDir(path) {
+		var size int64
+		var denied int
+		err := walkFiles(ctx, path, "", true, &denied, func(f FileEntry) error {
+			size += f.Size
+			return nil
+		})
+		return size, err
+	}
+	size, _ := getDirSizeWithCache(ctx, path, func(int64, int64) {})
+	return size, nil
+}
+
+// findLogFiles calls fn for each regular file of at least minSize below dir
+func findLogFiles(ctx context.Context, dir string, minSize int64, denied *int, fn func(LogFile)) {
+	walkFiles(ctx, dir, "", true, denied, func(f FileEntry) error {
+		if f.Size < minSize {
+			return nil
+		}
+		path := filepath.Join(dir, filepath.FromSlash(f.Path))
+		fn(LogFile{Path: path, Size: f.Size, ModTime: f.ModTime, Rotated: logs.IsRotated(filepath.Base(path))})
+		return nil
+	})
+}
+
+// handleLogs lists the large log files with their growth and what to do
+// about them, and the size of the systemd journal
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+	dirs := logs.Dirs()
+	if path := r.URL.Query().Get("path"); path != "" {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dirs = []string{path}
+	}
+	minSize := int64(defaultLogMinSize)
+	if s := r.URL.Query().Get("minSize"); s != "" {
+		n, err := logs.ParseSize(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		minSize = n
+	}
+
+	logWatches.Lock()
+	loadLogWatches()
+	watched := make(map[string]bool, len(logWatches.items))
+	for _, watch := range logWatches.items {
+		watched[watch.Path] = true
+	}
+	logWatches.Unlock()
+
+	history := logSizeHistory()
+	now := time.Now()
+	resp := LogsResponse{Dirs: dirs, Files: []LogFile{}}
+	for _, dir := range dirs {
+		findLogFiles(r.Context(), dir, minSize, &resp.Denied, func(f LogFile) {
+			if history != nil {
+				recorded := recordLogSize(history, f.Path, f.Size, now)
+				samples := history.Samples(f.Path)
+				if !recorded {
+					// the current size counts even between recorded samples
+					samples = append(samples, forecast.Sample{Time: now, Used: f.Size})
+				}
+				if t := forecast.Forecast(samples, 0, now).Linear; t != nil {
+					f.BytesPerDay = t.BytesPerDay
+				}
+			}
+			f.Watched = watched[f.Path]
+			switch {
+			case f.Rotated:
+				f.Suggestion = "delete"
+			case f.Size >= truncateLogSize:
+				f.Suggestion = "truncate"
+			case f.BytesPerDay >= watchLogRate && !f.Watched:
+				f.Suggestion = "watch"
+			}
+			resp.Files = append(resp.Files, f)
+			resp.Size += f.Size
+		})
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+	if history != nil {
+		history.Prune(now, logHistoryIdle, maxLogHistoryFiles, maxLogSamples)
+		if err := history.Save(); err != nil {
+			log.Printf("Error saving log history: %v", err)
+		}
+	}
+	sort.Slice(resp.Files, func(i, j int) bool {
+		return resp.Files[i].Size > resp.Files[j].Size
+	})
+	if len(resp.Files) > maxLogFiles {
+		resp.Files = resp.Files[:maxLogFiles]
+	}
+
+	if logs.JournalSupported() {
+		resp.Journal = &JournalInfo{}
+		size, err := logs.JournalUsage()
+		if err != nil {
+			resp.Journal.Error = err.Error()
+		}
+		resp.Journal.Size = size
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleVacuumJournal shrinks the systemd journal to size, e.g. 500M
+func handleVacuumJournal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !logs.JournalSupported() {
+		http.Error(w, "journalctl is not available", http.StatusNotImplemented)
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		http.Error(w, "size is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := logs.ParseSize(size); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp VacuumResponse
+	resp.Before, _ = logs.JournalUsage()
+	log.Printf("Vacuuming journal to %s", size)
+	out, err := logs.VacuumJournal(size)
+	resp.Output = out
+	audit(auditClientOf(r), "vacuum", "/var/log/journal", resp.Before, "", err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.After, _ = logs.JournalUsage()
+	resp.Reclaimed = resp.Before - resp.After
+	invalidateCaches("/var/log/journal")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleTruncateLog empties a log file. It must be inside a log directory,
+// and confirm must be the size the client showed, so nothing larger than
+// expected is discarded.
+func handleTruncateLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !logs.InDir(path) {
+		http.Error(w, "not inside a log directory: "+path, http.StatusBadRequest)
+		return
+	}
+	info, err := os.Lstat(scan.LocalPath(path))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	confirm, err := strconv.ParseInt(r.URL.Query().Get("confirm"), 10, 64)
+	if err != nil || confirm < info.Size() {
+		http.Error(w, "confirm the truncation with confirm=<size>, "+path+" has "+strconv.FormatInt(info.Size(), 10)+" bytes", http.StatusConflict)
+		return
+	}
+
+	log.Printf("Truncating log %s (%d bytes)", path, info.Size())
+	err = logs.Truncate(path)
+	audit(auditClientOf(r), "truncate", path, info.Size(), "", err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invalidateCaches(path)
+	w.Write([]byte("ok"))
+}
+
+func handleListLogWatches(w http.ResponseWriter, r *http.Request) {
+	logWatches.Lock()
+	loadLogWatches()
+	items := make([]LogWatch, 0, len(logWatches.items))
+	for _, watch := range logWatches.items {
+		items = append(items, *watch)
+	}
+	logWatches.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// handleAddLogWatch registers path to be checked against threshold (bytes
+// or a size like 1G), registering it again updates the threshold
+func handleAddLogWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+	threshold, err := logs.ParseSize(r.URL.Query().Get("threshold"))
+	if err != nil || threshold <= 0 {
+		http.Error(w, "Invalid threshold", http.StatusBadRequest)
+		return
+	}
+	size, err := logPathSize(r.Context(), path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logWatches.Lock()
+	defer logWatches.Unlock()
+	loadLogWatches()
+	var watch *LogWatch
+	for _, item := range logWatches.items {
+		if item.Path == path {
+			watch = item
+		}
+	}
+	if watch == nil {
+		watch = &LogWatch{Path: path}
+		logWatches.items = append(logWatches.items, watch)
+	}
+	watch.Threshold, watch.Size, watch.CheckedAt = threshold, size, time.Now()
+	watch.Exceeded = size > threshold
+	saveLogWatches()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(watch)
+}
+
+func handleRemoveLogWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+
+	logWatches.Lock()
+	defer logWatches.Unlock()
+	loadLogWatches()
+	items := logWatches.items[:0]
+	for _, item := range logWatches.items {
+		if item.Path != path {
+			items = append(items, item)
+		}
+	}
+	logWatches.items = items
+	saveLogWatches()
+	w.Write([]byte("ok"))
+}
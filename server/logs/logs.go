@@ -0,0 +1,142 @@
+// Package logs finds log files and reclaims their space: vacuuming the
+// systemd journal and truncating log files.
+package logs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"disk-usage-analyser/scan"
+)
+
+// Dirs returns the existing directories logs are written to
+func Dirs() []string {
+	candidates := []string{"/var/log"}
+	if runtime.GOOS == "darwin" {
+		candidates = append(candidates, "/Library/Logs")
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			candidates = append(candidates, filepath.Join(homeDir, "Library", "Logs"))
+		}
+	}
+	var dirs []string
+	for _, dir := range candidates {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// InDir reports whether path is inside one of Dirs once symlinks are
+// resolved, so that a link in a log directory does not lead out of it. A
+// path that cannot be resolved is not.
+func InDir(path string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range Dirs() {
+		// /var/log is /private/var/log on macOS
+		if dir, err = filepath.EvalSymlinks(dir); err == nil && strings.HasPrefix(resolved, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+var rotatedSuffix = regexp.MustCompile(`(\.\d+|\.old|-\d{8})$`)
+
+// IsRotated reports whether name is a rotated or compressed log,
+// nothing writes to it anymore
+func IsRotated(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".bz2", ".xz", ".zst":
+		return true
+	}
+	return rotatedSuffix.MatchString(name)
+}
+
+// Truncate empties a log file, a process writing to it continues at offset 0
+// (or at its old offset when it does not open it with O_APPEND)
+func Truncate(path string) error {
+	info, err := os.Lstat(scan.LocalPath(path))
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file: %s", path)
+	}
+	return os.Truncate(scan.LocalPath(path), 0)
+}
+
+// JournalSupported reports whether the systemd journal can be inspected
+func JournalSupported() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	_, err := exec.LookPath("journalctl")
+	return err == nil
+}
+
+// journal sizes are printed like "take up 1.2G in the file system"
+var journalUsage = regexp.MustCompile(`take up ([0-9.]+[BKMGTP]?) `)
+
+// JournalUsage returns the bytes used by archived and active journal files
+func JournalUsage() (int64, error) {
+	out, err := exec.Command("journalctl", "--disk-usage").CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("journalctl --disk-usage: %v, %s", err, strings.TrimSpace(string(out)))
+	}
+	m := journalUsage.FindStringSubmatch(string(out))
+	if m == nil {
+		return 0, fmt.Errorf("unexpected output of journalctl --disk-usage: %s", strings.TrimSpace(string(out)))
+	}
+	return ParseSize(m[1])
+}
+
+// VacuumJournal removes archived journal files until the journal is at most size,
+// e.g. "500M", and returns the output of journalctl
+func VacuumJournal(size string) (string, error) {
+	if _, err := ParseSize(size); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("journalctl", "--vacuum-size="+size).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("journalctl --vacuum-size=%s: %v, %s", size, err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+var sizeUnits = map[byte]int64{
+	'B': 1,
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+	'P': 1 << 50,
+}
+
+// ParseSize parses sizes as journalctl prints and accepts them: a number
+// of bytes with an optional K, M, G, T or P suffix (powers of 1024)
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	if num == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	unit := int64(1)
+	if u, ok := sizeUnits[strings.ToUpper(num[len(num)-1:])[0]]; ok {
+		unit = u
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(n * float64(unit)), nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/server/mft"
+)
+
+// UseMFT lists NTFS volumes from their Master File Table instead of
+// directory by directory, it needs administrator rights on Windows
+var UseMFT bool
+
+// mftMaxAge is how long an MFT snapshot answers listings before it is read again
+const mftMaxAge = 5 * time.Minute
+
+type mftVolume struct {
+	once  sync.Once
+	index *mft.Index
+}
+
+var mftVolumes = struct {
+	sync.Mutex
+	byVolume map[string]*mftVolume
+}{byVolume: make(map[string]*mftVolume)}
+
+// mftReadDir lists dir from the MFT of its volume, ok is false when
+// that is not possible and the directory must be read from disk
+func mftReadDir(dir string) ([]fs.DirEntry, bool) {
+	if !UseMFT || !mft.Supported() {
+		return nil, false
+	}
+	index := mftIndex(filepath.VolumeName(dir))
+	if index == nil {
+		return nil, false
+	}
+	entries, err := index.ReadDir(dir)
+	if err != nil {
+		return nil, false
+	}
+	return entries, true
+}
+
+// mftIndex returns the snapshot of vol, reading it on first use.
+// A volume whose MFT cannot be read is not tried again.
+func mftIndex(vol string) *mft.Index {
+	if vol == "" {
+		return nil
+	}
+	mftVolumes.Lock()
+	v := mftVolumes.byVolume[vol]
+	if v == nil || v.index != nil && time.Since(v.index.LoadedAt) > mftMaxAge {
+		v = &mftVolume{}
+		mftVolumes.byVolume[vol] = v
+	}
+	mftVolumes.Unlock()
+
+	v.once.Do(func() {
+		start := time.Now()
+		index, err := mft.Load(vol + `\`)
+		if err != nil {
+			log.Printf("Reading the MFT of %s failed, listing directories instead: %v", vol, err)
+			return
+		}
+		log.Printf("Read the MFT of %s: %d records in %v", vol, index.Records(), time.Since(start))
+		v.index = index
+	})
+	return v.index
+}
+
+// invalidateMFT drops the snapshot of the volume of path after changes to it
+func invalidateMFT(path string) {
+	vol := filepath.VolumeName(path)
+	mftVolumes.Lock()
+	if v := mftVolumes.byVolume[vol]; v != nil && v.index != nil {
+		delete(mftVolumes.byVolume, vol)
+	}
+	mftVolumes.Unlock()
+}
@@ -0,0 +1,540 @@
+// Package mft lists NTFS volumes from their Master File Table. Reading the
+// MFT sequentially gives every file of a volume in seconds, where listing
+// directory by directory takes minutes.
+package mft
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"disk-usage-analyser/server/fsstat"
+)
+
+const (
+	rootRecord = 5
+	// firstUserRecord is the first record not reserved for metadata files ($MFT, $Bitmap, ...)
+	firstUserRecord = 24
+	// fixupStride is the size of the blocks protected by the update sequence array
+	fixupStride = 512
+	// readChunk is how much of the MFT is read at once
+	readChunk = 1 << 20
+
+	attrStandardInformation = 0x10
+	attrAttributeList       = 0x20
+	attrFileName            = 0x30
+	attrData                = 0x80
+	attrReparsePoint        = 0xC0
+	attrEnd                 = 0xFFFFFFFF
+
+	recordInUse     = 0x01
+	recordDirectory = 0x02
+
+	// file attributes of $STANDARD_INFORMATION
+	fileAttributeReparsePoint = 0x400
+
+	// reparse tags of $REPARSE_POINT, name surrogates are links to another
+	// name: symlinks, junctions and mount points
+	reparseTagSymlink       = 0xA000000C
+	reparseTagDedup         = 0x80000013
+	reparseTagNameSurrogate = 0x20000000
+
+	// namespaceDOS marks the short 8.3 alias of a long name
+	namespaceDOS = 2
+
+	// referenceMask extracts the record number of a file reference,
+	// the upper 16 bits are a sequence number
+	referenceMask = 1<<48 - 1
+)
+
+var errNotNTFS = errors.New("not an NTFS volume")
+
+// errMFTIncomplete is returned when not all of the $MFT could be located,
+// the volume is then listed directory by directory
+var errMFTIncomplete = errors.New("the $MFT could not be located completely")
+
+type record struct {
+	inUse   bool
+	dir     bool
+	links   uint16
+	attrs   uint32
+	tag     uint32 // reparse tag, 0 unless read
+	modTime int64  // FILETIME
+	size    int64
+	alloc   int64
+}
+
+type child struct {
+	record uint64
+	name   string
+}
+
+// Index is a snapshot of the files of a volume
+type Index struct {
+	// Root is the path of the volume root, e.g. C:\
+	Root     string
+	LoadedAt time.Time
+	serial   uint64
+	records  []record
+	children map[uint64][]child
+	// dirs caches the record of each directory listed so far by lower-cased path
+	dirs sync.Map
+}
+
+// Records is the number of MFT records read
+func (ix *Index) Records() int {
+	return len(ix.records)
+}
+
+// Read builds the index of the volume at root from its raw device
+func Read(r io.ReaderAt, root string) (*Index, error) {
+	boot := make([]byte, 512)
+	if _, err := r.ReadAt(boot, 0); err != nil {
+		return nil, fmt.Errorf("read boot sector: %v", err)
+	}
+	if string(boot[3:11]) != "NTFS    " {
+		return nil, errNotNTFS
+	}
+	bytesPerSector := int64(binary.LittleEndian.Uint16(boot[0x0B:]))
+	sectorsPerCluster := int64(boot[0x0D])
+	if sectorsPerCluster > 0x80 {
+		// large clusters are stored as a negative power of two
+		sectorsPerCluster = 1 << (256 - sectorsPerCluster)
+	}
+	clusterSize := bytesPerSector * sectorsPerCluster
+	mftCluster := int64(binary.LittleEndian.Uint64(boot[0x30:]))
+	recordSize := int64(int8(boot[0x40]))
+	if recordSize < 0 {
+		recordSize = 1 << -recordSize
+	} else {
+		recordSize *= clusterSize
+	}
+	if clusterSize == 0 || recordSize < fixupStride || recordSize%fixupStride != 0 {
+		return nil, fmt.Errorf("invalid NTFS geometry: cluster %d, record %d", clusterSize, recordSize)
+	}
+
+	ix := &Index{
+		Root:     root,
+		LoadedAt: time.Now(),
+		serial:   binary.LittleEndian.Uint64(boot[0x48:]),
+		children: make(map[uint64][]child),
+	}
+
+	// record 0 is $MFT itself, its data runs tell where the rest of the table is
+	mftRecord := make([]byte, recordSize)
+	if _, err := r.ReadAt(mftRecord, mftCluster*clusterSize); err != nil {
+		return nil, fmt.Errorf("read $MFT record: %v", err)
+	}
+	if !fixup(mftRecord) {
+		return nil, fmt.Errorf("invalid $MFT record")
+	}
+	runs, mftSize, err := mftRuns(r, mftRecord, clusterSize, recordSize)
+	if err != nil {
+		return nil, err
+	}
+	ix.records = make([]record, mftSize/recordSize)
+
+	buf := make([]byte, readChunk-readChunk%recordSize)
+	var num uint64
+	for _, run := range runs {
+		runBytes := run.length * clusterSize
+		if run.lcn < 0 {
+			num += uint64(runBytes / recordSize)
+			continue
+		}
+		for off := int64(0); off < runBytes && num < uint64(len(ix.records)); {
+			n := min(int64(len(buf)), runBytes-off)
+			chunk := buf[:n]
+			if _, err := r.ReadAt(chunk, run.lcn*clusterSize+off); err != nil {
+				return nil, fmt.Errorf("read MFT: %v", err)
+			}
+			for p := int64(0); p+recordSize <= n && num < uint64(len(ix.records)); p += recordSize {
+				ix.parseRecord(num, chunk[p:p+recordSize])
+				num++
+			}
+			off += n
+		}
+	}
+	for parent := range ix.children {
+		kids := ix.children[parent]
+		sort.Slice(kids, func(i, j int) bool { return kids[i].name < kids[j].name })
+	}
+	return ix, nil
+}
+
+// fixup verifies and undoes the update sequence of a record, which
+// replaces the last two bytes of every 512 byte block
+func fixup(buf []byte) bool {
+	if len(buf) < 48 || string(buf[0:4]) != "FILE" {
+		return false
+	}
+	usaOffset := int(binary.LittleEndian.Uint16(buf[4:]))
+	usaCount := int(binary.LittleEndian.Uint16(buf[6:]))
+	if usaCount == 0 || usaOffset+usaCount*2 > len(buf) {
+		return false
+	}
+	for i := 1; i < usaCount; i++ {
+		end := i*fixupStride - 2
+		if end+2 > len(buf) {
+			break
+		}
+		if buf[end] != buf[usaOffset] || buf[end+1] != buf[usaOffset+1] {
+			// torn write
+			return false
+		}
+		copy(buf[end:end+2], buf[usaOffset+2*i:usaOffset+2*i+2])
+	}
+	return true
+}
+
+// attributes calls fn with the type and bytes of each attribute of a record
+func attributes(buf []byte, fn func(typ uint32, attr []byte)) {
+	off := int(binary.LittleEndian.Uint16(buf[0x14:]))
+	for off+16 <= len(buf) {
+		typ := binary.LittleEndian.Uint32(buf[off:])
+		if typ == attrEnd {
+			return
+		}
+		length := int(binary.LittleEndian.Uint32(buf[off+4:]))
+		if length < 16 || off+length > len(buf) {
+			return
+		}
+		fn(typ, buf[off:off+length])
+		off += length
+	}
+}
+
+// residentValue returns the value of a resident attribute, nil for non-resident ones
+func residentValue(attr []byte) []byte {
+	if attr[8] != 0 || len(attr) < 0x18 {
+		return nil
+	}
+	length := int(binary.LittleEndian.Uint32(attr[0x10:]))
+	off := int(binary.LittleEndian.Uint16(attr[0x14:]))
+	if off+length > len(attr) {
+		return nil
+	}
+	return attr[off : off+length]
+}
+
+func (ix *Index) parseRecord(num uint64, buf []byte) {
+	if !fixup(buf) {
+		return
+	}
+	flags := binary.LittleEndian.Uint16(buf[0x16:])
+	if flags&recordInUse == 0 {
+		return
+	}
+	// extension records hold attributes of their base record, every one
+	// is parsed for it, so what an $ATTRIBUTE_LIST points to is found
+	// without reading the list
+	target := num
+	if base := binary.LittleEndian.Uint64(buf[0x20:]) & referenceMask; base != 0 {
+		target = base
+	} else {
+		r := &ix.records[num]
+		r.inUse = true
+		r.dir = flags&recordDirectory != 0
+	}
+	if target >= uint64(len(ix.records)) {
+		return
+	}
+	r := &ix.records[target]
+
+	attributes(buf, func(typ uint32, attr []byte) {
+		switch typ {
+		case attrStandardInformation:
+			v := residentValue(attr)
+			if len(v) >= 0x24 {
+				r.modTime = int64(binary.LittleEndian.Uint64(v[0x08:]))
+				r.attrs = binary.LittleEndian.Uint32(v[0x20:])
+			}
+		case attrFileName:
+			v := residentValue(attr)
+			if len(v) < 0x42 || v[0x41] == namespaceDOS {
+				return
+			}
+			n := int(v[0x40])
+			if len(v) < 0x42+2*n {
+				return
+			}
+			units := make([]uint16, n)
+			for i := range units {
+				units[i] = binary.LittleEndian.Uint16(v[0x42+2*i:])
+			}
+			parent := binary.LittleEndian.Uint64(v) & referenceMask
+			// the link count of the record header includes DOS aliases
+			r.links++
+			ix.children[parent] = append(ix.children[parent], child{record: target, name: string(utf16.Decode(units))})
+		case attrReparsePoint:
+			if v := residentValue(attr); len(v) >= 4 {
+				r.tag = binary.LittleEndian.Uint32(v)
+			}
+		case attrData:
+			// named data attributes are alternate streams
+			if attr[9] != 0 {
+				return
+			}
+			if attr[8] == 0 {
+				r.size = int64(len(residentValue(attr)))
+				return
+			}
+			// the sizes are only valid in the first extent
+			if len(attr) >= 0x40 && binary.LittleEndian.Uint64(attr[0x10:]) == 0 {
+				r.alloc = int64(binary.LittleEndian.Uint64(attr[0x28:]))
+				r.size = int64(binary.LittleEndian.Uint64(attr[0x30:]))
+			}
+		}
+	})
+}
+
+type dataRun struct {
+	lcn    int64 // -1 for sparse runs
+	length int64 // in clusters
+}
+
+// mftRuns returns the data runs and the size of the $MFT from its record.
+// A heavily fragmented $MFT has more runs than fit in the record; they are
+// in extension records that its $ATTRIBUTE_LIST names and that lie in the
+// runs known so far. errMFTIncomplete tells that the runs found do not
+// cover the whole table.
+func mftRuns(r io.ReaderAt, mftRecord []byte, clusterSize, recordSize int64) ([]dataRun, int64, error) {
+	runs, size := dataRuns(mftRecord)
+	if len(runs) == 0 || size <= 0 {
+		return nil, 0, fmt.Errorf("$MFT has no data runs")
+	}
+	extensions, ok := attributeListRecords(mftRecord, attrData)
+	if !ok {
+		return nil, 0, errMFTIncomplete
+	}
+	for _, num := range extensions {
+		off, ok := runOffset(runs, num*uint64(recordSize), clusterSize)
+		if !ok {
+			return nil, 0, errMFTIncomplete
+		}
+		ext := make([]byte, recordSize)
+		if _, err := r.ReadAt(ext, off); err != nil {
+			return nil, 0, fmt.Errorf("read $MFT extension record %d: %v", num, err)
+		}
+		if !fixup(ext) {
+			return nil, 0, errMFTIncomplete
+		}
+		more, _ := dataRuns(ext)
+		runs = append(runs, more...)
+	}
+	var covered int64
+	for _, run := range runs {
+		covered += run.length * clusterSize
+	}
+	if covered < size {
+		return nil, 0, errMFTIncomplete
+	}
+	return runs, size, nil
+}
+
+// attributeListRecords returns the extension records holding the unnamed
+// attribute typ, in the order of its $ATTRIBUTE_LIST, which is that of
+// their starting VCN. ok is false if the list is not resident, it is not
+// read then.
+func attributeListRecords(buf []byte, typ uint32) (records []uint64, ok bool) {
+	ok = true
+	attributes(buf, func(t uint32, attr []byte) {
+		if t != attrAttributeList {
+			return
+		}
+		list := residentValue(attr)
+		if list == nil {
+			ok = false
+			return
+		}
+		for len(list) >= 0x1A {
+			length := int(binary.LittleEndian.Uint16(list[4:]))
+			if length < 0x1A || length > len(list) {
+				return
+			}
+			// the record itself holds the first extent
+			ref := binary.LittleEndian.Uint64(list[0x10:]) & referenceMask
+			if binary.LittleEndian.Uint32(list) == typ && list[6] == 0 && ref != 0 {
+				records = append(records, ref)
+			}
+			list = list[length:]
+		}
+	})
+	return records, ok
+}
+
+// runOffset maps the byte offset off of the data to its position on the
+// volume, ok is false if it is in a sparse run or beyond them
+func runOffset(runs []dataRun, off uint64, clusterSize int64) (int64, bool) {
+	vcn, rest := int64(off)/clusterSize, int64(off)%clusterSize
+	for _, run := range runs {
+		if vcn < run.length {
+			if run.lcn < 0 {
+				return 0, false
+			}
+			return (run.lcn+vcn)*clusterSize + rest, true
+		}
+		vcn -= run.length
+	}
+	return 0, false
+}
+
+// dataRuns decodes the runs of the unnamed $DATA attribute and its size.
+// The size is only set in the first extent, the one starting at VCN 0, an
+// extension record holding a later extent returns its runs and size 0.
+func dataRuns(buf []byte) (runs []dataRun, size int64) {
+	attributes(buf, func(typ uint32, attr []byte) {
+		if typ != attrData || attr[8] == 0 || attr[9] != 0 || len(attr) < 0x40 {
+			return
+		}
+		if binary.LittleEndian.Uint64(attr[0x10:]) == 0 {
+			size = int64(binary.LittleEndian.Uint64(attr[0x30:]))
+		}
+		b := attr[binary.LittleEndian.Uint16(attr[0x20:]):]
+		var lcn int64
+		for len(b) > 0 && b[0] != 0 {
+			lengthSize, offsetSize := int(b[0]&0x0F), int(b[0]>>4)
+			if 1+lengthSize+offsetSize > len(b) {
+				return
+			}
+			length := littleEndian(b[1:1+lengthSize], false)
+			if offsetSize == 0 {
+				runs = append(runs, dataRun{lcn: -1, length: length})
+			} else {
+				lcn += littleEndian(b[1+lengthSize:1+lengthSize+offsetSize], true)
+				runs = append(runs, dataRun{lcn: lcn, length: length})
+			}
+			b = b[1+lengthSize+offsetSize:]
+		}
+	})
+	return runs, size
+}
+
+// littleEndian decodes a variable length integer, sign extended if signed
+func littleEndian(b []byte, signed bool) int64 {
+	var v int64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | int64(b[i])
+	}
+	if signed && len(b) > 0 && len(b) < 8 && b[len(b)-1]&0x80 != 0 {
+		v -= 1 << (8 * len(b))
+	}
+	return v
+}
+
+// lookup returns the record of the directory at path
+func (ix *Index) lookup(path string) (uint64, bool) {
+	path = filepath.Clean(path)
+	key := strings.ToLower(path)
+	if num, ok := ix.dirs.Load(key); ok {
+		return num.(uint64), true
+	}
+	rel, ok := cutPrefixFold(path, ix.Root)
+	if !ok {
+		return 0, false
+	}
+	num := uint64(rootRecord)
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, c := range ix.children[num] {
+			if c.record != num && strings.EqualFold(c.name, name) && ix.records[c.record].dir {
+				num, found = c.record, true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	ix.dirs.Store(key, num)
+	return num, true
+}
+
+func cutPrefixFold(s string, prefix string) (string, bool) {
+	prefix = strings.TrimSuffix(prefix, string(filepath.Separator))
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return "", false
+	}
+	return s[len(prefix):], true
+}
+
+// ReadDir lists a directory of the volume like os.ReadDir, sorted by name
+func (ix *Index) ReadDir(dir string) ([]fs.DirEntry, error) {
+	num, ok := ix.lookup(dir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	kids := ix.children[num]
+	entries := make([]fs.DirEntry, 0, len(kids))
+	for _, c := range kids {
+		if c.record == num || c.record < firstUserRecord && c.record != rootRecord {
+			continue
+		}
+		r := &ix.records[c.record]
+		if !r.inUse {
+			continue
+		}
+		info := &fileInfo{name: c.name, record: r, stat: &fsstat.Stat{
+			Blocks: (r.alloc + 511) / 512,
+			Flags:  r.attrs,
+			Dev:    ix.serial,
+			Ino:    c.record,
+			Nlink:  uint64(r.links),
+		}}
+		if info.IsDir() {
+			ix.dirs.Store(strings.ToLower(filepath.Join(dir, c.name)), c.record)
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+type fileInfo struct {
+	name   string
+	record *record
+	stat   *fsstat.Stat
+}
+
+func (f *fileInfo) Name() string { return f.name }
+func (f *fileInfo) Size() int64  { return f.record.size }
+
+// Mode is the mode os.ReadDir reports: symlinks, junctions and mount
+// points are not directories, so they are not followed, and other reparse
+// points such as cloud placeholders are irregular files or directories
+func (f *fileInfo) Mode() fs.FileMode {
+	var irregular fs.FileMode
+	if f.record.attrs&fileAttributeReparsePoint != 0 {
+		switch tag := f.record.tag; {
+		case tag == reparseTagSymlink, tag == 0:
+			// a tag that could not be read is taken for a link too
+			return fs.ModeSymlink | 0777
+		case tag&reparseTagNameSurrogate != 0:
+			return fs.ModeIrregular | 0777
+		case tag != reparseTagDedup:
+			irregular = fs.ModeIrregular
+		}
+	}
+	if f.record.dir {
+		return fs.ModeDir | irregular | 0777
+	}
+	return irregular | 0666
+}
+func (f *fileInfo) IsDir() bool { return f.Mode().IsDir() }
+func (f *fileInfo) Sys() any    { return f.stat }
+
+// ModTime converts the FILETIME (100ns since 1601) of the record
+func (f *fileInfo) ModTime() time.Time {
+	const unixEpoch = 116444736000000000
+	return time.Unix(0, (f.record.modTime-unixEpoch)*100)
+}
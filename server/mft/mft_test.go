@@ -0,0 +1,48 @@
+package mft
+
+import (
+	"io/fs"
+	"testing"
+)
+
+// reparse tags of Windows that the MFT reader does not name
+const (
+	reparseTagMountPoint  = 0xA0000003 // junctions and volume mount points
+	reparseTagCloud       = 0x9000001A // OneDrive placeholders
+	reparseTagAppExecLink = 0x8000001B // WindowsApps aliases
+)
+
+func TestFileInfoMode(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  bool
+		tag  uint32
+		// reparse is whether the reparse point attribute is set
+		reparse bool
+		want    fs.FileMode
+	}{
+		{"file", false, 0, false, 0666},
+		{"dir", true, 0, false, fs.ModeDir | 0777},
+		{"symlink to a file", false, reparseTagSymlink, true, fs.ModeSymlink | 0777},
+		{"symlink to a dir", true, reparseTagSymlink, true, fs.ModeSymlink | 0777},
+		{"unread tag", true, 0, true, fs.ModeSymlink | 0777},
+		{"junction", true, reparseTagMountPoint, true, fs.ModeIrregular | 0777},
+		{"cloud placeholder file", false, reparseTagCloud, true, fs.ModeIrregular | 0666},
+		{"cloud placeholder dir", true, reparseTagCloud, true, fs.ModeDir | fs.ModeIrregular | 0777},
+		{"dedup file", false, reparseTagDedup, true, 0666},
+		{"app execution alias", false, reparseTagAppExecLink, true, fs.ModeIrregular | 0666},
+	}
+	for _, tt := range tests {
+		r := &record{inUse: true, dir: tt.dir, tag: tt.tag}
+		if tt.reparse {
+			r.attrs = fileAttributeReparsePoint
+		}
+		info := &fileInfo{name: tt.name, record: r}
+		if got := info.Mode(); got != tt.want {
+			t.Errorf("%s: Mode() = %v, want %v", tt.name, got, tt.want)
+		}
+		if got, want := info.IsDir(), tt.want.IsDir(); got != want {
+			t.Errorf("%s: IsDir() = %v, want %v", tt.name, got, want)
+		}
+	}
+}
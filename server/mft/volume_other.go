@@ -0,0 +1,13 @@
+//go:build !windows
+
+package mft
+
+import "errors"
+
+func Supported() bool {
+	return false
+}
+
+func Load(root string) (*Index, error) {
+	return nil, errors.New("MFT scanning is only supported on Windows")
+}
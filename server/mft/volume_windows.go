@@ -0,0 +1,36 @@
+package mft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+func Supported() bool {
+	return true
+}
+
+// Load reads the MFT of the drive letter volume holding root,
+// opening the raw volume needs administrator rights
+func Load(root string) (*Index, error) {
+	vol := filepath.VolumeName(root)
+	if len(vol) != 2 || vol[1] != ':' {
+		return nil, fmt.Errorf("not a drive letter volume: %s", root)
+	}
+	name := `\\.\` + vol
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(path, windows.GENERIC_READ,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", name, err)
+	}
+	f := os.NewFile(uintptr(h), name)
+	defer f.Close()
+	return Read(f, vol+`\`)
+}
@@ -0,0 +1,475 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// requestIDHeader carries the id of a request, a client may choose it to
+// find its request in the server log
+const requestIDHeader = "X-Request-ID"
+
+// routeTimeouts bound the routes that answer from memory or from a quick
+// system call, by path below APIPrefix. Scans, streams and cleanups take
+// as long as they need and are ended by the client disconnecting.
+var routeTimeouts = map[string]time.Duration{
+	"/instance":              10 * time.Second,
+	"/health":                10 * time.Second,
+	"/version":               10 * time.Second,
+	"/openapi.json":          10 * time.Second,
+	"/login":                 10 * time.Second,
+	"/logout":                10 * time.Second,
+	"/whoami":                10 * time.Second,
+	"/usage/watchers":        10 * time.Second,
+	"/jobs":                  10 * time.Second,
+	"/jobs/cancel":           10 * time.Second,
+	"/jobs/stats":            10 * time.Second,
+	"/bookmarks/list":        10 * time.Second,
+	"/pins":                  10 * time.Second,
+	"/annotations":           10 * time.Second,
+	"/ignore":                10 * time.Second,
+	"/recent":                10 * time.Second,
+	"/recent/clear":          10 * time.Second,
+	"/sessions":              10 * time.Second,
+	"/sessions/list":         10 * time.Second,
+	"/sessions/get":          10 * time.Second,
+	"/plugins":               10 * time.Second,
+	"/rules":                 10 * time.Second,
+	"/baselines":             10 * time.Second,
+	"/logs/watches":          10 * time.Second,
+	"/audit":                 time.Minute,
+	"/capabilities":          time.Minute,
+	"/forecast":              time.Minute,
+	"/volume/history":        time.Minute,
+	"/inodes":                time.Minute,
+	"/disks/list":            time.Minute,
+	"/diskImage/info":        time.Minute,
+	"/backupExclusions/list": time.Minute,
+}
+
+// corsRoutes may be called from any origin, they only read
+var corsRoutes = map[string]bool{
+	"/usage":        true,
+	"/usage.ndjson": true,
+}
+
+// devCORSRoutes change things and may only be called from devOrigins
+var devCORSRoutes = map[string]bool{
+	"/moveToTrash": true,
+	"/refresh":     true,
+}
+
+// devOrigins are the origins of the UI on the Vite dev server
+var devOrigins = map[string]bool{
+	"http://localhost:5173": true,
+	"http://127.0.0.1:5173": true,
+}
+
+// APIError is the body of the errors the middleware responds with
+type APIError struct {
+	Error     string `json:"error"`
+	RequestID string `json:"requestId"`
+}
+
+type requestIDKey struct{}
+
+// withMiddleware is the middleware stack of the server around mux,
+// outermost first
+func withMiddleware(mux http.Handler) http.Handler {
+	return chain(mux,
+		withRequestID,
+		withRequestLog,
+		withRecovery,
+		withAPIVersion,
+		withCORS,
+		withSameOrigin,
+		withAccess,
+		trackClients,
+		withRateLimit,
+		withScanPriority,
+		withLocalPaths,
+		withRouteTimeout,
+	)
+}
+
+// chain wraps h in middleware, the first one sees requests first
+func chain(h http.Handler, middleware ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// statusWriter keeps the status of a response and whether it started, it
+// is shared by the middleware of a request
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// writesOf returns the statusWriter of w, wrapping w unless it is one
+func writesOf(w http.ResponseWriter) *statusWriter {
+	if sw, ok := w.(*statusWriter); ok {
+		return sw
+	}
+	return &statusWriter{ResponseWriter: w}
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+func (s *statusWriter) Flush() {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusWriter) started() bool {
+	return s.status != 0
+}
+
+// requestIDOf returns the id withRequestID gave the request of ctx
+func requestIDOf(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID gives each request an id, that of the client when it sent
+// a short printable one
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestLog logs each API request once it is answered, streams when
+// they end
+func withRequestLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := writesOf(w)
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			log.Printf("%s %s %d %dB %v id=%s", r.Method, redactedURI(r.URL), status, sw.written, time.Since(start).Round(time.Millisecond), requestIDOf(r.Context()))
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// redactedURI is the path and query of u without the token parameter
+func redactedURI(u *url.URL) string {
+	if !u.Query().Has("token") {
+		return u.RequestURI()
+	}
+	query := u.Query()
+	query.Set("token", "redacted")
+	return u.Path + "?" + query.Encode()
+}
+
+// withRecovery turns a panic of a handler into a 500 error, or into a
+// server_error event once an event stream has started
+func withRecovery(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := writesOf(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			id := requestIDOf(r.Context())
+			log.Printf("Panic serving %s %s id=%s: %v\nStack: %s", r.Method, r.URL.Path, id, p, debug.Stack())
+			msg := fmt.Sprintf("Internal Server Error: %v", p)
+			if !sw.started() {
+				writeAPIError(sw, r, http.StatusInternalServerError, msg)
+				return
+			}
+			// the events of a gzipped stream went through its gzip writer,
+			// which its handler closed on the way out
+			if sw.Header().Get("Content-Encoding") != "" {
+				return
+			}
+			apiErr := APIError{Error: msg, RequestID: id}
+			switch contentType := sw.Header().Get("Content-Type"); {
+			case strings.HasPrefix(contentType, "text/event-stream"):
+				data, _ := json.Marshal(apiErr)
+				fmt.Fprintf(sw, "event: server_error\ndata: %s\n\n", data)
+				sw.Flush()
+			case strings.HasPrefix(contentType, ndjsonMediaType):
+				json.NewEncoder(sw).Encode(ndjsonEvent{Event: "server_error", Data: apiErr})
+				sw.Flush()
+			}
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// writeAPIError responds with an APIError
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Encoding")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Error: msg, RequestID: requestIDOf(r.Context())})
+}
+
+// withCORS lets other origins call corsRoutes, and devOrigins call
+// devCORSRoutes, and answers their preflight
+func withCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := strings.TrimPrefix(r.URL.Path, APIPrefix)
+		origin := r.Header.Get("Origin")
+		switch {
+		case corsRoutes[route]:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case devCORSRoutes[route] && devOrigins[origin]:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+requestIDHeader)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withSameOrigin rejects requests that change things from pages of other
+// sites: a cross-site form may post to any route, with its arguments in
+// the query string. Clients other than browsers send neither header.
+func withSameOrigin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			h.ServeHTTP(w, r)
+			return
+		}
+		devRequest := devCORSRoutes[strings.TrimPrefix(r.URL.Path, APIPrefix)] && devOrigins[r.Header.Get("Origin")]
+		if !sameOrigin(r) && !devRequest {
+			http.Error(w, "Forbidden: cross-origin request", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether r comes from a page of the server itself, or
+// from no page at all
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// withRouteTimeout ends the requests of routeTimeouts that take longer
+// with a 504. The handler runs in a goroutine of its own and writes to a
+// buffer, sent once it returns in time, like http.TimeoutHandler: a
+// handler stuck in a system call that ignores its context does not hold
+// the request. What it writes after the deadline is dropped.
+func withRouteTimeout(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, ok := routeTimeouts[strings.TrimPrefix(r.URL.Path, APIPrefix)]
+		if !ok || !strings.HasPrefix(r.URL.Path, APIPrefix+"/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					if p != http.ErrAbortHandler {
+						// the stack of the handler, withRecovery sees this goroutine's
+						p = fmt.Sprintf("%v\n%s", p, debug.Stack())
+					}
+					panicked <- p
+					return
+				}
+				close(done)
+			}()
+			h.ServeHTTP(tw, r.WithContext(ctx))
+		}()
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				writeAPIError(w, r, http.StatusGatewayTimeout, fmt.Sprintf("request timed out after %v", timeout))
+			}
+		}
+	})
+}
+
+// timeoutWriter is the response of a handler run by withRouteTimeout
+type timeoutWriter struct {
+	header http.Header
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut && tw.status == 0 {
+		tw.status = status
+	}
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+// withScanPriority runs the scans of requests with priority=background at
+// background priority, e.g. those of a script that should not slow the UI
+func withScanPriority(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := r.URL.Query().Get("priority")
+		if s == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		priority, ok := scan.ParsePriority(s)
+		if !ok {
+			writeAPIError(w, r, http.StatusBadRequest, "priority must be interactive or background")
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(scan.WithPriority(r.Context(), priority)))
+	})
+}
+
+// pathParams are the query parameters naming local paths
+var pathParams = []string{"path", "under", "dir", "mountPoint"}
+
+// withLocalPaths takes Windows extended-length paths (\\?\C:\...) in the
+// query by their usual form, see scan.CleanPath, so that handlers find
+// them in the cache and compare them with other paths. The Unicode form of
+// names is kept, the cache and comparisons use scan.NormalizePath.
+func withLocalPaths(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		changed := false
+		for _, name := range pathParams {
+			values := query[name]
+			for i, v := range values {
+				if clean := scan.CleanPath(v); clean != v {
+					values[i] = clean
+					changed = true
+				}
+			}
+		}
+		if changed {
+			u := *r.URL
+			u.RawQuery = query.Encode()
+			r = r.WithContext(r.Context())
+			r.URL = &u
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/analyzer"
+	"disk-usage-analyser/server/baseline"
+	"disk-usage-analyser/server/disk"
+	"disk-usage-analyser/server/openapi"
+	"disk-usage-analyser/server/plugin"
+	"disk-usage-analyser/server/timemachine"
+)
+
+var (
+	pathParam    = openapi.Param{Name: "path", Description: "absolute path, or a remote source such as s3://bucket/prefix", Required: true}
+	dryRunParam  = openapi.Param{Name: "dryRun", Description: "only report what would be removed", Type: "boolean"}
+	unitsParams  = []openapi.Param{{Name: "units", Description: "also format sizes: si or binary"}, {Name: "locale", Description: "locale of formatted sizes, the Accept-Language by default"}}
+	profileParam = openapi.Param{Name: "profile", Description: "scan profile: quick, standard or deep"}
+	// priorityParam is read by the middleware, for any route that scans
+	priorityParam = openapi.Param{Name: "priority", Description: "interactive, the default, or background"}
+	usageParams   = append([]openapi.Param{
+		pathParam,
+		profileParam,
+		priorityParam,
+		{Name: "descendBundles", Type: "boolean"},
+		{Name: "showIgnored", Description: "list the entries of the ignore list, flagged ignored, instead of leaving them out", Type: "boolean"},
+		{Name: "estimate", Description: "count to count the entries first for a progress estimate"},
+		{Name: "prefetch", Description: "send child_detail events for finished directories", Type: "boolean"},
+		{Name: "updateInterval", Description: "least milliseconds between updates of an item", Type: "integer"},
+		{Name: "sort", Description: "size, name or mtime"},
+		{Name: "order", Description: "asc or desc"},
+		{Name: "offset", Type: "integer"},
+		{Name: "limit", Type: "integer"},
+		{Name: "metric", Description: "size or count"},
+		{Name: "minSize", Description: "collapse smaller items into one aggregated item, e.g. 10M"},
+		{Name: "encoding", Description: "delta to send only the changed fields of items"},
+	}, unitsParams...)
+	usageEvents = map[string]any{
+		"path":            map[string]string{},
+		"item":            FileInfo{},
+		"remove":          map[string]string{},
+		"other":           OtherInfo{},
+		"child_detail":    ChildDetail{},
+		"watchers":        map[string]int{},
+		"progress":        Progress{},
+		"storage_classes": StorageClasses{},
+		"server_error":    map[string]string{},
+		"summary":         UsageSummary{},
+		"done":            nil,
+	}
+	searchParams = []openapi.Param{
+		pathParam,
+		{Name: "q", Description: "pattern of the name, case insensitive"},
+		{Name: "type", Description: "file or dir"},
+		{Name: "minSize"},
+		{Name: "maxSize"},
+		{Name: "modifiedAfter", Description: "RFC 3339 time or unix seconds"},
+		{Name: "modifiedBefore"},
+		{Name: "source", Description: "cache, spotlight or auto"},
+		{Name: "limit", Type: "integer"},
+	}
+	auditParams   = []openapi.Param{{Name: "path"}, {Name: "action"}, {Name: "client"}, {Name: "user"}, {Name: "since"}, {Name: "limit", Type: "integer"}}
+	baselineParam = openapi.Param{Name: "name", Description: "name of a saved baseline"}
+)
+
+// apiOperations describes the endpoints of RegisterAPI for /api/v1/openapi.json,
+// paths are relative to APIPrefix, request and response types are those
+// the handlers encode
+var apiOperations = []openapi.Operation{
+	{Method: "GET", Path: "/instance", Summary: "Identify a running analyser", Response: InstanceInfo{}},
+	{Method: "GET", Path: "/health", Summary: "Health of the server and its background tasks", Response: HealthInfo{}},
+	{Method: "GET", Path: "/version", Summary: "Version and features of the server", Response: VersionInfo{}},
+	{Method: "GET", Path: "/openapi.json", Summary: "This document"},
+	{Method: "POST", Path: "/login", Summary: "Check a token and keep it in a cookie", Body: struct {
+		Token string `json:"token"`
+	}{}, Response: Whoami{}},
+	{Method: "POST", Path: "/logout", Summary: "Remove the login cookie"},
+	{Method: "GET", Path: "/whoami", Summary: "User and role of the request", Response: Whoami{}},
+
+	{Method: "GET", Path: "/usage", Summary: "Scan a directory, streaming its children as they are sized. With Accept: text/plain the response is a du style table.", Params: usageParams, Events: usageEvents},
+	{Method: "GET", Path: "/usage.ndjson", Summary: "The events of /usage as JSON Lines, one {\"event\", \"data\"} object per line, ending when the scan is done", Params: usageParams, ContentType: ndjsonMediaType, Events: usageEvents},
+	{Method: "GET", Path: "/usage/cached", Summary: "Children of a directory from the cache, without scanning", Params: []openapi.Param{pathParam, profileParam}, Response: UsageResponse{}},
+	{Method: "GET", Path: "/usage/by-owner", Summary: "Usage of a directory by owner", Params: []openapi.Param{pathParam}, Response: ByOwnerResponse{}},
+	{Method: "GET", Path: "/usage/by-age", Summary: "Usage of a directory by modification age", Params: []openapi.Param{pathParam}, Response: ByAgeResponse{}},
+	{Method: "GET", Path: "/usage/by-extension", Summary: "Usage of a directory by file extension", Params: []openapi.Param{pathParam}, Response: ByExtensionResponse{}},
+	{Method: "GET", Path: "/usage/by-xattr", Summary: "Usage of a directory by extended attribute", Params: []openapi.Param{pathParam}, Response: ByXattrResponse{}},
+	{Method: "GET", Path: "/usage/filtered", Summary: "Usage of the files of a directory matching name globs", Params: []openapi.Param{pathParam, {Name: "include", Required: true, Description: "comma separated name globs like *.mp4,*.mkv, in any case"}, profileParam}, Response: FilteredUsageResponse{}},
+	{Method: "GET", Path: "/usage/watchers", Summary: "Number of clients watching a directory", Params: []openapi.Param{pathParam}, Response: map[string]int{}},
+	{Method: "GET", Path: "/inodes", Summary: "Inode usage of the volume of a path", Params: []openapi.Param{pathParam}, Response: InodeUsage{}},
+	{Method: "GET", Path: "/categories", Summary: "Usage by category of the scanned directories", Response: CategoriesResponse{}},
+	{Method: "GET", Path: "/forecast", Summary: "When each volume fills up at its current growth", Response: []VolumeForecast{}},
+	{Method: "GET", Path: "/volume/history", Summary: "Free space of the volumes over time, with the sudden drops", Params: []openapi.Param{
+		{Name: "mountPoint", Description: "only this volume"},
+		{Name: "days", Description: "period, 30 by default", Type: "integer"},
+		{Name: "interval", Description: "hour, or day for the last sample of each day"},
+		{Name: "minDrop", Description: "bytes of free space lost between two samples to be a drop, 1GiB by default", Type: "integer"},
+	}, Response: []VolumeHistory{}},
+	{Method: "GET", Path: "/summary", Summary: "Volumes, their growth and the top directories of a path", Params: append([]openapi.Param{{Name: "path"}, {Name: "top", Type: "integer"}, {Name: "depth", Description: "levels of directories, 1 by default", Type: "integer"}}, unitsParams...), Response: Summary{}},
+	{Method: "GET", Path: "/search", Summary: "Search files and directories by name, size and age", Params: searchParams, Response: SearchResponse{}},
+	{Method: "GET", Path: "/files", Summary: "Stream the files below a directory", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "recursive", Type: "boolean"}, {Name: "limit", Type: "integer"}}, Events: map[string]any{
+		"files": []FileEntry{},
+		"done":  FilesDone{},
+	}},
+	{Method: "GET", Path: "/hash", Summary: "Hash files, path may be repeated", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "algo", Description: "md5, sha1, sha256 or sha512"}}, Events: map[string]any{
+		"progress": HashProgress{},
+		"result":   HashResult{},
+		"done":     HashDone{},
+	}},
+	{Method: "POST", Path: "/simulate", Summary: "Free space after removing paths", Body: SimulateRequest{}, Response: SimulateResponse{}},
+	{Method: "GET", Path: "/archive", Summary: "List the entries of an archive", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "dir", Description: "directory inside the archive"}}, Response: ArchiveListing{}},
+	{Method: "GET", Path: "/preflight", Summary: "Directories of a path the server may not read", Params: []openapi.Param{pathParam, {Name: "limit", Type: "integer"}}, Response: PreflightResponse{}},
+	{Method: "GET", Path: "/capabilities", Summary: "Permissions and features of this host", Response: Capabilities{}},
+	{Method: "POST", Path: "/capabilities/openFullDiskAccess", Summary: "Open the Full Disk Access settings on macOS", Role: string(RoleOperator)},
+	{Method: "POST", Path: "/refresh", Summary: "Drop the cached sizes of a path", Role: string(RoleOperator), Params: []openapi.Param{pathParam}},
+	{Method: "POST", Path: "/moveToTrash", Summary: "Move a path to the trash, with dryRun only preview its size, file count and newest change", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "force", Type: "boolean"}, {Name: "dryRun", Description: "only answer with what would be moved", Type: "boolean"}}, Response: TrashPreview{}},
+
+	{Method: "GET", Path: "/sessions", Summary: "Clients seen in the last hour", Role: string(RoleAdmin), Response: []ClientSession{}},
+	{Method: "POST", Path: "/sessions/disconnect", Summary: "End the requests and streams of a client", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "id", Required: true}}},
+	{Method: "POST", Path: "/sessions/revoke", Summary: "Revoke the token of a client or user", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "id"}, {Name: "user"}}},
+	{Method: "POST", Path: "/sessions/create", Summary: "Start scanning several roots together", Body: CreateSessionRequest{}, Response: Session{}},
+	{Method: "GET", Path: "/sessions/list", Summary: "Scan sessions", Response: []SessionStatus{}},
+	{Method: "GET", Path: "/sessions/get", Summary: "A scan session", Params: []openapi.Param{{Name: "id", Required: true}}, Response: Session{}},
+	{Method: "POST", Path: "/sessions/delete", Summary: "Stop and remove a scan session", Role: string(RoleOperator), Params: []openapi.Param{{Name: "id", Required: true}}},
+	{Method: "GET", Path: "/jobs", Summary: "Scans in progress", Response: []JobStatus{}},
+	{Method: "POST", Path: "/jobs/cancel", Summary: "Cancel a scan", Role: string(RoleOperator), Params: []openapi.Param{pathParam, profileParam}},
+	{Method: "GET", Path: "/jobs/stats", Summary: "File system calls of a running or recently finished scan, per depth level", Params: []openapi.Param{{Name: "id", Description: "id of the job, see /jobs and the summary event of /usage", Required: true, Type: "integer"}}, Response: scan.IOStats{}},
+	{Method: "GET", Path: "/scan/{id}/stats", Summary: "File system calls of a running or recently finished scan, per depth level", Params: []openapi.Param{{Name: "id", Description: "id of the job, see /jobs and the summary event of /usage", Required: true, Type: "integer", In: "path"}}, Response: scan.IOStats{}},
+
+	{Method: "GET", Path: "/bookmarks/list", Summary: "Bookmarked directories", Response: []Bookmark{}},
+	{Method: "POST", Path: "/bookmarks/add", Summary: "Bookmark a directory", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "name"}}},
+	{Method: "POST", Path: "/bookmarks/remove", Summary: "Remove a bookmark", Role: string(RoleOperator), Params: []openapi.Param{pathParam}},
+	{Method: "POST", Path: "/bookmarks/visit", Summary: "Record a visit of a bookmark", Role: string(RoleOperator), Params: []openapi.Param{pathParam}},
+	{Method: "POST", Path: "/bookmarks/rescan", Summary: "Rescan a bookmarked directory", Role: string(RoleOperator), Params: []openapi.Param{pathParam}},
+	{Method: "GET", Path: "/pins", Summary: "Directories kept warm in the cache", Response: []Pin{}},
+	{Method: "POST", Path: "/pins/add", Summary: "Pin a directory, keeping it scanned", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "interval", Description: "how often to rescan it, e.g. 1h, 15m by default"}}, Response: Pin{}},
+	{Method: "POST", Path: "/pins/remove", Summary: "Unpin a directory", Role: string(RoleOperator), Params: []openapi.Param{pathParam}},
+	{Method: "GET", Path: "/ignore", Summary: "Paths and name patterns left out of every scan", Response: []IgnoreRule{}},
+	{Method: "POST", Path: "/ignore/add", Summary: "Leave a path or a name pattern out of every scan", Role: string(RoleOperator), Params: []openapi.Param{{Name: "pattern", Required: true, Description: "an absolute path, or a name pattern like node_modules or *.iso"}}, Response: IgnoreRule{}},
+	{Method: "POST", Path: "/ignore/remove", Summary: "Scan a path or name pattern of the ignore list again", Role: string(RoleOperator), Params: []openapi.Param{{Name: "pattern", Required: true}}},
+	{Method: "GET", Path: "/annotations", Summary: "Notes and labels attached to paths", Params: []openapi.Param{{Name: "under", Description: "only paths below this directory"}, {Name: "label", Description: "only annotations with this label"}}, Response: []Annotation{}},
+	{Method: "POST", Path: "/annotations/set", Summary: "Attach a note and labels to a path, replacing those it had", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "note"}, {Name: "label", Description: "repeated for several labels, e.g. owner=alice"}}, Response: Annotation{}},
+	{Method: "POST", Path: "/annotations/remove", Summary: "Remove the annotation of a path", Role: string(RoleOperator), Params: []openapi.Param{pathParam}},
+	{Method: "GET", Path: "/recent", Summary: "Recently scanned directories", Response: []RecentScan{}},
+	{Method: "POST", Path: "/recent/clear", Summary: "Forget the recent scans", Role: string(RoleOperator)},
+
+	{Method: "GET", Path: "/trash/list", Summary: "Contents of the trash locations", Response: TrashResponse{}},
+	{Method: "POST", Path: "/trash/empty", Summary: "Empty the trash", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "confirm", Description: "must be true unless dryRun", Type: "boolean"}, dryRunParam}, Response: EmptyTrashResponse{}},
+	{Method: "GET", Path: "/analyzers", Summary: "Analyzers and whether they apply to a path", Params: []openapi.Param{pathParam}, Response: []AnalyzerInfo{}},
+	{Method: "GET", Path: "/analyzers/run", Summary: "Run an analyzer on a path", Params: []openapi.Param{pathParam, {Name: "name", Required: true}}, Response: analyzer.Report{}},
+	{Method: "GET", Path: "/plugins", Summary: "Installed plugins and their approval", Response: PluginsResponse{}},
+	{Method: "POST", Path: "/plugins/approve", Summary: "Approve a plugin at its current hash", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "name", Required: true}, {Name: "hash", Required: true}}},
+	{Method: "POST", Path: "/plugins/revoke", Summary: "Revoke the approval of a plugin", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "name", Required: true}}},
+	{Method: "POST", Path: "/plugins/annotate", Summary: "Annotations of the approved plugins for paths", Role: string(RoleOperator), Body: PluginRequest{}, Response: []PluginAnnotations{}},
+	{Method: "POST", Path: "/plugins/run", Summary: "Run an action of a plugin", Role: string(RoleAdmin), Body: PluginRequest{}, Response: plugin.Response{}},
+	{Method: "GET", Path: "/rules", Summary: "Cleanup rules and their last reports", Response: RulesResponse{}},
+	{Method: "POST", Path: "/rules/run", Summary: "Run a cleanup rule, a dry run unless apply is set", Role: string(RoleOperator), Params: []openapi.Param{{Name: "name", Required: true}, {Name: "apply", Type: "boolean"}}, Response: RuleReport{}},
+
+	{Method: "GET", Path: "/baselines", Summary: "Saved baselines", Response: []BaselineInfo{}},
+	{Method: "GET", Path: "/baseline/export", Summary: "Manifest of a directory tree, from the cache when scanned", Params: []openapi.Param{pathParam, {Name: "minFileSize"}}, Response: baseline.Manifest{}},
+	{Method: "POST", Path: "/baseline/export", Summary: "Rescan a directory tree for its manifest, saved as a baseline when named", Role: string(RoleOperator), Params: []openapi.Param{pathParam, {Name: "name"}, {Name: "minFileSize"}, {Name: "cached", Type: "boolean"}}, Response: baseline.Manifest{}},
+	{Method: "GET", Path: "/report", Summary: "Standalone HTML report of a directory tree, with a treemap", Params: append([]openapi.Param{pathParam, {Name: "depth", Description: "levels of directories, 6 by default", Type: "integer"}}, unitsParams...), ContentType: "text/html"},
+	{Method: "GET", Path: "/report/summary", Summary: "Markdown or PDF summary of a directory tree for a weekly review, with the growth since the previous one", Params: append([]openapi.Param{pathParam, {Name: "format", Description: "markdown or pdf, markdown by default"}, {Name: "top", Description: "directories to list, 20 by default", Type: "integer"}}, unitsParams...), ContentType: "text/markdown"},
+	{Method: "POST", Path: "/baseline/compare", Summary: "Compare a directory tree against a baseline, a posted manifest without name", Role: string(RoleOperator), Params: []openapi.Param{pathParam, baselineParam, {Name: "minGrowth"}, {Name: "cached", Type: "boolean"}}, Body: baseline.Manifest{}, Response: BaselineReport{}},
+	{Method: "GET", Path: "/audit", Summary: "Destructive actions, newest first", Role: string(RoleAdmin), Params: auditParams, Response: AuditResponse{}},
+
+	{Method: "GET", Path: "/git/info", Summary: "Size of a repository and what git gc could reclaim", Params: []openapi.Param{pathParam}, Response: GitInfo{}},
+	{Method: "POST", Path: "/git/run", Summary: "Run git gc or lfs-prune in a repository", Role: string(RoleAdmin), Params: []openapi.Param{pathParam, {Name: "action", Required: true}, {Name: "prune", Description: "now to drop all unreachable objects with gc"}}, Events: map[string]any{
+		"output": map[string]string{},
+		"done":   GitRunDone{},
+	}},
+	{Method: "POST", Path: "/rpc/v1", Summary: "JSON-RPC 2.0: scan, query, export and delete"},
+
+	{Method: "GET", Path: "/disks/list", Summary: "Disks and their partitions", Response: []disk.Info{}},
+	{Method: "POST", Path: "/disks/mount", Summary: "Mount a disk", Role: string(RoleAdmin), Body: MountRequest{}},
+	{Method: "POST", Path: "/disks/unmount", Summary: "Unmount a disk", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "deviceID", Required: true}}},
+	{Method: "POST", Path: "/disks/open", Summary: "Open a path in the file manager", Role: string(RoleOperator), Params: []openapi.Param{pathParam}},
+	{Method: "GET", Path: "/disks/fsck", Summary: "Checks running on a device, a FsckStatus without watch=true", Params: []openapi.Param{{Name: "deviceID", Required: true}, {Name: "watch", Description: "stream progress and done events", Type: "boolean"}}, Events: map[string]any{
+		"progress": FsckStatus{},
+		"done":     FsckDone{},
+	}},
+	{Method: "GET", Path: "/diskImage/info", Summary: "Size of a disk image and the space compacting could reclaim", Params: []openapi.Param{pathParam}, Response: DiskImageInfo{}},
+	{Method: "POST", Path: "/diskImage/compact", Summary: "Compact a disk image", Role: string(RoleAdmin), Params: []openapi.Param{pathParam}, Response: CompactResult{}},
+	{Method: "GET", Path: "/devCaches/list", Summary: "Caches of developer tools", Response: []DevCacheInfo{}},
+	{Method: "POST", Path: "/devCaches/clean", Summary: "Clean a developer cache", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "id", Required: true}, dryRunParam}, Response: DevCacheCleanResponse{}},
+	{Method: "GET", Path: "/browserCaches/list", Summary: "Caches of browser profiles", Response: []BrowserCacheInfo{}},
+	{Method: "POST", Path: "/browserCaches/clean", Summary: "Clean the cache of a browser profile", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "id", Required: true}, {Name: "profile"}, dryRunParam}, Response: BrowserCacheCleanResponse{}},
+	{Method: "GET", Path: "/projects", Summary: "Projects below a directory and their build artifacts", Params: []openapi.Param{pathParam, {Name: "depth", Type: "integer"}}, Response: ProjectsResponse{}},
+	{Method: "POST", Path: "/projects/clean", Summary: "Remove build artifacts of a project", Role: string(RoleAdmin), Params: []openapi.Param{pathParam, {Name: "artifact"}, {Name: "confirm", Description: "must be true to delete, a dry run otherwise", Type: "boolean"}, dryRunParam}, Response: ProjectCleanResponse{}},
+	{Method: "GET", Path: "/vms", Summary: "Virtual machines and container runtimes", Response: VMsResponse{}},
+	{Method: "GET", Path: "/stores", Summary: "Photo, mail and message stores", Response: []StoreUsage{}},
+	{Method: "GET", Path: "/logs", Summary: "Log files and the systemd journal", Params: []openapi.Param{{Name: "path"}, {Name: "minSize"}}, Response: LogsResponse{}},
+	{Method: "POST", Path: "/logs/vacuum", Summary: "Vacuum the systemd journal to a size", Role: string(RoleAdmin), Params: []openapi.Param{{Name: "size", Description: "e.g. 500M", Required: true}}, Response: VacuumResponse{}},
+	{Method: "POST", Path: "/logs/truncate", Summary: "Truncate a log file", Role: string(RoleAdmin), Params: []openapi.Param{pathParam, {Name: "confirm", Description: "the size the client showed, nothing larger is truncated", Type: "integer", Required: true}}},
+	{Method: "GET", Path: "/logs/watches", Summary: "Watched log files", Response: []LogWatch{}},
+	{Method: "POST", Path: "/logs/watch", Summary: "Notify when a log file exceeds a size", Role: string(RoleAdmin), Params: []openapi.Param{pathParam, {Name: "threshold", Required: true}}, Response: LogWatch{}},
+	{Method: "POST", Path: "/logs/unwatch", Summary: "Stop watching a log file", Role: string(RoleAdmin), Params: []openapi.Param{pathParam}},
+	{Method: "GET", Path: "/backupExclusions/list", Summary: "Time Machine exclusions", Response: []timemachine.Exclusion{}},
+	{Method: "POST", Path: "/backupExclusions/add", Summary: "Exclude a path from Time Machine", Role: string(RoleAdmin), Params: []openapi.Param{pathParam}},
+	{Method: "POST", Path: "/backupExclusions/remove", Summary: "Include a path in Time Machine again", Role: string(RoleAdmin), Params: []openapi.Param{pathParam}},
+}
+
+var openAPIDoc = struct {
+	once sync.Once
+	data []byte
+}{}
+
+// OpenAPI returns the OpenAPI document of the API
+func OpenAPI() []byte {
+	openAPIDoc.once.Do(func() {
+		doc := openapi.Build(openapi.Info{
+			Title:       AppName,
+			Version:     Version + " (API " + APIVersion + ")",
+			Description: "Once access.json has users, requests authenticate with their token as a bearer, the token query parameter or the login cookie. x-role is the least role an operation needs.",
+		}, apiOperations)
+		doc.Servers = []openapi.Server{{URL: APIPrefix}}
+		doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+			"bearer": {Type: "http", Scheme: "bearer"},
+			"cookie": {Type: "apiKey", In: "cookie", Name: tokenCookie},
+		}
+		doc.Security = []map[string][]string{{"bearer": {}}, {"cookie": {}}, {}}
+		openAPIDoc.data, _ = json.MarshalIndent(doc, "", "  ")
+	})
+	return openAPIDoc.data
+}
+
+// handleOpenAPI serves the OpenAPI document, generated from the types the
+// handlers exchange, for generating clients
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPI())
+}
@@ -0,0 +1,380 @@
+// Package openapi builds an OpenAPI 3 document from a list of operations
+// and the Go types they exchange. Schemas follow encoding/json: field names
+// come from json tags, omitempty fields are optional and embedded structs
+// are flattened, so the document changes whenever the types do.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Version is the version of the OpenAPI specification documents follow
+const Version = "3.0.3"
+
+// Operation is one endpoint
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	// Role is the least role a user needs, "" for none
+	Role   string
+	Params []Param
+	// Body is a value of the type of the JSON request body, nil for none
+	Body any
+	// Response is a value of the type of the JSON response, nil for a
+	// plain "ok". It is ignored when Events is set.
+	Response any
+	// ContentType is that of a response that is not JSON, text/plain when
+	// empty, or of a stream of Events, text/event-stream when empty. It is
+	// ignored when Response is set.
+	ContentType string
+	// Events are the events of a stream, server-sent events unless
+	// ContentType says otherwise, by event name, a nil value is an event
+	// without data
+	Events map[string]any
+}
+
+// Param is a query parameter
+type Param struct {
+	Name        string
+	Description string
+	// Type is string, integer or boolean, string when empty
+	Type     string
+	Required bool
+	// In is path for a {Name} segment of the path, query when empty
+	In string
+}
+
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Servers    []Server                        `json:"servers,omitempty"`
+	Paths      map[string]map[string]*PathItem `json:"paths"`
+	Components Components                      `json:"components"`
+	Security   []map[string][]string           `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Server is a base URL the paths are relative to
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem is an operation of a path, keyed by lower case method
+type PathItem struct {
+	Summary     string               `json:"summary,omitempty"`
+	OperationID string               `json:"operationId"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *Body                `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	Role        string               `json:"x-role,omitempty"`
+	// Events names the event types of a stream response
+	Events map[string]*Schema `json:"x-events,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Body struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Build returns the document of ops
+func Build(info Info, ops []Operation) *Document {
+	g := &generator{
+		schemas: make(map[string]*Schema),
+		names:   make(map[reflect.Type]string),
+		types:   make(map[string]reflect.Type),
+	}
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]map[string]*PathItem),
+	}
+	for _, op := range ops {
+		item := &PathItem{
+			Summary:     op.Summary,
+			OperationID: operationID(op),
+			Role:        op.Role,
+			Responses:   make(map[string]*Response),
+		}
+		for _, p := range op.Params {
+			typ := p.Type
+			if typ == "" {
+				typ = "string"
+			}
+			in := p.In
+			if in == "" {
+				in = "query"
+			}
+			item.Parameters = append(item.Parameters, Parameter{
+				Name:        p.Name,
+				In:          in,
+				Description: p.Description,
+				Required:    p.Required,
+				Schema:      &Schema{Type: typ},
+			})
+		}
+		if op.Body != nil {
+			item.RequestBody = &Body{Required: true, Content: map[string]*MediaType{
+				"application/json": {Schema: g.schema(reflect.TypeOf(op.Body))},
+			}}
+		}
+		switch {
+		case op.Events != nil:
+			item.Events = make(map[string]*Schema, len(op.Events))
+			// in order, so that colliding type names resolve the same way every time
+			names := make([]string, 0, len(op.Events))
+			for name := range op.Events {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				data := op.Events[name]
+				if data == nil {
+					item.Events[name] = &Schema{}
+					continue
+				}
+				item.Events[name] = g.schema(reflect.TypeOf(data))
+			}
+			contentType := op.ContentType
+			if contentType == "" {
+				contentType = "text/event-stream"
+			}
+			item.Responses["200"] = &Response{Description: "A stream of the events of x-events", Content: map[string]*MediaType{
+				contentType: {Schema: &Schema{Type: "string"}},
+			}}
+		case op.Response != nil:
+			item.Responses["200"] = &Response{Description: "OK", Content: map[string]*MediaType{
+				"application/json": {Schema: g.schema(reflect.TypeOf(op.Response))},
+			}}
+		default:
+			contentType := op.ContentType
+			if contentType == "" {
+				contentType = "text/plain"
+			}
+			item.Responses["200"] = &Response{Description: "OK", Content: map[string]*MediaType{
+				contentType: {Schema: &Schema{Type: "string"}},
+			}}
+		}
+		if op.Role != "" {
+			item.Responses["403"] = &Response{Description: "The user lacks the " + op.Role + " role"}
+		}
+		if doc.Paths[op.Path] == nil {
+			doc.Paths[op.Path] = make(map[string]*PathItem)
+		}
+		doc.Paths[op.Path][strings.ToLower(op.Method)] = item
+	}
+	doc.Components.Schemas = g.schemas
+	return doc
+}
+
+// operationID is e.g. getUsageByOwner for GET /usage/by-owner
+func operationID(op Operation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	upper := true
+	for _, r := range op.Path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+type generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+	types   map[string]reflect.Type
+}
+
+// schema returns the schema of t, named structs become references to
+// components
+func (g *generator) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	if t.Kind() != reflect.Pointer && t.Implements(marshalerType) {
+		// its own encoding, nothing to tell about it
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if s.Ref != "" {
+			// siblings of $ref are ignored in 3.0, it stays non-nullable
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return g.ref(t)
+	}
+	// interfaces, any value
+	return &Schema{}
+}
+
+// ref adds the named struct t to the components once
+func (g *generator) ref(t reflect.Type) *Schema {
+	name, ok := g.names[t]
+	if !ok {
+		name = t.Name()
+		if other, taken := g.types[name]; taken && other != t {
+			// e.g. analyzer.Report next to another Report
+			pkg := t.PkgPath()[strings.LastIndexByte(t.PkgPath(), '/')+1:]
+			name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+		}
+		g.names[t] = name
+		g.types[name] = t
+		// registered before the fields so that recursive types terminate
+		g.schemas[name] = &Schema{}
+		*g.schemas[name] = *g.structSchema(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	sort.Strings(s.Required)
+	return s
+}
+
+// addFields adds the exported fields of t to s, those of embedded structs
+// without a json name as if they were fields of t
+func (g *generator) addFields(s *Schema, t reflect.Type) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := g.schema(f.Type)
+		if strings.Contains(opts, "string") && fs.Type != "" && fs.Type != "object" && fs.Type != "array" {
+			fs = &Schema{Type: "string"}
+		}
+		s.Properties[name] = fs
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	// fields of t shadow those of the structs it embeds
+	for _, et := range embedded {
+		inner := &Schema{Properties: make(map[string]*Schema)}
+		g.addFields(inner, et)
+		for name, fs := range inner.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				s.Properties[name] = fs
+			}
+		}
+		for _, name := range inner.Required {
+			if !slices.Contains(s.Required, name) {
+				s.Required = append(s.Required, name)
+			}
+		}
+	}
+}
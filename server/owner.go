@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+
+	"disk-usage-analyser/server/fsstat"
+)
+
+// fileOwner returns the uid and gid of info, ok is false when unknown
+func fileOwner(info fs.FileInfo) (uid uint32, gid uint32, ok bool) {
+	if _, hasUIDs := currentUID(); !hasUIDs {
+		return 0, 0, false
+	}
+	st, ok := fsstat.Of(info)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.UID, st.GID, true
+}
+
+var nameCache = struct {
+	sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}{
+	users:  make(map[uint32]string),
+	groups: make(map[uint32]string),
+}
+
+// lookupUserName resolves uid to a user name, falling back to the numeric id
+func lookupUserName(uid uint32) string {
+	nameCache.Lock()
+	defer nameCache.Unlock()
+	if name, ok := nameCache.users[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	nameCache.users[uid] = name
+	return name
+}
+
+// lookupGroupName resolves gid to a group name, falling back to the numeric id
+func lookupGroupName(gid uint32) string {
+	nameCache.Lock()
+	defer nameCache.Unlock()
+	if name, ok := nameCache.groups[gid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	nameCache.groups[gid] = name
+	return name
+}
+
+// setOwner fills the owner and permission fields of item from info
+func setOwner(item *FileInfo, info fs.FileInfo) {
+	item.Mode = info.Mode().String()
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return
+	}
+	item.UID = uid
+	item.GID = gid
+	item.Owner = lookupUserName(uid)
+	item.Group = lookupGroupName(gid)
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+const (
+	// defaultPinInterval is how often a pinned path is rescanned
+	defaultPinInterval = 15 * time.Minute
+	// minPinInterval keeps a pin from rescanning all the time
+	minPinInterval = time.Minute
+	// pinsCheckInterval is how often pins are checked for a rescan
+	pinsCheckInterval = time.Minute
+)
+
+// Pin is a path kept warm in the cache: rescanned every Interval, and
+// scanned again right away whenever something drops it from the cache,
+// so opening it never waits for a scan. Size and Count are the result
+// of its last scan.
+type Pin struct {
+	Path string `json:"path"`
+	// Interval is how often the path is rescanned, e.g. 15m
+	Interval  string    `json:"interval"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+	Count     int64     `json:"count"`
+	ScannedAt time.Time `json:"scannedAt"`
+	Partial   bool      `json:"partial"`
+	Error     string    `json:"error,omitempty"`
+	// Scanning is set while the path is being scanned again
+	Scanning bool `json:"scanning"`
+}
+
+var pins = struct {
+	sync.Mutex
+	once  sync.Once
+	file  string
+	items []*Pin
+	// warming are the directories being scanned for pins, true when
+	// they were dropped again meanwhile and need another scan
+	warming map[string]bool
+}{
+	warming: make(map[string]bool),
+}
+
+// loadPins reads the pins file once, callers hold pins.Mutex
+func loadPins() {
+	pins.once.Do(func() {
+		pins.file = configPath("pins.json")
+		if err := loadJSON(pins.file, &pins.items); err != nil {
+			log.Printf("Error loading pins %s: %v", pins.file, err)
+		}
+	})
+}
+
+func savePins() {
+	if err := saveJSON(pins.file, pins.items); err != nil {
+		log.Printf("Error saving pins: %v", err)
+	}
+}
+
+func findPin(path string) *Pin {
+	for _, p := range pins.items {
+		if p.Path == path {
+			return p
+		}
+	}
+	return nil
+}
+
+// interval is how often p is rescanned, defaultPinInterval if unset
+func (p *Pin) interval() time.Duration {
+	d, err := time.ParseDuration(p.Interval)
+	if err != nil || d < minPinInterval {
+		return defaultPinInterval
+	}
+	return d
+}
+
+// pathWithin reports whether path is dir or below it
+func pathWithin(path, dir string) bool {
+	path, dir = scan.NormalizePath(path), scan.NormalizePath(dir)
+	if path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// StartPins scans the pinned paths, then rescans each one once its
+// interval has passed
+func StartPins() {
+	go func() {
+		for {
+			checkPins()
+			time.Sleep(pinsCheckInterval)
+		}
+	}()
+}
+
+func checkPins() {
+	pins.Lock()
+	loadPins()
+	var missing, stale []string
+	for _, p := range pins.items {
+		if _, running := pins.warming[p.Path]; running {
+			continue
+		}
+		if GlobalCache.GetEntry(p.Path) == nil {
+			// not scanned since the server started, or its scan was cancelled
+			missing = append(missing, p.Path)
+		} else if time.Since(p.ScannedAt) >= p.interval() {
+			stale = append(stale, p.Path)
+		}
+	}
+	pins.Unlock()
+
+	for _, path := range missing {
+		warmPinned(path, false)
+	}
+	for _, path := range stale {
+		warmPinned(path, true)
+	}
+}
+
+// rewarmPins scans again what dropping path from the caches took from the
+// pins: the pins below path, or path itself when it is below a pin
+func rewarmPins(path string) {
+	pins.Lock()
+	loadPins()
+	var dirs []string
+	for _, p := range pins.items {
+		if pathWithin(p.Path, path) {
+			dirs = append(dirs, p.Path)
+		} else if pathWithin(path, p.Path) {
+			dirs = append(dirs, path)
+			break
+		}
+	}
+	pins.Unlock()
+
+	for _, dir := range dirs {
+		warmPinned(dir, false)
+	}
+}
+
+// warmPinned scans dir at background priority unless a scan for the pins
+// runs already, which then scans it once more when done. A stale dir is
+// rescanned while its previous scan stays cached, see scan.Scanner.Rescan.
+func warmPinned(dir string, stale bool) {
+	pins.Lock()
+	if _, running := pins.warming[dir]; running {
+		pins.warming[dir] = true
+		pins.Unlock()
+		return
+	}
+	pins.warming[dir] = false
+	pins.Unlock()
+
+	ctx := scan.WithPriority(context.Background(), scan.Background)
+	go func() {
+		for {
+			var entry *scan.Entry
+			if stale {
+				entry = scanner.Rescan(ctx, dir, func(int64, int64) {})
+			} else {
+				entry = scanner.ScanEntry(ctx, dir, func(int64, int64) {})
+			}
+			pins.Lock()
+			if p := findPin(dir); p != nil {
+				p.Size, p.Count = entry.Usage()
+				partial, err := entry.Outcome()
+				p.Partial, p.Error, p.ScannedAt = partial, "", time.Now()
+				if err != nil {
+					p.Error = err.Error()
+				}
+				savePins()
+			}
+			again := pins.warming[dir]
+			if !again {
+				delete(pins.warming, dir)
+				pins.Unlock()
+				return
+			}
+			// dropped meanwhile, scanned into the cache again
+			pins.warming[dir] = false
+			stale = false
+			pins.Unlock()
+		}
+	}()
+}
+
+func handleListPins(w http.ResponseWriter, r *http.Request) {
+	pins.Lock()
+	loadPins()
+	items := make([]Pin, 0, len(pins.items))
+	for _, p := range pins.items {
+		item := *p
+		_, item.Scanning = pins.warming[p.Path]
+		items = append(items, item)
+	}
+	pins.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// handleAddPin pins a directory and scans it unless cached, pinning it
+// again updates the interval
+func handleAddPin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+	if info, err := os.Stat(scan.LocalPath(path)); err != nil || !info.IsDir() {
+		http.Error(w, "not a directory: "+path, http.StatusBadRequest)
+		return
+	}
+	interval := r.URL.Query().Get("interval")
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < minPinInterval {
+			http.Error(w, "Invalid interval, at least "+minPinInterval.String(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pins.Lock()
+	loadPins()
+	p := findPin(path)
+	if p == nil {
+		p = &Pin{Path: path, CreatedAt: time.Now()}
+		pins.items = append(pins.items, p)
+	}
+	p.Interval = interval
+	savePins()
+	item := *p
+	pins.Unlock()
+
+	warmPinned(path, false)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// handleRemovePin unpins a path, its results stay cached until dropped
+func handleRemovePin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := bookmarkPath(w, r)
+	if !ok {
+		return
+	}
+
+	pins.Lock()
+	defer pins.Unlock()
+	loadPins()
+	for i, p := range pins.items {
+		if p.Path == path {
+			pins.items = append(pins.items[:i], pins.items[i+1:]...)
+			savePins()
+			w.Write([]byte("ok"))
+			return
+		}
+	}
+	http.Error(w, "pin not found", http.StatusNotFound)
+}
@@ -0,0 +1,233 @@
+// Package plugin runs user provided executables that annotate paths and
+// offer actions on them, e.g. a company specific "archive to tape".
+//
+// A plugin is an executable file in the plugins directory. It is run once
+// per request with a JSON Request on stdin and must print a JSON Response
+// on stdout before its timeout. It runs with a minimal environment in a
+// temporary working directory, and its output is capped. What runs is a
+// private copy of the approved executable, so it cannot find files next to
+// it by its own path.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxOutput caps what a plugin may print
+const maxOutput = 1 << 20
+
+// Plugin is an executable found in the plugins directory
+type Plugin struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Hash is the sha256 of the executable, approvals are bound to it
+	Hash string `json:"hash"`
+}
+
+// Request is what a plugin reads on stdin
+type Request struct {
+	// Op is "annotate" to describe Paths, "run" to run Action on them
+	Op     string   `json:"op"`
+	Paths  []string `json:"paths"`
+	Action string   `json:"action,omitempty"`
+}
+
+// Response is what a plugin prints on stdout
+type Response struct {
+	Annotations []Annotation `json:"annotations,omitempty"`
+	Actions     []Action     `json:"actions,omitempty"`
+	// Message is shown after running an action
+	Message string `json:"message,omitempty"`
+	// Changed tells that the action modified Paths, they are rescanned
+	Changed bool `json:"changed,omitempty"`
+}
+
+// Annotation is a label a plugin puts on a path
+type Annotation struct {
+	Path  string `json:"path"`
+	Label string `json:"label"`
+	Note  string `json:"note,omitempty"`
+}
+
+// Action is something a plugin can do with the paths it annotated
+type Action struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	// Confirm is the question to ask before running the action, if any
+	Confirm string `json:"confirm,omitempty"`
+}
+
+// List returns the executables of dir, a missing dir has none
+func List(dir string) ([]Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var plugins []Plugin
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if !isExecutable(path) {
+			continue
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			continue
+		}
+		plugins = append(plugins, Plugin{Name: e.Name(), Path: path, Hash: hash})
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins, nil
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(path))
+		return ext == ".exe" || ext == ".bat" || ext == ".cmd"
+	}
+	return info.Mode().Perm()&0111 != 0
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Call runs p with req, killing it after timeout. The executable must
+// still have the hash it was listed with, so an approval of one version
+// never runs another. What runs is a private copy of the bytes that were
+// hashed, replacing the file after the check changes nothing.
+func Call(ctx context.Context, p Plugin, req Request, timeout time.Duration) (*Response, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	workDir, err := os.MkdirTemp("", "dua-plugin-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	exeDir, err := os.MkdirTemp("", "dua-plugin-exe-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(exeDir)
+	exe, err := copyVerified(p, exeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe)
+	cmd.Dir = workDir
+	cmd.Env = minimalEnv()
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr limitedBuffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// do not wait for children that keep the pipes open
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("plugin %s timed out after %v", p.Name, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %v: %s", p.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("plugin %s printed more than %d bytes", p.Name, maxOutput)
+	}
+	var resp Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid response: %v", p.Name, err)
+	}
+	return &resp, nil
+}
+
+// copyVerified copies the executable of p into dir, a directory only this
+// user can write, and returns the path of the copy. It fails unless the
+// bytes copied have the hash of p.
+func copyVerified(p Plugin, dir string) (string, error) {
+	src, err := os.Open(p.Path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	path := filepath.Join(dir, filepath.Base(p.Path))
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0700)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, h), src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != p.Hash {
+		return "", fmt.Errorf("plugin %s changed since it was approved", p.Name)
+	}
+	return path, nil
+}
+
+// minimalEnv passes what executables need to run, no secrets of the server
+func minimalEnv() []string {
+	var env []string
+	for _, key := range []string{"PATH", "HOME", "USER", "LANG", "TMPDIR", "SystemRoot", "USERPROFILE"} {
+		if v, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+v)
+		}
+	}
+	return env
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/server/plugin"
+)
+
+const (
+	// pluginAnnotateTimeout bounds describing a selection, it runs on every selection
+	pluginAnnotateTimeout = 10 * time.Second
+	// pluginRunTimeout bounds running an action, e.g. copying to an archive
+	pluginRunTimeout = 30 * time.Minute
+	// maxPluginPaths caps the selection passed to plugins
+	maxPluginPaths = 1000
+)
+
+// PluginApproval records that the user allowed a plugin to run. It is
+// bound to the hash of the executable, a changed plugin asks again.
+type PluginApproval struct {
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	ApprovedAt time.Time `json:"approvedAt"`
+}
+
+type PluginStatus struct {
+	plugin.Plugin
+	// Approved is false until the user allowed this version to run
+	Approved bool `json:"approved"`
+}
+
+type PluginsResponse struct {
+	Dir     string         `json:"dir"`
+	Plugins []PluginStatus `json:"plugins"`
+}
+
+type PluginRequest struct {
+	Paths []string `json:"paths"`
+	// Plugin and Action select the action to run, see /api/plugins/run
+	Plugin string `json:"plugin,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+// PluginAnnotations is what one approved plugin says about a selection
+type PluginAnnotations struct {
+	Plugin      string              `json:"plugin"`
+	Annotations []plugin.Annotation `json:"annotations"`
+	Actions     []plugin.Action     `json:"actions"`
+	Error       string              `json:"error,omitempty"`
+}
+
+var pluginApprovals = struct {
+	sync.Mutex
+	once  sync.Once
+	file  string
+	items []*PluginApproval
+}{}
+
+// loadPluginApprovals reads the approvals file once, callers hold pluginApprovals.Mutex
+func loadPluginApprovals() {
+	pluginApprovals.once.Do(func() {
+		pluginApprovals.file = configPath("plugin-approvals.json")
+		if err := loadJSON(pluginApprovals.file, &pluginApprovals.items); err != nil {
+			log.Printf("Error loading plugin approvals %s: %v", pluginApprovals.file, err)
+		}
+	})
+}
+
+func savePluginApprovals() {
+	if err := saveJSON(pluginApprovals.file, pluginApprovals.items); err != nil {
+		log.Printf("Error saving plugin approvals: %v", err)
+	}
+}
+
+func pluginApproved(p plugin.Plugin) bool {
+	pluginApprovals.Lock()
+	defer pluginApprovals.Unlock()
+	loadPluginApprovals()
+	for _, a := range pluginApprovals.items {
+		if a.Name == p.Name && a.Hash == p.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+func pluginsDir() string {
+	return configPath("plugins")
+}
+
+// findPlugin returns the plugin called name, writing an error if there is none
+func findPlugin(w http.ResponseWriter, name string) (plugin.Plugin, bool) {
+	plugins, err := plugin.List(pluginsDir())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return plugin.Plugin{}, false
+	}
+	for _, p := range plugins {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	http.Error(w, "plugin not found: "+name, http.StatusNotFound)
+	return plugin.Plugin{}, false
+}
+
+// decodePluginRequest reads the selection of a plugin request, made absolute
+func decodePluginRequest(w http.ResponseWriter, r *http.Request) (*PluginRequest, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	var req PluginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if len(req.Paths) == 0 {
+		http.Error(w, "paths is required", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(req.Paths) > maxPluginPaths {
+		http.Error(w, "too many paths", http.StatusBadRequest)
+		return nil, false
+	}
+	for i, path := range req.Paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		req.Paths[i] = absPath
+	}
+	return &req, true
+}
+
+// handleListPlugins lists the executables of the plugins directory,
+// the UI asks the user to approve each before it runs
+func handleListPlugins(w http.ResponseWriter, r *http.Request) {
+	plugins, err := plugin.List(pluginsDir())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := PluginsResponse{Dir: pluginsDir(), Plugins: []PluginStatus{}}
+	for _, p := range plugins {
+		resp.Plugins = append(resp.Plugins, PluginStatus{Plugin: p, Approved: pluginApproved(p)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleApprovePlugin allows the plugin name to run. hash must be the one
+// shown to the user, so that a plugin replaced meanwhile is not approved.
+func handleApprovePlugin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := findPlugin(w, r.URL.Query().Get("name"))
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("hash") != p.Hash {
+		http.Error(w, "plugin "+p.Name+" changed, review it again", http.StatusConflict)
+		return
+	}
+
+	pluginApprovals.Lock()
+	defer pluginApprovals.Unlock()
+	loadPluginApprovals()
+	items := pluginApprovals.items[:0]
+	for _, a := range pluginApprovals.items {
+		if a.Name != p.Name {
+			items = append(items, a)
+		}
+	}
+	pluginApprovals.items = append(items, &PluginApproval{Name: p.Name, Hash: p.Hash, ApprovedAt: time.Now()})
+	savePluginApprovals()
+	log.Printf("Approved plugin %s (%s)", p.Name, p.Hash)
+
+	w.Write([]byte("ok"))
+}
+
+func handleRevokePlugin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	pluginApprovals.Lock()
+	defer pluginApprovals.Unlock()
+	loadPluginApprovals()
+	items := pluginApprovals.items[:0]
+	for _, a := range pluginApprovals.items {
+		if a.Name != name {
+			items = append(items, a)
+		}
+	}
+	pluginApprovals.items = items
+	savePluginApprovals()
+
+	w.Write([]byte("ok"))
+}
+
+// handleAnnotatePlugins asks every approved plugin, concurrently, for
+// annotations and actions on the selected paths
+func handleAnnotatePlugins(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePluginRequest(w, r)
+	if !ok {
+		return
+	}
+	plugins, err := plugin.List(pluginsDir())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var approved []plugin.Plugin
+	for _, p := range plugins {
+		if pluginApproved(p) {
+			approved = append(approved, p)
+		}
+	}
+	results := make([]PluginAnnotations, len(approved))
+	var wg sync.WaitGroup
+	for i, p := range approved {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := PluginAnnotations{Plugin: p.Name, Annotations: []plugin.Annotation{}, Actions: []plugin.Action{}}
+			resp, err := plugin.Call(r.Context(), p, plugin.Request{Op: "annotate", Paths: req.Paths}, pluginAnnotateTimeout)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Annotations = append(result.Annotations, resp.Annotations...)
+				result.Actions = append(result.Actions, resp.Actions...)
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// handleRunPlugin runs an action of an approved plugin on the selected
+// paths. The action must be one the plugin offers for that selection.
+func handleRunPlugin(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePluginRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Action == "" {
+		http.Error(w, "action is required", http.StatusBadRequest)
+		return
+	}
+	p, ok := findPlugin(w, req.Plugin)
+	if !ok {
+		return
+	}
+	if !pluginApproved(p) {
+		http.Error(w, "plugin "+p.Name+" is not approved", http.StatusForbidden)
+		return
+	}
+
+	offered, err := plugin.Call(r.Context(), p, plugin.Request{Op: "annotate", Paths: req.Paths}, pluginAnnotateTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	found := false
+	for _, a := range offered.Actions {
+		found = found || a.ID == req.Action
+	}
+	if !found {
+		http.Error(w, "plugin "+p.Name+" does not offer "+req.Action+" for these paths", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Running plugin %s action %s on %d paths", p.Name, req.Action, len(req.Paths))
+	sizes := make([]int64, len(req.Paths))
+	for i, path := range req.Paths {
+		sizes[i] = auditSize(path)
+	}
+	// a client going away must not kill an action half way, it ends by
+	// its timeout only
+	resp, err := plugin.Call(context.WithoutCancel(r.Context()), p, plugin.Request{Op: "run", Paths: req.Paths, Action: req.Action}, pluginRunTimeout)
+	for i, path := range req.Paths {
+		audit(auditClientOf(r), req.Action, path, sizes[i], "plugin "+p.Name, err)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if resp.Changed {
+		for _, path := range req.Paths {
+			invalidateCaches(path)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"sort"
+
+	"disk-usage-analyser/scan"
+)
+
+// maxChildDetailItems caps the items of one child_detail event
+const maxChildDetailItems = 200
+
+// ChildDetail is the content of a finished subdirectory, sent with
+// prefetch=true so that opening it renders at once
+type ChildDetail struct {
+	Name  string     `json:"name"`
+	Items []FileInfo `json:"items"`
+	// More is the number of smaller items left out
+	More int `json:"more,omitempty"`
+}
+
+// childDetail lists the cached content of path, known once its scan is done
+func childDetail(s *scan.Scanner, path string, name string) (ChildDetail, bool) {
+	items, done := cachedItems(s, path)
+	if !done {
+		return ChildDetail{}, false
+	}
+	detail := ChildDetail{Name: name, Items: items}
+	sort.Slice(detail.Items, func(i, j int) bool {
+		return detail.Items[i].Size > detail.Items[j].Size
+	})
+	if len(detail.Items) > maxChildDetailItems {
+		detail.More = len(detail.Items) - maxChildDetailItems
+		detail.Items = detail.Items[:maxChildDetailItems]
+	}
+	return detail, true
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPreflightLimit = 1000
+	preflightTimeout      = 3 * time.Second
+	// maxPreflightDenied caps how many denied paths are reported
+	maxPreflightDenied = 50
+)
+
+type PreflightResponse struct {
+	Path string `json:"path"`
+	// Sampled is the number of directories read, breadth first
+	Sampled int `json:"sampled"`
+	Denied  int `json:"denied"`
+	// DeniedPaths are the first denied directories, shallowest first
+	DeniedPaths []string `json:"deniedPaths"`
+	// DeniedFraction is Denied / Sampled
+	DeniedFraction float64 `json:"deniedFraction"`
+	// VolumeUsed is the used space of the volume containing path, 0 when unknown
+	VolumeUsed int64 `json:"volumeUsed"`
+	// EstimatedInvisible is a rough estimate, DeniedFraction of VolumeUsed
+	EstimatedInvisible int64 `json:"estimatedInvisible"`
+	// NeedsFullDiskAccess is set when macOS privacy protection denied a directory
+	NeedsFullDiskAccess bool `json:"needsFullDiskAccess"`
+	// NeedsPrivileged is set when unix permissions denied a directory
+	// and the privileged helper is not running
+	NeedsPrivileged bool `json:"needsPrivileged"`
+	Complete        bool `json:"complete"` // the whole tree was sampled
+}
+
+// handlePreflight samples the tree under path for directories that
+// cannot be read, before a long scan is started
+func handlePreflight(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultPreflightLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := PreflightResponse{Path: path, DeniedPaths: []string{}}
+	deadline := time.Now().Add(preflightTimeout)
+	queue := []string{path}
+	for len(queue) > 0 && resp.Sampled < limit && time.Now().Before(deadline) {
+		if r.Context().Err() != nil {
+			return
+		}
+		dir := queue[0]
+		queue = queue[1:]
+		resp.Sampled++
+
+		entries, err := readDir(dir)
+		if err != nil {
+			if !errors.Is(err, fs.ErrPermission) {
+				continue
+			}
+			resp.Denied++
+			if len(resp.DeniedPaths) < maxPreflightDenied {
+				resp.DeniedPaths = append(resp.DeniedPaths, dir)
+			}
+			if isTCCDenied(err) {
+				resp.NeedsFullDiskAccess = true
+			} else if PrivilegedClient == nil {
+				resp.NeedsPrivileged = true
+			}
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				queue = append(queue, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	resp.Complete = len(queue) == 0
+
+	if resp.Sampled > 0 {
+		resp.DeniedFraction = float64(resp.Denied) / float64(resp.Sampled)
+	}
+	if total, free, err := volumeSpace(path); err == nil {
+		resp.VolumeUsed = int64(total - free)
+		resp.EstimatedInvisible = int64(resp.DeniedFraction * float64(resp.VolumeUsed))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
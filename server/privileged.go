@@ -0,0 +1,38 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/privileged"
+)
+
+// PrivilegedClient, when set, is used to read directories
+// that the server itself has no permission to read
+var PrivilegedClient *privileged.Client
+
+// localFS is the file system of the server scanners, read through readDir
+type localFS struct{}
+
+func (localFS) ReadDir(dir string) ([]fs.DirEntry, error) { return readDir(dir) }
+
+func (localFS) Lstat(path string) (fs.FileInfo, error) { return os.Lstat(scan.LocalPath(path)) }
+
+// readDir reads dirPath, from the MFT snapshot of its volume with UseMFT
+// or through io_uring once enabled, falling back to the privileged
+// helper on permission errors
+func readDir(dirPath string) ([]fs.DirEntry, error) {
+	if entries, ok := mftReadDir(dirPath); ok {
+		return entries, nil
+	}
+	if entries, ok := uringReadDir(dirPath); ok {
+		return entries, nil
+	}
+	entries, err := os.ReadDir(scan.LocalPath(dirPath))
+	if err != nil && PrivilegedClient != nil && errors.Is(err, fs.ErrPermission) {
+		return PrivilegedClient.ReadDir(dirPath)
+	}
+	return entries, err
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package privileged
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerUID cannot tell who is connected, every connection is refused
+func peerUID(conn net.Conn) (int, error) {
+	return -1, fmt.Errorf("peer credentials are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,378 @@
+package privileged
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/server/disk"
+	"disk-usage-analyser/server/fsstat"
+)
+
+// Request is sent by the server to the helper, one JSON object per line
+type Request struct {
+	Op   string `json:"op"` // "readDir", "stat", "mount"
+	Path string `json:"path"`
+	// Mount is how to mount a disk on Path
+	Mount *disk.MountOptions `json:"mount,omitempty"`
+}
+
+// Response is sent by the helper back to the server, one JSON object per line
+type Response struct {
+	Entries []Entry `json:"entries,omitempty"`
+	Entry   *Entry  `json:"entry,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Entry is a file as seen by the helper
+type Entry struct {
+	Name    string       `json:"name"`
+	Size    int64        `json:"size"`
+	Mode    fs.FileMode  `json:"mode"`
+	ModTime time.Time    `json:"modTime"`
+	Stat    *fsstat.Stat `json:"stat,omitempty"`
+}
+
+// Serve runs the helper: it listens on socketPath and answers
+// ReadDir/Stat and mount requests until the listener fails or its stdin,
+// a pipe from the server that started it, is closed.
+// It is expected to run as root, so the socket is created private, in a
+// directory only the invoking user (SUDO_UID) can enter, and connections
+// of any other user are refused.
+func Serve(socketPath string) error {
+	uid, err := clientUID()
+	if err != nil {
+		return err
+	}
+	if err := checkSocketDir(filepath.Dir(socketPath), uid); err != nil {
+		return err
+	}
+	os.Remove(socketPath)
+	// no other user may connect before the socket is chmod-ed
+	old := umask(0o077)
+	ln, err := net.Listen("unix", socketPath)
+	umask(old)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", socketPath, err)
+	}
+	defer ln.Close()
+
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		return fmt.Errorf("failed to chmod socket: %v", err)
+	}
+	if err := os.Chown(socketPath, uid, -1); err != nil {
+		return fmt.Errorf("failed to chown socket: %v", err)
+	}
+
+	// the helper ends with the server, even if it was killed
+	stopped := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		close(stopped)
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-stopped:
+				return nil
+			default:
+				return err
+			}
+		}
+		if peer, err := peerUID(conn); err != nil || peer != uid {
+			log.Printf("Refused helper connection of uid %d: %v", peer, err)
+			conn.Close()
+			continue
+		}
+		go handleConn(conn)
+	}
+}
+
+// clientHome is the home directory of the user the helper serves, not the
+// one of root
+func clientHome() string {
+	uid, err := clientUID()
+	if err != nil {
+		return ""
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return ""
+	}
+	if home, err := filepath.EvalSymlinks(u.HomeDir); err == nil {
+		return home
+	}
+	return u.HomeDir
+}
+
+// clientUID is the user the helper serves: the one who ran sudo, or the
+// user it runs as without sudo
+func clientUID() (int, error) {
+	uidStr := os.Getenv("SUDO_UID")
+	if uidStr == "" {
+		return os.Getuid(), nil
+	}
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SUDO_UID %s: %v", uidStr, err)
+	}
+	return uid, nil
+}
+
+func handleConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			resp = handleRequest(req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			log.Printf("Error writing helper response: %v", err)
+			return
+		}
+	}
+}
+
+func handleRequest(req Request) Response {
+	if !filepath.IsAbs(req.Path) {
+		return Response{Error: "path must be absolute"}
+	}
+	switch req.Op {
+	case "readDir":
+		dirEntries, err := os.ReadDir(req.Path)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		entries := make([]Entry, 0, len(dirEntries))
+		for _, e := range dirEntries {
+			info, err := e.Info()
+			if err != nil {
+				entries = append(entries, Entry{Name: e.Name(), Mode: e.Type()})
+				continue
+			}
+			entries = append(entries, toEntry(info))
+		}
+		return Response{Entries: entries}
+	case "stat":
+		info, err := os.Lstat(req.Path)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		entry := toEntry(info)
+		return Response{Entry: &entry}
+	case "mount":
+		if req.Mount == nil || req.Mount.MountPoint != req.Path {
+			return Response{Error: "mount options must be for path"}
+		}
+		// the server checked all of it too, but its requests are not
+		// trusted. The mount goes where the mount point resolves.
+		opts := *req.Mount
+		if err := opts.Validate(); err != nil {
+			return Response{Error: err.Error()}
+		}
+		mountPoint, err := disk.ResolveMountPoint(opts.MountPoint)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		if err := disk.CheckMountPoint(mountPoint, clientHome()); err != nil {
+			return Response{Error: err.Error()}
+		}
+		opts.MountPoint = mountPoint
+		info, err := disk.GetDiskInfo(opts.Device)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		if !info.External() {
+			return Response{Error: "only external or removable disks can be mounted: " + opts.Device}
+		}
+		args, err := opts.Args()
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		out, err := exec.Command("mount", args...).CombinedOutput()
+		if err != nil {
+			return Response{Error: fmt.Sprintf("%v\nOutput: %s", err, out)}
+		}
+		return Response{}
+	default:
+		return Response{Error: fmt.Sprintf("unknown op: %s", req.Op)}
+	}
+}
+
+func toEntry(info fs.FileInfo) Entry {
+	entry := Entry{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+	if st, ok := fsstat.Of(info); ok {
+		entry.Stat = &st
+	}
+	return entry
+}
+
+// Client talks to a running helper
+type Client struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	scanner *bufio.Scanner
+	enc     *json.Encoder
+	// cleanup stops a launched helper
+	cleanup func()
+}
+
+func Dial(socketPath string) (*Client, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to privileged helper: %v", err)
+	}
+	scanner := bufio.NewScanner(conn)
+	// directory listings can be large
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	return &Client{
+		conn:    conn,
+		scanner: scanner,
+		enc:     json.NewEncoder(conn),
+	}, nil
+}
+
+// Launch starts the helper via sudo and connects to it, on a socket in a
+// fresh directory private to the user. sudo may prompt for the password
+// on the terminal. The helper exits once the stdin pipe it gets is closed,
+// by Close or when this process ends.
+func Launch() (*Client, *exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to locate executable: %v", err)
+	}
+	dir, err := os.MkdirTemp("", "disk-usage-analyser-helper-")
+	if err != nil {
+		return nil, nil, err
+	}
+	socketPath := filepath.Join(dir, "helper.sock")
+	cmd := exec.Command("sudo", exe, "helper", "--socket", socketPath)
+	// sudo asks for the password on the terminal, not on stdin
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("failed to start privileged helper: %v", err)
+	}
+	cleanup := func() {
+		stdin.Close()
+		os.RemoveAll(dir)
+	}
+
+	// Wait for socket to be ready, sudo may be waiting for a password
+	for i := 0; i < 120; i++ {
+		client, err := Dial(socketPath)
+		if err == nil {
+			client.cleanup = cleanup
+			return client, cmd, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	cleanup()
+	cmd.Process.Kill()
+	return nil, nil, fmt.Errorf("privileged helper failed to start within timeout")
+}
+
+// Close disconnects, a launched helper exits
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	if c.cleanup != nil {
+		c.cleanup()
+	}
+	return err
+}
+
+func (c *Client) call(req Request) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("privileged helper closed connection")
+	}
+	var resp Response
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("invalid helper response: %v", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// ReadDir lists dirPath through the helper
+func (c *Client) ReadDir(dirPath string) ([]fs.DirEntry, error) {
+	resp, err := c.call(Request{Op: "readDir", Path: dirPath})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, 0, len(resp.Entries))
+	for _, e := range resp.Entries {
+		entries = append(entries, fs.FileInfoToDirEntry(&fileInfo{e}))
+	}
+	return entries, nil
+}
+
+// Stat stats path through the helper, without following symlinks
+func (c *Client) Stat(path string) (fs.FileInfo, error) {
+	resp, err := c.call(Request{Op: "stat", Path: path})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Entry == nil {
+		return nil, fmt.Errorf("empty helper response")
+	}
+	return &fileInfo{*resp.Entry}, nil
+}
+
+// Mount runs mount(8) with opts as root, without the password sudo would need
+func (c *Client) Mount(opts disk.MountOptions) error {
+	_, err := c.call(Request{Op: "mount", Path: opts.MountPoint, Mount: &opts})
+	return err
+}
+
+// fileInfo adapts Entry to fs.FileInfo
+type fileInfo struct {
+	e Entry
+}
+
+func (f *fileInfo) Name() string       { return f.e.Name }
+func (f *fileInfo) Size() int64        { return f.e.Size }
+func (f *fileInfo) Mode() fs.FileMode  { return f.e.Mode }
+func (f *fileInfo) ModTime() time.Time { return f.e.ModTime }
+func (f *fileInfo) IsDir() bool        { return f.e.Mode.IsDir() }
+func (f *fileInfo) Sys() any           { return f.e.Stat }
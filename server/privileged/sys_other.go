@@ -0,0 +1,16 @@
+//go:build !unix
+
+package privileged
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func umask(mask int) int {
+	return 0
+}
+
+func checkSocketDir(dir string, uid int) error {
+	return fmt.Errorf("the privileged helper is not supported on %s", runtime.GOOS)
+}
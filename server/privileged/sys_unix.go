@@ -0,0 +1,27 @@
+//go:build unix
+
+package privileged
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
+
+// checkSocketDir makes sure dir is a directory of uid that no one else can
+// enter, so the socket path cannot be taken over or raced by another user
+func checkSocketDir(dir string, uid int) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || info.Mode().Perm() != 0o700 || !ok || int(st.Uid) != uid {
+		return fmt.Errorf("socket directory %s must be a directory of uid %d with mode 0700", dir, uid)
+	}
+	return nil
+}
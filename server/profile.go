@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/analyzer"
+	"disk-usage-analyser/server/fsstat"
+)
+
+// Profile selects how much a scan collects besides directory sizes.
+// Each profile has its own cache since their results differ.
+type Profile string
+
+const (
+	// ProfileQuick only computes sizes and skips hidden and system paths
+	ProfileQuick Profile = "quick"
+	// ProfileStandard also collects owners, ages and allocated sizes
+	ProfileStandard Profile = "standard"
+	// ProfileDeep also counts hard linked files once, breaks sizes down
+	// by extension and counts extended attributes
+	ProfileDeep Profile = "deep"
+)
+
+// DefaultProfile is used when a request does not name one
+var DefaultProfile = ProfileStandard
+
+// systemPaths are skipped by the quick profile
+var systemPaths = map[string]bool{
+	"/System":              true,
+	"/private/var/vm":      true,
+	"/private/var/db":      true,
+	"/Library/Caches":      true,
+	"/System/Volumes/Data": true,
+	"/proc":                true,
+	"/sys":                 true,
+	"/dev":                 true,
+	"/run":                 true,
+	"/snap":                true,
+}
+
+var (
+	quickScanner = scan.New(nil, scan.Options{
+		FS:      localFS{},
+		OnError: onScanError,
+		Skip: func(dir string, e fs.DirEntry) bool {
+			return skipQuick(dir, e) || skipIgnored(dir, e)
+		},
+	})
+	deepScanner = scan.New(nil, scan.Options{
+		FS:      localFS{},
+		OnError: onScanError,
+		NewStats: func() scan.Stats {
+			return &SubtreeStats{collectExtensions: true, collectXattrs: true}
+		},
+		CountFile: hardLinks.countOnce,
+		Skip:      skipIgnored,
+	})
+)
+
+func skipQuick(dir string, e fs.DirEntry) bool {
+	return strings.HasPrefix(e.Name(), ".") || systemPaths[filepath.Join(dir, e.Name())]
+}
+
+// ParseProfile validates a profile name, empty means DefaultProfile
+func ParseProfile(name string) (Profile, error) {
+	switch p := Profile(name); p {
+	case "":
+		return DefaultProfile, nil
+	case ProfileQuick, ProfileStandard, ProfileDeep:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid profile: %s", name)
+	}
+}
+
+func scannerFor(p Profile) *scan.Scanner {
+	switch p {
+	case ProfileQuick:
+		return quickScanner
+	case ProfileDeep:
+		return deepScanner
+	}
+	return scanner
+}
+
+// invalidateCaches drops path and its subdirectories from the caches of all profiles
+func invalidateCaches(path string) {
+	if invalidateSource(path) {
+		return
+	}
+	for _, ps := range profileScanners {
+		ps.scanner.Cache().Invalidate(path)
+	}
+	hardLinks.forget(path)
+	invalidateMFT(path)
+	rewarmPins(path)
+}
+
+// invalidateAllCaches drops everything the profiles cached of local
+// paths, pins are scanned again by their next check
+func invalidateAllCaches() {
+	for _, ps := range profileScanners {
+		ps.scanner.Cache().Clear()
+	}
+	hardLinks.clear()
+}
+
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+// hardLinks remembers which path claimed each hard linked file, so that
+// a file is counted once, and again by the same path when rescanned. The
+// claims of an invalidated subtree are dropped with it, so that another
+// link counts the file once the claiming one was deleted or moved.
+var hardLinks = &hardLinkSet{paths: make(map[inodeKey]string)}
+
+type hardLinkSet struct {
+	mu    sync.Mutex
+	paths map[inodeKey]string
+}
+
+func (h *hardLinkSet) countOnce(path string, info fs.FileInfo) bool {
+	st, ok := fsstat.Of(info)
+	if !ok || st.Nlink <= 1 || st.Ino == 0 {
+		return true
+	}
+	key := inodeKey{dev: st.Dev, ino: st.Ino}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if claimed, ok := h.paths[key]; ok {
+		return claimed == path
+	}
+	h.paths[key] = path
+	return true
+}
+
+// forget drops the claims of the files at or below path
+func (h *hardLinkSet) forget(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, claimed := range h.paths {
+		if analyzer.Under(claimed, path) {
+			delete(h.paths, key)
+		}
+	}
+}
+
+func (h *hardLinkSet) clear() {
+	h.mu.Lock()
+	clear(h.paths)
+	h.mu.Unlock()
+}
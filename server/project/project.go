@@ -0,0 +1,126 @@
+// Package project finds software projects by their manifest files and
+// tells their dependency and build output directories apart from sources.
+package project
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// kinds maps manifest files to the kind of project they mark
+var kinds = map[string]string{
+	"go.mod":           "go",
+	"package.json":     "node",
+	"Cargo.toml":       "rust",
+	"pyproject.toml":   "python",
+	"requirements.txt": "python",
+	"setup.py":         "python",
+	"pom.xml":          "java",
+	"build.gradle":     "java",
+	"build.gradle.kts": "java",
+	"Gemfile":          "ruby",
+	"composer.json":    "php",
+}
+
+// artifactDirs are the regenerable directories of each kind: dependencies and build output.
+// vendor and bin are left out, they are often committed or hold tools.
+var artifactDirs = map[string][]string{
+	"node":   {"node_modules", "dist", "build", ".next", ".nuxt", ".turbo", "coverage"},
+	"rust":   {"target"},
+	"python": {".venv", "venv", "__pycache__", ".tox", ".pytest_cache", ".mypy_cache", "build", "dist"},
+	"java":   {"target", "build", ".gradle"},
+	"ruby":   {".bundle"},
+}
+
+// Project is a directory holding at least one manifest
+type Project struct {
+	Path  string   `json:"path"`
+	Name  string   `json:"name"`
+	Kinds []string `json:"kinds"` // sorted, e.g. ["go", "node"]
+	// Artifacts are the names of the existing artifact directories in Path
+	Artifacts []string `json:"artifacts"`
+}
+
+// Detect returns the project at dir given its entries, ok is false if dir is none
+func Detect(dir string, entries []fs.DirEntry) (Project, bool) {
+	names := make(map[string]bool, len(entries))
+	found := make(map[string]bool)
+	for _, e := range entries {
+		names[e.Name()] = e.IsDir()
+		if kind, ok := kinds[e.Name()]; ok && !e.IsDir() {
+			found[kind] = true
+		}
+	}
+	if len(found) == 0 {
+		return Project{}, false
+	}
+	p := Project{Path: dir, Name: filepath.Base(dir), Kinds: []string{}, Artifacts: []string{}}
+	seen := make(map[string]bool)
+	for kind := range found {
+		p.Kinds = append(p.Kinds, kind)
+		for _, a := range artifactDirs[kind] {
+			if names[a] && !seen[a] {
+				seen[a] = true
+				p.Artifacts = append(p.Artifacts, a)
+			}
+		}
+	}
+	sort.Strings(p.Kinds)
+	sort.Strings(p.Artifacts)
+	return p, true
+}
+
+// IsArtifact reports whether name is one of the project's artifact directories
+func (p *Project) IsArtifact(name string) bool {
+	for _, a := range p.Artifacts {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Find walks root breadth first up to maxDepth levels and returns the
+// projects found. It does not descend into projects, nested packages
+// of a monorepo count towards the outermost project, nor into hidden directories.
+func Find(ctx context.Context, root string, maxDepth int, readDir func(string) ([]fs.DirEntry, error)) []Project {
+	type dir struct {
+		path  string
+		depth int
+	}
+	var projects []Project
+	queue := []dir{{root, 0}}
+	for len(queue) > 0 && ctx.Err() == nil {
+		d := queue[0]
+		queue = queue[1:]
+		entries, err := readDir(d.path)
+		if err != nil {
+			continue
+		}
+		if p, ok := Detect(d.path, entries); ok {
+			projects = append(projects, p)
+			continue
+		}
+		if d.depth >= maxDepth {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") && e.Name() != "node_modules" {
+				queue = append(queue, dir{filepath.Join(d.path, e.Name()), d.depth + 1})
+			}
+		}
+	}
+	return projects
+}
+
+// Get detects the project at dir
+func Get(dir string, readDir func(string) ([]fs.DirEntry, error)) (Project, bool) {
+	entries, err := readDir(dir)
+	if err != nil {
+		return Project{}, false
+	}
+	return Detect(dir, entries)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/project"
+)
+
+// defaultProjectDepth is how many levels below the root projects are looked for
+const defaultProjectDepth = 4
+
+type ArtifactUsage struct {
+	Name string `json:"name"` // e.g. node_modules
+	Size int64  `json:"size"`
+}
+
+type ProjectUsage struct {
+	project.Project
+	Size int64 `json:"size"`
+	// ArtifactsSize is the dependencies and build output, SourceSize the rest
+	ArtifactsSize int64           `json:"artifactsSize"`
+	SourceSize    int64           `json:"sourceSize"`
+	ArtifactSizes []ArtifactUsage `json:"artifactSizes"` // largest first
+}
+
+type ProjectsResponse struct {
+	Path          string         `json:"path"`
+	Projects      []ProjectUsage `json:"projects"` // largest first
+	Size          int64          `json:"size"`
+	ArtifactsSize int64          `json:"artifactsSize"`
+}
+
+type ProjectCleanResponse struct {
+	DryRun bool            `json:"dryRun"`
+	Path   string          `json:"path"`
+	Size   int64           `json:"size"` // bytes expected to be freed
+	Delete []ArtifactUsage `json:"delete"`
+}
+
+func projectUsage(r *http.Request, p project.Project) ProjectUsage {
+	u := ProjectUsage{Project: p, ArtifactSizes: []ArtifactUsage{}}
+	u.Size, _ = getDirSizeWithCache(r.Context(), p.Path, func(int64, int64) {})
+	for _, a := range p.Artifacts {
+		// scanned along with the project
+		size := cachedSize(filepath.Join(p.Path, a))
+		u.ArtifactSizes = append(u.ArtifactSizes, ArtifactUsage{Name: a, Size: size})
+		u.ArtifactsSize += size
+	}
+	u.SourceSize = u.Size - u.ArtifactsSize
+	sort.Slice(u.ArtifactSizes, func(i, j int) bool {
+		return u.ArtifactSizes[i].Size > u.ArtifactSizes[j].Size
+	})
+	return u
+}
+
+// handleProjects finds the projects below path and rolls their usage
+// up into sources and artifacts (dependencies and build output)
+func handleProjects(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	depth := defaultProjectDepth
+	if s := r.URL.Query().Get("depth"); s != "" {
+		depth, err = strconv.Atoi(s)
+		if err != nil || depth < 0 {
+			http.Error(w, "Invalid depth", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := ProjectsResponse{Path: path, Projects: []ProjectUsage{}}
+	for _, p := range project.Find(r.Context(), path, depth, readDir) {
+		u := projectUsage(r, p)
+		if r.Context().Err() != nil {
+			return
+		}
+		resp.Projects = append(resp.Projects, u)
+		resp.Size += u.Size
+		resp.ArtifactsSize += u.ArtifactsSize
+	}
+	sort.Slice(resp.Projects, func(i, j int) bool {
+		return resp.Projects[i].Size > resp.Projects[j].Size
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCleanProject deletes artifact directories of a project,
+// artifact names one of them, without it all are deleted. Without
+// confirm=true it is a dry run.
+func handleCleanProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, ok := project.Get(path, readDir)
+	if !ok {
+		http.Error(w, "not a project: "+path, http.StatusBadRequest)
+		return
+	}
+	artifacts := p.Artifacts
+	if name := r.URL.Query().Get("artifact"); name != "" {
+		if !p.IsArtifact(name) {
+			http.Error(w, "not an artifact directory of "+path+": "+name, http.StatusBadRequest)
+			return
+		}
+		artifacts = []string{name}
+	}
+	// nothing is deleted without confirm=true
+	dryRun := r.URL.Query().Get("dryRun") == "true" || r.URL.Query().Get("confirm") != "true"
+
+	resp := ProjectCleanResponse{DryRun: dryRun, Path: path, Delete: []ArtifactUsage{}}
+	for _, a := range artifacts {
+		size, _ := getDirSizeWithCache(r.Context(), filepath.Join(path, a), func(int64, int64) {})
+		resp.Delete = append(resp.Delete, ArtifactUsage{Name: a, Size: size})
+		resp.Size += size
+	}
+	if !dryRun {
+		for i, a := range artifacts {
+			dir := filepath.Join(path, a)
+			log.Printf("Deleting project artifacts: %s", dir)
+			err := os.RemoveAll(scan.LocalPath(dir))
+			audit(auditClientOf(r), "delete", dir, resp.Delete[i].Size, "project clean", err)
+			invalidateCaches(dir)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		// the project keeps its old size until it is scanned again
+		invalidateCaches(path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
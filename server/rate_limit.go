@@ -0,0 +1,139 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit bounds how often and how many at once a client may call a route
+type rateLimit struct {
+	// perSecond requests are allowed on average, burst of them at once
+	perSecond float64
+	burst     int
+	// concurrent is the number of requests a client may have in flight
+	concurrent int
+}
+
+var (
+	scanLimit   = rateLimit{perSecond: 2, burst: 20, concurrent: 16}
+	exportLimit = rateLimit{perSecond: 0.2, burst: 5, concurrent: 2}
+)
+
+// rateLimits are the routes that scan or read whole trees, by path below
+// APIPrefix. The UI opens a usage stream for each directory visited, the
+// limits only stop a script calling in a loop.
+var rateLimits = map[string]rateLimit{
+	"/usage":              scanLimit,
+	"/usage.ndjson":       scanLimit,
+	"/usage/by-owner":     scanLimit,
+	"/usage/by-age":       scanLimit,
+	"/usage/by-extension": scanLimit,
+	"/usage/by-xattr":     scanLimit,
+	"/usage/filtered":     scanLimit,
+	"/summary":            scanLimit,
+	"/categories":         scanLimit,
+	"/search":             scanLimit,
+	"/files":              scanLimit,
+	"/preflight":          scanLimit,
+	"/projects":           scanLimit,
+	"/analyzers/run":      scanLimit,
+	"/sessions/create":    scanLimit,
+	"/rpc/" + RPCVersion:  scanLimit,
+	"/hash":               exportLimit,
+	"/baseline/export":    exportLimit,
+	"/baseline/compare":   exportLimit,
+	"/report":             exportLimit,
+	"/report/summary":     exportLimit,
+}
+
+// rateBucket is the state of one client on one route
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+	active int
+}
+
+var rateBuckets = struct {
+	sync.Mutex
+	byKey map[string]*rateBucket
+}{
+	byKey: make(map[string]*rateBucket),
+}
+
+// rateClient is who a limit applies to: the user of access.json, else the
+// address, so that one user in several browsers shares its limit
+func rateClient(r *http.Request) string {
+	if u := userOf(r.Context()); u != nil && !u.anonymous() {
+		return "user " + u.Name
+	}
+	return auditClientOf(r).Addr
+}
+
+// takeRate takes a request of key from its bucket, it returns how long to
+// wait when there is none left
+func takeRate(key string, limit rateLimit, now time.Time) (bool, time.Duration) {
+	rateBuckets.Lock()
+	defer rateBuckets.Unlock()
+	b := rateBuckets.byKey[key]
+	if b == nil {
+		if len(rateBuckets.byKey) > 1000 {
+			pruneRateBuckets(now)
+		}
+		b = &rateBucket{tokens: float64(limit.burst), last: now}
+		rateBuckets.byKey[key] = b
+	}
+	b.tokens = math.Min(float64(limit.burst), b.tokens+now.Sub(b.last).Seconds()*limit.perSecond)
+	b.last = now
+	if b.active >= limit.concurrent {
+		return false, time.Second
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.perSecond * float64(time.Second))
+	}
+	b.tokens--
+	b.active++
+	return true, 0
+}
+
+func releaseRate(key string) {
+	rateBuckets.Lock()
+	defer rateBuckets.Unlock()
+	if b := rateBuckets.byKey[key]; b != nil {
+		b.active--
+	}
+}
+
+// pruneRateBuckets forgets the clients idle for long enough to have a full
+// bucket again, callers hold rateBuckets.Mutex
+func pruneRateBuckets(now time.Time) {
+	for key, b := range rateBuckets.byKey {
+		if b.active == 0 && now.Sub(b.last) > time.Minute {
+			delete(rateBuckets.byKey, key)
+		}
+	}
+}
+
+// withRateLimit answers 429 with a Retry-After to clients calling the
+// routes of rateLimits too often or too many times at once
+func withRateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := rateLimits[strings.TrimPrefix(r.URL.Path, APIPrefix)]
+		if !ok || !strings.HasPrefix(r.URL.Path, APIPrefix+"/") || r.Method == http.MethodOptions {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key := rateClient(r) + "\n" + r.URL.Path
+		allowed, wait := takeRate(key, limit, time.Now())
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeAPIError(w, r, http.StatusTooManyRequests, "too many requests to "+r.URL.Path+", retry later")
+			return
+		}
+		defer releaseRate(key)
+		h.ServeHTTP(w, r)
+	})
+}
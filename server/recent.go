@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRecentScans caps the number of remembered scans
+const maxRecentScans = 50
+
+// RecentScan is a finished listing of a directory
+type RecentScan struct {
+	Path      string    `json:"path"`
+	ScannedAt time.Time `json:"scannedAt"`
+	Size      int64     `json:"size"`
+	Entries   int64     `json:"entries"`
+}
+
+var recentScans = struct {
+	sync.Mutex
+	once  sync.Once
+	file  string
+	items []RecentScan // most recent first, one per path
+}{}
+
+// loadRecentScans reads the history file once, callers hold recentScans.Mutex
+func loadRecentScans() {
+	recentScans.once.Do(func() {
+		recentScans.file = configPath("recent.json")
+		if err := loadJSON(recentScans.file, &recentScans.items); err != nil {
+			log.Printf("Error loading recent scans %s: %v", recentScans.file, err)
+		}
+	})
+}
+
+// recordRecentScan moves path to the front of the history
+func recordRecentScan(scan RecentScan) {
+	recentScans.Lock()
+	defer recentScans.Unlock()
+	loadRecentScans()
+
+	items := make([]RecentScan, 0, len(recentScans.items)+1)
+	items = append(items, scan)
+	for _, item := range recentScans.items {
+		if item.Path != scan.Path && len(items) < maxRecentScans {
+			items = append(items, item)
+		}
+	}
+	recentScans.items = items
+	if err := saveJSON(recentScans.file, recentScans.items); err != nil {
+		log.Printf("Error saving recent scans: %v", err)
+	}
+}
+
+func handleRecent(w http.ResponseWriter, r *http.Request) {
+	recentScans.Lock()
+	loadRecentScans()
+	items := append([]RecentScan{}, recentScans.items...)
+	recentScans.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func handleClearRecent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recentScans.Lock()
+	defer recentScans.Unlock()
+	loadRecentScans()
+	recentScans.items = nil
+	if err := saveJSON(recentScans.file, []RecentScan{}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok"))
+}
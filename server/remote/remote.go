@@ -0,0 +1,369 @@
+// Package remote presents a directory tree of another machine to the
+// scanner over SSH. It needs nothing installed there but a POSIX shell and
+// ls: a few "sh -s" sessions are kept open through the ssh client of this
+// machine, so ~/.ssh/config, keys and the agent apply as usual, and each
+// directory is read with one "ls -lnAq" sent to a session. -q keeps a name
+// holding a newline on one line, the names it changed are read again.
+package remote
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"disk-usage-analyser/server/vfs"
+)
+
+// Scheme prefixes the paths that name a remote directory, e.g. ssh://nas/volume1
+const Scheme = "ssh://"
+
+// sessions is the number of ssh sessions reading directories concurrently
+const sessions = 4
+
+// endMarker starts the line ending the output of one command. ls -q and od
+// never print a control character.
+const endMarker = "\x01"
+
+// namesCommand prints the names in the current directory that ls -q may
+// have changed, those holding a ? or a byte that is not printable ASCII,
+// NUL separated and hex encoded by od so that no name can break the lines
+// of the protocol
+const namesCommand = `for f in * .[!.]* ..?*; do [ -e "$f" ] || [ -L "$f" ] || continue; case $f in *[![:print:]]*|*'?'*) printf '%s\000' "$f";; esac; done | od -An -v -tx1`
+
+// Target is a host reachable with ssh
+type Target struct {
+	// Host is host or user@host, as passed to ssh
+	Host string
+	Port string
+}
+
+// ParseURL parses ssh://[user@]host[:port]/dir into the host and the
+// directory, which defaults to /
+func ParseURL(rawURL string) (Target, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return Target{}, "", fmt.Errorf("invalid ssh URL: %s", rawURL)
+	}
+	t := Target{Host: u.Hostname(), Port: u.Port()}
+	if u.User != nil {
+		t.Host = u.User.Username() + "@" + t.Host
+	}
+	dir := u.Path
+	if dir == "" {
+		dir = "/"
+	}
+	return t, dir, nil
+}
+
+// URL formats the root of t, ssh://host[:port]
+func (t Target) URL() string {
+	host := t.Host
+	if t.Port != "" {
+		host += ":" + t.Port
+	}
+	return Scheme + host
+}
+
+// FS reads the directories of a host, paths are absolute on the host
+type FS struct {
+	target   Target
+	next     atomic.Uint64
+	sessions [sessions]session
+}
+
+func NewFS(t Target) *FS {
+	f := &FS{target: t}
+	for i := range f.sessions {
+		f.sessions[i].target = t
+	}
+	return f
+}
+
+// Close stops the ssh sessions of f, a later ReadDir starts them again
+func (f *FS) Close() error {
+	for i := range f.sessions {
+		f.sessions[i].close()
+	}
+	return nil
+}
+
+func (f *FS) ReadDir(dir string) ([]fs.DirEntry, error) {
+	dir = "/" + vfs.Rel(dir)
+	s := &f.sessions[f.next.Add(1)%sessions]
+	quoted := shellQuote(strings.TrimSuffix(dir, "/") + "/")
+	lines, status, err := s.run("LC_ALL=C ls -lnAq -- " + quoted + " 2>/dev/null")
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: err}
+	}
+	// 1 is a minor problem such as an entry vanishing, 2 a directory that cannot be read
+	if status > 1 || status == 1 && len(lines) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrPermission}
+	}
+	now := time.Now()
+	listed := make([]lsEntry, 0, len(lines))
+	var mangled bool
+	for _, line := range lines {
+		if e, ok := parseLs(line, now); ok {
+			listed = append(listed, e)
+			mangled = mangled || strings.Contains(e.name, "?")
+		}
+	}
+	var real map[string][]string
+	if mangled {
+		out, _, err := s.run("(LC_ALL=C; export LC_ALL; cd -- " + quoted + " 2>/dev/null && " + namesCommand + ")")
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: dir, Err: err}
+		}
+		real = realNames(out)
+	}
+	entries := make([]fs.DirEntry, 0, len(listed))
+	for _, e := range listed {
+		if strings.Contains(e.name, "?") {
+			// the name of another entry with the same ? is taken in turn,
+			// one that vanished since ls is left out
+			names := real[e.name]
+			if len(names) == 0 {
+				continue
+			}
+			e.name, real[e.name] = names[0], names[1:]
+		}
+		if e.isDir {
+			entries = append(entries, vfs.Dir(e.name, e.modTime))
+		} else {
+			entries = append(entries, vfs.File(e.name, e.size, e.modTime, nil))
+		}
+	}
+	return entries, nil
+}
+
+// realNames decodes the output of namesCommand into the names by the
+// form ls -q prints them in
+func realNames(lines []string) map[string][]string {
+	var raw []byte
+	for _, line := range lines {
+		for _, field := range strings.Fields(line) {
+			if b, err := hex.DecodeString(field); err == nil {
+				raw = append(raw, b...)
+			}
+		}
+	}
+	names := make(map[string][]string)
+	for _, name := range strings.Split(string(raw), "\x00") {
+		if name != "" {
+			names[lsQuote(name)] = append(names[lsQuote(name)], name)
+		}
+	}
+	return names
+}
+
+// lsQuote is name as ls -q prints it in the C locale, every byte that is
+// not printable ASCII replaced by ?
+func lsQuote(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c < 0x20 || c > 0x7e {
+			b[i] = '?'
+		}
+	}
+	return string(b)
+}
+
+// Lstat describes every path as a directory, the scanner only asks for
+// directories and ls already gave their times to the listing
+func (f *FS) Lstat(p string) (fs.FileInfo, error) {
+	return vfs.Dir(path.Base("/"+vfs.Rel(p)), time.Time{}).Info()
+}
+
+// lsEntry is an entry of a listing, name as ls -q printed it
+type lsEntry struct {
+	name    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// parseLs parses a line of "ls -ln" in the C locale:
+// mode links uid gid size month day time-or-year name, where devices
+// print "major, minor" as two fields instead of a size
+func parseLs(line string, now time.Time) (lsEntry, bool) {
+	rest := line
+	n := 8
+	fields := make([]string, 0, 9)
+	for len(fields) < n {
+		rest = strings.TrimLeft(rest, " ")
+		i := strings.IndexByte(rest, ' ')
+		if i < 0 {
+			return lsEntry{}, false
+		}
+		fields = append(fields, rest[:i])
+		rest = rest[i+1:]
+		if len(fields) == 5 && (fields[0][0] == 'c' || fields[0][0] == 'b') && strings.HasSuffix(fields[4], ",") {
+			n = 9
+		}
+	}
+	// the name starts after exactly one space, it may begin with spaces
+	name := rest
+	mode := fields[0]
+	if len(mode) < 10 || name == "" || name == "." || name == ".." {
+		return lsEntry{}, false
+	}
+	if mode[0] == 'l' {
+		name, _, _ = strings.Cut(name, " -> ")
+	}
+	modTime := parseLsTime(fields[n-3], fields[n-2], fields[n-1], now)
+	if mode[0] == 'd' {
+		return lsEntry{name: name, isDir: true, modTime: modTime}, true
+	}
+	if n == 9 {
+		return lsEntry{name: name, modTime: modTime}, true
+	}
+	size, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		size = 0
+	}
+	return lsEntry{name: name, size: size, modTime: modTime}, true
+}
+
+// parseLsTime parses "Jan 2 15:04", a time of the last half year, or
+// "Jan 2 2006" for older ones
+func parseLsTime(month, day, clock string, now time.Time) time.Time {
+	if strings.Contains(clock, ":") {
+		t, err := time.ParseInLocation("Jan 2 2006 15:04", month+" "+day+" "+strconv.Itoa(now.Year())+" "+clock, time.Local)
+		if err != nil {
+			return time.Time{}
+		}
+		if t.After(now.AddDate(0, 0, 1)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t
+	}
+	t, _ := time.ParseInLocation("Jan 2 2006", month+" "+day+" "+clock, time.Local)
+	return t
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// session is one "ssh host sh -s", started on first use and again after it died
+type session struct {
+	target Target
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *limitedBuffer
+}
+
+// run sends command to the shell and returns the lines it printed and its exit status
+func (s *session) run(command string) ([]string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if _, err := io.WriteString(s.stdin, command+"; printf '\\001%s\\n' \"$?\"\n"); err != nil {
+		return nil, 0, s.fail()
+	}
+	var lines []string
+	for {
+		line, err := s.stdout.ReadString('\n')
+		if err != nil {
+			return nil, 0, s.fail()
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if status, ok := strings.CutPrefix(line, endMarker); ok {
+			code, _ := strconv.Atoi(status)
+			return lines, code, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (s *session) start() error {
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10", "-o", "ServerAliveInterval=30"}
+	if s.target.Port != "" {
+		args = append(args, "-p", s.target.Port)
+	}
+	args = append(args, "--", s.target.Host, "sh", "-s")
+	cmd := exec.Command("ssh", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	s.stderr = &limitedBuffer{}
+	cmd.Stderr = s.stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ssh: %v", err)
+	}
+	s.cmd, s.stdin, s.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// fail stops a broken session and returns why it broke, e.g. what ssh
+// said about authentication
+func (s *session) fail() error {
+	s.stop()
+	msg := strings.TrimSpace(s.stderr.String())
+	if msg == "" {
+		msg = "connection closed"
+	}
+	return fmt.Errorf("ssh %s: %s", s.target.Host, msg)
+}
+
+// close stops the session if it runs
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd != nil {
+		s.stop()
+	}
+}
+
+// stop kills ssh, callers hold s.mu
+func (s *session) stop() {
+	s.stdin.Close()
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	s.cmd = nil
+}
+
+// limitedBuffer keeps the last 4 KiB written to it, enough for an ssh error
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > 4096 {
+		b.buf = b.buf[len(b.buf)-4096:]
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,59 @@
+package remote
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLs(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		line string
+		ok   bool
+		want lsEntry
+	}{
+		{"crw-rw-rw- 1 0 0 1, 3 Oct 14 09:00 null", true,
+			lsEntry{name: "null", modTime: time.Date(2026, time.October, 14, 9, 0, 0, 0, time.Local)}},
+		{"brw-rw----. 1 0 6 259,   0 Oct  1 08:00 nvme0n1", true,
+			lsEntry{name: "nvme0n1", modTime: time.Date(2026, time.October, 1, 8, 0, 0, 0, time.Local)}},
+		{"lrwxrwxrwx 1 0 0 15 Jan  5  2024 stdin -> /proc/self/fd/0", true,
+			lsEntry{name: "stdin", size: 15, modTime: time.Date(2024, time.January, 5, 0, 0, 0, 0, time.Local)}},
+		{"-rw-r--r-- 1 1000 1000 42 Oct 13 23:59   spaced", true,
+			lsEntry{name: "  spaced", size: 42, modTime: time.Date(2026, time.October, 13, 23, 59, 0, 0, time.Local)}},
+		{"-rw-r--r-- 1 1000 1000 7 Dec 20 10:00 last year", true,
+			lsEntry{name: "last year", size: 7, modTime: time.Date(2025, time.December, 20, 10, 0, 0, 0, time.Local)}},
+		{"drwxr-xr-x 2 0 0 4096 Mar  3  2025 dir", true,
+			lsEntry{name: "dir", isDir: true, modTime: time.Date(2025, time.March, 3, 0, 0, 0, 0, time.Local)}},
+		{"total 8", false, lsEntry{}},
+		{"drwxr-xr-x 2 0 0 4096 Mar  3  2025 ..", false, lsEntry{}},
+	}
+	for _, tt := range tests {
+		got, ok := parseLs(tt.line, now)
+		if ok != tt.ok {
+			t.Errorf("parseLs(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if got.name != tt.want.name || got.isDir != tt.want.isDir || got.size != tt.want.size || !got.modTime.Equal(tt.want.modTime) {
+			t.Errorf("parseLs(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseLsTime(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		month, day, clock string
+		want              time.Time
+	}{
+		{"Oct", "14", "09:00", time.Date(2026, time.October, 14, 9, 0, 0, 0, time.Local)},
+		{"Oct", "15", "01:00", time.Date(2026, time.October, 15, 1, 0, 0, 0, time.Local)},
+		{"Nov", "2", "10:30", time.Date(2025, time.November, 2, 10, 30, 0, 0, time.Local)},
+		{"Feb", "29", "2024", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.Local)},
+		{"Foo", "1", "2024", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := parseLsTime(tt.month, tt.day, tt.clock, now); !got.Equal(tt.want) {
+			t.Errorf("parseLsTime(%q, %q, %q) = %v, want %v", tt.month, tt.day, tt.clock, got, tt.want)
+		}
+	}
+}
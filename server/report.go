@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/report"
+)
+
+const (
+	// defaultReportDepth is how many levels of directories a report has
+	defaultReportDepth = 6
+	// maxReportDepth keeps a report small enough to mail
+	maxReportDepth = 12
+	// maxReportChildren caps the items of a directory in a report, the
+	// rest and those below a thousandth of the root are summed up
+	maxReportChildren = 50
+	// reportTopItems is the number of rows of the tables of a report
+	reportTopItems = 50
+)
+
+// reportFileName keeps the characters of a path that are safe in a file name
+var reportFileName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ReportOptions are the options of WriteReport
+type ReportOptions struct {
+	// Depth is the levels of directories, defaultReportDepth when 0
+	Depth int
+	// Format formats the sizes, in the units of the OS when nil
+	Format *SizeFormat
+}
+
+// WriteReport scans path unless cached and writes it as a standalone
+// HTML report
+func WriteReport(ctx context.Context, w io.Writer, path string, opts ReportOptions) error {
+	rep, err := buildReport(ctx, path, opts)
+	if err != nil {
+		return err
+	}
+	return report.Render(w, rep)
+}
+
+func buildReport(ctx context.Context, path string, opts ReportOptions) (*report.Report, error) {
+	s, root, src, err := resolvePath(ctx, path, DefaultProfile)
+	if err != nil {
+		return nil, err
+	}
+	entry := s.ScanEntry(ctx, root, func(int64, int64) {})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	partial, err := entry.Outcome()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = defaultReportDepth
+	}
+	format := opts.Format
+	if format == nil {
+		units, _ := ParseSizeUnits("")
+		format = &SizeFormat{Binary: units == "binary", Decimal: ".", Group: ","}
+	}
+
+	rep := &report.Report{
+		Root:        displayPath(src, root),
+		GeneratedAt: time.Now(),
+		Partial:     partial,
+		Binary:      format.Binary,
+		FormatSize:  format.Format,
+	}
+	if src == nil {
+		rep.Host, _ = os.Hostname()
+	}
+	rep.Size, rep.Count = entry.Usage()
+	rep.Tree = reportNode(s.Cache(), entry, rep.Root, opts.Depth, rep.Size/1000)
+
+	for _, child := range s.Cache().Children(root) {
+		size, _ := child.Usage()
+		rep.Dirs = append(rep.Dirs, report.Item{Path: child.Name(), Size: size})
+	}
+	rep.Dirs = sortReportItems(rep.Dirs)
+
+	for _, e := range s.Cache().Under(root) {
+		rel, err := filepath.Rel(root, e.Path())
+		if err != nil {
+			continue
+		}
+		e.Lock()
+		for _, f := range e.Files {
+			rep.Files = append(rep.Files, report.Item{Path: filepath.ToSlash(filepath.Join(rel, f.Name)), Size: f.Size, ModTime: f.ModTime})
+		}
+		e.Unlock()
+		// keep the largest only, a tree may have millions of files
+		if len(rep.Files) > 4*reportTopItems {
+			rep.Files = sortReportItems(rep.Files)
+		}
+	}
+	rep.Files = sortReportItems(rep.Files)
+	return rep, nil
+}
+
+// sortReportItems sorts items largest first and cuts them to reportTopItems
+func sortReportItems(items []report.Item) []report.Item {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Size > items[j].Size
+	})
+	return items[:min(len(items), reportTopItems)]
+}
+
+// reportNode is the treemap node of e with depth levels of directories
+// below it. Its files and subdirectories from minSize are listed, up to
+// maxReportChildren, the others are summed up in Other.
+func reportNode(cache *scan.Cache, e *scan.Entry, name string, depth int, minSize int64) *report.Node {
+	node := &report.Node{Name: name}
+	node.Size, node.Count = e.Usage()
+	if depth == 0 {
+		return node
+	}
+
+	type child struct {
+		entry *scan.Entry
+		node  *report.Node
+	}
+	var children []child
+	for _, sub := range cache.Children(e.Path()) {
+		size, count := sub.Usage()
+		children = append(children, child{entry: sub, node: &report.Node{Name: sub.Name(), Size: size, Count: count}})
+	}
+	e.Lock()
+	for _, f := range e.Files {
+		children = append(children, child{node: &report.Node{Name: f.Name, Size: f.Size, File: true}})
+	}
+	e.Unlock()
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].node.Size > children[j].node.Size
+	})
+
+	for i, c := range children {
+		if i >= maxReportChildren || c.node.Size < minSize || c.node.Size == 0 {
+			node.Other += c.node.Size
+			continue
+		}
+		if c.entry != nil {
+			c.node = reportNode(cache, c.entry, c.node.Name, depth-1, minSize)
+		}
+		node.Children = append(node.Children, c.node)
+	}
+	return node
+}
+
+// handleReport scans path unless cached and responds with a standalone
+// HTML report of it, to be saved and shared
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	opts := ReportOptions{Depth: defaultReportDepth}
+	if v := query.Get("depth"); v != "" {
+		depth, err := strconv.Atoi(v)
+		if err != nil || depth < 1 || depth > maxReportDepth {
+			http.Error(w, fmt.Sprintf("depth must be between 1 and %d", maxReportDepth), http.StatusBadRequest)
+			return
+		}
+		opts.Depth = depth
+	}
+	format, err := parseSizeFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Format = format
+
+	rep, err := buildReport(r.Context(), path, opts)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		http.Error(w, "Failed to scan: "+err.Error(), scanErrorStatus(err))
+		return
+	}
+	name := strings.Trim(reportFileName.ReplaceAllString(filepath.Base(rep.Root), "_"), "_")
+	if name == "" {
+		name = "root"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="disk-usage-%s-%s.html"`, name, rep.GeneratedAt.Format("20060102")))
+	report.Render(w, rep)
+}
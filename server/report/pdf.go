@@ -0,0 +1,165 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// pdfFont is one of the standard fonts every PDF reader has, so that
+// nothing needs embedding
+type pdfFont int
+
+const (
+	pdfRegular pdfFont = iota
+	pdfBold
+	pdfMono
+)
+
+var pdfFontNames = [...]string{"Helvetica", "Helvetica-Bold", "Courier"}
+
+// A4 in points, with the margin on every side
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+	// pdfLeading is the line height relative to the font size
+	pdfLeading = 1.35
+)
+
+type pdfLine struct {
+	font pdfFont
+	size float64
+	text string
+}
+
+// pdfDoc lays lines of text out top to bottom, starting a page when one
+// is full
+type pdfDoc struct {
+	lines []pdfLine
+}
+
+func (d *pdfDoc) add(font pdfFont, size float64, text string) {
+	d.lines = append(d.lines, pdfLine{font: font, size: size, text: text})
+}
+
+// space adds an empty line of half the usual height
+func (d *pdfDoc) space() {
+	d.lines = append(d.lines, pdfLine{size: 5})
+}
+
+// wrap adds text broken into lines at spaces. Helvetica has no fixed
+// width, half the font size per character is a safe average.
+func (d *pdfDoc) wrap(font pdfFont, size float64, text string) {
+	maxChars := int((pdfPageWidth - 2*pdfMargin) / (size * 0.5))
+	var line []string
+	length := 0
+	for _, word := range strings.Fields(text) {
+		n := len([]rune(word))
+		if length > 0 && length+1+n > maxChars {
+			d.add(font, size, strings.Join(line, " "))
+			line, length = nil, 0
+		}
+		if length > 0 {
+			length++
+		}
+		line = append(line, word)
+		length += n
+	}
+	if len(line) > 0 {
+		d.add(font, size, strings.Join(line, " "))
+	}
+}
+
+// pdfMonoColumns is how many Courier characters of size fit on a line,
+// each is 0.6 of the size wide
+func pdfMonoColumns(size float64) int {
+	return int((pdfPageWidth - 2*pdfMargin) / (size * 0.6))
+}
+
+// pages splits the lines into the content streams of the pages
+func (d *pdfDoc) pages() []string {
+	var pages []string
+	var page strings.Builder
+	y := float64(pdfPageHeight - pdfMargin)
+	for _, l := range d.lines {
+		height := l.size * pdfLeading
+		if y-height < pdfMargin && page.Len() > 0 {
+			pages = append(pages, page.String())
+			page.Reset()
+			y = pdfPageHeight - pdfMargin
+		}
+		y -= height
+		if l.text != "" {
+			fmt.Fprintf(&page, "BT /F%d %g Tf %d %.2f Td (%s) Tj ET\n", int(l.font)+1, l.size, pdfMargin, y, pdfString(l.text))
+		}
+	}
+	return append(pages, page.String())
+}
+
+// write writes the document: the catalog, the page tree and the fonts,
+// then a page and its content stream for each page, and the offsets of
+// all objects at the end
+func (d *pdfDoc) write(w io.Writer) error {
+	pages := d.pages()
+	bw := bufio.NewWriter(w)
+	var offsets []int
+	written := 0
+	out := func(format string, args ...any) {
+		n, _ := fmt.Fprintf(bw, format, args...)
+		written += n
+	}
+	object := func(body string) {
+		offsets = append(offsets, written)
+		out("%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	const fontsFirst = 3
+	pagesFirst := fontsFirst + len(pdfFontNames)
+	out("%%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pagesFirst+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	var fonts []string
+	for i, name := range pdfFontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fonts = append(fonts, fmt.Sprintf("/F%d %d 0 R", i+1, fontsFirst+i))
+	}
+	for i, content := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, strings.Join(fonts, " "), pagesFirst+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := written
+	out("xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		out("%010d 00000 n \n", offset)
+	}
+	out("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return bw.Flush()
+}
+
+// pdfString escapes s for a string literal in WinAnsiEncoding, which
+// matches Latin-1 from 160 on; other characters become ?
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
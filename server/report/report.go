@@ -0,0 +1,76 @@
+// Package report renders a finished scan as a single HTML file, with the
+// tree embedded as JSON and a small script drawing it as a treemap, so
+// that it can be mailed or attached to a ticket and opened anywhere
+// without the server.
+package report
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+//go:embed report.html
+var reportHTML string
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(n, total int64) string {
+		if total <= 0 {
+			return "0%"
+		}
+		return formatPercent(float64(n) * 100 / float64(total))
+	},
+}).Parse(reportHTML))
+
+// Report is what a report shows
+type Report struct {
+	Root        string    `json:"root"`
+	Host        string    `json:"host,omitempty"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Size        int64     `json:"size"`
+	Count       int64     `json:"count"`
+	// Partial is set when part of the tree could not be read
+	Partial bool `json:"partial"`
+	// Binary formats sizes in powers of 1024 in the treemap
+	Binary bool  `json:"binary"`
+	Tree   *Node `json:"tree"`
+	// Dirs are the subdirectories of the root and Files the largest files
+	// of the tree, largest first, also listed without the script
+	Dirs  []Item `json:"-"`
+	Files []Item `json:"-"`
+	// FormatSize formats the sizes of the tables
+	FormatSize func(int64) string `json:"-"`
+}
+
+// Node is a directory or file of the treemap. The smallest children of a
+// directory are summed up in Other.
+type Node struct {
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Count    int64   `json:"count,omitempty"`
+	File     bool    `json:"file,omitempty"`
+	Other    int64   `json:"other,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Item is a row of the tables, Path is relative to the root
+type Item struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Render writes r as an HTML document
+func Render(w io.Writer, r *Report) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// formatPercent is p with one decimal, e.g. 12.5%
+func formatPercent(p float64) string {
+	if p < 0.1 {
+		return "<0.1%"
+	}
+	return strconv.FormatFloat(p, 'f', 1, 64) + "%"
+}
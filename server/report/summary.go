@@ -0,0 +1,265 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Summary is the short report of a directory tree for a weekly review:
+// the volumes, the largest directories and their growth since the
+// previous summary, and what could be cleaned up
+type Summary struct {
+	Path        string    `json:"path"`
+	Host        string    `json:"host,omitempty"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Size        int64     `json:"size"`
+	Count       int64     `json:"count"`
+	Partial     bool      `json:"partial"`
+	// PreviousAt is when the summary growth is measured from was made,
+	// nil for the first summary of Path
+	PreviousAt *time.Time      `json:"previousAt,omitempty"`
+	Growth     *int64          `json:"growth,omitempty"`
+	Volumes    []SummaryVolume `json:"volumes"`
+	// Top are the largest directories directly below Path
+	Top             []SummaryDir `json:"top"`
+	Recommendations []string     `json:"recommendations"`
+	// FormatSize formats the sizes
+	FormatSize func(int64) string `json:"-"`
+}
+
+type SummaryVolume struct {
+	MountPoint  string `json:"mountPoint"`
+	Size        int64  `json:"size"`
+	Used        int64  `json:"used"`
+	Available   int64  `json:"available"`
+	UsedPercent int    `json:"usedPercent"`
+	// Growth7d is the change of the used space over the last week, nil
+	// until the space history is that old
+	Growth7d *int64 `json:"growth7d,omitempty"`
+	// FullAt is when the volume runs full at the rate of the last week
+	FullAt *time.Time `json:"fullAt,omitempty"`
+}
+
+type SummaryDir struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Growth is since the previous summary, nil when the directory is new
+	Growth *int64 `json:"growth,omitempty"`
+}
+
+// section is a part of a summary, both formats render the same sections
+type section struct {
+	title      string
+	paragraphs []string
+	bullets    []string
+	table      *table
+}
+
+type table struct {
+	header []string
+	// right aligns the columns of numbers
+	right []bool
+	rows  [][]string
+}
+
+const dateFormat = "2006-01-02"
+
+func (s *Summary) title() string {
+	return "Disk usage summary of " + s.Path
+}
+
+func (s *Summary) sections() []section {
+	format := s.FormatSize
+	signed := func(n int64) string {
+		if n < 0 {
+			return "-" + format(-n)
+		}
+		return "+" + format(n)
+	}
+
+	overview := fmt.Sprintf("%s in %d entries", format(s.Size), s.Count)
+	if s.Host != "" {
+		overview += " on " + s.Host
+	}
+	overview += ", generated " + s.GeneratedAt.Format("2006-01-02 15:04 MST") + "."
+	paragraphs := []string{overview}
+	if s.Growth != nil {
+		paragraphs = append(paragraphs, fmt.Sprintf("%s since the previous summary of %s.", signed(*s.Growth), s.PreviousAt.Format(dateFormat)))
+	}
+	if s.Partial {
+		paragraphs = append(paragraphs, "Some directories could not be read, their sizes are lower bounds.")
+	}
+	sections := []section{{paragraphs: paragraphs}}
+
+	if len(s.Volumes) > 0 {
+		t := &table{
+			header: []string{"Volume", "Size", "Used", "Free", "Used %", "7 days", "Full by"},
+			right:  []bool{false, true, true, true, true, true, true},
+		}
+		for _, v := range s.Volumes {
+			growth, fullAt := "", ""
+			if v.Growth7d != nil {
+				growth = signed(*v.Growth7d)
+			}
+			if v.FullAt != nil {
+				fullAt = v.FullAt.Format(dateFormat)
+			}
+			t.rows = append(t.rows, []string{v.MountPoint, format(v.Size), format(v.Used), format(v.Available), fmt.Sprintf("%d%%", v.UsedPercent), growth, fullAt})
+		}
+		sections = append(sections, section{title: "Volumes", table: t})
+	}
+
+	top := section{title: fmt.Sprintf("Top %d directories", len(s.Top))}
+	if len(s.Top) == 0 {
+		top.paragraphs = []string{"No subdirectories."}
+	} else {
+		t := &table{header: []string{"Directory", "Size", "Share"}, right: []bool{false, true, true}}
+		if s.PreviousAt != nil {
+			t.header = append(t.header, "Since "+s.PreviousAt.Format(dateFormat))
+			t.right = append(t.right, true)
+		}
+		for _, d := range s.Top {
+			share := "0%"
+			if s.Size > 0 {
+				share = formatPercent(float64(d.Size) * 100 / float64(s.Size))
+			}
+			row := []string{d.Name, format(d.Size), share}
+			if s.PreviousAt != nil {
+				growth := "new"
+				if d.Growth != nil {
+					growth = signed(*d.Growth)
+				}
+				row = append(row, growth)
+			}
+			t.rows = append(t.rows, row)
+		}
+		top.table = t
+	}
+	sections = append(sections, top)
+
+	recommendations := section{title: "Recommendations", bullets: s.Recommendations}
+	if len(s.Recommendations) == 0 {
+		recommendations.paragraphs = []string{"Nothing to clean up."}
+	}
+	return append(sections, recommendations)
+}
+
+// Markdown writes the summary as Markdown, to paste into a review
+func (s *Summary) Markdown(w io.Writer) error {
+	blocks := []string{"# " + markdownEscape(s.title())}
+	for _, sec := range s.sections() {
+		if sec.title != "" {
+			blocks = append(blocks, "## "+sec.title)
+		}
+		for _, p := range sec.paragraphs {
+			blocks = append(blocks, markdownEscape(p))
+		}
+		if len(sec.bullets) > 0 {
+			bullets := make([]string, len(sec.bullets))
+			for i, bullet := range sec.bullets {
+				bullets[i] = "- " + markdownEscape(bullet)
+			}
+			blocks = append(blocks, strings.Join(bullets, "\n"))
+		}
+		if t := sec.table; t != nil {
+			var b strings.Builder
+			b.WriteString("| " + strings.Join(t.header, " | ") + " |\n|")
+			for _, right := range t.right {
+				if right {
+					b.WriteString("---:|")
+				} else {
+					b.WriteString("---|")
+				}
+			}
+			for _, row := range t.rows {
+				cells := make([]string, len(row))
+				for i, cell := range row {
+					cells[i] = strings.ReplaceAll(markdownEscape(cell), "|", `\|`)
+				}
+				b.WriteString("\n| " + strings.Join(cells, " | ") + " |")
+			}
+			blocks = append(blocks, b.String())
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(blocks, "\n\n")+"\n")
+	return err
+}
+
+// markdownEscaper keeps the characters of paths, e.g. _ and *, from
+// being read as emphasis
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`, "[", `\[`)
+
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// PDF writes the summary as a PDF document of A4 pages
+func (s *Summary) PDF(w io.Writer) error {
+	doc := &pdfDoc{}
+	doc.add(pdfBold, 16, s.title())
+	doc.space()
+	for _, sec := range s.sections() {
+		if sec.title != "" {
+			doc.space()
+			doc.add(pdfBold, 12, sec.title)
+			doc.space()
+		}
+		for _, p := range sec.paragraphs {
+			doc.wrap(pdfRegular, 10, p)
+		}
+		for _, bullet := range sec.bullets {
+			doc.wrap(pdfRegular, 10, "- "+bullet)
+		}
+		if t := sec.table; t != nil {
+			for _, line := range t.lines(pdfMonoColumns(8)) {
+				doc.add(pdfMono, 8, line)
+			}
+		}
+	}
+	return doc.write(w)
+}
+
+// lines renders t as text in columns for a monospaced font, the first
+// column is cut to fit maxWidth characters
+func (t *table) lines(maxWidth int) []string {
+	widths := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = len([]rune(h))
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+	total := 2 * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	if cut := min(total-maxWidth, widths[0]-10); cut > 0 {
+		widths[0] -= cut
+		total -= cut
+	}
+	render := func(row []string) string {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			if r := []rune(cell); len(r) > widths[i] {
+				// the end of a path tells more than its start
+				cell = "..." + string(r[len(r)-widths[i]+3:])
+			}
+			pad := strings.Repeat(" ", widths[i]-len([]rune(cell)))
+			if t.right[i] {
+				cells[i] = pad + cell
+			} else {
+				cells[i] = cell + pad
+			}
+		}
+		return strings.TrimRight(strings.Join(cells, "  "), " ")
+	}
+	lines := []string{render(t.header), strings.Repeat("-", total)}
+	for _, row := range t.rows {
+		lines = append(lines, render(row))
+	}
+	return lines
+}
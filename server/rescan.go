@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// StartPeriodicScan rescans root every interval so results are warm when the UI is opened
+func StartPeriodicScan(root string, interval time.Duration) {
+	go func() {
+		for {
+			log.Printf("Scheduled scan of %s", root)
+			rescan(root)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Rescan scans root again in the background, its cached results stay
+// until the new ones replace them
+func Rescan(root string) {
+	go rescan(root)
+}
+
+// rescan runs at background priority, directories opened meanwhile are
+// read first. Every profile that cached root scans it into a cache of its
+// own, so that the UI neither waits for a scheduled scan nor sees the sizes
+// drop while it runs.
+func rescan(root string) {
+	ctx := scan.WithPriority(context.Background(), scan.Background)
+	invalidateMFT(root)
+	for _, ps := range profileScanners {
+		if ps.scanner.Cache().GetEntry(root) != nil {
+			ps.scanner.Rescan(ctx, root, func(int64, int64) {})
+		} else if ps.scanner == scanner {
+			scanner.Scan(ctx, root, func(int64, int64) {})
+		}
+	}
+}
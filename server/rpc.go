@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"disk-usage-analyser/scan"
+)
+
+// RPCVersion is the version of the programmatic API served at /api/v1/rpc/v1.
+// Methods and fields are only added within a version.
+const RPCVersion = "v1"
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// ScanParams starts a scan of Path, Wait blocks until it is done
+type ScanParams struct {
+	Path string `json:"path"`
+	Wait bool   `json:"wait"`
+}
+
+type ScanResult struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Count int64  `json:"count"`
+	Done  bool   `json:"done"`
+	// Partial is set when part of the tree could not be read,
+	// Error when Path itself could not
+	Partial bool   `json:"partial,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// QueryParams asks for the cached usage of Path and its children
+type QueryParams struct {
+	Path  string `json:"path"`
+	Limit int    `json:"limit"` // 0 means all children
+}
+
+type QueryChild struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Count int64  `json:"count"`
+	IsDir bool   `json:"isDir"`
+	Done  bool   `json:"done"`
+}
+
+type QueryResult struct {
+	ScanResult
+	Children []QueryChild `json:"children"` // largest first
+}
+
+// ExportParams lists every cached file and directory under Path
+type ExportParams struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	MinSize int64  `json:"minSize"`
+}
+
+type ExportResult struct {
+	Results []SearchResult `json:"results"`
+	Indexed bool           `json:"indexed"`
+}
+
+// DeleteParams moves Path to the trash
+type DeleteParams struct {
+	Path string `json:"path"`
+	// Force deletes inside Mail and Photos libraries, which are refused otherwise
+	Force bool `json:"force,omitempty"`
+}
+
+type DeleteResult struct {
+	Path string `json:"path"`
+}
+
+var rpcMethods = map[string]func(ctx context.Context, params json.RawMessage) (interface{}, error){
+	"scan":   rpcScan,
+	"query":  rpcQuery,
+	"export": rpcExport,
+	"delete": rpcDelete,
+}
+
+// handleRPC serves JSON-RPC 2.0 requests, a request or a batch of them per
+// POST. Notifications, the requests without an id, are run but not
+// answered: a POST of only notifications gets 204 No Content.
+func handleRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeRPC(w, rpcErrorResponse(rpcParseError, err.Error()))
+		return
+	}
+	ctx := withAuditClient(r.Context(), r)
+	if trimmed := bytes.TrimSpace(body); len(trimmed) == 0 || trimmed[0] != '[' {
+		if resp, ok := callRPC(ctx, body); ok {
+			writeRPC(w, resp)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writeRPC(w, rpcErrorResponse(rpcParseError, err.Error()))
+		return
+	}
+	if len(batch) == 0 {
+		writeRPC(w, rpcErrorResponse(rpcInvalidRequest, "empty batch"))
+		return
+	}
+	resps := []rpcResponse{}
+	for _, msg := range batch {
+		if resp, ok := callRPC(ctx, msg); ok {
+			resps = append(resps, resp)
+		}
+	}
+	if len(resps) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPC(w, resps)
+}
+
+func writeRPC(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func rpcErrorResponse(code int, message string) rpcResponse {
+	return rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: code, Message: message}}
+}
+
+// callRPC runs the request msg, ok is false for a notification, which
+// gets no response. A request that is not valid is answered with id null.
+func callRPC(ctx context.Context, msg json.RawMessage) (resp rpcResponse, ok bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(rpcInvalidRequest, "invalid request"), true
+	}
+	// an id of null is a request, only a missing id makes a notification
+	resp = rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	method, found := rpcMethods[req.Method]
+	if !found {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	} else if result, err := method(ctx, req.Params); err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcServerError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else {
+		resp.Result = result
+	}
+	return resp, req.ID != nil
+}
+
+// decodeParams decodes params into v and resolves its path
+func decodeParams(params json.RawMessage, v interface{}, path *string) error {
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, v); err != nil {
+			return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+	if *path == "" {
+		return &rpcError{Code: rpcInvalidParams, Message: "path required"}
+	}
+	absPath, err := filepath.Abs(*path)
+	if err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid path: " + err.Error()}
+	}
+	*path = absPath
+	return nil
+}
+
+func scanResult(path string, entry *scan.Entry) ScanResult {
+	result := ScanResult{Path: path}
+	if entry != nil {
+		entry.Lock()
+		result.Size, result.Count, result.Done = entry.Size, entry.Count, entry.Done
+		result.Partial = entry.Partial
+		if entry.Error != nil {
+			result.Error = entry.Error.Error()
+		}
+		entry.Unlock()
+	}
+	return result
+}
+
+func rpcScan(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p ScanParams
+	if err := decodeParams(params, &p, &p.Path); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(scan.LocalPath(p.Path)); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "not a directory: " + p.Path}
+	}
+
+	// the scan outlives the request, it is shared through the cache and
+	// only takes its values such as the priority
+	entry := scanner.Start(ctx, p.Path)
+	if p.Wait {
+		select {
+		case <-entry.WaitChan():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return scanResult(p.Path, entry), nil
+}
+
+func rpcQuery(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p QueryParams
+	if err := decodeParams(params, &p, &p.Path); err != nil {
+		return nil, err
+	}
+	entry := GlobalCache.GetEntry(p.Path)
+	if entry == nil {
+		return nil, &rpcError{Code: rpcServerError, Message: "not scanned: " + p.Path}
+	}
+
+	result := QueryResult{ScanResult: scanResult(p.Path, entry), Children: []QueryChild{}}
+	for _, child := range GlobalCache.Children(p.Path) {
+		c := scanResult(child.Path(), child)
+		result.Children = append(result.Children, QueryChild{
+			Name:  child.Name(),
+			Size:  c.Size,
+			Count: c.Count,
+			IsDir: true,
+			Done:  c.Done,
+		})
+	}
+	entry.Lock()
+	for _, f := range entry.Files {
+		result.Children = append(result.Children, QueryChild{Name: f.Name, Size: f.Size, Count: 1, Done: true})
+	}
+	entry.Unlock()
+
+	sort.Slice(result.Children, func(i, j int) bool {
+		a, b := result.Children[i], result.Children[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Name < b.Name
+	})
+	if p.Limit > 0 && len(result.Children) > p.Limit {
+		result.Children = result.Children[:p.Limit]
+	}
+	return result, nil
+}
+
+func rpcExport(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p ExportParams
+	if err := decodeParams(params, &p, &p.Path); err != nil {
+		return nil, err
+	}
+	results, indexed := search(GlobalCache, p.Path, &searchQuery{
+		pattern: strings.ToLower(p.Pattern),
+		minSize: p.MinSize,
+	})
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	return ExportResult{Results: results, Indexed: indexed}, nil
+}
+
+func rpcDelete(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	if !hasRole(ctx, RoleOperator) {
+		return nil, &rpcError{Code: rpcServerError, Message: "forbidden: delete requires the operator role"}
+	}
+	var p DeleteParams
+	if err := decodeParams(params, &p, &p.Path); err != nil {
+		return nil, err
+	}
+	if !p.Force {
+		if err := checkStore(p.Path); err != nil {
+			return nil, err
+		}
+	}
+	size := auditSize(p.Path)
+	err := moveToTrash(p.Path)
+	audit(auditClientFrom(ctx), "trash", p.Path, size, "rpc", err)
+	if err != nil {
+		return nil, err
+	}
+	// ancestors keep their old size until they are scanned again
+	invalidateCaches(p.Path)
+	return DeleteResult{Path: p.Path}, nil
+}
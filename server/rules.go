@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/rules"
+)
+
+const (
+	// rulesCheckInterval is how often scheduled rules are checked
+	rulesCheckInterval = time.Minute
+	// maxRuleReportFiles caps the files listed by one report, totals count all
+	maxRuleReportFiles = 1000
+)
+
+// RuleFile is a file a rule matched, Error is set when removing it failed
+type RuleFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// RuleReport is what one run of a rule removed, or would have removed
+// when it was a dry run
+type RuleReport struct {
+	Rule   string    `json:"rule"`
+	RanAt  time.Time `json:"ranAt"`
+	DryRun bool      `json:"dryRun"`
+	// Scheduled is set for runs started by the schedule, not by a request
+	Scheduled bool       `json:"scheduled,omitempty"`
+	Files     []RuleFile `json:"files"`
+	Count     int        `json:"count"`
+	Size      int64      `json:"size"`
+	// Failed is the number of matched files that could not be removed
+	Failed    int    `json:"failed"`
+	Truncated bool   `json:"truncated"`
+	Denied    int    `json:"denied"`
+	Error     string `json:"error,omitempty"`
+}
+
+type RuleStatus struct {
+	*rules.Rule
+	LastReport *RuleReport `json:"lastReport,omitempty"`
+}
+
+type RulesResponse struct {
+	File  string       `json:"file"`
+	Rules []RuleStatus `json:"rules"`
+	// Error is set when the rules file is invalid
+	Error string `json:"error,omitempty"`
+}
+
+// ruleReports keeps the last report of each rule, and when the schedule
+// last counts it as run
+var ruleReports = struct {
+	sync.Mutex
+	once sync.Once
+	file string
+	ruleReportsFile
+}{}
+
+// ruleReportsFile is rule-reports.json
+type ruleReportsFile struct {
+	ByRule map[string]*RuleReport `json:"byRule"`
+	// RanAt is the last real run of each rule: a scheduled one or one that
+	// removed files, which did not fail. A dry run asked for by a request
+	// does not postpone the next scheduled run.
+	RanAt map[string]time.Time `json:"ranAt"`
+}
+
+// loadRuleReports reads the reports file once, callers hold ruleReports.Mutex
+func loadRuleReports() {
+	ruleReports.once.Do(func() {
+		ruleReports.file = configPath("rule-reports.json")
+		if err := loadJSON(ruleReports.file, &ruleReports.ruleReportsFile); err != nil {
+			log.Printf("Error loading rule reports %s: %v", ruleReports.file, err)
+		}
+		if ruleReports.ByRule == nil {
+			ruleReports.ByRule = make(map[string]*RuleReport)
+		}
+		if ruleReports.RanAt == nil {
+			ruleReports.RanAt = make(map[string]time.Time)
+		}
+	})
+}
+
+func saveRuleReports() {
+	if err := saveJSON(ruleReports.file, ruleReports.ruleReportsFile); err != nil {
+		log.Printf("Error saving rule reports: %v", err)
+	}
+}
+
+func lastRuleReport(name string) *RuleReport {
+	ruleReports.Lock()
+	defer ruleReports.Unlock()
+	loadRuleReports()
+	return ruleReports.ByRule[name]
+}
+
+// lastRuleRun is the time of the last real run of the rule name
+func lastRuleRun(name string) (time.Time, bool) {
+	ruleReports.Lock()
+	defer ruleReports.Unlock()
+	loadRuleReports()
+	ranAt, ok := ruleReports.RanAt[name]
+	return ranAt, ok
+}
+
+func recordRuleReport(report *RuleReport) {
+	ruleReports.Lock()
+	defer ruleReports.Unlock()
+	loadRuleReports()
+	ruleReports.ByRule[report.Rule] = report
+	if report.Error == "" && (report.Scheduled || !report.DryRun) {
+		ruleReports.RanAt[report.Rule] = report.RanAt
+	}
+	saveRuleReports()
+}
+
+// pruneRuleReports drops the reports of rules that are no longer in list,
+// so that renaming rules does not grow the reports file
+func pruneRuleReports(list []*rules.Rule) {
+	names := make(map[string]bool, len(list))
+	for _, rule := range list {
+		names[rule.Name] = true
+	}
+	ruleReports.Lock()
+	defer ruleReports.Unlock()
+	loadRuleReports()
+	var pruned bool
+	for name := range ruleReports.ByRule {
+		if !names[name] {
+			delete(ruleReports.ByRule, name)
+			pruned = true
+		}
+	}
+	for name := range ruleReports.RanAt {
+		if !names[name] {
+			delete(ruleReports.RanAt, name)
+			pruned = true
+		}
+	}
+	if pruned {
+		saveRuleReports()
+	}
+}
+
+func rulesFile() string {
+	return configPath("rules.yaml")
+}
+
+// runRule finds the files matching rule and, unless dryRun, trashes or deletes them
+func runRule(ctx context.Context, rule *rules.Rule, dryRun bool) *RuleReport {
+	report := &RuleReport{Rule: rule.Name, RanAt: time.Now(), DryRun: dryRun, Files: []RuleFile{}}
+	if info, err := os.Stat(scan.LocalPath(rule.Under)); err != nil || !info.IsDir() {
+		report.Error = "not a directory: " + rule.Under
+		return report
+	}
+	now := time.Now()
+	var removed bool
+	walkFiles(ctx, rule.Under, "", true, &report.Denied, func(f FileEntry) error {
+		path := filepath.Join(rule.Under, filepath.FromSlash(f.Path))
+		info, err := os.Lstat(scan.LocalPath(path))
+		if err != nil || !rule.Matches(info.Name(), info, now) {
+			return nil
+		}
+		file := RuleFile{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+		if !dryRun {
+			err := removeRuleFile(rule, path)
+			audit(auditClientFrom(ctx), rule.Action, path, file.Size, "rule "+rule.Name, err)
+			if err != nil {
+				file.Error = err.Error()
+				report.Failed++
+			} else {
+				removed = true
+			}
+		}
+		if file.Error == "" {
+			report.Count++
+			report.Size += file.Size
+		}
+		if len(report.Files) < maxRuleReportFiles {
+			report.Files = append(report.Files, file)
+		} else {
+			report.Truncated = true
+		}
+		return nil
+	})
+	if removed {
+		invalidateCaches(rule.Under)
+	}
+	return report
+}
+
+func removeRuleFile(rule *rules.Rule, path string) error {
+	// files of Mail and Photos libraries are only removed through the app
+	if err := checkStore(path); err != nil {
+		return err
+	}
+	if rule.Action == "delete" {
+		return os.Remove(scan.LocalPath(path))
+	}
+	return moveToTrash(path)
+}
+
+// StartRules runs the scheduled rules of the rules file. Rules without
+// apply: true only report what they would remove.
+func StartRules() {
+	go func() {
+		for {
+			runScheduledRules(scan.WithPriority(context.Background(), scan.Background))
+			time.Sleep(rulesCheckInterval)
+		}
+	}()
+}
+
+func runScheduledRules(ctx context.Context) {
+	list, err := rules.Load(rulesFile())
+	if err != nil {
+		log.Printf("Error loading rules %s: %v", rulesFile(), err)
+		return
+	}
+	pruneRuleReports(list)
+	for _, rule := range list {
+		if rule.Every() == 0 {
+			continue
+		}
+		if ranAt, ok := lastRuleRun(rule.Name); ok && time.Since(ranAt) < rule.Every() {
+			continue
+		}
+		report := runRule(ctx, rule, !rule.Apply)
+		report.Scheduled = true
+		recordRuleReport(report)
+		log.Printf("Rule %s: %d files, %d bytes (dry run: %v, failed: %d)", rule.Name, report.Count, report.Size, report.DryRun, report.Failed)
+	}
+}
+
+func handleListRules(w http.ResponseWriter, r *http.Request) {
+	resp := RulesResponse{File: rulesFile(), Rules: []RuleStatus{}}
+	list, err := rules.Load(rulesFile())
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	for _, rule := range list {
+		resp.Rules = append(resp.Rules, RuleStatus{Rule: rule, LastReport: lastRuleReport(rule.Name)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleRunRule runs the rule name now, as a dry run unless apply=true
+func handleRunRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	list, err := rules.Load(rulesFile())
+	if err != nil {
+		http.Error(w, "Invalid rules file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var rule *rules.Rule
+	for _, rl := range list {
+		if rl.Name == name {
+			rule = rl
+		}
+	}
+	if rule == nil {
+		http.Error(w, "rule not found: "+name, http.StatusNotFound)
+		return
+	}
+	dryRun := r.URL.Query().Get("apply") != "true"
+	// operators may trash files, deleting them is for admins
+	if !dryRun && rule.Action == "delete" && !hasRole(r.Context(), RoleAdmin) {
+		http.Error(w, "Forbidden: applying a delete rule requires the admin role", http.StatusForbidden)
+		return
+	}
+
+	report := runRule(withAuditClient(r.Context(), r), rule, dryRun)
+	if r.Context().Err() != nil {
+		return
+	}
+	recordRuleReport(report)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
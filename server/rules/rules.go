@@ -0,0 +1,188 @@
+// Package rules parses the auto-cleanup rules file, a YAML list of
+// policies such as "trash files matching *.log.gz older than 90 days
+// under /var/log":
+//
+//	rules:
+//	  - name: old rotated logs
+//	    under: /var/log
+//	    match: "*.log.gz"
+//	    olderThan: 90d
+//	    action: trash
+//	    schedule: daily
+//	    apply: true
+package rules
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"disk-usage-analyser/server/logs"
+)
+
+// Rule is one cleanup policy
+type Rule struct {
+	Name string `yaml:"name" json:"name"`
+	// Under is the directory searched recursively, ~ is the home directory
+	Under string `yaml:"under" json:"under"`
+	// Match is a glob of file names, e.g. *.log.gz, empty matches all files
+	Match string `yaml:"match" json:"match,omitempty"`
+	// OlderThan is the minimum age since modification, e.g. 90d, 2w or 12h
+	OlderThan string `yaml:"olderThan" json:"olderThan,omitempty"`
+	// LargerThan is the minimum size, e.g. 100M
+	LargerThan string `yaml:"largerThan" json:"largerThan,omitempty"`
+	// Action is "trash" (the default) or "delete"
+	Action string `yaml:"action" json:"action"`
+	// Schedule is hourly, daily, weekly or a duration such as 6h,
+	// empty runs the rule on demand only
+	Schedule string `yaml:"schedule" json:"schedule,omitempty"`
+	// Apply lets scheduled runs remove files, without it they only report
+	Apply bool `yaml:"apply" json:"apply"`
+
+	olderThan  time.Duration
+	largerThan int64
+	every      time.Duration
+}
+
+type file struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Load reads and validates the rules file at path, a missing file has no rules
+func Load(path string) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a rules file
+func Parse(data []byte) ([]*Rule, error) {
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, r := range f.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate rule name: %s", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.init(); err != nil {
+			return nil, fmt.Errorf("%s: %v", r.Name, err)
+		}
+	}
+	return f.Rules, nil
+}
+
+func (r *Rule) init() error {
+	if r.Under == "" {
+		return fmt.Errorf("under is required")
+	}
+	if strings.HasPrefix(r.Under, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		r.Under = filepath.Join(home, r.Under[1:])
+	}
+	under, err := filepath.Abs(r.Under)
+	if err != nil {
+		return err
+	}
+	r.Under = under
+	if r.Match != "" {
+		if _, err := filepath.Match(r.Match, ""); err != nil {
+			return fmt.Errorf("invalid match %q: %v", r.Match, err)
+		}
+	}
+	if r.Match == "" && r.OlderThan == "" && r.LargerThan == "" {
+		// a rule removing everything below a directory is most likely a mistake
+		return fmt.Errorf("one of match, olderThan or largerThan is required")
+	}
+	if r.OlderThan != "" {
+		if r.olderThan, err = ParseAge(r.OlderThan); err != nil {
+			return err
+		}
+	}
+	if r.LargerThan != "" {
+		if r.largerThan, err = logs.ParseSize(r.LargerThan); err != nil {
+			return err
+		}
+	}
+	switch r.Action {
+	case "":
+		r.Action = "trash"
+	case "trash", "delete":
+	default:
+		return fmt.Errorf("invalid action %q, must be trash or delete", r.Action)
+	}
+	switch r.Schedule {
+	case "":
+	case "hourly":
+		r.every = time.Hour
+	case "daily":
+		r.every = 24 * time.Hour
+	case "weekly":
+		r.every = 7 * 24 * time.Hour
+	default:
+		if r.every, err = ParseAge(r.Schedule); err != nil || r.every < time.Minute {
+			return fmt.Errorf("invalid schedule %q", r.Schedule)
+		}
+	}
+	return nil
+}
+
+// Every is how often the rule is scheduled, 0 for on demand only
+func (r *Rule) Every() time.Duration {
+	return r.every
+}
+
+// Matches reports whether the file name with info falls under the rule
+func (r *Rule) Matches(name string, info fs.FileInfo, now time.Time) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if r.Match != "" {
+		if ok, _ := filepath.Match(r.Match, name); !ok {
+			return false
+		}
+	}
+	if r.olderThan > 0 && now.Sub(info.ModTime()) < r.olderThan {
+		return false
+	}
+	return info.Size() >= r.largerThan
+}
+
+// ParseAge parses a duration with day (d) and week (w) units besides
+// those of time.ParseDuration, e.g. 90d
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid duration: %s", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
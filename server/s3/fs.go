@@ -0,0 +1,58 @@
+package s3
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/server/vfs"
+)
+
+// FS presents a bucket as a tree for the scanner: the prefixes of keys up
+// to a slash are directories, their objects files
+type FS struct {
+	client *Client
+	bucket string
+}
+
+func NewFS(client *Client, bucket string) *FS {
+	return &FS{client: client, bucket: bucket}
+}
+
+func (f *FS) ReadDir(dir string) ([]fs.DirEntry, error) {
+	return f.ReadDirContext(context.Background(), dir)
+}
+
+// ReadDirContext lists dir with requests that stop once ctx is done
+func (f *FS) ReadDirContext(ctx context.Context, dir string) ([]fs.DirEntry, error) {
+	prefix := vfs.Rel(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+	objects, prefixes, err := f.client.ListDir(ctx, f.bucket, prefix)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: Scheme + f.bucket + "/" + prefix, Err: err}
+	}
+	entries := make([]fs.DirEntry, 0, len(objects)+len(prefixes))
+	for _, p := range prefixes {
+		if name := strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/"); name != "" {
+			entries = append(entries, vfs.Dir(name, time.Time{}))
+		}
+	}
+	for _, o := range objects {
+		// the placeholder object some tools create for a "folder"
+		if o.Key == prefix {
+			continue
+		}
+		entries = append(entries, vfs.File(strings.TrimPrefix(o.Key, prefix), o.Size, o.LastModified, &vfs.Meta{StorageClass: o.StorageClass}))
+	}
+	return entries, nil
+}
+
+// Lstat describes every path as a directory, a prefix has no object
+// of its own. The scanner only asks for directories.
+func (f *FS) Lstat(p string) (fs.FileInfo, error) {
+	return vfs.Dir(path.Base("/"+vfs.Rel(p)), time.Time{}).Info()
+}
@@ -0,0 +1,233 @@
+// Package s3 lists the objects of an S3 compatible bucket through the
+// ListObjectsV2 API, signing requests with AWS Signature Version 4, and
+// presents the bucket as a tree to the scanner, see FS.
+//
+// Credentials and endpoint come from the usual AWS environment variables:
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION
+// (or AWS_DEFAULT_REGION) and, for other providers such as MinIO or R2,
+// AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL). Without credentials requests
+// are anonymous, which works for public buckets.
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Scheme prefixes the paths that name a bucket, e.g. s3://bucket/prefix/
+const Scheme = "s3://"
+
+// emptyHash is the sha256 of the empty body of a GET
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Object is one object of a listing
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// StorageClass is e.g. STANDARD, GLACIER or DEEP_ARCHIVE
+	StorageClass string
+}
+
+type Client struct {
+	Endpoint     string // empty for AWS
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	HTTP         *http.Client
+}
+
+// FromEnv returns a client configured from the AWS environment variables
+func FromEnv() *Client {
+	c := &Client{
+		Endpoint:     strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "/"),
+		Region:       firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		HTTP:         &http.Client{Timeout: time.Minute},
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	return c
+}
+
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		StorageClass string    `xml:"StorageClass"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+type errorResult struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// ListDir lists the objects directly below prefix and the prefixes one
+// level deeper, the "directories" of a bucket when keys use slashes
+func (c *Client) ListDir(ctx context.Context, bucket, prefix string) (objects []Object, prefixes []string, err error) {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "max-keys": {"1000"}, "delimiter": {"/"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result listResult
+		if err := c.get(ctx, bucket, query, &result); err != nil {
+			return nil, nil, err
+		}
+		for _, o := range result.Contents {
+			class := o.StorageClass
+			if class == "" {
+				class = "STANDARD"
+			}
+			objects = append(objects, Object{Key: o.Key, Size: o.Size, LastModified: o.LastModified, StorageClass: class})
+		}
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, prefixes, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// get sends a signed GET of the bucket and decodes the XML response into v.
+// A bucket in another region than configured is retried in its region.
+func (c *Client) get(ctx context.Context, bucket string, query url.Values, v interface{}) error {
+	region := c.Region
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, bucket, region, query)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return xml.Unmarshal(body, v)
+		}
+		if actual := resp.Header.Get("X-Amz-Bucket-Region"); actual != "" && actual != region && attempt == 0 {
+			region = actual
+			continue
+		}
+		var e errorResult
+		if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+			return fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+		}
+		return fmt.Errorf("s3: %s", resp.Status)
+	}
+}
+
+func (c *Client) do(ctx context.Context, bucket, region string, query url.Values) (*http.Response, error) {
+	// custom endpoints (MinIO, ...) use path style, AWS virtual hosted
+	// style except for bucket names with dots the certificate does not cover
+	var host, path, scheme string
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %v", c.Endpoint, err)
+		}
+		scheme, host, path = u.Scheme, u.Host, strings.TrimSuffix(u.Path, "/")+"/"+bucket+"/"
+	} else if strings.Contains(bucket, ".") {
+		scheme, host, path = "https", "s3."+region+".amazonaws.com", "/"+bucket+"/"
+	} else {
+		scheme, host, path = "https", bucket+".s3."+region+".amazonaws.com", "/"
+	}
+	// url.Values.Encode sorts by key, signing wants %20 for spaces
+	rawQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+host+path+"?"+rawQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.AccessKey != "" {
+		c.sign(req, host, path, rawQuery, region, time.Now())
+	}
+	return c.HTTP.Do(req)
+}
+
+// sign adds the Signature Version 4 authorization to req
+func (c *Client) sign(req *http.Request, host, path, rawQuery, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	headers := map[string]string{
+		"host":                 host,
+		"x-amz-content-sha256": emptyHash,
+		"x-amz-date":           amzDate,
+	}
+	if c.SessionToken != "" {
+		headers["x-amz-security-token"] = c.SessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, path, rawQuery, canonicalHeaders.String(), signedHeaders, emptyHash}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hashHex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
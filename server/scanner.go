@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// Global cache for directory sizes
+var GlobalCache = scan.NewCache()
+
+// scanner runs every scan of the server, they share GlobalCache
+var scanner = scan.New(GlobalCache, scan.Options{
+	FS:      localFS{},
+	OnError: onScanError,
+	NewStats: func() scan.Stats {
+		return &SubtreeStats{}
+	},
+	Skip: skipIgnored,
+})
+
+// progressInterval are the bounds of how often scans push partial sizes,
+// those of package scan unless set by SetProgressInterval
+var progressInterval struct {
+	shortest, longest time.Duration
+}
+
+// SetProgressInterval bounds how often the partial sizes of scans are
+// pushed to usage streams, 0 keeps a bound. It applies to the scans started
+// afterwards and is meant to be called before serving.
+func SetProgressInterval(shortest, longest time.Duration) {
+	progressInterval.shortest, progressInterval.longest = shortest, longest
+	for _, ps := range profileScanners {
+		ps.scanner.SetProgressInterval(shortest, longest)
+	}
+}
+
+// ParseProgressInterval parses <shortest>,<longest> or just <longest>,
+// e.g. 20ms,500ms or 2s
+func ParseProgressInterval(s string) (shortest, longest time.Duration, err error) {
+	first, second, both := strings.Cut(s, ",")
+	if !both {
+		second, first = first, ""
+	}
+	if first != "" {
+		if shortest, err = time.ParseDuration(first); err != nil || shortest <= 0 {
+			return 0, 0, fmt.Errorf("invalid progress interval: %s", s)
+		}
+	}
+	if longest, err = time.ParseDuration(second); err != nil || longest <= 0 || longest < shortest {
+		return 0, 0, fmt.Errorf("invalid progress interval: %s", s)
+	}
+	return shortest, longest, nil
+}
+
+func onScanError(dir string, err error) {
+	log.Printf("Error reading %s: %v", dir, err)
+	recordDenied(dir, err)
+}
+
+// getDirSizeWithCache returns the size and the number of entries below path,
+// scanning it unless cached, and reports progress until it is done
+func getDirSizeWithCache(ctx context.Context, path string, onProgress func(size int64, count int64)) (int64, int64) {
+	return scanner.Scan(ctx, path, onProgress)
+}
+
+// entryStats returns the SubtreeStats of a cache entry
+func entryStats(entry *scan.Entry) SubtreeStats {
+	if stats, ok := entry.GetStats().(*SubtreeStats); ok && stats != nil {
+		return *stats
+	}
+	return SubtreeStats{}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/spotlight"
+)
+
+const defaultSearchLimit = 1000
+
+type SearchResult struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"isDir"`
+	ModTime time.Time `json:"modTime"`
+	// Verified marks results of the Spotlight index confirmed by stat
+	Verified bool `json:"verified,omitempty"`
+}
+
+type SearchResponse struct {
+	Results   []SearchResult `json:"results"`
+	Truncated bool           `json:"truncated"`
+	// Indexed is false when path has not been scanned, is still being
+	// scanned, or a directory below it could not be read
+	Indexed bool `json:"indexed"`
+	// Source is "cache", or "spotlight" when the Spotlight index was
+	// queried too. Stale counts its hits that no longer matched on disk.
+	Source string `json:"source"`
+	Stale  int    `json:"stale,omitempty"`
+}
+
+type searchQuery struct {
+	pattern        string // lower-cased glob, or substring when it has no glob meta chars
+	minSize        int64
+	maxSize        int64 // 0 means unlimited
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+	typ            string // "", "file", "dir"
+}
+
+func (q *searchQuery) match(name string, size int64, modTime time.Time, isDir bool) bool {
+	if q.typ == "file" && isDir || q.typ == "dir" && !isDir {
+		return false
+	}
+	if size < q.minSize || (q.maxSize > 0 && size > q.maxSize) {
+		return false
+	}
+	if !q.modifiedAfter.IsZero() && modTime.Before(q.modifiedAfter) {
+		return false
+	}
+	if !q.modifiedBefore.IsZero() && modTime.After(q.modifiedBefore) {
+		return false
+	}
+	if q.pattern == "" {
+		return true
+	}
+	lowerName := strings.ToLower(name)
+	if strings.ContainsAny(q.pattern, "*?[") {
+		ok, _ := filepath.Match(q.pattern, lowerName)
+		return ok
+	}
+	return strings.Contains(lowerName, q.pattern)
+}
+
+// handleSearch searches the scan cache, it never touches the disk
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	root := query.Get("path")
+	if root == "" {
+		root = InitialDir
+	}
+	if root == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	// the cache searched is that of the scans behind /api/usage, of the
+	// default profile for local paths
+	s, root, src, err := resolvePath(r.Context(), root, DefaultProfile)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), pathErrorStatus(err))
+		return
+	}
+
+	q := searchQuery{
+		pattern: strings.ToLower(query.Get("q")),
+		typ:     query.Get("type"),
+	}
+	if q.typ != "" && q.typ != "file" && q.typ != "dir" {
+		http.Error(w, "type must be file or dir", http.StatusBadRequest)
+		return
+	}
+	if q.minSize, err = parseSize(query.Get("minSize")); err != nil {
+		http.Error(w, "Invalid minSize: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.maxSize, err = parseSize(query.Get("maxSize")); err != nil {
+		http.Error(w, "Invalid maxSize: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.modifiedAfter, err = parseTime(query.Get("modifiedAfter")); err != nil {
+		http.Error(w, "Invalid modifiedAfter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.modifiedBefore, err = parseTime(query.Get("modifiedBefore")); err != nil {
+		http.Error(w, "Invalid modifiedBefore: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	source := query.Get("source")
+	switch source {
+	case "", "cache", "auto":
+	case "spotlight":
+		if !spotlight.Supported() {
+			http.Error(w, "Spotlight is not available", http.StatusNotImplemented)
+			return
+		}
+	default:
+		http.Error(w, "source must be cache, spotlight or auto", http.StatusBadRequest)
+		return
+	}
+
+	resp := SearchResponse{Source: "cache"}
+	resp.Results, resp.Indexed = search(s.Cache(), root, &q)
+	for i := range resp.Results {
+		resp.Results[i].Path = displayPath(src, resp.Results[i].Path)
+	}
+	// auto asks Spotlight while the cache cannot answer yet
+	if src == nil && (source == "spotlight" || source == "auto" && !resp.Indexed && spotlight.Supported() && q.typ != "dir") {
+		found, stale, err := spotlightSearch(r.Context(), root, &q, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Results = mergeSearchResults(resp.Results, found)
+		resp.Source, resp.Stale = "spotlight", stale
+	}
+	sort.Slice(resp.Results, func(i, j int) bool {
+		return resp.Results[i].Size > resp.Results[j].Size
+	})
+	if len(resp.Results) > limit {
+		resp.Results = resp.Results[:limit]
+		resp.Truncated = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// search collects cached directories and files under root matching q.
+// root itself is not included. indexed reports whether everything
+// cached under root has finished scanning and was read completely.
+func search(c *scan.Cache, root string, q *searchQuery) (results []SearchResult, indexed bool) {
+	entries := c.Under(root)
+
+	results = []SearchResult{}
+	indexed = len(entries) > 0
+	for _, entry := range entries {
+		entry.Lock()
+		if !entry.Done || entry.Partial {
+			indexed = false
+		}
+		path := entry.Path()
+		if path != root && q.match(entry.Name(), entry.Size, entry.ModTime, true) {
+			results = append(results, SearchResult{
+				Path:    path,
+				Size:    entry.Size,
+				IsDir:   true,
+				ModTime: entry.ModTime,
+			})
+		}
+		for _, f := range entry.Files {
+			if q.match(f.Name, f.Size, f.ModTime, false) {
+				results = append(results, SearchResult{
+					Path:    filepath.Join(path, f.Name),
+					Size:    f.Size,
+					ModTime: f.ModTime,
+				})
+			}
+		}
+		entry.Unlock()
+	}
+	return results, indexed
+}
+
+// spotlightSearch finds files matching q with the Spotlight index and
+// verifies each hit by stat, the index lags behind the disk
+func spotlightSearch(ctx context.Context, root string, q *searchQuery, limit int) (results []SearchResult, stale int, err error) {
+	paths, err := spotlight.Find(ctx, root, spotlight.Query(q.minSize, q.pattern), limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, path := range paths {
+		info, err := os.Lstat(scan.LocalPath(path))
+		if err != nil || info.IsDir() || !q.match(info.Name(), info.Size(), info.ModTime(), false) {
+			stale++
+			continue
+		}
+		results = append(results, SearchResult{
+			Path:     path,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Verified: true,
+		})
+	}
+	return results, stale, nil
+}
+
+// mergeSearchResults adds the results of found not already in results,
+// verified sizes replace cached ones
+func mergeSearchResults(results []SearchResult, found []SearchResult) []SearchResult {
+	index := make(map[string]int, len(results))
+	for i, result := range results {
+		index[result.Path] = i
+	}
+	for _, f := range found {
+		if i, ok := index[f.Path]; ok {
+			results[i] = f
+			continue
+		}
+		results = append(results, f)
+	}
+	return results
+}
+
+// parseSize parses sizes like "1024", "500K", "1.5MB", "1GB".
+// Units are powers of 1024. Empty string is 0.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(s, "B")
+	multiplier := float64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size")
+	}
+	return int64(v * multiplier), nil
+}
+
+// parseTime parses RFC3339 or a unix timestamp in seconds. Empty string is zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// secret is a password decoded from JSON into bytes rather than a string,
+// so that it can be cleared after use. It is never encoded, logging a
+// request that holds one shows "".
+type secret []byte
+
+var errInvalidSecret = errors.New("password must be a string")
+
+func (s secret) MarshalJSON() ([]byte, error) {
+	return []byte(`""`), nil
+}
+
+// UnmarshalJSON unescapes the JSON string data into a buffer of its own,
+// data itself is the request body that the caller clears
+func (s *secret) UnmarshalJSON(data []byte) error {
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return errInvalidSecret
+	}
+	data = data[1 : len(data)-1]
+	buf := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c != '\\' {
+			buf = append(buf, c)
+			continue
+		}
+		i++
+		if i == len(data) {
+			clear(buf)
+			return errInvalidSecret
+		}
+		switch c = data[i]; c {
+		case '"', '\\', '/':
+			buf = append(buf, c)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, ok := hexRune(data[i+1:])
+			if !ok {
+				clear(buf)
+				return errInvalidSecret
+			}
+			i += 4
+			if utf16.IsSurrogate(r) {
+				// the second half follows as another \uXXXX
+				if low, ok := hexRune(data[min(i+3, len(data)):]); ok && data[i+1] == '\\' && data[i+2] == 'u' {
+					r = utf16.DecodeRune(r, low)
+					i += 6
+				} else {
+					r = utf8.RuneError
+				}
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			clear(buf)
+			return errInvalidSecret
+		}
+	}
+	*s = buf
+	return nil
+}
+
+// hexRune parses the four hex digits at the start of b
+func hexRune(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			r = r<<4 | rune(c-'0')
+		case c >= 'a' && c <= 'f':
+			r = r<<4 | rune(c-'a'+10)
+		case c >= 'A' && c <= 'F':
+			r = r<<4 | rune(c-'A'+10)
+		default:
+			return 0, false
+		}
+	}
+	return r, true
+}
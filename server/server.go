@@ -14,7 +14,8 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -78,13 +79,14 @@ func EnsureFrontendDevServer(ctx context.Context) (chan struct{}, error) {
 	return nil, fmt.Errorf("frontend server failed to start within timeout")
 }
 
-func Serve(port int, dev bool) error {
+func Serve(port int, opts ServeOptions) error {
+	dev := opts.Dev
 	mux := http.NewServeMux()
 	server := &http.Server{
-		Addr:        fmt.Sprintf(":%d", port),
+		Addr:        net.JoinHostPort(opts.Host, strconv.Itoa(port)),
 		ReadTimeout: 30 * time.Second,
 		// WriteTimeout: 30 * time.Second, // Disable write timeout for SSE
-		Handler: mux,
+		Handler: withMiddleware(mux),
 	}
 
 	if dev {
@@ -134,16 +136,27 @@ func Serve(port int, dev bool) error {
 		return err
 	}
 
-	fmt.Printf("Serving directory preview at http://localhost:%d\n", port)
+	url := ServeURL(opts.Host, port)
+	fmt.Printf("Serving directory preview at %s\n", url)
 
-	go func() {
-		time.Sleep(1 * time.Second)
-		web.OpenBrowser(fmt.Sprintf("http://localhost:%d", port))
-	}()
+	if !opts.NoOpenBrowser {
+		go func() {
+			time.Sleep(1 * time.Second)
+			web.OpenBrowser(url)
+		}()
+	}
 
 	return server.ListenAndServe()
 }
 
+// ServeURL is the URL to reach a server bound to host:port
+func ServeURL(host string, port int) string {
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
 func ProxyDev(mux *http.ServeMux) error {
 	targetURL, err := url.Parse("http://localhost:5173")
 	if err != nil {
@@ -176,23 +189,38 @@ func Static(mux *http.ServeMux, opts StaticOptions) error {
 		return fmt.Errorf("failed to create assets file system: %v", err)
 	}
 
-	// Serve React assets from /assets/ path with proper MIME types
-
 	// Serve index.css and index.js from assets with pattern matching
 	mux.HandleFunc("/assets/index.css", func(w http.ResponseWriter, r *http.Request) {
-		serveAssetWithPattern(w, r, assetsFileSystem, "index.css", "index-", ".css", "text/css")
+		serveAssetWithPattern(w, r, assetsFileSystem, "index.css", "index-", ".css")
 	})
 	mux.HandleFunc("/assets/index.js", func(w http.ResponseWriter, r *http.Request) {
-		serveAssetWithPattern(w, r, assetsFileSystem, "index.js", "index-", ".js", "application/javascript")
+		serveAssetWithPattern(w, r, assetsFileSystem, "index.js", "index-", ".js")
 	})
 
-	mux.Handle("/assets/", http.StripPrefix("/assets/", &mimeTypeHandler{http.FileServer(http.FS(assetsFileSystem))}))
-	// Serve React static files like vite.svg from root
-	mux.Handle("/disk-usage-analyser.svg", &mimeTypeHandler{http.FileServer(http.FS(reactFileSystem))})
+	// Serve React assets from /assets/ path with proper MIME types and caching
+	mux.HandleFunc("/assets/", func(w http.ResponseWriter, r *http.Request) {
+		if !serveStatic(w, r, assetsFileSystem, strings.TrimPrefix(r.URL.Path, "/assets/")) {
+			http.NotFound(w, r)
+		}
+	})
 
-	// Serve the main HTML page
+	// Serve the main HTML page for every client side route, other
+	// files of the build like disk-usage-analyser.svg from root
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
+			return
+		}
+		if name := strings.TrimPrefix(path.Clean(r.URL.Path), "/"); path.Ext(name) != "" && name != "index.html" {
+			if !serveStatic(w, r, reactFileSystem, name) {
+				http.NotFound(w, r)
+			}
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/html")
+		// the index names the hashed assets of the current build
+		w.Header().Set("Cache-Control", "no-cache")
 
 		// Use custom IndexHtml if provided
 		if opts.IndexHtml != "" {
@@ -220,15 +248,114 @@ func Static(mux *http.ServeMux, opts StaticOptions) error {
 }
 
 func RegisterAPI(mux *http.ServeMux) error {
+	registerAnalyzers()
+
 	// ping
 	mux.HandleFunc("/ping", handlePing)
-	mux.HandleFunc("/api/usage", handleUsage)
-	mux.HandleFunc("/api/refresh", handleRefresh)
-	mux.HandleFunc("/api/moveToTrash", handleMoveToTrash)
-	mux.HandleFunc("/api/disks/list", handleListDisks)
-	mux.HandleFunc("/api/disks/mount", handleMountDisk)
-	mux.HandleFunc("/api/disks/unmount", handleUnmountDisk)
-	mux.HandleFunc("/api/disks/open", handleOpenDisk)
+	mux.HandleFunc(APIPrefix+"/instance", handleInstance)
+	mux.HandleFunc(APIPrefix+"/health", handleHealth)
+	mux.HandleFunc(APIPrefix+"/version", handleVersion)
+	mux.HandleFunc(APIPrefix+"/openapi.json", handleOpenAPI)
+	mux.HandleFunc(APIPrefix+"/login", handleLogin)
+	mux.HandleFunc(APIPrefix+"/logout", handleLogout)
+	mux.HandleFunc(APIPrefix+"/whoami", handleWhoami)
+	mux.HandleFunc("/browse/", handleBrowse)
+	mux.HandleFunc("/browse", handleBrowse)
+	mux.HandleFunc(APIPrefix+"/usage", handleUsage)
+	mux.HandleFunc(APIPrefix+"/usage.ndjson", handleUsage)
+	mux.HandleFunc(APIPrefix+"/usage/by-owner", handleUsageByOwner)
+	mux.HandleFunc(APIPrefix+"/usage/by-age", handleUsageByAge)
+	mux.HandleFunc(APIPrefix+"/usage/by-extension", handleUsageByExtension)
+	mux.HandleFunc(APIPrefix+"/usage/by-xattr", handleUsageByXattr)
+	mux.HandleFunc(APIPrefix+"/usage/filtered", handleUsageFiltered)
+	mux.HandleFunc(APIPrefix+"/usage/watchers", handleUsageWatchers)
+	mux.HandleFunc(APIPrefix+"/usage/cached", handleUsageCached)
+	mux.HandleFunc(APIPrefix+"/inodes", handleInodes)
+	mux.HandleFunc(APIPrefix+"/categories", handleCategories)
+	mux.HandleFunc(APIPrefix+"/forecast", handleForecast)
+	mux.HandleFunc(APIPrefix+"/volume/history", handleVolumeHistory)
+	mux.HandleFunc(APIPrefix+"/summary", handleSummary)
+	mux.HandleFunc(APIPrefix+"/sessions", requireRole(RoleAdmin, handleListClientSessions))
+	mux.HandleFunc(APIPrefix+"/sessions/disconnect", requireRole(RoleAdmin, handleDisconnectClient))
+	mux.HandleFunc(APIPrefix+"/sessions/revoke", requireRole(RoleAdmin, handleRevokeToken))
+	mux.HandleFunc(APIPrefix+"/sessions/create", handleCreateSession)
+	mux.HandleFunc(APIPrefix+"/sessions/list", handleListSessions)
+	mux.HandleFunc(APIPrefix+"/sessions/get", handleGetSession)
+	mux.HandleFunc(APIPrefix+"/sessions/delete", requireRole(RoleOperator, handleDeleteSession))
+	mux.HandleFunc(APIPrefix+"/jobs", handleJobs)
+	mux.HandleFunc(APIPrefix+"/jobs/cancel", requireRole(RoleOperator, handleCancelJob))
+	mux.HandleFunc(APIPrefix+"/jobs/stats", handleJobStats)
+	mux.HandleFunc("GET "+APIPrefix+"/scan/{id}/stats", handleJobStats)
+	mux.HandleFunc(APIPrefix+"/bookmarks/list", handleListBookmarks)
+	mux.HandleFunc(APIPrefix+"/bookmarks/add", requireRole(RoleOperator, handleAddBookmark))
+	mux.HandleFunc(APIPrefix+"/bookmarks/remove", requireRole(RoleOperator, handleRemoveBookmark))
+	mux.HandleFunc(APIPrefix+"/bookmarks/visit", requireRole(RoleOperator, handleVisitBookmark))
+	mux.HandleFunc(APIPrefix+"/bookmarks/rescan", requireRole(RoleOperator, handleRescanBookmark))
+	mux.HandleFunc(APIPrefix+"/report", handleReport)
+	mux.HandleFunc(APIPrefix+"/report/summary", handleSummaryReport)
+	mux.HandleFunc(APIPrefix+"/pins", handleListPins)
+	mux.HandleFunc(APIPrefix+"/pins/add", requireRole(RoleOperator, handleAddPin))
+	mux.HandleFunc(APIPrefix+"/pins/remove", requireRole(RoleOperator, handleRemovePin))
+	mux.HandleFunc(APIPrefix+"/ignore", handleListIgnore)
+	mux.HandleFunc(APIPrefix+"/ignore/add", requireRole(RoleOperator, handleAddIgnore))
+	mux.HandleFunc(APIPrefix+"/ignore/remove", requireRole(RoleOperator, handleRemoveIgnore))
+	mux.HandleFunc(APIPrefix+"/annotations", handleListAnnotations)
+	mux.HandleFunc(APIPrefix+"/annotations/set", requireRole(RoleOperator, handleSetAnnotation))
+	mux.HandleFunc(APIPrefix+"/annotations/remove", requireRole(RoleOperator, handleRemoveAnnotation))
+	mux.HandleFunc(APIPrefix+"/recent", handleRecent)
+	mux.HandleFunc(APIPrefix+"/recent/clear", requireRole(RoleOperator, handleClearRecent))
+	mux.HandleFunc(APIPrefix+"/refresh", requireRole(RoleOperator, handleRefresh))
+	mux.HandleFunc(APIPrefix+"/moveToTrash", requireRole(RoleOperator, handleMoveToTrash))
+	mux.HandleFunc(APIPrefix+"/trash/list", handleListTrash)
+	mux.HandleFunc(APIPrefix+"/trash/empty", requireRole(RoleAdmin, handleEmptyTrash))
+	mux.HandleFunc(APIPrefix+"/analyzers", handleAnalyzers)
+	mux.HandleFunc(APIPrefix+"/analyzers/run", handleRunAnalyzer)
+	mux.HandleFunc(APIPrefix+"/plugins", handleListPlugins)
+	mux.HandleFunc(APIPrefix+"/plugins/approve", requireRole(RoleAdmin, handleApprovePlugin))
+	mux.HandleFunc(APIPrefix+"/plugins/revoke", requireRole(RoleAdmin, handleRevokePlugin))
+	mux.HandleFunc(APIPrefix+"/plugins/annotate", requireRole(RoleOperator, handleAnnotatePlugins))
+	mux.HandleFunc(APIPrefix+"/plugins/run", requireRole(RoleAdmin, handleRunPlugin))
+	mux.HandleFunc(APIPrefix+"/rules", handleListRules)
+	mux.HandleFunc(APIPrefix+"/rules/run", requireRole(RoleOperator, handleRunRule))
+	mux.HandleFunc(APIPrefix+"/baselines", handleListBaselines)
+	mux.HandleFunc(APIPrefix+"/baseline/export", handleExportBaseline)
+	mux.HandleFunc(APIPrefix+"/baseline/compare", requireRole(RoleOperator, handleCompareBaseline))
+	mux.HandleFunc(APIPrefix+"/audit", requireRole(RoleAdmin, handleAudit))
+	mux.HandleFunc(APIPrefix+"/search", handleSearch)
+	mux.HandleFunc(APIPrefix+"/files", requireRole(RoleOperator, handleFiles))
+	mux.HandleFunc(APIPrefix+"/hash", requireRole(RoleOperator, handleHash))
+	mux.HandleFunc(APIPrefix+"/simulate", handleSimulate)
+	mux.HandleFunc(APIPrefix+"/archive", requireRole(RoleOperator, handleArchive))
+	mux.HandleFunc(APIPrefix+"/git/info", handleGitInfo)
+	mux.HandleFunc(APIPrefix+"/git/run", requireRole(RoleAdmin, handleGitRun))
+	mux.HandleFunc(APIPrefix+"/rpc/"+RPCVersion, handleRPC)
+	mux.HandleFunc(APIPrefix+"/disks/list", handleListDisks)
+	mux.HandleFunc(APIPrefix+"/disks/mount", requireRole(RoleAdmin, handleMountDisk))
+	mux.HandleFunc(APIPrefix+"/disks/unmount", requireRole(RoleAdmin, handleUnmountDisk))
+	mux.HandleFunc(APIPrefix+"/disks/open", requireRole(RoleOperator, handleOpenDisk))
+	mux.HandleFunc(APIPrefix+"/disks/fsck", handleFsck)
+	mux.HandleFunc(APIPrefix+"/diskImage/info", handleDiskImageInfo)
+	mux.HandleFunc(APIPrefix+"/diskImage/compact", requireRole(RoleAdmin, handleCompactDiskImage))
+	mux.HandleFunc(APIPrefix+"/preflight", handlePreflight)
+	mux.HandleFunc(APIPrefix+"/capabilities", handleCapabilities)
+	mux.HandleFunc(APIPrefix+"/capabilities/openFullDiskAccess", requireRole(RoleOperator, handleOpenFullDiskAccess))
+	mux.HandleFunc(APIPrefix+"/devCaches/list", handleListDevCaches)
+	mux.HandleFunc(APIPrefix+"/devCaches/clean", requireRole(RoleAdmin, handleCleanDevCache))
+	mux.HandleFunc(APIPrefix+"/browserCaches/list", handleListBrowserCaches)
+	mux.HandleFunc(APIPrefix+"/browserCaches/clean", requireRole(RoleAdmin, handleCleanBrowserCache))
+	mux.HandleFunc(APIPrefix+"/projects", handleProjects)
+	mux.HandleFunc(APIPrefix+"/projects/clean", requireRole(RoleAdmin, handleCleanProject))
+	mux.HandleFunc(APIPrefix+"/vms", handleVMs)
+	mux.HandleFunc(APIPrefix+"/stores", handleStores)
+	mux.HandleFunc(APIPrefix+"/logs", handleLogs)
+	mux.HandleFunc(APIPrefix+"/logs/vacuum", requireRole(RoleAdmin, handleVacuumJournal))
+	mux.HandleFunc(APIPrefix+"/logs/truncate", requireRole(RoleAdmin, handleTruncateLog))
+	mux.HandleFunc(APIPrefix+"/logs/watches", handleListLogWatches)
+	mux.HandleFunc(APIPrefix+"/logs/watch", requireRole(RoleAdmin, handleAddLogWatch))
+	mux.HandleFunc(APIPrefix+"/logs/unwatch", requireRole(RoleAdmin, handleRemoveLogWatch))
+	mux.HandleFunc(APIPrefix+"/backupExclusions/list", handleListBackupExclusions)
+	mux.HandleFunc(APIPrefix+"/backupExclusions/add", requireRole(RoleAdmin, handleAddBackupExclusion))
+	mux.HandleFunc(APIPrefix+"/backupExclusions/remove", requireRole(RoleAdmin, handleRemoveBackupExclusion))
 
 	return nil
 }
@@ -237,37 +364,25 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("pong"))
 }
 
-// mimeTypeHandler wraps an http.Handler and sets proper MIME types
-type mimeTypeHandler struct {
-	handler http.Handler
-}
-
-func (h *mimeTypeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Set MIME type based on file extension
-	ext := filepath.Ext(r.URL.Path)
+// contentTypeOf returns the MIME type of a file extension, "" if unknown
+func contentTypeOf(ext string) string {
 	switch ext {
 	case ".css":
-		w.Header().Set("Content-Type", "text/css")
+		return "text/css"
 	case ".js":
-		w.Header().Set("Content-Type", "application/javascript")
+		return "application/javascript"
 	case ".svg":
-		w.Header().Set("Content-Type", "image/svg+xml")
-	default:
-		// Use Go's built-in MIME type detection for other files
-		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
-			w.Header().Set("Content-Type", mimeType)
-		}
+		return "image/svg+xml"
 	}
-
-	// Call the wrapped handler
-	h.handler.ServeHTTP(w, r)
+	// Use Go's built-in MIME type detection for other files
+	return mime.TypeByExtension(ext)
 }
 
 // serveAssetWithPattern finds and serves the first available file matching the given exact match or prefix and suffix
-func serveAssetWithPattern(w http.ResponseWriter, r *http.Request, assetsFS fs.FS, exactMatch, prefix, suffix, contentType string) {
+func serveAssetWithPattern(w http.ResponseWriter, r *http.Request, assetsFS fs.FS, exactMatch, prefix, suffix string) {
 	// First try exact match
 	if _, err := fs.Stat(assetsFS, exactMatch); err == nil {
-		serveAssetFile(w, r, assetsFS, exactMatch, contentType)
+		serveStatic(w, r, assetsFS, exactMatch)
 		return
 	}
 
@@ -280,7 +395,7 @@ func serveAssetWithPattern(w http.ResponseWriter, r *http.Request, assetsFS fs.F
 
 	for _, entry := range entries {
 		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) && strings.HasSuffix(entry.Name(), suffix) {
-			serveAssetFile(w, r, assetsFS, entry.Name(), contentType)
+			serveStatic(w, r, assetsFS, entry.Name())
 			return
 		}
 	}
@@ -289,28 +404,11 @@ func serveAssetWithPattern(w http.ResponseWriter, r *http.Request, assetsFS fs.F
 	http.NotFound(w, r)
 }
 
-// serveAssetFile serves a specific file from the assets filesystem
-func serveAssetFile(w http.ResponseWriter, r *http.Request, assetsFS fs.FS, filename string, contentType string) {
-	file, err := assetsFS.Open(filename)
-	if err != nil {
-		http.Error(w, "Failed to open asset file", http.StatusInternalServerError)
-		return
-	}
-	defer file.Close()
-
-	content, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "Failed to read asset file", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", contentType)
-	w.Write(content)
-}
-
-// checkPortAvailable checks if a port is available
-func checkPortAvailable(port int) bool {
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// checkPortAvailable checks if a port is available on host, empty
+// meaning all interfaces: a port taken on 127.0.0.1 only is free on
+// another address and the other way around
+func checkPortAvailable(host string, port int) bool {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 	if err != nil {
 		return false
 	}
@@ -318,11 +416,11 @@ func checkPortAvailable(port int) bool {
 	return true
 }
 
-// FindAvailablePort finds a port starting from startPort
-func FindAvailablePort(startPort int, maxAttempts int) (int, error) {
+// FindAvailablePort finds a port of host starting from startPort
+func FindAvailablePort(host string, startPort int, maxAttempts int) (int, error) {
 	for i := 0; i < maxAttempts; i++ {
 		port := startPort + i
-		if checkPortAvailable(port) {
+		if checkPortAvailable(host, port) {
 			return port, nil
 		}
 	}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// sessionIdleTimeout is how long a session nobody asked for is kept, its
+// scans are cancelled once it expires
+const sessionIdleTimeout = time.Hour
+
+// Session scans several roots at once, e.g. /, /Volumes/Backup and ~/nas
+type Session struct {
+	ID        string    `json:"id"`
+	Roots     []string  `json:"roots"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	cancel context.CancelFunc
+	usedAt time.Time // guarded by sessions.Mutex
+}
+
+type SessionRootStatus struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Count int64  `json:"count"`
+	Done  bool   `json:"done"`
+}
+
+type SessionStatus struct {
+	ID        string              `json:"id"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Roots     []SessionRootStatus `json:"roots"`
+	TotalSize int64               `json:"totalSize"`
+	Done      bool                `json:"done"`
+}
+
+type CreateSessionRequest struct {
+	Roots []string `json:"roots"`
+}
+
+var sessions = struct {
+	sync.Mutex
+	byID map[string]*Session
+}{
+	byID: make(map[string]*Session),
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func startSession(id string, roots []string) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &Session{
+		ID:        id,
+		Roots:     roots,
+		CreatedAt: time.Now(),
+		cancel:    cancel,
+		usedAt:    time.Now(),
+	}
+
+	// share the global scan concurrency fairly across roots
+	share := scanner.Concurrency() / len(roots)
+	if share < 1 {
+		share = 1
+	}
+	for _, root := range roots {
+		rootCtx := scan.WithQuota(ctx, share)
+		go getDirSizeWithCache(rootCtx, root, func(int64, int64) {})
+	}
+	return session
+}
+
+// stop cancels the scans of the session, they are jobs of their own and
+// would outlive it
+func (s *Session) stop() {
+	s.cancel()
+	for _, root := range s.Roots {
+		scanner.Cancel(root)
+	}
+}
+
+// expireSessions stops and drops the sessions idle for sessionIdleTimeout
+func expireSessions(now time.Time) {
+	var expired []*Session
+	sessions.Lock()
+	for id, s := range sessions.byID {
+		if now.Sub(s.usedAt) >= sessionIdleTimeout {
+			delete(sessions.byID, id)
+			expired = append(expired, s)
+		}
+	}
+	sessions.Unlock()
+	for _, s := range expired {
+		log.Printf("Session %s expired", s.ID)
+		s.stop()
+	}
+}
+
+func (s *Session) status() SessionStatus {
+	status := SessionStatus{
+		ID:        s.ID,
+		CreatedAt: s.CreatedAt,
+		Roots:     make([]SessionRootStatus, 0, len(s.Roots)),
+		Done:      true,
+	}
+	for _, root := range s.Roots {
+		rootStatus := SessionRootStatus{Path: root}
+		if entry := GlobalCache.GetEntry(root); entry != nil {
+			rootStatus.Size, rootStatus.Count = entry.Usage()
+			rootStatus.Done = entry.IsDone()
+		}
+		if !rootStatus.Done {
+			status.Done = false
+		}
+		status.TotalSize += rootStatus.Size
+		status.Roots = append(status.Roots, rootStatus)
+	}
+	return status
+}
+
+func handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Roots) == 0 {
+		http.Error(w, "roots is required", http.StatusBadRequest)
+		return
+	}
+	roots := make([]string, 0, len(req.Roots))
+	for _, root := range req.Roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid root %s: %v", root, err), http.StatusBadRequest)
+			return
+		}
+		roots = append(roots, absRoot)
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		http.Error(w, "Failed to create session id: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	expireSessions(time.Now())
+	session := startSession(id, roots)
+	sessions.Lock()
+	sessions.byID[session.ID] = session
+	sessions.Unlock()
+	log.Printf("Started session %s for roots: %v", session.ID, roots)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session.status())
+}
+
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	expireSessions(time.Now())
+	sessions.Lock()
+	list := make([]*Session, 0, len(sessions.byID))
+	for _, s := range sessions.byID {
+		list = append(list, s)
+	}
+	sessions.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	statuses := make([]SessionStatus, 0, len(list))
+	for _, s := range list {
+		statuses = append(statuses, s.status())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func handleGetSession(w http.ResponseWriter, r *http.Request) {
+	session := lookupSession(w, r)
+	if session == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session.status())
+}
+
+func handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := lookupSession(w, r)
+	if session == nil {
+		return
+	}
+
+	sessions.Lock()
+	delete(sessions.byID, session.ID)
+	sessions.Unlock()
+	session.stop()
+
+	w.Write([]byte("ok"))
+}
+
+// lookupSession finds the session by the id query param, writing an error
+// if not found, and keeps it from expiring
+func lookupSession(w http.ResponseWriter, r *http.Request) *Session {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return nil
+	}
+	expireSessions(time.Now())
+	sessions.Lock()
+	session := sessions.byID[id]
+	if session != nil {
+		session.usedAt = time.Now()
+	}
+	sessions.Unlock()
+	if session == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil
+	}
+	return session
+}
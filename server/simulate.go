@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/fsstat"
+)
+
+// maxSimulatePaths caps the selection of one simulation
+const maxSimulatePaths = 1000
+
+type SimulateRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// SimulatedPath is what deleting one selected path would free. Bytes of
+// hard linked files are attributed to the first selected path linking them.
+type SimulatedPath struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	DiskSize int64  `json:"diskSize"`
+	Files    int64  `json:"files"`
+	// CoveredBy is the selected ancestor that already includes this path,
+	// it adds nothing on its own
+	CoveredBy string `json:"coveredBy,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type SimulateResponse struct {
+	Paths []SimulatedPath `json:"paths"`
+	// Reclaimable and ReclaimableDisk are the logical and allocated bytes freed
+	Reclaimable     int64 `json:"reclaimable"`
+	ReclaimableDisk int64 `json:"reclaimableDisk"`
+	Files           int64 `json:"files"`
+	// HardLinkRetained are bytes of selected files that stay on disk
+	// because they have hard links outside the selection
+	HardLinkRetained int64 `json:"hardLinkRetained"`
+	// Denied is the number of directories that could not be read,
+	// their content is missing from the totals
+	Denied int `json:"denied"`
+}
+
+// linkedFile is a hard linked file found in the selection
+type linkedFile struct {
+	owner    int // index of the path it is attributed to
+	seen     uint64
+	nlink    uint64
+	size     int64
+	diskSize int64
+}
+
+// handleSimulate computes how much space deleting a selection would
+// free, without deleting anything. Selected paths inside other selected
+// paths are not counted twice, and hard linked files only count when
+// all their links are selected.
+func handleSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) == 0 {
+		http.Error(w, "paths is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) > maxSimulatePaths {
+		http.Error(w, "too many paths", http.StatusBadRequest)
+		return
+	}
+
+	resp := SimulateResponse{Paths: make([]SimulatedPath, 0, len(req.Paths))}
+	for _, path := range req.Paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Paths = append(resp.Paths, SimulatedPath{Path: absPath})
+	}
+
+	// shallowest first, so that ancestors are known before their descendants
+	order := make([]int, len(resp.Paths))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(resp.Paths[order[i]].Path) < len(resp.Paths[order[j]].Path)
+	})
+	var selected []string
+	for _, i := range order {
+		p := &resp.Paths[i]
+		for _, s := range selected {
+			if p.Path == s || strings.HasPrefix(p.Path, strings.TrimSuffix(s, string(os.PathSeparator))+string(os.PathSeparator)) {
+				p.CoveredBy = s
+				break
+			}
+		}
+		if p.CoveredBy == "" {
+			selected = append(selected, p.Path)
+		}
+	}
+
+	links := make(map[inodeKey]*linkedFile)
+	for i := range resp.Paths {
+		p := &resp.Paths[i]
+		if p.CoveredBy != "" {
+			continue
+		}
+		info, err := os.Lstat(scan.LocalPath(p.Path))
+		if err != nil {
+			p.Error = err.Error()
+			continue
+		}
+		simulateFile(p, i, info, links)
+		if info.IsDir() {
+			simulateDir(r.Context(), p, i, p.Path, links, &resp.Denied)
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+
+	for _, f := range links {
+		p := &resp.Paths[f.owner]
+		if f.seen < f.nlink {
+			resp.HardLinkRetained += f.size
+			continue
+		}
+		p.Size += f.size
+		p.DiskSize += f.diskSize
+	}
+	for _, p := range resp.Paths {
+		resp.Reclaimable += p.Size
+		resp.ReclaimableDisk += p.DiskSize
+		resp.Files += p.Files
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func simulateDir(ctx context.Context, p *SimulatedPath, owner int, dir string, links map[inodeKey]*linkedFile, denied *int) {
+	if ctx.Err() != nil {
+		return
+	}
+	entries, err := readDir(dir)
+	if err != nil {
+		*denied++
+		return
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			simulateDir(ctx, p, owner, path, links, denied)
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		simulateFile(p, owner, info, links)
+	}
+}
+
+// simulateFile accounts one file, hard linked files are only counted
+// once all their links are known
+func simulateFile(p *SimulatedPath, owner int, info os.FileInfo, links map[inodeKey]*linkedFile) {
+	if info.IsDir() {
+		return
+	}
+	p.Files++
+	st, ok := fsstat.Of(info)
+	if !ok || st.Nlink <= 1 || st.Ino == 0 {
+		p.Size += info.Size()
+		p.DiskSize += fileDiskSize(info)
+		return
+	}
+	key := inodeKey{dev: st.Dev, ino: st.Ino}
+	f := links[key]
+	if f == nil {
+		f = &linkedFile{owner: owner, nlink: st.Nlink, size: info.Size(), diskSize: fileDiskSize(info)}
+		links[key] = f
+	}
+	f.seen++
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// SizeFormat renders byte counts for display, e.g. "1,5 GB" or "1.4 GiB"
+type SizeFormat struct {
+	// Binary uses powers of 1024 (KiB, MiB) as Windows does,
+	// otherwise powers of 1000 (kB, MB) as Finder and most Linux tools do
+	Binary  bool
+	Decimal string // decimal separator
+	Group   string // thousands separator
+}
+
+// DefaultSizeUnits is "si", "binary" or empty. When set, every usage
+// stream includes formatted sizes, not only those asking for it.
+var DefaultSizeUnits string
+
+// localeSeparators maps a language to its decimal and thousands
+// separators, languages not listed use "." and ","
+var localeSeparators = map[string][2]string{
+	"de":    {",", "."},
+	"es":    {",", "."},
+	"it":    {",", "."},
+	"nl":    {",", "."},
+	"pt":    {",", "."},
+	"tr":    {",", "."},
+	"id":    {",", "."},
+	"da":    {",", "."},
+	"fr":    {",", " "},
+	"ru":    {",", " "},
+	"pl":    {",", " "},
+	"cs":    {",", " "},
+	"sv":    {",", " "},
+	"fi":    {",", " "},
+	"nb":    {",", " "},
+	"uk":    {",", " "},
+	"de-ch": {".", "’"},
+	"hi":    {".", ","},
+	"ja":    {".", ","},
+	"zh":    {".", ","},
+	"ko":    {".", ","},
+}
+
+// ParseSizeUnits validates units, empty defaults to the convention of the OS
+func ParseSizeUnits(units string) (string, error) {
+	switch units {
+	case "":
+		if runtime.GOOS == "windows" {
+			return "binary", nil
+		}
+		return "si", nil
+	case "si", "binary":
+		return units, nil
+	default:
+		return "", fmt.Errorf("invalid units: %s, must be si or binary", units)
+	}
+}
+
+// parseSizeFormat reads the units and locale query parameters. It returns
+// nil when neither is given and DefaultSizeUnits is not set. The locale
+// falls back to Accept-Language, then to the server's LANG.
+func parseSizeFormat(r *http.Request) (*SizeFormat, error) {
+	query := r.URL.Query()
+	units, locale := query.Get("units"), query.Get("locale")
+	if units == "" && locale == "" && DefaultSizeUnits == "" {
+		return nil, nil
+	}
+	if units == "" {
+		units = DefaultSizeUnits
+	}
+	units, err := ParseSizeUnits(units)
+	if err != nil {
+		return nil, err
+	}
+	if locale == "" {
+		locale = r.Header.Get("Accept-Language")
+	}
+	if locale == "" {
+		locale = os.Getenv("LANG")
+	}
+	f := &SizeFormat{Binary: units == "binary", Decimal: ".", Group: ","}
+	if sep, ok := lookupLocale(locale); ok {
+		f.Decimal, f.Group = sep[0], sep[1]
+	}
+	return f, nil
+}
+
+// lookupLocale finds the separators of the first tag of locale,
+// which may be a BCP 47 tag, an Accept-Language list or a POSIX locale
+func lookupLocale(locale string) ([2]string, bool) {
+	tag, _, _ := strings.Cut(locale, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	tag, _, _ = strings.Cut(tag, ".") // de_DE.UTF-8
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if sep, ok := localeSeparators[tag]; ok {
+		return sep, true
+	}
+	lang, region, _ := strings.Cut(tag, "-")
+	if sep, ok := localeSeparators[lang+"-"+region]; ok {
+		return sep, true
+	}
+	sep, ok := localeSeparators[lang]
+	return sep, ok
+}
+
+var (
+	siUnits     = []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+	binaryUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+)
+
+// Format renders n with three significant digits, e.g. 1.23 GB, 12.3 GB, 123 GB
+func (f *SizeFormat) Format(n int64) string {
+	base, units := 1000.0, siUnits
+	if f.Binary {
+		base, units = 1024.0, binaryUnits
+	}
+	v := math.Abs(float64(n))
+	i := 0
+	for v >= base && i < len(units)-1 {
+		v /= base
+		i++
+	}
+	if n < 0 {
+		v = -v
+	}
+	if i == 0 {
+		return f.group(strconv.FormatInt(n, 10)) + " " + units[0]
+	}
+	decimals := 0
+	switch av := math.Abs(v); {
+	case av < 10:
+		decimals = 2
+	case av < 100:
+		decimals = 1
+	}
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	intPart, frac, hasFrac := strings.Cut(s, ".")
+	s = f.group(intPart)
+	if hasFrac {
+		s += f.Decimal + frac
+	}
+	return s + " " + units[i]
+}
+
+// group inserts the thousands separator into a formatted integer
+func (f *SizeFormat) group(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + f.Group + s[i:]
+	}
+	return sign + s
+}
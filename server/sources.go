@@ -0,0 +1,410 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/archive"
+	"disk-usage-analyser/server/remote"
+	"disk-usage-analyser/server/s3"
+	"disk-usage-analyser/server/vfs"
+)
+
+// source is a file system other than the local one, browsed like a
+// directory through the usual APIs: a bucket, a remote host, an archive
+// or an ncdu export. Paths of a source are URLs below its root, its
+// scanner knows them by the path after the root, e.g. s3://bucket/logs
+// is /logs of the source s3://bucket.
+type source struct {
+	root    string
+	scanner *scan.Scanner
+	// size and modTime are those of the local file of an archive or an
+	// export, the source is read again once the file changes
+	size    int64
+	modTime time.Time
+	// usedAt is when the source was last opened, guarded by sources.Mutex
+	usedAt time.Time
+}
+
+// sourceIdleTimeout is how long a source that no request opened and no
+// job scans stays open, see evictSources
+const sourceIdleTimeout = 30 * time.Minute
+
+// sourceScheme splits the URLs of a scheme into the root of their source
+// and the path within it, and opens the file system of a root
+type sourceScheme struct {
+	split func(url string) (root, path string, err error)
+	open  func(ctx context.Context, root string) (scan.FS, error)
+	// fromFile is set for sources read from a local file, the path after the scheme
+	fromFile bool
+	// remote is set for sources reached over the network with the
+	// credentials of the server, only operators may open them
+	remote bool
+}
+
+var sourceSchemes = map[string]sourceScheme{
+	s3.Scheme:     {split: splitS3URL, open: openS3, remote: true},
+	remote.Scheme: {split: splitRemoteURL, open: openRemote, remote: true},
+	"archive://":  {split: splitFileURL("archive://"), open: openArchive, fromFile: true},
+	"ncdu://":     {split: splitFileURL("ncdu://"), open: openNcdu, fromFile: true},
+}
+
+// errSourceForbidden refuses a remote source to a viewer, who would read
+// s3 buckets and ssh hosts with the credentials of the server
+var errSourceForbidden = errors.New("remote sources require the " + string(RoleOperator) + " role")
+
+// pathErrorStatus is the status answering an error of resolvePath
+func pathErrorStatus(err error) int {
+	if errors.Is(err, errSourceForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// scanErrorStatus is the status answering a failed scan of a requested path
+func scanErrorStatus(err error) int {
+	if errors.Is(err, errSourceForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+var sources = struct {
+	sync.Mutex
+	byRoot map[string]*source
+	// opening are the sources being opened, the callers asking for one
+	// meanwhile wait for it rather than open it again
+	opening map[string]*sourceOpen
+	evictor sync.Once
+}{
+	byRoot:  make(map[string]*source),
+	opening: make(map[string]*sourceOpen),
+}
+
+// sourceOpen is the opening of a source, done is closed once src or err
+// is set. waiters are the callers waiting for it, cancel stops it once the
+// last one left.
+type sourceOpen struct {
+	done    chan struct{}
+	src     *source
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// isSourceURL reports whether path names a source rather than a local path
+func isSourceURL(path string) bool {
+	_, ok := schemeOf(path)
+	return ok
+}
+
+func schemeOf(path string) (sourceScheme, bool) {
+	i := strings.Index(path, "://")
+	if i < 0 {
+		return sourceScheme{}, false
+	}
+	scheme, ok := sourceSchemes[path[:i+3]]
+	return scheme, ok
+}
+
+// resolvePath returns the scanner reading a path of a request and the
+// path that scanner knows it by. Local paths are made absolute and read
+// by the scanner of profile, URLs by the scanner of their source. A viewer
+// of ctx may not open remote sources, see errSourceForbidden; requests of
+// the server itself, without a user, may.
+func resolvePath(ctx context.Context, path string, profile Profile) (*scan.Scanner, string, *source, error) {
+	scheme, ok := schemeOf(path)
+	if !ok {
+		absPath, err := filepath.Abs(scan.CleanPath(path))
+		if err != nil {
+			return nil, "", nil, err
+		}
+		s := scannerFor(profile)
+		if s == scanner {
+			restoreVolumeScans(absPath)
+		}
+		return s, absPath, nil, nil
+	}
+	if u := userOf(ctx); scheme.remote && u != nil && !hasRole(ctx, RoleOperator) {
+		return nil, "", nil, errSourceForbidden
+	}
+	root, inner, err := scheme.split(path)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	src, err := openSource(ctx, scheme, root)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return src.scanner, filepath.FromSlash("/" + vfs.Rel(inner)), src, nil
+}
+
+// openSource returns the source of root, opening it unless it is open
+// and its file, if any, is unchanged. Opening reads the whole archive or
+// export, it runs without holding sources.Mutex and once for the callers
+// asking meanwhile.
+func openSource(ctx context.Context, scheme sourceScheme, root string) (*source, error) {
+	var info os.FileInfo
+	if scheme.fromFile {
+		var err error
+		if info, err = os.Stat(scan.LocalPath(filepath.FromSlash(root[strings.Index(root, "://")+3:]))); err != nil {
+			return nil, err
+		}
+	}
+	sources.evictor.Do(func() { go evictSources() })
+	sources.Lock()
+	if src := sources.byRoot[root]; src != nil && (info == nil || src.size == info.Size() && src.modTime.Equal(info.ModTime())) {
+		src.usedAt = time.Now()
+		sources.Unlock()
+		return src, nil
+	}
+	op := sources.opening[root]
+	if op == nil {
+		var openCtx context.Context
+		op = &sourceOpen{done: make(chan struct{})}
+		openCtx, op.cancel = context.WithCancel(context.Background())
+		sources.opening[root] = op
+		go op.open(openCtx, scheme, root, info)
+	}
+	op.waiters++
+	sources.Unlock()
+	select {
+	case <-op.done:
+		return op.src, op.err
+	case <-ctx.Done():
+		sources.Lock()
+		if op.waiters--; op.waiters == 0 {
+			// the next caller opens root anew
+			if sources.opening[root] == op {
+				delete(sources.opening, root)
+			}
+			op.cancel()
+		}
+		sources.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// open opens root and registers it in place of its previous source, if
+// any, which is closed. It goes on if the caller that started it leaves,
+// until no caller waits for it anymore.
+func (op *sourceOpen) open(ctx context.Context, scheme sourceScheme, root string, info os.FileInfo) {
+	defer close(op.done)
+	defer op.cancel()
+	fsys, err := scheme.open(ctx, root)
+	sources.Lock()
+	defer sources.Unlock()
+	if sources.opening[root] == op {
+		delete(sources.opening, root)
+	}
+	if err != nil {
+		op.err = err
+		return
+	}
+	src := &source{
+		root: root,
+		scanner: scan.New(nil, scan.Options{
+			FS: fsys,
+			OnError: func(dir string, err error) {
+				log.Printf("Error reading %s: %v", dir, err)
+			},
+			NewStats: func() scan.Stats {
+				return &SubtreeStats{}
+			},
+			MinProgressInterval: progressInterval.shortest,
+			MaxProgressInterval: progressInterval.longest,
+		}),
+		usedAt: time.Now(),
+	}
+	if info != nil {
+		src.size, src.modTime = info.Size(), info.ModTime()
+	}
+	if prev := sources.byRoot[root]; prev != nil {
+		go prev.close()
+	}
+	sources.byRoot[root] = src
+	op.src = src
+	log.Printf("Opened %s", root)
+}
+
+// evictSources closes the sources idle for sourceIdleTimeout, so that
+// the ssh sessions of a host and the trees of archives are not kept for
+// the life of the server. Opening one again reads it anew.
+func evictSources() {
+	for {
+		time.Sleep(sourceIdleTimeout / 10)
+		var idle []*source
+		sources.Lock()
+		for root, src := range sources.byRoot {
+			if time.Since(src.usedAt) >= sourceIdleTimeout && len(src.scanner.Jobs()) == 0 {
+				delete(sources.byRoot, root)
+				idle = append(idle, src)
+			}
+		}
+		sources.Unlock()
+		for _, src := range idle {
+			log.Printf("Closing %s, idle since %s", src.root, src.usedAt.Format(time.RFC3339))
+			src.close()
+		}
+	}
+}
+
+// close stops what the file system of s holds open, e.g. ssh sessions
+func (s *source) close() {
+	if c, ok := s.scanner.FS().(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// StorageClasses is the storage class breakdown of a prefix of a bucket,
+// sent as a "storage_classes" event once its listing is done
+type StorageClasses struct {
+	// Total is the number of bytes per storage class below the prefix
+	Total map[string]int64 `json:"total"`
+	// Items is the same breakdown for each subdirectory, files carry
+	// their class in FileInfo.StorageClass
+	Items map[string]map[string]int64 `json:"items"`
+}
+
+// storageClassesOf totals the storage classes below path of s, ok is
+// false outside buckets. The subdirectories are cached by the listing.
+func storageClassesOf(ctx context.Context, s *scan.Scanner, path string) (StorageClasses, bool) {
+	classes := StorageClasses{Items: make(map[string]map[string]int64)}
+	classes.Total = entryStats(s.ScanEntry(ctx, path, func(int64, int64) {})).StorageClasses
+	if len(classes.Total) == 0 {
+		return classes, false
+	}
+	for _, child := range s.Cache().Children(path) {
+		if c := entryStats(child).StorageClasses; len(c) > 0 {
+			classes.Items[child.Name()] = c
+		}
+	}
+	return classes, true
+}
+
+// url returns the URL of a path of the scanner of s
+func (s *source) url(path string) string {
+	if rel := vfs.Rel(path); rel != "" {
+		return s.root + "/" + rel
+	}
+	return s.root
+}
+
+// displayPath is the path shown for a path of scanner s, its URL for sources
+func displayPath(src *source, path string) string {
+	if src == nil {
+		return path
+	}
+	return src.url(path)
+}
+
+// invalidateSource drops the cached sizes of path, it reports whether
+// path is the URL of a source
+func invalidateSource(path string) bool {
+	scheme, ok := schemeOf(path)
+	if !ok {
+		return false
+	}
+	root, inner, err := scheme.split(path)
+	if err != nil {
+		return true
+	}
+	sources.Lock()
+	src := sources.byRoot[root]
+	sources.Unlock()
+	if src != nil {
+		src.scanner.Cache().Invalidate(filepath.FromSlash("/" + vfs.Rel(inner)))
+	}
+	return true
+}
+
+func splitS3URL(url string) (string, string, error) {
+	rest := strings.TrimPrefix(url, s3.Scheme)
+	bucket, path, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("bucket is required: %s", url)
+	}
+	return s3.Scheme + bucket, path, nil
+}
+
+func openS3(ctx context.Context, root string) (scan.FS, error) {
+	return s3.NewFS(s3.FromEnv(), strings.TrimPrefix(root, s3.Scheme)), nil
+}
+
+func splitRemoteURL(url string) (string, string, error) {
+	target, dir, err := remote.ParseURL(url)
+	if err != nil {
+		return "", "", err
+	}
+	return target.URL(), dir, nil
+}
+
+func openRemote(ctx context.Context, root string) (scan.FS, error) {
+	target, _, err := remote.ParseURL(root)
+	if err != nil {
+		return nil, err
+	}
+	return remote.NewFS(target), nil
+}
+
+// splitFileURL splits scheme:///path/to/file/inner at the first
+// component that is a regular file
+func splitFileURL(scheme string) func(url string) (string, string, error) {
+	return func(url string) (string, string, error) {
+		rest := strings.TrimPrefix(url, scheme)
+		names := strings.Split(rest, "/")
+		for i := range names {
+			file := strings.Join(names[:i+1], "/")
+			if file == "" {
+				continue
+			}
+			if info, err := os.Stat(scan.LocalPath(filepath.FromSlash(file))); err == nil && info.Mode().IsRegular() {
+				return scheme + file, strings.Join(names[i+1:], "/"), nil
+			}
+		}
+		return "", "", fmt.Errorf("no file in %s", url)
+	}
+}
+
+func openArchive(ctx context.Context, root string) (scan.FS, error) {
+	file := filepath.FromSlash(strings.TrimPrefix(root, "archive://"))
+	if archive.Kind(file) == "" {
+		return nil, fmt.Errorf("not a zip or tar archive: %s", file)
+	}
+	info, err := os.Stat(scan.LocalPath(file))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readArchive(ctx, file, info)
+	if err != nil {
+		return nil, err
+	}
+	tree := vfs.NewTree()
+	for _, e := range entries {
+		var meta *vfs.Meta
+		if e.CompressedSize > 0 {
+			meta = &vfs.Meta{DiskSize: e.CompressedSize}
+		}
+		tree.Add(e.Name, e.IsDir, e.Size, e.ModTime, meta)
+	}
+	return tree, nil
+}
+
+func openNcdu(ctx context.Context, root string) (scan.FS, error) {
+	f, err := os.Open(scan.LocalPath(filepath.FromSlash(strings.TrimPrefix(root, "ncdu://"))))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return vfs.ReadNcdu(f)
+}
@@ -0,0 +1,83 @@
+// Package spotlight queries the macOS Spotlight index through mdfind,
+// finding large files of a volume without scanning it.
+package spotlight
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+func Supported() bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+	_, err := exec.LookPath("mdfind")
+	return err == nil
+}
+
+// Query builds the mdfind query for files larger than minSize whose name
+// contains pattern, a lower-cased substring or glob, "" matches any name
+func Query(minSize int64, pattern string) string {
+	query := fmt.Sprintf("kMDItemFSSize > %d", minSize)
+	if pattern != "" {
+		// Spotlight globs know * only, the name is compared case insensitively (c)
+		name := strings.NewReplacer(`"`, ``, `\`, ``, "?", "*", "[", "*", "]", "*").Replace(pattern)
+		if !strings.ContainsAny(name, "*") {
+			name = "*" + name + "*"
+		}
+		query += fmt.Sprintf(` && kMDItemFSName == "%s"c`, name)
+	}
+	return query
+}
+
+// Find returns the paths below root the index has for query, at most limit.
+// The index can be stale: callers verify the paths before trusting them.
+func Find(ctx context.Context, root string, query string, limit int) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "mdfind", "-0", "-onlyin", root, query)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var paths []string
+	lines := bufio.NewScanner(out)
+	lines.Buffer(make([]byte, 64*1024), 1024*1024)
+	lines.Split(splitNUL)
+	for lines.Scan() {
+		if lines.Text() == "" {
+			continue
+		}
+		paths = append(paths, lines.Text())
+		if len(paths) >= limit {
+			break
+		}
+	}
+	if len(paths) >= limit {
+		// enough results, mdfind is still listing
+		cmd.Process.Kill()
+		cmd.Wait()
+		return paths, nil
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("mdfind: %v", err)
+	}
+	return paths, nil
+}
+
+func splitNUL(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		if b == 0 {
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
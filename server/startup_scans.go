@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/scan"
+)
+
+// StartupScanRoots are scanned when the server starts, from --startup-scan
+var StartupScanRoots []string
+
+// startupScanRoots are StartupScanRoots followed by those of
+// startup-scans.json, a list of paths where ~ is the home directory,
+// without duplicates
+func startupScanRoots() []string {
+	var configured []string
+	file := configPath("startup-scans.json")
+	if err := loadJSON(file, &configured); err != nil {
+		log.Printf("Error loading startup scans %s: %v", file, err)
+	}
+	home, _ := os.UserHomeDir()
+	var roots []string
+	seen := make(map[string]bool)
+	for _, root := range append(append([]string{}, StartupScanRoots...), configured...) {
+		if home != "" && (root == "~" || strings.HasPrefix(root, "~/")) {
+			root = filepath.Join(home, root[1:])
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			log.Printf("Invalid startup scan %s: %v", root, err)
+			continue
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			roots = append(roots, abs)
+		}
+	}
+	return roots
+}
+
+// StartStartupScans scans the startup roots one after the other at
+// background priority, with the default profile of the usage view, so
+// that they are sized by the time the UI is opened. It waits for the saved
+// scans of mounted volumes to be restored, a root they cover is not
+// scanned again.
+func StartStartupScans() {
+	roots := startupScanRoots()
+	if len(roots) == 0 {
+		return
+	}
+	go func() {
+		checkVolumes()
+		s := scannerFor(DefaultProfile)
+		ctx := scan.WithPriority(context.Background(), scan.Background)
+		for _, root := range roots {
+			if s.Cache().GetEntry(root) != nil {
+				log.Printf("Startup scan of %s: restored", root)
+				continue
+			}
+			if _, err := os.Stat(scan.LocalPath(root)); err != nil {
+				log.Printf("Startup scan of %s: %v", root, err)
+				continue
+			}
+			start := time.Now()
+			size, count := s.Scan(ctx, root, func(int64, int64) {})
+			log.Printf("Startup scan of %s: %d bytes in %d entries, %v", root, size, count, time.Since(start).Round(time.Millisecond))
+		}
+	}()
+}
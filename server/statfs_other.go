@@ -0,0 +1,13 @@
+//go:build !darwin && !linux
+
+package server
+
+import "fmt"
+
+func volumeInodes(path string) (total uint64, free uint64, err error) {
+	return 0, 0, fmt.Errorf("inode usage is not supported on this OS")
+}
+
+func volumeSpace(path string) (total uint64, free uint64, err error) {
+	return 0, 0, fmt.Errorf("volume usage is not supported on this OS")
+}
@@ -0,0 +1,24 @@
+//go:build darwin || linux
+
+package server
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func volumeInodes(path string) (total uint64, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("statfs %s: %v", path, err)
+	}
+	return st.Files, st.Ffree, nil
+}
+
+func volumeSpace(path string) (total uint64, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("statfs %s: %v", path, err)
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bfree * uint64(st.Bsize), nil
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hashedAsset matches the content hashed file names of the vite build,
+// e.g. index-B1a2c3D4.js, which never change and can be cached forever.
+// The hash is base64url: letters, digits, _ and -.
+var hashedAsset = regexp.MustCompile(`-[A-Za-z0-9_-]{8,}\.[A-Za-z0-9]+$`)
+
+// precompressed are the encodings of pre-compressed variants of a file
+// (name.br, name.gz) in order of preference
+var precompressed = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// serveStatic serves name from fsys, as its pre-compressed variant if the
+// client accepts one. It returns false if name does not exist.
+func serveStatic(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) bool {
+	if !fs.ValidPath(name) || name == "." {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return true
+	}
+	file, encoding := openStatic(r, fsys, name)
+	if file == nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	if ct := contentTypeOf(path.Ext(name)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	// by the requested name, /assets/index.js is an alias of the current hashed build
+	if hashedAsset.MatchString(path.Base(r.URL.Path)) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Failed to read asset file", http.StatusInternalServerError)
+			return true
+		}
+		content = bytes.NewReader(data)
+	}
+	// embedded files have no modification time, so no Last-Modified is sent
+	http.ServeContent(w, r, name, time.Time{}, content)
+	return true
+}
+
+// openStatic opens the preferred variant of name the client accepts
+func openStatic(r *http.Request, fsys fs.FS, name string) (fs.File, string) {
+	for _, p := range precompressed {
+		if !acceptsEncoding(r, p.encoding) {
+			continue
+		}
+		if file, err := fsys.Open(name + p.ext); err == nil {
+			return file, p.encoding
+		}
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, ""
+	}
+	return file, ""
+}
+
+// acceptsEncoding reports whether the Accept-Encoding of r allows encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
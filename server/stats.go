@@ -0,0 +1,156 @@
+package server
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/fsstat"
+	"disk-usage-analyser/server/vfs"
+)
+
+// ageBuckets are upper bounds of file age (by mtime), the last bucket is unbounded
+var ageBuckets = []struct {
+	Label  string
+	MaxAge time.Duration
+}{
+	{"<1 week", 7 * 24 * time.Hour},
+	{"<1 month", 30 * 24 * time.Hour},
+	{"<1 year", 365 * 24 * time.Hour},
+	{"older", 0},
+}
+
+const numAgeBuckets = 4
+
+func ageBucket(modTime time.Time, now time.Time) int {
+	age := now.Sub(modTime)
+	for i, b := range ageBuckets {
+		if b.MaxAge == 0 || age < b.MaxAge {
+			return i
+		}
+	}
+	return numAgeBuckets - 1
+}
+
+// SubtreeStats are aggregated over a directory's whole subtree.
+// They are complete once the entry is done.
+type SubtreeStats struct {
+	// OwnerSizes maps uid to the number of bytes in files owned by it
+	OwnerSizes map[uint32]int64
+	// AgeSizes holds bytes per ageBuckets entry
+	AgeSizes [numAgeBuckets]int64
+	// DiskSize is the number of bytes allocated on disk, smaller
+	// than the logical size when transparent compression is used
+	DiskSize int64
+	// CloudSize is the logical size of cloud placeholder files
+	// whose content is not stored locally
+	CloudSize int64
+	// Extensions maps lower-cased file extensions ("" for none) to bytes,
+	// only collected by the deep profile
+	Extensions map[string]int64
+	// XattrSize is the number of bytes in extended attributes and
+	// resource forks of files, only collected by the deep profile
+	XattrSize int64
+	// StorageClasses maps S3 storage classes to bytes, only set for buckets
+	StorageClasses map[string]int64
+
+	collectExtensions bool
+	collectXattrs     bool
+}
+
+func (s *SubtreeStats) AddFile(info fs.FileInfo) {
+	s.AddFileAt("", info)
+}
+
+func (s *SubtreeStats) AddFileAt(path string, info fs.FileInfo) {
+	s.AgeSizes[ageBucket(info.ModTime(), time.Now())] += info.Size()
+	s.DiskSize += fileDiskSize(info)
+	if isPlaceholder(info) {
+		s.CloudSize += info.Size()
+	}
+	if meta := vfs.MetaOf(info); meta != nil && meta.StorageClass != "" {
+		if s.StorageClasses == nil {
+			s.StorageClasses = make(map[string]int64)
+		}
+		s.StorageClasses[meta.StorageClass] += info.Size()
+	}
+	if s.collectExtensions {
+		if s.Extensions == nil {
+			s.Extensions = make(map[string]int64)
+		}
+		s.Extensions[strings.ToLower(filepath.Ext(info.Name()))] += info.Size()
+	}
+	if s.collectXattrs && path != "" {
+		if size, err := fsstat.XattrSize(path); err == nil {
+			s.XattrSize += size
+		}
+	}
+
+	uid, _, ok := fileOwner(info)
+	if !ok {
+		return
+	}
+	if s.OwnerSizes == nil {
+		s.OwnerSizes = make(map[uint32]int64)
+	}
+	s.OwnerSizes[uid] += info.Size()
+}
+
+func (s *SubtreeStats) Add(child scan.Stats) {
+	o, ok := child.(*SubtreeStats)
+	if !ok {
+		return
+	}
+	s.DiskSize += o.DiskSize
+	s.CloudSize += o.CloudSize
+	s.XattrSize += o.XattrSize
+	for i, size := range o.AgeSizes {
+		s.AgeSizes[i] += size
+	}
+	if len(o.OwnerSizes) > 0 && s.OwnerSizes == nil {
+		s.OwnerSizes = make(map[uint32]int64, len(o.OwnerSizes))
+	}
+	for uid, size := range o.OwnerSizes {
+		s.OwnerSizes[uid] += size
+	}
+	if len(o.Extensions) > 0 && s.Extensions == nil {
+		s.Extensions = make(map[string]int64, len(o.Extensions))
+	}
+	for ext, size := range o.Extensions {
+		s.Extensions[ext] += size
+	}
+	if len(o.StorageClasses) > 0 && s.StorageClasses == nil {
+		s.StorageClasses = make(map[string]int64, len(o.StorageClasses))
+	}
+	for class, size := range o.StorageClasses {
+		s.StorageClasses[class] += size
+	}
+}
+
+// StorageClass is the storage class holding most bytes, "" outside buckets
+func (s *SubtreeStats) StorageClass() string {
+	var best string
+	for class, size := range s.StorageClasses {
+		if best == "" || size > s.StorageClasses[best] || size == s.StorageClasses[best] && class < best {
+			best = class
+		}
+	}
+	return best
+}
+
+// OthersSize is the number of bytes in files not owned by the current user
+func (s *SubtreeStats) OthersSize() int64 {
+	me, ok := currentUID()
+	if !ok {
+		return 0
+	}
+	var size int64
+	for uid, n := range s.OwnerSizes {
+		if uid != me {
+			size += n
+		}
+	}
+	return size
+}
@@ -0,0 +1,231 @@
+// Package store knows the libraries that apps manage themselves, like
+// Mail and Photos, whose internals must not be deleted by hand.
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Store is a library managed by an app
+type Store struct {
+	ID   string `json:"id"` // mail or photos
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Warning explains why its content should not be deleted directly,
+	// Cleanup how to reclaim space with the app instead
+	Warning string   `json:"warning"`
+	Cleanup []string `json:"cleanup"`
+}
+
+// Group is a part of a store that can be sized on its own, e.g. a mail account
+type Group struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+const photosExt = ".photoslibrary"
+
+var (
+	mailWarning   = "Mail keeps an index of every message, deleting files inside the library corrupts it and Mail downloads the messages again."
+	photosWarning = "Photos keeps a database of the library, deleting files inside it breaks the library and can lose photos."
+)
+
+func mailStores(homeDir string) []Store {
+	if runtime.GOOS == "darwin" {
+		return []Store{{
+			ID:      "mail",
+			Name:    "Mail",
+			Path:    filepath.Join(homeDir, "Library", "Mail"),
+			Warning: mailWarning,
+			Cleanup: []string{
+				"Delete large messages or whole mailboxes in Mail, then Mailbox > Erase Deleted Items",
+				"Mail > Settings > Accounts: uncheck \"Download Attachments\" or remove accounts you no longer use",
+				"Remove the downloads Mail kept in ~/Library/Containers/com.apple.mail/Data/Library/Mail Downloads",
+			},
+		}}
+	}
+	return []Store{{
+		ID:      "mail",
+		Name:    "Thunderbird",
+		Path:    filepath.Join(homeDir, ".thunderbird"),
+		Warning: "Thunderbird keeps an index (.msf) next to every mailbox, edit mailboxes in Thunderbird only.",
+		Cleanup: []string{
+			"Delete large messages in Thunderbird, then File > Compact Folders",
+			"Account Settings > Synchronization & Storage: keep fewer messages offline",
+		},
+	}}
+}
+
+func photosStore(path string) Store {
+	return Store{
+		ID:      "photos",
+		Name:    "Photos (" + strings.TrimSuffix(filepath.Base(path), photosExt) + ")",
+		Path:    path,
+		Warning: photosWarning,
+		Cleanup: []string{
+			"Delete photos and videos in Photos, then empty the Recently Deleted album",
+			"Photos > Settings > iCloud: choose \"Optimize Mac Storage\" to keep originals in iCloud only",
+			"File > Export the library elsewhere before removing it as a whole",
+		},
+	}
+}
+
+// Find returns the stores that exist on this machine. Photos libraries
+// are looked for in ~/Pictures.
+func Find() ([]Store, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	var stores []Store
+	for _, s := range mailStores(homeDir) {
+		if info, err := os.Stat(s.Path); err == nil && info.IsDir() {
+			stores = append(stores, s)
+		}
+	}
+	if runtime.GOOS == "darwin" {
+		entries, _ := os.ReadDir(filepath.Join(homeDir, "Pictures"))
+		for _, e := range entries {
+			if e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), photosExt) {
+				stores = append(stores, photosStore(filepath.Join(homeDir, "Pictures", e.Name())))
+			}
+		}
+	}
+	return stores, nil
+}
+
+// Of returns the store path is or is inside of, ok is false if there is none.
+// Photos libraries are recognized anywhere by their extension.
+func Of(path string) (Store, bool) {
+	path = filepath.Clean(path)
+	for p := path; ; p = filepath.Dir(p) {
+		if strings.EqualFold(filepath.Ext(p), photosExt) {
+			return photosStore(p), true
+		}
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return Store{}, false
+	}
+	for _, s := range mailStores(homeDir) {
+		if path == s.Path || strings.HasPrefix(path, s.Path+string(os.PathSeparator)) {
+			return s, true
+		}
+	}
+	return Store{}, false
+}
+
+// Groups breaks a store down: mail by account, Photos by the parts of
+// the library (originals, thumbnails, database, ...)
+func (s *Store) Groups() []Group {
+	var groups []Group
+	switch {
+	case s.ID == "photos":
+		for dir, name := range photosParts {
+			path := filepath.Join(s.Path, dir)
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				groups = append(groups, Group{Name: name, Path: path})
+			}
+		}
+	case runtime.GOOS == "darwin":
+		groups = mailAccounts(s.Path)
+	default:
+		groups = thunderbirdAccounts(s.Path)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// photosParts names the top level directories of a Photos library
+var photosParts = map[string]string{
+	"originals":   "Originals",
+	"Masters":     "Originals",
+	"resources":   "Thumbnails and previews",
+	"Thumbnails":  "Thumbnails and previews",
+	"database":    "Database",
+	"private":     "Analysis and faces",
+	"scopes":      "Shared libraries",
+	"external":    "External",
+	"Attachments": "Attachments",
+}
+
+// Originals returns the directory of the original photos and videos
+func (s *Store) Originals() string {
+	for _, dir := range []string{"originals", "Masters"} {
+		path := filepath.Join(s.Path, dir)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// mailAccounts lists the accounts of the newest ~/Library/Mail/V<n>,
+// accounts are directories named by id, older ones IMAP-user@host
+func mailAccounts(mailDir string) []Group {
+	entries, err := os.ReadDir(mailDir)
+	if err != nil {
+		return nil
+	}
+	version, newest := "", 0
+	for _, e := range entries {
+		n, err := strconv.Atoi(strings.TrimPrefix(e.Name(), "V"))
+		if e.IsDir() && strings.HasPrefix(e.Name(), "V") && err == nil && n > newest {
+			version, newest = e.Name(), n
+		}
+	}
+	if version == "" {
+		return nil
+	}
+	dir := filepath.Join(mailDir, version)
+	accounts, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var groups []Group
+	for _, e := range accounts {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		name := e.Name()
+		if name == "MailData" {
+			name = "Mail data and index"
+		}
+		groups = append(groups, Group{Name: name, Path: filepath.Join(dir, e.Name())})
+	}
+	return groups
+}
+
+// thunderbirdAccounts lists the per server directories of every profile
+func thunderbirdAccounts(dir string) []Group {
+	profiles, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var groups []Group
+	for _, p := range profiles {
+		if !p.IsDir() {
+			continue
+		}
+		for _, kind := range []string{"ImapMail", "Mail"} {
+			servers, err := os.ReadDir(filepath.Join(dir, p.Name(), kind))
+			if err != nil {
+				continue
+			}
+			for _, s := range servers {
+				if s.IsDir() {
+					groups = append(groups, Group{Name: s.Name(), Path: filepath.Join(dir, p.Name(), kind, s.Name())})
+				}
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/store"
+)
+
+type StoreGroupUsage struct {
+	store.Group
+	Size int64 `json:"size"`
+}
+
+// YearUsage is the size of the photos and videos of one year,
+// by the modification time of the originals
+type YearUsage struct {
+	Year  int   `json:"year"`
+	Size  int64 `json:"size"`
+	Count int   `json:"count"`
+}
+
+type StoreUsage struct {
+	store.Store
+	Size   int64             `json:"size"`
+	Groups []StoreGroupUsage `json:"groups"` // largest first
+	// ByYear is only set for Photos libraries, newest first
+	ByYear []YearUsage `json:"byYear,omitempty"`
+}
+
+// handleStores reports the libraries managed by Mail and Photos,
+// broken down by account or part of the library and by year
+func handleStores(w http.ResponseWriter, r *http.Request) {
+	stores, err := store.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]StoreUsage, 0, len(stores))
+	for _, s := range stores {
+		usage := StoreUsage{Store: s, Groups: []StoreGroupUsage{}}
+		usage.Size, _ = getDirSizeWithCache(r.Context(), s.Path, func(int64, int64) {})
+		if r.Context().Err() != nil {
+			return
+		}
+		// the scan of the store filled the entries of its groups
+		for _, g := range s.Groups() {
+			usage.Groups = append(usage.Groups, StoreGroupUsage{Group: g, Size: cachedSize(g.Path)})
+		}
+		sort.Slice(usage.Groups, func(i, j int) bool {
+			return usage.Groups[i].Size > usage.Groups[j].Size
+		})
+		if originals := s.Originals(); originals != "" {
+			usage.ByYear = photosByYear(GlobalCache, originals)
+		}
+		resp = append(resp, usage)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkStore refuses to delete path when it is part of a store,
+// the error tells how to reclaim the space in the app instead
+func checkStore(path string) error {
+	s, ok := store.Of(path)
+	if !ok {
+		return nil
+	}
+	return fmt.Errorf("%s is part of the %s library. %s Instead: %s. Pass force to delete it anyway.", path, s.Name, s.Warning, s.Cleanup[0])
+}
+
+// storeOf returns the id of the store path is or is inside of, "" if none
+func storeOf(path string) string {
+	if s, ok := store.Of(path); ok {
+		return s.ID
+	}
+	return ""
+}
+
+// photosByYear buckets the files of originals by year from the cache, the
+// scan of the library filled it, so that polling does not walk the
+// originals again
+func photosByYear(c *scan.Cache, originals string) []YearUsage {
+	years := make(map[int]*YearUsage)
+	for _, entry := range c.Under(originals) {
+		entry.Lock()
+		for _, f := range entry.Files {
+			year := f.ModTime.In(time.Local).Year()
+			y := years[year]
+			if y == nil {
+				y = &YearUsage{Year: year}
+				years[year] = y
+			}
+			y.Size += f.Size
+			y.Count++
+		}
+		entry.Unlock()
+	}
+	result := make([]YearUsage, 0, len(years))
+	for _, y := range years {
+		result = append(result, *y)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Year > result[j].Year })
+	return result
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// deltaMediaType selects the delta encoding through the Accept header,
+// EventSource cannot set headers so encoding=delta does the same
+const deltaMediaType = "application/x-dua-delta"
+
+// ndjsonMediaType is that of streams sent as JSON Lines, one
+// {"event", "data"} object per line instead of server-sent events. It is
+// selected by a path ending in .ndjson or through the Accept header.
+const ndjsonMediaType = "application/x-ndjson"
+
+// ndjsonEvent is an event of a JSON Lines stream
+type ndjsonEvent struct {
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+// streamWriter wraps the response of an SSE stream.
+// It gzips the stream when the client accepts it, and with the
+// delta encoding item events carry a numeric id, the full item
+// is sent the first time and afterwards only the changed fields.
+// Removed fields are sent as null, "remove" events carry the id.
+// With ndjson the events are JSON Lines rather than server-sent events.
+type streamWriter struct {
+	http.ResponseWriter
+	gz     *gzip.Writer
+	delta  bool
+	ndjson bool
+	ids    map[string]int
+	last   map[int]map[string]json.RawMessage
+}
+
+func newStreamWriter(w http.ResponseWriter, r *http.Request) *streamWriter {
+	sw := &streamWriter{ResponseWriter: w}
+	markStream(r)
+	if r.URL.Query().Get("encoding") == "delta" || strings.Contains(r.Header.Get("Accept"), deltaMediaType) {
+		sw.delta = true
+		sw.ids = make(map[string]int)
+		sw.last = make(map[int]map[string]json.RawMessage)
+	}
+	if strings.HasSuffix(r.URL.Path, ".ndjson") || strings.Contains(r.Header.Get("Accept"), ndjsonMediaType) {
+		sw.ndjson = true
+		w.Header().Set("Content-Type", ndjsonMediaType)
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		sw.gz = gzip.NewWriter(w)
+	}
+	return sw
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(enc) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.gz != nil {
+		return s.gz.Write(p)
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *streamWriter) Flush() {
+	if s.gz != nil {
+		s.gz.Flush()
+	}
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the gzip stream, it is safe to call more than once
+func (s *streamWriter) Close() error {
+	if s.gz != nil {
+		return s.gz.Close()
+	}
+	return nil
+}
+
+// encode rewrites the data of an event for the delta encoding
+func (s *streamWriter) encode(event string, data interface{}) interface{} {
+	switch event {
+	case "item":
+		item, ok := data.(FileInfo)
+		if !ok {
+			return data
+		}
+		return s.encodeItem(item)
+	case "remove":
+		d, ok := data.(map[string]string)
+		if !ok {
+			return data
+		}
+		id, ok := s.ids[d["name"]]
+		if !ok {
+			return data
+		}
+		// the client drops the item, send it in full if it comes back
+		delete(s.last, id)
+		return map[string]int{"id": id}
+	}
+	return data
+}
+
+func (s *streamWriter) encodeItem(item FileInfo) interface{} {
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return item
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return item
+	}
+	id, ok := s.ids[item.Name]
+	if !ok {
+		id = len(s.ids) + 1
+		s.ids[item.Name] = id
+	}
+	prev := s.last[id]
+	delta := make(map[string]json.RawMessage, len(fields)+1)
+	for k, v := range fields {
+		if p, ok := prev[k]; !ok || !bytes.Equal(p, v) {
+			delta[k] = v
+		}
+	}
+	for k := range prev {
+		if _, ok := fields[k]; !ok {
+			delta[k] = json.RawMessage("null")
+		}
+	}
+	delta["id"] = json.RawMessage(strconv.Itoa(id))
+	s.last[id] = fields
+	return delta
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/disk"
+	"disk-usage-analyser/server/forecast"
+)
+
+const (
+	// defaultSummaryTop is the number of directories a summary lists by
+	// default, per directory when it has several levels
+	defaultSummaryTop = 10
+	// maxSummaryDepth keeps a summary small, the UI is there for more
+	maxSummaryDepth = 4
+)
+
+// Summary is the essentials of /api/usage and /api/forecast in one small
+// document, for small screens and slow links
+type Summary struct {
+	Path     string          `json:"path"`
+	Size     int64           `json:"size"`
+	SizeText string          `json:"sizeText,omitempty"`
+	Partial  bool            `json:"partial,omitempty"`
+	Volumes  []SummaryVolume `json:"volumes"`
+	// Top are the largest directories directly below Path, with theirs
+	// down to the depth asked for
+	Top []SummaryDir `json:"top"`
+}
+
+type SummaryVolume struct {
+	MountPoint  string `json:"mountPoint"`
+	Size        int64  `json:"size"`
+	Used        int64  `json:"used"`
+	Available   int64  `json:"available"`
+	UsedPercent int    `json:"usedPercent"`
+	// Growth24h and Growth7d are the change of the used space over the
+	// last day and week, absent until the space history is that old
+	Growth24h *int64 `json:"growth24h,omitempty"`
+	Growth7d  *int64 `json:"growth7d,omitempty"`
+	// Text is e.g. "120 GB of 500 GB used, 380 GB free, +1.20 GB in 24h"
+	Text string `json:"text,omitempty"`
+}
+
+type SummaryDir struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	SizeText string `json:"sizeText,omitempty"`
+	// Children are the largest directories below this one, when the
+	// summary has more levels
+	Children []SummaryDir `json:"children,omitempty"`
+}
+
+// growthSince is how much used grew since the last sample at or before
+// now-window, nil without such a sample
+func growthSince(samples []forecast.Sample, used int64, now time.Time, window time.Duration) *int64 {
+	var base *forecast.Sample
+	for i := range samples {
+		if samples[i].Time.After(now.Add(-window)) {
+			break
+		}
+		base = &samples[i]
+	}
+	if base == nil {
+		return nil
+	}
+	growth := used - base.Used
+	return &growth
+}
+
+// summaryDirs returns the top largest directories below dir, each with
+// its own down to depth levels, from the finished scan in c
+func summaryDirs(c *scan.Cache, dir string, depth, top int, format *SizeFormat) []SummaryDir {
+	dirs := []SummaryDir{}
+	for _, child := range c.Children(dir) {
+		size, _ := child.Usage()
+		dirs = append(dirs, SummaryDir{Name: child.Name(), Size: size})
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].Size > dirs[j].Size
+	})
+	if len(dirs) > top {
+		dirs = dirs[:top]
+	}
+	for i := range dirs {
+		if format != nil {
+			dirs[i].SizeText = format.Format(dirs[i].Size)
+		}
+		if depth > 1 {
+			dirs[i].Children = summaryDirs(c, filepath.Join(dir, dirs[i].Name), depth-1, top, format)
+		}
+	}
+	return dirs
+}
+
+// handleSummary returns the volumes with their recent growth and the top
+// directories of path, which is scanned unless cached, blocking until the
+// scan is done. depth=2 also lists the top directories of each of them,
+// and so on, for scripts that would rather not follow a usage stream.
+// Sizes are also formatted when units or locale are given, as for
+// /api/usage.
+func handleSummary(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	if path == "" {
+		path, _ = os.UserHomeDir()
+	}
+	top := defaultSummaryTop
+	if s := query.Get("top"); s != "" {
+		var err error
+		top, err = strconv.Atoi(s)
+		if err != nil || top < 0 {
+			http.Error(w, "Invalid top", http.StatusBadRequest)
+			return
+		}
+	}
+	depth := 1
+	if v := query.Get("depth"); v != "" {
+		var err error
+		depth, err = strconv.Atoi(v)
+		if err != nil || depth < 1 || depth > maxSummaryDepth {
+			http.Error(w, fmt.Sprintf("depth must be between 1 and %d", maxSummaryDepth), http.StatusBadRequest)
+			return
+		}
+	}
+	format, err := parseSizeFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, root, src, err := resolvePath(r.Context(), path, DefaultProfile)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), pathErrorStatus(err))
+		return
+	}
+
+	summary := Summary{Path: displayPath(src, root), Volumes: []SummaryVolume{}, Top: []SummaryDir{}}
+	// the summary still lists directories where df is not available
+	volumes, err := disk.ListVolumes()
+	if err != nil {
+		log.Printf("Error listing volumes: %v", err)
+	}
+	now := time.Now()
+	for _, v := range volumes {
+		sv := SummaryVolume{MountPoint: v.MountPoint, Size: v.Size, Used: v.Used, Available: v.Available}
+		if v.Size > 0 {
+			sv.UsedPercent = int(v.Used * 100 / v.Size)
+		}
+		if history := spaceHistory.history; history != nil {
+			samples := history.Samples(v.MountPoint)
+			sv.Growth24h = growthSince(samples, v.Used, now, 24*time.Hour)
+			sv.Growth7d = growthSince(samples, v.Used, now, 7*24*time.Hour)
+		}
+		if format != nil {
+			sv.Text = format.Format(v.Used) + " of " + format.Format(v.Size) + " used, " + format.Format(v.Available) + " free"
+			if sv.Growth24h != nil {
+				sign := "+"
+				if *sv.Growth24h < 0 {
+					sign = ""
+				}
+				sv.Text += ", " + sign + format.Format(*sv.Growth24h) + " in 24h"
+			}
+		}
+		summary.Volumes = append(summary.Volumes, sv)
+	}
+
+	entry := s.ScanEntry(r.Context(), root, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+	summary.Size, _ = entry.Usage()
+	partial, err := entry.Outcome()
+	if err != nil {
+		http.Error(w, "Failed to scan: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	summary.Partial = partial
+	summary.Top = summaryDirs(s.Cache(), root, depth, top, format)
+	if format != nil {
+		summary.SizeText = format.Format(summary.Size)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		json.NewEncoder(gz).Encode(summary)
+		return
+	}
+	json.NewEncoder(w).Encode(summary)
+}
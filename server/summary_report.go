@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"disk-usage-analyser/server/analyzer"
+	"disk-usage-analyser/server/disk"
+	"disk-usage-analyser/server/forecast"
+	"disk-usage-analyser/server/report"
+)
+
+const (
+	// defaultSummaryReportTop is the number of directories a summary report lists
+	defaultSummaryReportTop = 20
+	// summaryReportMinAge is how old the summary growth is measured from
+	// must be: a summary made again the same day does not reset it, and
+	// weekly reviews compare with the week before
+	summaryReportMinAge = 24 * time.Hour
+	// maxSummaryRecords is the number of past summaries kept per path
+	maxSummaryRecords = 60
+	// maxSummaryRecordDirs is the number of directories a record keeps
+	maxSummaryRecordDirs = 100
+	// maxRecommendations caps the recommendations of a summary report
+	maxRecommendations = 10
+	// fullVolumePercent is the usage from which a volume is recommended
+	fullVolumePercent = 90
+	// soonFull is how close a volume's full date is mentioned
+	soonFull = 30 * 24 * time.Hour
+)
+
+// summaryRecord is what a summary report remembers of a path, to report
+// the growth in the next one
+type summaryRecord struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Size        int64            `json:"size"`
+	Dirs        map[string]int64 `json:"dirs"`
+}
+
+var summaryRecords = struct {
+	sync.Mutex
+	once   sync.Once
+	file   string
+	byPath map[string][]summaryRecord
+}{}
+
+// loadSummaryRecords reads the records file once, callers hold summaryRecords.Mutex
+func loadSummaryRecords() {
+	summaryRecords.once.Do(func() {
+		summaryRecords.file = configPath("summary-reports.json")
+		summaryRecords.byPath = make(map[string][]summaryRecord)
+		if err := loadJSON(summaryRecords.file, &summaryRecords.byPath); err != nil {
+			log.Printf("Error loading summary reports %s: %v", summaryRecords.file, err)
+		}
+	})
+}
+
+func saveSummaryRecords() {
+	if err := saveJSON(summaryRecords.file, summaryRecords.byPath); err != nil {
+		log.Printf("Error saving summary reports: %v", err)
+	}
+}
+
+// recordSummary returns the last record of path at least
+// summaryReportMinAge old, nil if there is none, and records rec. A record
+// younger than that is replaced, so there is about one a day.
+func recordSummary(path string, rec summaryRecord) *summaryRecord {
+	summaryRecords.Lock()
+	defer summaryRecords.Unlock()
+	loadSummaryRecords()
+	records := summaryRecords.byPath[path]
+	var previous *summaryRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if rec.GeneratedAt.Sub(records[i].GeneratedAt) >= summaryReportMinAge {
+			p := records[i]
+			previous = &p
+			break
+		}
+	}
+	if n := len(records); n > 0 && rec.GeneratedAt.Sub(records[n-1].GeneratedAt) < summaryReportMinAge {
+		records = records[:n-1]
+	}
+	records = append(records, rec)
+	if len(records) > maxSummaryRecords {
+		records = records[len(records)-maxSummaryRecords:]
+	}
+	summaryRecords.byPath[path] = records
+	saveSummaryRecords()
+	return previous
+}
+
+// SummaryReportOptions are the options of WriteSummaryReport
+type SummaryReportOptions struct {
+	// Top is the number of directories, defaultSummaryReportTop when 0
+	Top int
+	// Format formats the sizes, in the units of the OS when nil
+	Format *SizeFormat
+	// PDF writes a PDF document instead of Markdown
+	PDF bool
+}
+
+// WriteSummaryReport scans path unless cached and writes its summary
+// report, recording it for the growth of the next one
+func WriteSummaryReport(ctx context.Context, w io.Writer, path string, opts SummaryReportOptions) error {
+	summary, err := buildSummaryReport(ctx, path, opts)
+	if err != nil {
+		return err
+	}
+	if opts.PDF {
+		return summary.PDF(w)
+	}
+	return summary.Markdown(w)
+}
+
+func buildSummaryReport(ctx context.Context, path string, opts SummaryReportOptions) (*report.Summary, error) {
+	s, root, src, err := resolvePath(ctx, path, DefaultProfile)
+	if err != nil {
+		return nil, err
+	}
+	entry := s.ScanEntry(ctx, root, func(int64, int64) {})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	partial, err := entry.Outcome()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Top <= 0 {
+		opts.Top = defaultSummaryReportTop
+	}
+	format := opts.Format
+	if format == nil {
+		units, _ := ParseSizeUnits("")
+		format = &SizeFormat{Binary: units == "binary", Decimal: ".", Group: ","}
+	}
+
+	now := time.Now()
+	summary := &report.Summary{
+		Path:            displayPath(src, root),
+		GeneratedAt:     now,
+		Partial:         partial,
+		Volumes:         []report.SummaryVolume{},
+		Top:             []report.SummaryDir{},
+		Recommendations: []string{},
+		FormatSize:      format.Format,
+	}
+	summary.Size, summary.Count = entry.Usage()
+	if src == nil {
+		summary.Host, _ = os.Hostname()
+		summary.Volumes = summaryReportVolumes(now)
+	}
+
+	var dirs []report.SummaryDir
+	for _, child := range s.Cache().Children(root) {
+		size, _ := child.Usage()
+		dirs = append(dirs, report.SummaryDir{Name: child.Name(), Size: size})
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].Size > dirs[j].Size
+	})
+	rec := summaryRecord{GeneratedAt: now, Size: summary.Size, Dirs: make(map[string]int64)}
+	for _, d := range dirs[:min(len(dirs), maxSummaryRecordDirs)] {
+		rec.Dirs[d.Name] = d.Size
+	}
+	summary.Top = dirs[:min(len(dirs), opts.Top)]
+
+	if previous := recordSummary(summary.Path, rec); previous != nil {
+		summary.PreviousAt = &previous.GeneratedAt
+		growth := summary.Size - previous.Size
+		summary.Growth = &growth
+		for i, d := range summary.Top {
+			if size, ok := previous.Dirs[d.Name]; ok {
+				growth := d.Size - size
+				summary.Top[i].Growth = &growth
+			}
+		}
+	}
+
+	summary.Recommendations = summaryRecommendations(ctx, summary, root, src == nil)
+	return summary, nil
+}
+
+// summaryReportVolumes are the volumes with their growth over the last
+// week and when they would be full at its rate
+func summaryReportVolumes(now time.Time) []report.SummaryVolume {
+	volumes, err := disk.ListVolumes()
+	if err != nil {
+		log.Printf("Error listing volumes: %v", err)
+	}
+	list := []report.SummaryVolume{}
+	for _, v := range volumes {
+		sv := report.SummaryVolume{MountPoint: v.MountPoint, Size: v.Size, Used: v.Used, Available: v.Available}
+		if v.Size > 0 {
+			sv.UsedPercent = int(v.Used * 100 / v.Size)
+		}
+		if history := spaceHistory.history; history != nil {
+			samples := history.Samples(v.MountPoint)
+			sv.Growth7d = growthSince(samples, v.Used, now, 7*24*time.Hour)
+			samples = append(samples, forecast.Sample{Time: now, Used: v.Used, Size: v.Size})
+			if recent := forecast.Forecast(samples, v.Available, now).Recent; recent != nil {
+				sv.FullAt = recent.FullAt
+			}
+		}
+		list = append(list, sv)
+	}
+	return list
+}
+
+// summaryRecommendations names the full volumes, the directories that grew
+// the most and, for local paths, what the analyzers find reclaimable
+func summaryRecommendations(ctx context.Context, summary *report.Summary, root string, local bool) []string {
+	format := summary.FormatSize
+	recommendations := []string{}
+	for _, v := range summary.Volumes {
+		if v.UsedPercent < fullVolumePercent {
+			continue
+		}
+		text := fmt.Sprintf("%s is %d%% full, %s free", v.MountPoint, v.UsedPercent, format(v.Available))
+		if v.FullAt != nil && v.FullAt.Sub(summary.GeneratedAt) < soonFull {
+			text += ", at the rate of the last week it is full by " + v.FullAt.Format("2006-01-02")
+		}
+		recommendations = append(recommendations, text+".")
+	}
+
+	if summary.PreviousAt != nil {
+		grown := make([]report.SummaryDir, 0, len(summary.Top))
+		for _, d := range summary.Top {
+			// a hundredth of the total, smaller growth is not worth a review
+			if d.Growth != nil && *d.Growth > 0 && *d.Growth*100 >= summary.Size {
+				grown = append(grown, d)
+			}
+		}
+		sort.Slice(grown, func(i, j int) bool {
+			return *grown[i].Growth > *grown[j].Growth
+		})
+		for _, d := range grown[:min(len(grown), 3)] {
+			recommendations = append(recommendations, fmt.Sprintf("%s grew by %s since %s, review what was added.",
+				d.Name, format(*d.Growth), summary.PreviousAt.Format("2006-01-02")))
+		}
+	}
+
+	if local {
+		var items []analyzer.Item
+		for _, a := range analyzer.All() {
+			if !a.Detect(root) {
+				continue
+			}
+			rep, err := a.Analyze(ctx, root)
+			if err != nil {
+				log.Printf("Error running analyzer %s on %s: %v", a.Name(), root, err)
+				continue
+			}
+			for _, item := range rep.Items {
+				if item.Reclaimable && item.Size > 0 {
+					items = append(items, item)
+				}
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Size > items[j].Size
+		})
+		for _, item := range items {
+			text := fmt.Sprintf("%s can be cleaned up, %s in %s", item.Name, format(item.Size), item.Path)
+			if item.Note != "" {
+				text += ": " + item.Note
+			}
+			recommendations = append(recommendations, text+".")
+		}
+	}
+	return recommendations[:min(len(recommendations), maxRecommendations)]
+}
+
+// handleSummaryReport scans path unless cached and responds with its
+// summary report, Markdown unless format is pdf
+func handleSummaryReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	opts := SummaryReportOptions{}
+	switch query.Get("format") {
+	case "", "markdown":
+	case "pdf":
+		opts.PDF = true
+	default:
+		http.Error(w, "format must be markdown or pdf", http.StatusBadRequest)
+		return
+	}
+	if v := query.Get("top"); v != "" {
+		top, err := strconv.Atoi(v)
+		if err != nil || top < 1 {
+			http.Error(w, "Invalid top", http.StatusBadRequest)
+			return
+		}
+		opts.Top = top
+	}
+	format, err := parseSizeFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Format = format
+
+	summary, err := buildSummaryReport(r.Context(), path, opts)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		http.Error(w, "Failed to scan: "+err.Error(), scanErrorStatus(err))
+		return
+	}
+	name := strings.Trim(reportFileName.ReplaceAllString(filepath.Base(summary.Path), "_"), "_")
+	if name == "" {
+		name = "root"
+	}
+	file := fmt.Sprintf("disk-usage-summary-%s-%s", name, summary.GeneratedAt.Format("20060102"))
+	if opts.PDF {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+file+`.pdf"`)
+		summary.PDF(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="`+file+`.md"`)
+	summary.Markdown(w)
+}
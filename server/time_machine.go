@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"disk-usage-analyser/server/timemachine"
+)
+
+func handleListBackupExclusions(w http.ResponseWriter, r *http.Request) {
+	if !timemachine.Supported() {
+		http.Error(w, "Time Machine is only available on macOS", http.StatusNotImplemented)
+		return
+	}
+
+	exclusions, err := timemachine.ListExclusions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exclusions)
+}
+
+func handleAddBackupExclusion(w http.ResponseWriter, r *http.Request) {
+	handleModifyBackupExclusion(w, r, timemachine.AddExclusion)
+}
+
+func handleRemoveBackupExclusion(w http.ResponseWriter, r *http.Request) {
+	handleModifyBackupExclusion(w, r, timemachine.RemoveExclusion)
+}
+
+func handleModifyBackupExclusion(w http.ResponseWriter, r *http.Request, modify func(path string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !timemachine.Supported() {
+		http.Error(w, "Time Machine is only available on macOS", http.StatusNotImplemented)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := modify(path); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,97 @@
+package timemachine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+const preferencesPlist = "/Library/Preferences/com.apple.TimeMachine.plist"
+
+// Exclusion is a path excluded from Time Machine backups
+type Exclusion struct {
+	Path string `json:"path"`
+	// Sticky exclusions follow the item when moved (tmutil addexclusion),
+	// non-sticky ones are fixed paths configured in System Settings
+	Sticky bool `json:"sticky"`
+}
+
+func Supported() bool {
+	return runtime.GOOS == "darwin"
+}
+
+// IsExcluded checks paths in a single tmutil call
+func IsExcluded(paths []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(paths))
+	if len(paths) == 0 {
+		return result, nil
+	}
+	output, err := cmd.New().Stderr(io.Discard).Output("tmutil", append([]string{"isexcluded"}, paths...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run tmutil isexcluded: %v", err)
+	}
+	// each line looks like: [Excluded]    /path/to/item
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[Excluded]") {
+			result[strings.TrimSpace(strings.TrimPrefix(line, "[Excluded]"))] = true
+		}
+	}
+	return result, nil
+}
+
+// AddExclusion adds a sticky exclusion, which does not require root
+func AddExclusion(path string) error {
+	var outBuf bytes.Buffer
+	err := cmd.Debug().Stdout(&outBuf).Stderr(&outBuf).Run("tmutil", "addexclusion", path)
+	if err != nil {
+		return fmt.Errorf("failed to add exclusion: %v\nOutput: %s", err, outBuf.String())
+	}
+	return nil
+}
+
+// RemoveExclusion removes a sticky exclusion
+func RemoveExclusion(path string) error {
+	var outBuf bytes.Buffer
+	err := cmd.Debug().Stdout(&outBuf).Stderr(&outBuf).Run("tmutil", "removeexclusion", path)
+	if err != nil {
+		return fmt.Errorf("failed to remove exclusion: %v\nOutput: %s", err, outBuf.String())
+	}
+	return nil
+}
+
+// ListExclusions returns fixed-path exclusions from the Time Machine
+// preferences and sticky exclusions found by Spotlight
+func ListExclusions() ([]Exclusion, error) {
+	var exclusions []Exclusion
+
+	jsonOutput, err := cmd.New().Stderr(io.Discard).Output("plutil", "-extract", "SkipPaths", "json", "-o", "-", preferencesPlist)
+	if err == nil {
+		var skipPaths []string
+		if err := json.Unmarshal([]byte(jsonOutput), &skipPaths); err != nil {
+			return nil, fmt.Errorf("failed to parse SkipPaths: %v", err)
+		}
+		for _, p := range skipPaths {
+			exclusions = append(exclusions, Exclusion{Path: p})
+		}
+	}
+	// missing SkipPaths simply means no fixed-path exclusions
+
+	output, err := cmd.New().Stderr(io.Discard).Output("mdfind", "com_apple_backup_excludeItem = 'com.apple.backupd'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run mdfind: %v", err)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		exclusions = append(exclusions, Exclusion{Path: line, Sticky: true})
+	}
+	return exclusions, nil
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/trash"
+)
+
+type TrashItem struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"isDir"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type TrashLocationUsage struct {
+	trash.Location
+	Size  int64       `json:"size"`
+	Count int64       `json:"count"`
+	Items []TrashItem `json:"items"` // largest first
+}
+
+type TrashResponse struct {
+	Locations []TrashLocationUsage `json:"locations"`
+	Size      int64                `json:"size"`
+}
+
+// trashPreviewWait is how long a preview waits for the scan of a path that
+// is not cached, the scan goes on meanwhile
+const trashPreviewWait = 5 * time.Second
+
+// TrashPreview is what moving a path to the trash would move, for the
+// confirmation. Complete is false when the scan did not finish in time,
+// the numbers are then lower bounds.
+type TrashPreview struct {
+	Path  string `json:"path"`
+	IsDir bool   `json:"isDir"`
+	Size  int64  `json:"size"`
+	// Entries counts the files and directories below Path
+	Entries int64 `json:"entries"`
+	Files   int64 `json:"files"`
+	Dirs    int64 `json:"dirs"`
+	// NewestModTime is the latest modification of Path or anything below it
+	NewestModTime time.Time `json:"newestModTime"`
+	Complete      bool      `json:"complete"`
+	// Store is set when Path is part of an app library, moving it then
+	// needs force
+	Store string `json:"store,omitempty"`
+}
+
+// previewTrash sizes path from the cache, scanning it unless cached
+func previewTrash(ctx context.Context, path string) (TrashPreview, error) {
+	preview := TrashPreview{Path: path}
+	info, err := os.Lstat(scan.LocalPath(path))
+	if err != nil {
+		return preview, err
+	}
+	if err := checkStore(path); err != nil {
+		preview.Store = err.Error()
+	}
+	preview.NewestModTime = info.ModTime()
+	if !info.IsDir() {
+		preview.Size, preview.Entries, preview.Files, preview.Complete = info.Size(), 1, 1, true
+		return preview, nil
+	}
+	preview.IsDir = true
+
+	// the scan outlives the request, the move or another preview reuses it
+	entry := scanner.Start(context.WithoutCancel(ctx), path)
+	select {
+	case <-entry.WaitChan():
+	case <-time.After(trashPreviewWait):
+	case <-ctx.Done():
+		return preview, ctx.Err()
+	}
+	preview.Complete = entry.IsDone()
+	preview.Size, preview.Entries = entry.Usage()
+	for _, e := range GlobalCache.Under(path) {
+		e.Lock()
+		if e != entry {
+			preview.Dirs++
+		}
+		preview.Files += int64(len(e.Files))
+		if e.ModTime.After(preview.NewestModTime) {
+			preview.NewestModTime = e.ModTime
+		}
+		for _, f := range e.Files {
+			if f.ModTime.After(preview.NewestModTime) {
+				preview.NewestModTime = f.ModTime
+			}
+		}
+		e.Unlock()
+	}
+	return preview, nil
+}
+
+type EmptyTrashResponse struct {
+	DryRun bool  `json:"dryRun"`
+	Size   int64 `json:"size"` // bytes expected to be freed
+}
+
+func handleListTrash(w http.ResponseWriter, r *http.Request) {
+	locations, err := trash.Locations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := TrashResponse{Locations: make([]TrashLocationUsage, 0, len(locations))}
+	for _, l := range locations {
+		usage := TrashLocationUsage{Location: l}
+		usage.Size, usage.Count = getDirSizeWithCache(r.Context(), l.ContentDir, func(int64, int64) {})
+		usage.Items = listTrashItems(l.ContentDir)
+		resp.Size += usage.Size
+		resp.Locations = append(resp.Locations, usage)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listTrashItems lists the top level items of a trash,
+// directory sizes come from the finished scan of dir
+func listTrashItems(dir string) []TrashItem {
+	items := []TrashItem{}
+	entries, err := readDir(dir)
+	if err != nil {
+		log.Printf("Error reading %s: %v", dir, err)
+		recordDenied(dir, err)
+		return items
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		item := TrashItem{Name: e.Name(), Size: info.Size(), IsDir: e.IsDir(), ModTime: info.ModTime()}
+		if e.IsDir() {
+			item.Size = 0
+			if entry := GlobalCache.GetEntry(filepath.Join(dir, e.Name())); entry != nil {
+				item.Size, _ = entry.Usage()
+			}
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Size > items[j].Size
+	})
+	return items
+}
+
+func handleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	if !dryRun && r.URL.Query().Get("confirm") != "true" {
+		http.Error(w, "confirm=true is required to empty the trash", http.StatusBadRequest)
+		return
+	}
+
+	locations, err := trash.Locations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := EmptyTrashResponse{DryRun: dryRun}
+	sizes := make([]int64, len(locations))
+	for i, l := range locations {
+		sizes[i], _ = getDirSizeWithCache(r.Context(), l.ContentDir, func(int64, int64) {})
+		resp.Size += sizes[i]
+	}
+
+	if !dryRun {
+		log.Printf("Emptying trash")
+		err := trash.Empty(locations)
+		for i, l := range locations {
+			audit(auditClientOf(r), "empty trash", l.ContentDir, sizes[i], "", err)
+			invalidateCaches(l.ContentDir)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
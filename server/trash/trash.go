@@ -0,0 +1,120 @@
+package trash
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/disk"
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+// Location is the trash directory of the current user on one volume
+type Location struct {
+	Path   string `json:"path"`
+	Volume string `json:"volume"` // mount point, "/" for the home trash
+	// ContentDir holds the trashed items, on Linux it is the
+	// files subdirectory of Path, on macOS it is Path itself
+	ContentDir string `json:"contentDir"`
+}
+
+// Locations returns the existing trash directories of the current user,
+// the home trash and the trash of each other mounted volume
+func Locations() ([]Location, error) {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("trash is not supported on this OS")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %v", err)
+	}
+	uid := strconv.Itoa(os.Getuid())
+
+	var candidates []Location
+	if runtime.GOOS == "darwin" {
+		candidates = append(candidates, Location{Path: filepath.Join(homeDir, ".Trash"), Volume: "/"})
+	} else {
+		dataDir := os.Getenv("XDG_DATA_HOME")
+		if dataDir == "" {
+			dataDir = filepath.Join(homeDir, ".local", "share")
+		}
+		candidates = append(candidates, Location{Path: filepath.Join(dataDir, "Trash"), Volume: "/"})
+	}
+
+	volumes, _ := disk.ListVolumes()
+	for _, v := range volumes {
+		if v.MountPoint == "/" {
+			continue
+		}
+		if runtime.GOOS == "darwin" {
+			candidates = append(candidates, Location{Path: filepath.Join(v.MountPoint, ".Trashes", uid), Volume: v.MountPoint})
+		} else {
+			candidates = append(candidates,
+				Location{Path: filepath.Join(v.MountPoint, ".Trash", uid), Volume: v.MountPoint},
+				Location{Path: filepath.Join(v.MountPoint, ".Trash-"+uid), Volume: v.MountPoint},
+			)
+		}
+	}
+
+	var locations []Location
+	for _, l := range candidates {
+		l.ContentDir = l.Path
+		if runtime.GOOS == "linux" {
+			l.ContentDir = filepath.Join(l.Path, "files")
+		}
+		if st, err := os.Stat(scan.LocalPath(l.ContentDir)); err == nil && st.IsDir() {
+			locations = append(locations, l)
+		}
+	}
+	return locations, nil
+}
+
+// Empty permanently deletes the items in the given trash locations
+func Empty(locations []Location) error {
+	if runtime.GOOS == "darwin" && all(locations) {
+		// Finder also handles items it needs authorization for, but it
+		// empties the trash of every volume
+		return cmd.Debug().Run("osascript", "-e", `tell application "Finder" to empty trash`)
+	}
+	for _, l := range locations {
+		// keep the trash directories, delete the items and their
+		// .trashinfo records, see the freedesktop.org trash spec
+		for _, dir := range []string{l.ContentDir, filepath.Join(l.Path, "info"), filepath.Join(l.Path, "expunged")} {
+			entries, err := os.ReadDir(scan.LocalPath(dir))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return fmt.Errorf("failed to read %s: %v", dir, err)
+			}
+			for _, e := range entries {
+				p := filepath.Join(dir, e.Name())
+				if err := os.RemoveAll(scan.LocalPath(p)); err != nil {
+					return fmt.Errorf("failed to delete %s: %v", p, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// all reports whether locations are every trash location there is
+func all(locations []Location) bool {
+	existing, err := Locations()
+	if err != nil {
+		return false
+	}
+	given := make(map[string]bool, len(locations))
+	for _, l := range locations {
+		given[l.Path] = true
+	}
+	for _, l := range existing {
+		if !given[l.Path] {
+			return false
+		}
+	}
+	return true
+}
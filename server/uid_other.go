@@ -0,0 +1,7 @@
+//go:build !unix
+
+package server
+
+func currentUID() (uint32, bool) {
+	return 0, false
+}
@@ -0,0 +1,9 @@
+//go:build unix
+
+package server
+
+import "os"
+
+func currentUID() (uint32, bool) {
+	return uint32(os.Getuid()), true
+}
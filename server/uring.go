@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sync/atomic"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/uring"
+)
+
+const (
+	// uringEntries is the number of stats submitted with one io_uring_enter
+	uringEntries = 256
+	// uringBenchmarkEntries is how many entries the benchmark lists each way
+	uringBenchmarkEntries = 20000
+)
+
+// uringPool lists directories once EnableIOURing found it faster
+var uringPool *uring.Pool
+
+// uringDisabled is set once the kernel rejected a statx, every directory
+// is read by the portable walker from then on
+var uringDisabled atomic.Bool
+
+// EnableIOURing stats the entries of directories in batches through
+// io_uring, if that is faster than the portable walker on a sample of
+// root. Only statx goes through the ring, directories are opened and
+// listed with openat and getdents as before. It is experimental and
+// Linux only.
+func EnableIOURing(root string) error {
+	if !uring.Supported() {
+		return fmt.Errorf("io_uring is only supported on Linux")
+	}
+	pool, err := uring.NewPool(scan.DefaultConcurrency, uringEntries)
+	if err != nil {
+		return err
+	}
+	// a kernel failing every statx would win the benchmark
+	if _, err := pool.ReadDir(root); errors.Is(err, uring.ErrStatxUnsupported) {
+		pool.Close()
+		return err
+	}
+	portable, batched, entries := pool.Benchmark(root, uringBenchmarkEntries)
+	if batched >= portable {
+		log.Printf("io_uring is not faster on %s (%v vs %v for %d entries), using the portable walker", root, batched, portable, entries)
+		pool.Close()
+		return nil
+	}
+	log.Printf("io_uring enabled: %v vs %v for %d entries of %s", batched, portable, entries, root)
+	uringPool = pool
+	return nil
+}
+
+// uringReadDir lists dir through io_uring, ok is false when disabled or
+// failed, the portable walker then lists it and reports the error
+func uringReadDir(dir string) ([]fs.DirEntry, bool) {
+	if uringPool == nil || uringDisabled.Load() {
+		return nil, false
+	}
+	entries, err := uringPool.ReadDir(dir)
+	if errors.Is(err, uring.ErrStatxUnsupported) && !uringDisabled.Swap(true) {
+		log.Printf("io_uring rejected statx in %s, using the portable walker", dir)
+	}
+	if err != nil {
+		return nil, false
+	}
+	return entries, true
+}
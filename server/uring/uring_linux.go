@@ -0,0 +1,401 @@
+package uring
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"disk-usage-analyser/server/fsstat"
+)
+
+const (
+	opStatx = 21
+
+	enterGetEvents = 1
+
+	registerProbe = 8
+	// opSupported is the flag of an op the kernel implements
+	opSupported = 1
+	probeOps    = 256
+
+	offSQRing = 0
+	offCQRing = 0x8000000
+	offSQEs   = 0x10000000
+
+	sqeSize = 64
+	cqeSize = 16
+)
+
+func Supported() bool {
+	return true
+}
+
+// ErrStatxUnsupported is returned when the kernel rejects the statx op
+// with EINVAL, io_uring should not be used any more then
+var ErrStatxUnsupported = errors.New("io_uring does not support statx")
+
+type sqringOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type cqringOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type params struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  sqringOffsets
+	cqOff                                                                  cqringOffsets
+}
+
+// probe is struct io_uring_probe with room for probeOps ops
+type probe struct {
+	lastOp uint8
+	opsLen uint8
+	resv   uint16
+	resv2  [3]uint32
+	ops    [probeOps]probeOp
+}
+
+type probeOp struct {
+	op    uint8
+	resv  uint8
+	flags uint16
+	resv2 uint32
+}
+
+// probeStatx asks the kernel whether the ring supports the statx op, which
+// io_uring_setup alone does not tell: kernels before 5.6 have io_uring but
+// not statx and fail every submission with EINVAL
+func (r *ring) probeStatx() error {
+	var p probe
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), registerProbe, uintptr(unsafe.Pointer(&p)), probeOps, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("io_uring_register probe: %v", errno)
+	}
+	if p.lastOp < opStatx || p.ops[opStatx].flags&opSupported == 0 {
+		return ErrStatxUnsupported
+	}
+	return nil
+}
+
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64 // addr2: the statx buffer
+	addr        uint64 // the path
+	len         uint32 // the statx mask
+	opFlags     uint32 // the statx flags
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ring is one io_uring instance, it is used by one goroutine at a time
+type ring struct {
+	fd      int
+	entries uint32
+	sqRing  []byte
+	cqRing  []byte
+	sqes    []byte
+	p       params
+}
+
+func newRing(entries uint32) (*ring, error) {
+	r := &ring{}
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&r.p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %v", errno)
+	}
+	r.fd = int(fd)
+	r.entries = r.p.sqEntries
+	var err error
+	r.sqRing, err = unix.Mmap(r.fd, offSQRing, int(r.p.sqOff.array+r.p.sqEntries*4), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err == nil {
+		r.cqRing, err = unix.Mmap(r.fd, offCQRing, int(r.p.cqOff.cqes+r.p.cqEntries*cqeSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	}
+	if err == nil {
+		r.sqes, err = unix.Mmap(r.fd, offSQEs, int(r.p.sqEntries*sqeSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	}
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap io_uring: %v", err)
+	}
+	if err := r.probeStatx(); err != nil {
+		r.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ring) close() {
+	for _, m := range [][]byte{r.sqRing, r.cqRing, r.sqes} {
+		if m != nil {
+			unix.Munmap(m)
+		}
+	}
+	unix.Close(r.fd)
+}
+
+func (r *ring) u32(m []byte, off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&m[off]))
+}
+
+// statx stats names relative to dirfd without following symlinks, one
+// io_uring_enter per batch of ring entries instead of a syscall per name.
+// A completion failing with EINVAL means the kernel does not take the
+// request at all, it returns ErrStatxUnsupported once all completed.
+func (r *ring) statx(dirfd int, names []string, out []unix.Statx_t, errs []error) error {
+	paths := make([][]byte, len(names))
+	for i, name := range names {
+		paths[i] = append([]byte(name), 0)
+	}
+	sqMask := *r.u32(r.sqRing, r.p.sqOff.ringMask)
+	cqMask := *r.u32(r.cqRing, r.p.cqOff.ringMask)
+	sqTail := r.u32(r.sqRing, r.p.sqOff.tail)
+	cqHead := r.u32(r.cqRing, r.p.cqOff.head)
+	cqTail := r.u32(r.cqRing, r.p.cqOff.tail)
+
+	invalid := false
+	for start := 0; start < len(names); start += int(r.entries) {
+		end := min(start+int(r.entries), len(names))
+		tail := atomic.LoadUint32(sqTail)
+		for i := start; i < end; i++ {
+			idx := tail & sqMask
+			e := (*sqe)(unsafe.Pointer(&r.sqes[idx*sqeSize]))
+			*e = sqe{
+				opcode:   opStatx,
+				fd:       int32(dirfd),
+				off:      uint64(uintptr(unsafe.Pointer(&out[i]))),
+				addr:     uint64(uintptr(unsafe.Pointer(&paths[i][0]))),
+				len:      unix.STATX_BASIC_STATS,
+				opFlags:  unix.AT_SYMLINK_NOFOLLOW,
+				userData: uint64(i),
+			}
+			*r.u32(r.sqRing, r.p.sqOff.array+idx*4) = idx
+			tail++
+		}
+		atomic.StoreUint32(sqTail, tail)
+
+		submit, pending := end-start, end-start
+		for pending > 0 {
+			_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(submit), uintptr(pending), enterGetEvents, 0, 0)
+			if errno == unix.EINTR {
+				continue
+			}
+			if errno != 0 {
+				return fmt.Errorf("io_uring_enter: %v", errno)
+			}
+			head := atomic.LoadUint32(cqHead)
+			for head != atomic.LoadUint32(cqTail) {
+				c := (*cqe)(unsafe.Pointer(&r.cqRing[r.p.cqOff.cqes+(head&cqMask)*cqeSize]))
+				if c.res < 0 {
+					errs[c.userData] = syscall.Errno(-c.res)
+					invalid = invalid || syscall.Errno(-c.res) == unix.EINVAL
+				}
+				head++
+				pending--
+			}
+			atomic.StoreUint32(cqHead, head)
+			// everything is submitted, only wait for the rest
+			submit = 0
+		}
+	}
+	// the kernel read and wrote through pointers to these
+	runtime.KeepAlive(paths)
+	runtime.KeepAlive(out)
+	if invalid {
+		return ErrStatxUnsupported
+	}
+	return nil
+}
+
+// Pool hands out rings to concurrent ReadDir calls
+type Pool struct {
+	rings chan *ring
+}
+
+// NewPool creates n rings of entries submission slots each
+func NewPool(n int, entries uint32) (*Pool, error) {
+	p := &Pool{rings: make(chan *ring, n)}
+	for i := 0; i < n; i++ {
+		r, err := newRing(entries)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.rings <- r
+	}
+	return p, nil
+}
+
+func (p *Pool) Close() {
+	for {
+		select {
+		case r := <-p.rings:
+			r.close()
+		default:
+			return
+		}
+	}
+}
+
+// ReadDir lists dir like os.ReadDir, sorted by name, with the stat of
+// every entry done up front through io_uring. Opening and listing dir
+// are ordinary system calls. An entry removed since it was listed is
+// left out, any other failed stat fails the listing.
+func (p *Pool) ReadDir(dir string) ([]fs.DirEntry, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dirEntries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(dirEntries))
+	for i, e := range dirEntries {
+		names[i] = e.Name()
+	}
+	sort.Strings(names)
+
+	stats := make([]unix.Statx_t, len(names))
+	errs := make([]error, len(names))
+	r := <-p.rings
+	err = r.statx(int(f.Fd()), names, stats, errs)
+	p.rings <- r
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(names))
+	for i, name := range names {
+		if errors.Is(errs[i], unix.ENOENT) {
+			// removed since it was listed
+			continue
+		}
+		if errs[i] != nil {
+			return nil, &fs.PathError{Op: "statx", Path: filepath.Join(dir, name), Err: errs[i]}
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(newFileInfo(name, &stats[i])))
+	}
+	return entries, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	stat    *fsstat.Stat
+}
+
+func newFileInfo(name string, st *unix.Statx_t) *fileInfo {
+	mode := fs.FileMode(st.Mode & 0777)
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		mode |= fs.ModeDir
+	case unix.S_IFLNK:
+		mode |= fs.ModeSymlink
+	case unix.S_IFIFO:
+		mode |= fs.ModeNamedPipe
+	case unix.S_IFSOCK:
+		mode |= fs.ModeSocket
+	case unix.S_IFBLK:
+		mode |= fs.ModeDevice
+	case unix.S_IFCHR:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	}
+	if st.Mode&unix.S_ISUID != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if st.Mode&unix.S_ISGID != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if st.Mode&unix.S_ISVTX != 0 {
+		mode |= fs.ModeSticky
+	}
+	return &fileInfo{
+		name:    name,
+		size:    int64(st.Size),
+		mode:    mode,
+		modTime: time.Unix(st.Mtime.Sec, int64(st.Mtime.Nsec)),
+		stat: &fsstat.Stat{
+			UID:    st.Uid,
+			GID:    st.Gid,
+			Blocks: int64(st.Blocks),
+			Dev:    unix.Mkdev(st.Dev_major, st.Dev_minor),
+			Ino:    st.Ino,
+			Nlink:  uint64(st.Nlink),
+		},
+	}
+}
+
+func (f *fileInfo) Name() string       { return f.name }
+func (f *fileInfo) Size() int64        { return f.size }
+func (f *fileInfo) Mode() fs.FileMode  { return f.mode }
+func (f *fileInfo) ModTime() time.Time { return f.modTime }
+func (f *fileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f *fileInfo) Sys() any           { return f.stat }
+
+// Benchmark lists the directories below root, up to limit entries,
+// once through os.ReadDir with a stat per entry and once through the pool
+func (p *Pool) Benchmark(root string, limit int) (portable time.Duration, uring time.Duration, entries int) {
+	var dirs []string
+	queue := []string{root}
+	for len(queue) > 0 && entries < limit {
+		dir := queue[0]
+		queue = queue[1:]
+		list, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, dir)
+		entries += len(list)
+		for _, e := range list {
+			if e.IsDir() {
+				queue = append(queue, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	// the first pass above warmed the dentry cache for both
+	start := time.Now()
+	for _, dir := range dirs {
+		list, _ := os.ReadDir(dir)
+		for _, e := range list {
+			e.Info()
+		}
+	}
+	portable = time.Since(start)
+	start = time.Now()
+	for _, dir := range dirs {
+		p.ReadDir(dir)
+	}
+	uring = time.Since(start)
+	return portable, uring, entries
+}
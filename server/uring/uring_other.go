@@ -0,0 +1,31 @@
+//go:build !linux
+
+package uring
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+func Supported() bool {
+	return false
+}
+
+var ErrStatxUnsupported = errors.New("io_uring is only supported on Linux")
+
+type Pool struct{}
+
+func NewPool(n int, entries uint32) (*Pool, error) {
+	return nil, errors.New("io_uring is only supported on Linux")
+}
+
+func (p *Pool) Close() {}
+
+func (p *Pool) ReadDir(dir string) ([]fs.DirEntry, error) {
+	return nil, errors.New("io_uring is only supported on Linux")
+}
+
+func (p *Pool) Benchmark(root string, limit int) (portable time.Duration, uring time.Duration, entries int) {
+	return 0, 0, 0
+}
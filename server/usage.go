@@ -1,49 +1,105 @@
 package server
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"runtime/debug"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
 var InitialDir string
 
 type FileInfo struct {
-	Name   string `json:"name"`
-	Size   int64  `json:"size"`
-	IsDir  bool   `json:"isDir"`
-	Status string `json:"status"` // "pending", "done"
+	Name          string `json:"name"`
+	Size          int64  `json:"size"`
+	IsDir         bool   `json:"isDir"`
+	Status        string `json:"status"` // "pending", "done"
+	BundleType    string `json:"bundleType,omitempty"`
+	BundleVersion string `json:"bundleVersion,omitempty"`
+	Leaf          bool   `json:"leaf,omitempty"` // directory that should not be descended into, e.g. a bundle
+	// BackupExcluded is set when the directory is excluded from Time Machine
+	BackupExcluded bool      `json:"backupExcluded,omitempty"`
+	ModTime        time.Time `json:"modTime"`
+	// Aggregated marks a synthesized item standing for Count small items,
+	// always named "« small items »"
+	Aggregated bool   `json:"aggregated,omitempty"`
+	Count      int    `json:"count,omitempty"`
+	UID        uint32 `json:"uid"`
+	GID        uint32 `json:"gid"`
+	Owner      string `json:"owner,omitempty"`
+	Group      string `json:"group,omitempty"`
+	Mode       string `json:"mode,omitempty"` // e.g. drwxr-xr-x
+	// OthersSize is the number of bytes in the subtree not owned by the current user
+	OthersSize int64 `json:"othersSize,omitempty"`
+	// Entries is the number of inodes: 1 for files, entries below it for directories
+	Entries int64 `json:"entries"`
+	// DiskSize is the physical size, for directories it is known once done
+	DiskSize   int64 `json:"diskSize"`
+	Compressed bool  `json:"compressed,omitempty"`
+	// Placeholder marks cloud files whose content is not stored locally,
+	// CloudSize is the logical size of such content
+	Placeholder bool  `json:"placeholder,omitempty"`
+	CloudSize   int64 `json:"cloudSize,omitempty"`
+	// SystemDir describes a per-volume system directory, e.g. "Spotlight index",
+	// Unreadable is set when it cannot be read and its size is unknown
+	SystemDir  string `json:"systemDir,omitempty"`
+	Unreadable bool   `json:"unreadable,omitempty"`
+	// Error is set when the directory could not be read, Partial when
+	// part of its subtree could not, its size is then a lower bound
+	Error   string `json:"error,omitempty"`
+	Partial bool   `json:"partial,omitempty"`
+	// Estimated marks an item whose size is derived from the volume usage
+	Estimated bool `json:"estimated,omitempty"`
+	// GitRepo marks a directory holding a .git directory, GitSize is the
+	// size of that .git once the directory is done
+	GitRepo bool  `json:"gitRepo,omitempty"`
+	GitSize int64 `json:"gitSize,omitempty"`
+	// Archive is the format of a zip or tar archive that /api/archive can list
+	Archive string `json:"archive,omitempty"`
+	// DiskImage is the kind of disk image: dmg, sparseimage or sparsebundle
+	DiskImage string `json:"diskImage,omitempty"`
+	// VM is the format of a VM disk image (qcow2, vmdk, ...) or VM bundle (utm, parallels, vmware)
+	VM string `json:"vm,omitempty"`
+	// Store is the app managed library (mail or photos) the item is or is
+	// inside of, see /api/stores
+	Store string `json:"store,omitempty"`
+	// XattrSize is the number of bytes in extended attributes and
+	// resource forks, only counted by the deep profile
+	XattrSize int64 `json:"xattrSize,omitempty"`
+	// SizeText and DiskSizeText are the sizes formatted for display,
+	// only sent when the client asks for units or a locale
+	SizeText     string `json:"sizeText,omitempty"`
+	DiskSizeText string `json:"diskSizeText,omitempty"`
+	// StorageClass is the S3 storage class holding most of the bytes of
+	// an item of a bucket listing
+	StorageClass string `json:"storageClass,omitempty"`
+	// Annotation is the note and labels attached to the item, see /api/annotations
+	Annotation *Annotation `json:"annotation,omitempty"`
+	// Ignored marks an entry of the ignore list, only listed with
+	// showIgnored, it is not scanned and its size is 0
+	Ignored bool `json:"ignored,omitempty"`
 }
 
-// Semaphore to limit concurrent ReadDir operations
-var scanSem = make(chan struct{}, 20)
+// defaultItemUpdateInterval is the minimum time between two progress
+// updates of the same item sent to a client
+const defaultItemUpdateInterval = 250 * time.Millisecond
 
 func handleUsage(w http.ResponseWriter, r *http.Request) {
+	// a panic is reported by withRecovery, after the stream is closed
 	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in handleUsage: %v\nStack: %s", r, debug.Stack())
-			// Try to send error event if possible
-			fmt.Fprintf(w, "event: server_error\ndata: {\"error\": \"Internal Server Error: %v\"}\n\n", r)
+		if sw, ok := w.(*streamWriter); ok {
+			sw.Close()
 		}
 	}()
 
-	// CORS for dev
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if r.Method == "OPTIONS" {
-		return
-	}
-
 	dirPath := r.URL.Query().Get("path")
 	if dirPath == "" {
 		if InitialDir != "" {
@@ -60,7 +116,7 @@ func handleUsage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Ensure absolute path
-	if !filepath.IsAbs(dirPath) {
+	if !filepath.IsAbs(dirPath) && !isSourceURL(dirPath) {
 		absPath, err := filepath.Abs(dirPath)
 		if err != nil {
 			log.Printf("Error resolving absolute path for %s: %v", dirPath, err)
@@ -70,18 +126,73 @@ func handleUsage(w http.ResponseWriter, r *http.Request) {
 		dirPath = absPath
 	}
 
+	descendBundles := r.URL.Query().Get("descendBundles") == "true"
+	showIgnored := r.URL.Query().Get("showIgnored") == "true"
+	profile, err := ParseProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	viewOpts, err := parseViewOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	viewOpts.Format, err = parseSizeFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	view := newUsageView(viewOpts)
+	// the scanner of a source, e.g. s3://bucket, knows dirPath by scanPath
+	s, scanPath, src, err := resolvePath(r.Context(), dirPath, profile)
+	if err != nil {
+		http.Error(w, err.Error(), pathErrorStatus(err))
+		return
+	}
+	// terminals asking for text get a du like table once the scan is done
+	if wantsPlainText(r) {
+		writeUsageText(w, r, s, scanPath, src, viewOpts.Format)
+		return
+	}
+	updateInterval := defaultItemUpdateInterval
+	if s := r.URL.Query().Get("updateInterval"); s != "" {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms < 0 {
+			http.Error(w, "Invalid updateInterval", http.StatusBadRequest)
+			return
+		}
+		updateInterval = time.Duration(ms) * time.Millisecond
+	}
+
+	// estimate=count counts the entries in a pre-pass when
+	// no prior scan tells how many to expect
+	estimate := r.URL.Query().Get("estimate")
+	if estimate != "" && estimate != "count" {
+		http.Error(w, "Invalid estimate: "+estimate, http.StatusBadRequest)
+		return
+	}
+
+	// prefetch=true sends the content of each finished subdirectory as a
+	// "child_detail" event, the scan already sized it
+	prefetch := r.URL.Query().Get("prefetch") == "true"
+
 	log.Printf("Starting usage scan for path: %s", dirPath)
 
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
 	// Set SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-		return
-	}
+	// from here on events go through the negotiated encoding
+	sw := newStreamWriter(w, r)
+	w = sw
+	flusher := http.Flusher(sw)
 
 	// Send path info event
 	if err := sendEvent(w, "path", map[string]string{"path": dirPath}); err != nil {
@@ -89,136 +200,91 @@ func handleUsage(w http.ResponseWriter, r *http.Request) {
 	}
 	flusher.Flush()
 
-	entries, err := os.ReadDir(dirPath)
-	if err != nil {
-		log.Printf("Error reading directory %s: %v", dirPath, err)
-		sendEvent(w, "server_error", map[string]string{"error": err.Error()})
-		return
+	l, watcher, unwatch := watchListing(listingKey{
+		path:           dirPath,
+		descendBundles: descendBundles,
+		profile:        profile,
+		showIgnored:    showIgnored,
+	})
+	defer unwatch()
+	if estimate == "count" {
+		l.startCount()
 	}
-
-	// Identify subdirectories and files
-	var subDirs []fs.DirEntry
-	var files []fs.DirEntry
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			subDirs = append(subDirs, entry)
-		} else {
-			files = append(files, entry)
+	var progressSent Progress
+	detailSent := make(map[string]bool)
+
+	// Stream results as they arrive, held back updates
+	// and windowed views are flushed on each tick
+	ticker := time.NewTicker(viewFlushInterval)
+	defer ticker.Stop()
+	for {
+		tick := false
+		select {
+		case <-r.Context().Done():
+			return
+		case <-watcher.notify:
+		case <-ticker.C:
+			tick = true
 		}
-	}
 
-	// Send all files immediately
-	for _, entry := range files {
-		info, err := entry.Info()
+		items, watchers, done, err := l.drain(watcher, updateInterval)
+		for _, item := range items {
+			if err := view.Send(w, item); err != nil {
+				log.Printf("Client disconnected, stopping scan")
+				return
+			}
+			if prefetch && item.IsDir && !item.Leaf && item.Status == "done" && !detailSent[item.Name] {
+				if detail, ok := childDetail(s, filepath.Join(scanPath, item.Name), item.Name); ok {
+					sendEvent(w, "child_detail", detail)
+					detailSent[item.Name] = true
+				}
+			}
+		}
+		if watchers >= 0 {
+			sendEvent(w, "watchers", map[string]int{"count": watchers})
+		}
 		if err != nil {
-			continue
+			sendEvent(w, "server_error", map[string]string{"error": err.Error()})
+			flusher.Flush()
+			return
 		}
-		sendEvent(w, "item", FileInfo{
-			Name:   entry.Name(),
-			Size:   info.Size(),
-			IsDir:  false,
-			Status: "done",
-		})
-	}
-	// Send all directories immediately with pending status
-	for _, entry := range subDirs {
-		sendEvent(w, "item", FileInfo{
-			Name:   entry.Name(),
-			Size:   0,
-			IsDir:  true,
-			Status: "pending",
-		})
-	}
-	flusher.Flush()
-
-	// Channel to collect results from workers
-	resultChan := make(chan FileInfo)
-	var wg sync.WaitGroup
-	// Limit concurrency for top level response handling
-	// Note: scanDirRecursive now handles its own concurrency,
-	// but we still want to limit how many `getDirSizeWithCache` we invoke concurrently from here
-	// to avoid overwhelming the system if a folder has 10k subfolders.
-	sem := make(chan struct{}, 20)
-
-	// Create cancellable context
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
-
-	// Start workers for directories
-	for _, dir := range subDirs {
-		wg.Add(1)
-		go func(d fs.DirEntry) {
-			defer wg.Done()
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("Panic in worker for %s: %v", d.Name(), r)
-				}
-			}()
-
-			// Acquire semaphore
-			select {
-			case sem <- struct{}{}:
-			case <-ctx.Done():
-				return
+		if done || tick {
+			if p := l.progress(); p != progressSent && (p.Entries > 0 || p.Done) {
+				sendEvent(w, "progress", p)
+				progressSent = p
 			}
-			defer func() { <-sem }()
-
-			fullPath := filepath.Join(dirPath, d.Name())
-
-			onProgress := func(currentSize int64) {
-				select {
-				case resultChan <- FileInfo{
-					Name:   d.Name(),
-					Size:   currentSize,
-					IsDir:  true,
-					Status: "pending",
-				}:
-				case <-ctx.Done():
+		}
+		if done {
+			view.Flush(w)
+			if src != nil {
+				if classes, ok := storageClassesOf(r.Context(), s, scanPath); ok {
+					sendEvent(w, "storage_classes", classes)
 				}
 			}
-
-			// Use the smart cache-aware scanner
-			size := getDirSizeWithCache(ctx, fullPath, onProgress)
-
-			select {
-			case resultChan <- FileInfo{
-				Name:   d.Name(),
-				Size:   size,
-				IsDir:  true,
-				Status: "done",
-			}:
-			case <-ctx.Done():
+			summary := l.usageSummary()
+			if viewOpts.Format != nil {
+				summary.SizeText = viewOpts.Format.Format(summary.Size)
+				summary.DiskSizeText = viewOpts.Format.Format(summary.DiskSize)
 			}
-		}(dir)
-	}
-
-	// Closer routine
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	// Stream results as they arrive
-	for item := range resultChan {
-		if err := sendEvent(w, "item", item); err != nil {
-			log.Printf("Client disconnected, stopping scan")
+			sendEvent(w, "summary", summary)
+			sendEvent(w, "done", nil)
+			flusher.Flush()
 			return
 		}
+		if view.windowed() {
+			if !tick {
+				continue
+			}
+			if err := view.Flush(w); err != nil {
+				log.Printf("Client disconnected, stopping scan")
+				return
+			}
+		}
 		flusher.Flush()
 	}
-
-	sendEvent(w, "done", nil)
-	flusher.Flush()
 }
 
 func handleMoveToTrash(w http.ResponseWriter, r *http.Request) {
-	// CORS
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if r.Method == "OPTIONS" {
-		return
-	}
-
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -230,34 +296,64 @@ func handleMoveToTrash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if runtime.GOOS == "darwin" {
-		// Use AppleScript to move to trash via Finder
-		// Escape double quotes in path
-		escapedPath := strings.ReplaceAll(path, "\"", "\\\"")
-		script := fmt.Sprintf(`tell application "Finder" to move POSIX file "%s" to trash`, escapedPath)
-		cmd := exec.Command("osascript", "-e", script)
-		out, err := cmd.CombinedOutput()
+	// dryRun answers with what would be moved, for the confirmation
+	if r.URL.Query().Get("dryRun") == "true" {
+		preview, err := previewTrash(r.Context(), path)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Trash failed: %v, %s", err, string(out)), http.StatusInternalServerError)
+			if os.IsNotExist(err) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	} else {
-		// Fallback or error?
-		// User requested safer delete.
-		http.Error(w, "Move to trash not supported on this OS", http.StatusNotImplemented)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	if r.URL.Query().Get("force") != "true" {
+		if err := checkStore(path); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	size := auditSize(path)
+	err := moveToTrash(path)
+	audit(auditClientOf(r), "trash", path, size, "", err)
+	if err != nil {
+		if errors.Is(err, errTrashUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func handleRefresh(w http.ResponseWriter, r *http.Request) {
-	// CORS
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if r.Method == "OPTIONS" {
-		return
+var errTrashUnsupported = errors.New("Move to trash not supported on this OS")
+
+func moveToTrash(path string) error {
+	if runtime.GOOS != "darwin" {
+		// Fallback or error?
+		// User requested safer delete.
+		return errTrashUnsupported
+	}
+	// Use AppleScript to move to trash via Finder
+	// Escape double quotes in path
+	escapedPath := strings.ReplaceAll(path, "\"", "\\\"")
+	script := fmt.Sprintf(`tell application "Finder" to move POSIX file "%s" to trash`, escapedPath)
+	cmd := exec.Command("osascript", "-e", script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Trash failed: %v, %s", err, string(out))
 	}
+	return nil
+}
 
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -270,7 +366,7 @@ func handleRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Ensure absolute path
-	if !filepath.IsAbs(path) {
+	if !filepath.IsAbs(path) && !isSourceURL(path) {
 		absPath, err := filepath.Abs(path)
 		if err != nil {
 			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
@@ -280,160 +376,26 @@ func handleRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("Invalidating cache for path: %s", path)
-	GlobalCache.Invalidate(path)
+	invalidateCaches(path)
 
 	w.WriteHeader(http.StatusOK)
 }
 
 func sendEvent(w http.ResponseWriter, event string, data interface{}) error {
-	jsonData, _ := json.Marshal(data)
-	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonData)
+	if sw, ok := w.(*streamWriter); ok && sw.delta {
+		data = sw.encode(event, data)
+	}
+	var err error
+	if sw, ok := w.(*streamWriter); ok && sw.ndjson {
+		line, _ := json.Marshal(ndjsonEvent{Event: event, Data: data})
+		_, err = w.Write(append(line, '\n'))
+	} else {
+		jsonData, _ := json.Marshal(data)
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonData)
+	}
 	if err != nil {
 		log.Printf("Error sending event %s: %v", event, err)
 		return err
 	}
 	return nil
 }
-
-// getDirSizeWithCache checks the cache first. If scanning is needed, it performs it.
-// If scanning is already in progress (by another request/worker), it subscribes to it.
-func getDirSizeWithCache(ctx context.Context, path string, onProgress func(int64)) int64 {
-	entry, exists := GlobalCache.GetOrCreateEntry(path)
-
-	if !exists {
-		// We own it. Start scanning in background.
-		go scanDirRecursive(ctx, path, entry)
-	}
-
-	// Subscribe to progress updates
-	unsubscribe := entry.Subscribe(func(s int64) {
-		onProgress(s)
-	})
-	defer unsubscribe()
-
-	// Wait until done or context cancelled
-	select {
-	case <-entry.doneCh:
-		return entry.Size
-	case <-ctx.Done():
-		return entry.Size
-	}
-}
-
-// scanDirRecursive implements a recursive scan to correctly handle cache population
-// It updates the entry in real-time as subdirectories are scanned.
-func scanDirRecursive(ctx context.Context, dirPath string, entry *CacheEntry) {
-	defer entry.MarkDone()
-
-	// Acquire semaphore for IO (ReadDir)
-	select {
-	case scanSem <- struct{}{}:
-	case <-ctx.Done():
-		return
-	}
-
-	entries, err := os.ReadDir(dirPath)
-	// Release semaphore
-	<-scanSem
-
-	if err != nil {
-		log.Printf("Error reading %s: %v", dirPath, err)
-		return
-	}
-
-	var (
-		mu          sync.Mutex
-		filesSize   int64
-		subDirSizes = make(map[string]int64)
-		dirty       bool
-		wg          sync.WaitGroup
-	)
-
-	// Ticker to push updates to entry
-	ticker := time.NewTicker(200 * time.Millisecond)
-	doneCh := make(chan struct{})
-
-	go func() {
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-doneCh:
-				return
-			case <-ticker.C:
-				mu.Lock()
-				if dirty {
-					total := filesSize
-					for _, s := range subDirSizes {
-						total += s
-					}
-					entry.UpdateSize(total)
-					dirty = false
-				}
-				mu.Unlock()
-			}
-		}
-	}()
-
-	updateLocal := func(name string, size int64) {
-		mu.Lock()
-		subDirSizes[name] = size
-		dirty = true
-		mu.Unlock()
-	}
-
-	for _, e := range entries {
-		if ctx.Err() != nil {
-			break
-		}
-
-		if !e.IsDir() {
-			info, err := e.Info()
-			if err == nil {
-				mu.Lock()
-				filesSize += info.Size()
-				dirty = true
-				mu.Unlock()
-			}
-		} else {
-			subPath := filepath.Join(dirPath, e.Name())
-			subName := e.Name()
-
-			wg.Add(1)
-
-			// Handle subdirectories
-			subEntry, exists := GlobalCache.GetOrCreateEntry(subPath)
-
-			if !exists {
-				// We start it
-				go scanDirRecursive(ctx, subPath, subEntry)
-			}
-
-			// Subscribe to changes
-			unsub := subEntry.Subscribe(func(size int64) {
-				updateLocal(subName, size)
-			})
-
-			// Wait for done to decrement WG
-			go func() {
-				defer wg.Done()
-				defer unsub() // Unsubscribe when done waiting
-				subEntry.Wait()
-			}()
-		}
-	}
-
-	// Wait for all children to complete
-	wg.Wait()
-	close(doneCh)
-
-	// Final update
-	mu.Lock()
-	total := filesSize
-	for _, s := range subDirSizes {
-		total += s
-	}
-	entry.UpdateSize(total)
-	mu.Unlock()
-}
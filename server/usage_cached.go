@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"disk-usage-analyser/scan"
+)
+
+// UsageResponse is the cached listing of a directory
+type UsageResponse struct {
+	Path      string     `json:"path"`
+	TotalSize int64      `json:"totalSize"`
+	Items     []FileInfo `json:"items"`
+	// Done is set once the scan of path finished, only then the response has an ETag
+	Done bool `json:"done"`
+}
+
+// cachedItems lists the files and subdirectories of path known to the
+// cache of s, without reading the disk. done reports whether the scan of
+// path finished, it is false when path is not cached.
+func cachedItems(s *scan.Scanner, path string) (items []FileInfo, done bool) {
+	entry := s.Cache().GetEntry(path)
+	if entry == nil {
+		return nil, false
+	}
+	items = []FileInfo{}
+	entry.Lock()
+	done = entry.Done
+	for _, f := range entry.Files {
+		items = append(items, FileInfo{
+			Name:    f.Name,
+			Size:    f.Size,
+			Status:  "done",
+			ModTime: f.ModTime,
+			Entries: 1,
+		})
+	}
+	entry.Unlock()
+	for _, child := range s.Cache().Children(path) {
+		item := FileInfo{Name: child.Name(), IsDir: true, Status: "pending"}
+		child.Lock()
+		item.Size, item.Entries, item.ModTime, item.Partial = child.Size, child.Count, child.ModTime, child.Partial
+		if child.Done {
+			item.Status = "done"
+		}
+		child.Unlock()
+		stats := entryStats(child)
+		item.DiskSize = stats.DiskSize
+		item.StorageClass = stats.StorageClass()
+		items = append(items, item)
+	}
+	return items, done
+}
+
+// handleUsageCached returns the cached listing of path without scanning.
+// Finished listings carry an ETag of the cache generation of path and of
+// the annotations, so polling with If-None-Match costs a 304 until
+// something below path or an annotation changes.
+func handleUsageCached(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = InitialDir
+	}
+	profile, err := ParseProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, scanPath, src, err := resolvePath(r.Context(), path, profile)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), pathErrorStatus(err))
+		return
+	}
+	path = displayPath(src, scanPath)
+
+	// taken before reading, so a change while reading yields a new ETag next time
+	gen := s.Cache().Generation(scanPath)
+	annotationsGen := annotationsGeneration()
+	items, done := cachedItems(s, scanPath)
+	if items == nil {
+		http.Error(w, "not scanned: "+path, http.StatusNotFound)
+		return
+	}
+	if src == nil {
+		if byName := annotationsIn(scanPath); byName != nil {
+			for i := range items {
+				items[i].Annotation = byName[scan.NormalizePath(items[i].Name)]
+			}
+		}
+	}
+	if done {
+		etag := `"` + strconv.FormatUint(gen, 36) + "-" + strconv.FormatUint(annotationsGen, 36) + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	resp := UsageResponse{Path: path, Items: items, Done: done}
+	for _, item := range items {
+		resp.TotalSize += item.Size
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
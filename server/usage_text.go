@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"disk-usage-analyser/scan"
+)
+
+// wantsPlainText reports whether a usage request asked for a text table
+// rather than the event stream, e.g. curl -H 'Accept: text/plain'
+func wantsPlainText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/event-stream")
+}
+
+// writeUsageText scans path and writes its entries like du -h | sort -h:
+// one line per directory and file, smallest first, the total last
+func writeUsageText(w http.ResponseWriter, r *http.Request, s *scan.Scanner, path string, src *source, format *SizeFormat) {
+	if format == nil {
+		units, _ := ParseSizeUnits("")
+		format = &SizeFormat{Binary: units == "binary", Decimal: ".", Group: ","}
+	}
+	entry := s.ScanEntry(r.Context(), path, func(int64, int64) {})
+	if r.Context().Err() != nil {
+		return
+	}
+	partial, err := entry.Outcome()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type line struct {
+		size int64
+		name string
+	}
+	var lines []line
+	for _, child := range s.Cache().Children(path) {
+		size, _ := child.Usage()
+		lines = append(lines, line{size, child.Name() + "/"})
+	}
+	entry.Lock()
+	total := entry.Size
+	for _, f := range entry.Files {
+		lines = append(lines, line{f.Size, f.Name})
+	}
+	entry.Unlock()
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].size != lines[j].size {
+			return lines[i].size < lines[j].size
+		}
+		return lines[i].name < lines[j].name
+	})
+
+	sizes := make([]string, len(lines))
+	// fmt pads to a number of runes, separators such as ’ are several bytes
+	width := utf8.RuneCountInString(format.Format(total))
+	for i, l := range lines {
+		sizes[i] = format.Format(l.size)
+		width = max(width, utf8.RuneCountInString(sizes[i]))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for i, l := range lines {
+		fmt.Fprintf(bw, "%*s  %s\n", width, sizes[i], l.name)
+	}
+	fmt.Fprintf(bw, "%*s  %s\n", width, format.Format(total), displayPath(src, path))
+	if partial {
+		fmt.Fprintln(bw, "# some directories could not be read, sizes are lower bounds")
+	}
+	bw.Flush()
+}
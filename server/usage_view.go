@@ -0,0 +1,250 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// viewFlushInterval is how often a windowed view pushes its diff to the client
+const viewFlushInterval = 200 * time.Millisecond
+
+// smallItemsName names the item standing for the items below MinSize. It
+// does not change with their number, Count, so a client updates the item
+// in place instead of removing it and adding another.
+const smallItemsName = "« small items »"
+
+// ViewOptions controls which items of a directory the client receives
+type ViewOptions struct {
+	Sort   string // "size", "name", "mtime", empty means stream everything unsorted
+	Desc   bool
+	Offset int
+	Limit  int // 0 means no limit
+	// MinSize collapses items smaller than it into one synthesized item
+	MinSize int64
+	// Metric is "size" (bytes, default) or "count" (entries), "size" sorting uses it
+	Metric string
+	// Format adds formatted sizes to items, nil sends bytes only
+	Format *SizeFormat
+}
+
+// OtherInfo aggregates the items outside the current window
+type OtherInfo struct {
+	Total int   `json:"total"` // number of items in the directory
+	Count int   `json:"count"` // number of items not sent
+	Size  int64 `json:"size"`  // combined size of items not sent
+	// Entries is the combined entry count of items not sent
+	Entries  int64  `json:"entries"`
+	SizeText string `json:"sizeText,omitempty"`
+}
+
+func parseViewOptions(query url.Values) (ViewOptions, error) {
+	var opts ViewOptions
+	switch sortBy := query.Get("sort"); sortBy {
+	case "":
+	case "size", "mtime":
+		opts.Sort = sortBy
+		opts.Desc = true
+	case "name":
+		opts.Sort = sortBy
+	default:
+		return opts, fmt.Errorf("invalid sort: %s", sortBy)
+	}
+	switch order := query.Get("order"); order {
+	case "":
+	case "asc":
+		opts.Desc = false
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("invalid order: %s", order)
+	}
+	var err error
+	if s := query.Get("offset"); s != "" {
+		opts.Offset, err = strconv.Atoi(s)
+		if err != nil || opts.Offset < 0 {
+			return opts, fmt.Errorf("invalid offset: %s", s)
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		opts.Limit, err = strconv.Atoi(s)
+		if err != nil || opts.Limit < 0 {
+			return opts, fmt.Errorf("invalid limit: %s", s)
+		}
+	}
+	switch metric := query.Get("metric"); metric {
+	case "", "size":
+		opts.Metric = "size"
+	case "count":
+		opts.Metric = metric
+	default:
+		return opts, fmt.Errorf("invalid metric: %s", metric)
+	}
+	if opts.MinSize, err = parseSize(query.Get("minSize")); err != nil {
+		return opts, fmt.Errorf("invalid minSize: %s", query.Get("minSize"))
+	}
+	if (opts.Offset > 0 || opts.Limit > 0) && opts.Sort == "" {
+		// pagination needs a stable order
+		opts.Sort = "name"
+	}
+	return opts, nil
+}
+
+// usageView decides which items are sent to the client.
+// Without options every item is sent as it arrives.
+// With options, items are kept server side and Flush sends
+// only the changes within the window, "remove" events for
+// items that left it, and an "other" event for the rest.
+type usageView struct {
+	opts      ViewOptions
+	items     map[string]FileInfo
+	sent      map[string]FileInfo
+	otherSent *OtherInfo
+}
+
+func newUsageView(opts ViewOptions) *usageView {
+	return &usageView{
+		opts:  opts,
+		items: make(map[string]FileInfo),
+		sent:  make(map[string]FileInfo),
+	}
+}
+
+func (v *usageView) windowed() bool {
+	return v.opts.Sort != "" || v.opts.MinSize > 0
+}
+
+func (v *usageView) Send(w http.ResponseWriter, item FileInfo) error {
+	v.format(&item)
+	if !v.windowed() {
+		return sendEvent(w, "item", item)
+	}
+	v.items[item.Name] = item
+	return nil
+}
+
+func (v *usageView) Flush(w http.ResponseWriter) error {
+	if !v.windowed() {
+		return nil
+	}
+	sorted := make([]FileInfo, 0, len(v.items))
+	small := FileInfo{Name: smallItemsName, Aggregated: true, Status: "done"}
+	for _, item := range v.items {
+		if item.Size < v.opts.MinSize {
+			small.Count++
+			small.Size += item.Size
+			small.Entries += item.Entries
+			if item.Status == "pending" {
+				small.Status = "pending"
+			}
+			continue
+		}
+		sorted = append(sorted, item)
+	}
+	if small.Count > 0 {
+		v.format(&small)
+		sorted = append(sorted, small)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return v.less(&sorted[i], &sorted[j])
+	})
+
+	start := v.opts.Offset
+	if start > len(sorted) {
+		start = len(sorted)
+	}
+	end := len(sorted)
+	if v.opts.Limit > 0 && start+v.opts.Limit < end {
+		end = start + v.opts.Limit
+	}
+	window := sorted[start:end]
+
+	inWindow := make(map[string]bool, len(window))
+	for _, item := range window {
+		inWindow[item.Name] = true
+		if prev, ok := v.sent[item.Name]; ok && prev == item {
+			continue
+		}
+		if err := sendEvent(w, "item", item); err != nil {
+			return err
+		}
+		v.sent[item.Name] = item
+	}
+	for name := range v.sent {
+		if inWindow[name] {
+			continue
+		}
+		if err := sendEvent(w, "remove", map[string]string{"name": name}); err != nil {
+			return err
+		}
+		delete(v.sent, name)
+	}
+
+	other := OtherInfo{Total: len(v.items), Count: len(sorted) - len(window)}
+	for _, item := range sorted[:start] {
+		other.Size += item.Size
+		other.Entries += item.Entries
+	}
+	for _, item := range sorted[end:] {
+		other.Size += item.Size
+		other.Entries += item.Entries
+	}
+	if v.opts.Format != nil {
+		other.SizeText = v.opts.Format.Format(other.Size)
+	}
+	if v.otherSent == nil || *v.otherSent != other {
+		if err := sendEvent(w, "other", other); err != nil {
+			return err
+		}
+		v.otherSent = &other
+	}
+	return nil
+}
+
+// format fills the formatted sizes of item, if the client asked for them
+func (v *usageView) format(item *FileInfo) {
+	if v.opts.Format == nil {
+		return
+	}
+	item.SizeText = v.opts.Format.Format(item.Size)
+	item.DiskSizeText = v.opts.Format.Format(item.DiskSize)
+}
+
+func (v *usageView) less(a, b *FileInfo) bool {
+	var cmp int
+	switch v.opts.Sort {
+	case "size":
+		if v.opts.Metric == "count" {
+			cmp = compareInt64(a.Entries, b.Entries)
+		} else {
+			cmp = compareInt64(a.Size, b.Size)
+		}
+	case "mtime":
+		cmp = a.ModTime.Compare(b.ModTime)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.Name, b.Name)
+		if v.opts.Sort != "name" {
+			// ties are always broken by ascending name
+			return cmp < 0
+		}
+	}
+	if v.opts.Desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Commit is the git commit of the build, taken from the
+// build info when not set with -ldflags "-X disk-usage-analyser/server.Commit=..."
+var Commit = ""
+
+type VersionInfo struct {
+	App       string `json:"app"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Modified  bool   `json:"modified"` // built from a dirty tree
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	// APIVersion is the version of the HTTP API, see APIPrefix
+	APIVersion string `json:"apiVersion"`
+	// RPCVersion is the version of /api/v1/rpc
+	RPCVersion string          `json:"rpcVersion"`
+	Features   VersionFeatures `json:"features"`
+}
+
+// VersionFeatures are the optional features available on this OS
+type VersionFeatures struct {
+	MoveToTrash bool `json:"moveToTrash"`
+	EmptyTrash  bool `json:"emptyTrash"`
+	Mount       bool `json:"mount"` // mounting and unmounting disks
+	// LiveUpdates is set when usage views stream updates while scanning
+	LiveUpdates      bool `json:"liveUpdates"`
+	PrivilegedHelper bool `json:"privilegedHelper"`
+}
+
+type HealthInfo struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Uptime  string `json:"uptime"`
+	// Listings is the number of usage listings kept in memory
+	Listings int `json:"listings"`
+}
+
+func versionInfo() VersionInfo {
+	info := VersionInfo{
+		App:        AppName,
+		Version:    Version,
+		Commit:     Commit,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		APIVersion: APIVersion,
+		RPCVersion: RPCVersion,
+		Features: VersionFeatures{
+			MoveToTrash:      runtime.GOOS == "darwin",
+			EmptyTrash:       runtime.GOOS == "darwin" || runtime.GOOS == "linux",
+			Mount:            runtime.GOOS == "darwin",
+			LiveUpdates:      true,
+			PrivilegedHelper: PrivilegedClient != nil,
+		},
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range build.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// VersionString is the one-line version printed by --version
+func VersionString() string {
+	info := versionInfo()
+	s := info.App + " " + info.Version
+	if info.Commit != "" {
+		s += " (" + info.Commit
+		if info.Modified {
+			s += ", modified"
+		}
+		s += ")"
+	}
+	return s + " " + info.OS + "/" + info.Arch
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionInfo())
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	listings.Lock()
+	n := len(listings.byKey)
+	listings.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthInfo{
+		Status:   "ok",
+		Version:  Version,
+		Uptime:   time.Since(startedAt).Round(time.Second).String(),
+		Listings: n,
+	})
+}
@@ -0,0 +1,83 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ncduInfo is the object describing a file or directory in an ncdu export
+type ncduInfo struct {
+	Name  string `json:"name"`
+	ASize int64  `json:"asize"`
+	DSize int64  `json:"dsize"`
+	MTime int64  `json:"mtime"`
+}
+
+// ReadNcdu reads the JSON export of ncdu (ncdu -o), e.g. of a server
+// scanned where this program does not run. The scanned directory is the
+// root of the tree.
+func ReadNcdu(r io.Reader) (*Tree, error) {
+	var dump []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, fmt.Errorf("invalid ncdu export: %v", err)
+	}
+	if len(dump) < 4 {
+		return nil, fmt.Errorf("invalid ncdu export: missing root directory")
+	}
+	var major int
+	if err := json.Unmarshal(dump[0], &major); err != nil || major != 1 {
+		return nil, fmt.Errorf("unsupported ncdu export version %s", dump[0])
+	}
+	var root []json.RawMessage
+	if err := json.Unmarshal(dump[3], &root); err != nil || len(root) == 0 {
+		return nil, fmt.Errorf("invalid ncdu export: root is not a directory")
+	}
+	t := NewTree()
+	// the name of the root is the scanned path, its children are the top
+	for _, child := range root[1:] {
+		if err := t.addNcdu("", child); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// addNcdu adds an item of an export below dir: an object for a file, an
+// array of the directory's object followed by its children for a directory
+func (t *Tree) addNcdu(dir string, item json.RawMessage) error {
+	var children []json.RawMessage
+	item = bytes.TrimSpace(item)
+	isDir := len(item) > 0 && item[0] == '['
+	if isDir {
+		if err := json.Unmarshal(item, &children); err != nil || len(children) == 0 {
+			return fmt.Errorf("invalid ncdu export: bad directory below %q", dir)
+		}
+		item = children[0]
+	}
+	var info ncduInfo
+	if err := json.Unmarshal(item, &info); err != nil || info.Name == "" {
+		return fmt.Errorf("invalid ncdu export: bad entry below %q", dir)
+	}
+	path := info.Name
+	if dir != "" {
+		path = dir + "/" + info.Name
+	}
+	var modTime time.Time
+	if info.MTime > 0 {
+		modTime = time.Unix(info.MTime, 0)
+	}
+	if isDir {
+		t.Add(path, true, 0, modTime, nil)
+		for _, child := range children[1:] {
+			if err := t.addNcdu(path, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	t.Add(path, false, info.ASize, modTime, &Meta{DiskSize: info.DSize})
+	return nil
+}
@@ -0,0 +1,170 @@
+// Package vfs builds the entries of file systems other than the local
+// one, so that a scan.FS over a bucket, a remote host or an archive hands
+// the scanner what os.ReadDir would. Tree is such a file system held in
+// memory, for sources that are read all at once.
+package vfs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Meta is what an entry knows beyond fs.FileInfo, returned by its Sys method
+type Meta struct {
+	// DiskSize is the allocated size, the size when 0
+	DiskSize int64
+	// StorageClass is the S3 storage class of an object, e.g. GLACIER
+	StorageClass string
+}
+
+// MetaOf returns the Meta of info, nil for entries of the local file system
+func MetaOf(info fs.FileInfo) *Meta {
+	m, _ := info.Sys().(*Meta)
+	return m
+}
+
+// entry is both the fs.DirEntry and the fs.FileInfo of a file or directory
+type entry struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+	meta    *Meta
+}
+
+// File returns the entry of a file, meta may be nil
+func File(name string, size int64, modTime time.Time, meta *Meta) fs.DirEntry {
+	return &entry{name: name, size: size, modTime: modTime, meta: meta}
+}
+
+// Dir returns the entry of a directory
+func Dir(name string, modTime time.Time) fs.DirEntry {
+	return &entry{name: name, modTime: modTime, isDir: true}
+}
+
+func (e *entry) Name() string               { return e.name }
+func (e *entry) IsDir() bool                { return e.isDir }
+func (e *entry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *entry) Info() (fs.FileInfo, error) { return e, nil }
+func (e *entry) Size() int64                { return e.size }
+func (e *entry) ModTime() time.Time         { return e.modTime }
+
+func (e *entry) Mode() fs.FileMode {
+	if e.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (e *entry) Sys() any {
+	if e.meta == nil {
+		return nil
+	}
+	return e.meta
+}
+
+// Rel turns a path of a scan, e.g. /a/b, into the slash separated path
+// below the root of the source, "" for the root itself
+func Rel(path string) string {
+	return strings.Trim(filepath.ToSlash(path), "/")
+}
+
+// Tree is a file system held in memory
+type Tree struct {
+	root *node
+}
+
+type node struct {
+	entry
+	children map[string]*node
+}
+
+func NewTree() *Tree {
+	return &Tree{root: &node{entry: entry{isDir: true}, children: make(map[string]*node)}}
+}
+
+// Add adds the file or directory at the slash separated path, creating
+// its parent directories. A directory added again keeps its children.
+func (t *Tree) Add(path string, isDir bool, size int64, modTime time.Time, meta *Meta) {
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	if names[0] == "" {
+		return
+	}
+	n := t.root
+	for i, name := range names {
+		child := n.children[name]
+		last := i == len(names)-1
+		if child == nil {
+			child = &node{entry: entry{name: name, isDir: true}}
+			n.children[name] = child
+		}
+		if last {
+			child.isDir, child.size, child.modTime, child.meta = isDir, size, modTime, meta
+		}
+		if child.isDir && child.children == nil {
+			child.children = make(map[string]*node)
+		}
+		n = child
+	}
+}
+
+func (t *Tree) lookup(path string) (*node, error) {
+	n := t.root
+	if rel := Rel(path); rel != "" {
+		for _, name := range strings.Split(rel, "/") {
+			if n = n.child(name); n == nil {
+				return nil, &fs.PathError{Op: "lstat", Path: path, Err: fs.ErrNotExist}
+			}
+		}
+	}
+	return n, nil
+}
+
+// child returns the child named name. Names are kept as they were added,
+// a name in the other Unicode normalization form is found too, e.g. the
+// NFC name typed for the NFD one of an export made on macOS.
+func (n *node) child(name string) *node {
+	if c := n.children[name]; c != nil {
+		return c
+	}
+	for _, form := range []norm.Form{norm.NFC, norm.NFD} {
+		if other := form.String(name); other != name {
+			if c := n.children[other]; c != nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Tree) ReadDir(dir string) ([]fs.DirEntry, error) {
+	n, err := t.lookup(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: os.ErrInvalid}
+	}
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, &child.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (t *Tree) Lstat(path string) (fs.FileInfo, error) {
+	n, err := t.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return &n.entry, nil
+}
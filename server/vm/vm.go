@@ -0,0 +1,320 @@
+// Package vm finds the disk images of virtual machines and container
+// runtimes, and the commands that manage them.
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"disk-usage-analyser/scan"
+)
+
+// imageKinds maps VM disk image extensions to their format. Raw images
+// (.raw, .img) are too generic to tell apart from other files by name.
+var imageKinds = map[string]string{
+	".qcow2": "qcow2",
+	".vmdk":  "vmdk",
+	".vdi":   "vdi",
+	".vhd":   "vhd",
+	".vhdx":  "vhdx",
+	".hdd":   "hdd",
+}
+
+// bundleKinds are the directories holding a whole VM
+var bundleKinds = map[string]string{
+	".utm":      "utm",
+	".pvm":      "parallels",
+	".vmwarevm": "vmware",
+}
+
+// Kind returns the kind of VM disk image or bundle by name, "" if it is none
+func Kind(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if kind, ok := imageKinds[ext]; ok {
+		return kind
+	}
+	return bundleKinds[ext]
+}
+
+// Action is a way to manage a VM, either a command or an app to open
+type Action struct {
+	Label   string   `json:"label"`
+	Command []string `json:"command"`
+}
+
+// VM is one virtual machine (or the single VM of a container runtime)
+type VM struct {
+	Runtime string   `json:"runtime"` // id of the runtime, e.g. lima
+	Name    string   `json:"name"`
+	Path    string   `json:"path"`
+	Actions []Action `json:"actions"`
+}
+
+// vmRuntime describes where a runtime keeps its VMs
+type vmRuntime struct {
+	id   string
+	name string
+	dirs []string
+	// ext selects the entries of dirs that are VMs, "" selects every
+	// subdirectory. single means each dir is one VM itself.
+	ext    string
+	files  bool
+	single bool
+	// vmName turns an entry name into the name the runtime's commands expect
+	vmName  func(entry string) string
+	actions func(name string) []Action
+}
+
+// Runtime is a VM runtime found on this machine
+type Runtime struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Dirs are the existing directories holding its VMs
+	Dirs []string `json:"dirs"`
+}
+
+func knownRuntimes() ([]vmRuntime, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home dir: %v", err)
+	}
+	home := func(elem ...string) string {
+		return filepath.Join(append([]string{homeDir}, elem...)...)
+	}
+	podmanDir := home(".local", "share", "containers", "podman", "machine")
+
+	runtimes := []vmRuntime{
+		{
+			id:   "lima",
+			name: "Lima",
+			dirs: []string{home(".lima")},
+			actions: func(name string) []Action {
+				return []Action{
+					{Label: "Stop", Command: []string{"limactl", "stop", name}},
+					{Label: "Delete", Command: []string{"limactl", "delete", name}},
+					{Label: "Prune caches", Command: []string{"limactl", "prune"}},
+				}
+			},
+		},
+		{
+			id:   "colima",
+			name: "Colima",
+			dirs: []string{home(".colima", "_lima")},
+			// instances are named colima or colima-<profile>
+			vmName: func(entry string) string {
+				if profile, ok := strings.CutPrefix(entry, "colima-"); ok {
+					return profile
+				}
+				return "default"
+			},
+			actions: func(name string) []Action {
+				return []Action{
+					{Label: "Stop", Command: []string{"colima", "stop", "--profile", name}},
+					{Label: "Delete", Command: []string{"colima", "delete", "--profile", name}},
+					{Label: "Prune caches", Command: []string{"colima", "prune"}},
+				}
+			},
+		},
+		{
+			id:    "podman",
+			name:  "Podman machine",
+			dirs:  []string{filepath.Join(podmanDir, "qemu"), filepath.Join(podmanDir, "applehv"), filepath.Join(podmanDir, "libkrun")},
+			files: true,
+			// disk images are named <machine>-<arch>.<ext>
+			vmName: func(entry string) string {
+				name := strings.TrimSuffix(entry, filepath.Ext(entry))
+				for _, arch := range []string{"-arm64", "-amd64", "-aarch64", "-x86_64"} {
+					name = strings.TrimSuffix(name, arch)
+				}
+				return name
+			},
+			actions: func(name string) []Action {
+				return []Action{
+					{Label: "Stop", Command: []string{"podman", "machine", "stop", name}},
+					{Label: "Delete", Command: []string{"podman", "machine", "rm", name}},
+					{Label: "Prune images", Command: []string{"podman", "system", "prune", "--all"}},
+				}
+			},
+		},
+		{
+			id:   "virtualbox",
+			name: "VirtualBox",
+			dirs: []string{home("VirtualBox VMs")},
+			actions: func(name string) []Action {
+				return []Action{
+					{Label: "Delete", Command: []string{"VBoxManage", "unregistervm", name, "--delete"}},
+				}
+			},
+		},
+	}
+	if runtime.GOOS == "darwin" {
+		runtimes = append(runtimes,
+			vmRuntime{
+				id:   "utm",
+				name: "UTM",
+				dirs: []string{home("Library", "Containers", "com.utmapp.UTM", "Data", "Documents")},
+				ext:  ".utm",
+				actions: func(name string) []Action {
+					return []Action{{Label: "Open UTM", Command: []string{"open", "-a", "UTM"}}}
+				},
+			},
+			vmRuntime{
+				id:   "parallels",
+				name: "Parallels Desktop",
+				dirs: []string{home("Parallels")},
+				ext:  ".pvm",
+				actions: func(name string) []Action {
+					return []Action{
+						{Label: "Open Parallels Desktop", Command: []string{"open", "-a", "Parallels Desktop"}},
+						{Label: "Delete", Command: []string{"prlctl", "delete", name}},
+					}
+				},
+			},
+			vmRuntime{
+				id:   "vmware",
+				name: "VMware Fusion",
+				dirs: []string{home("Virtual Machines.localized"), home("Virtual Machines")},
+				ext:  ".vmwarevm",
+				actions: func(name string) []Action {
+					return []Action{{Label: "Open VMware Fusion", Command: []string{"open", "-a", "VMware Fusion"}}}
+				},
+			},
+			vmRuntime{
+				id:     "docker",
+				name:   "Docker Desktop",
+				dirs:   []string{home("Library", "Containers", "com.docker.docker", "Data", "vms")},
+				single: true,
+				actions: func(name string) []Action {
+					return []Action{
+						{Label: "Show usage", Command: []string{"docker", "system", "df"}},
+						{Label: "Prune unused data", Command: []string{"docker", "system", "prune", "--all"}},
+					}
+				},
+			},
+		)
+	} else {
+		runtimes = append(runtimes,
+			vmRuntime{
+				id:   "vmware",
+				name: "VMware Workstation",
+				dirs: []string{home("vmware")},
+				actions: func(name string) []Action {
+					return []Action{{Label: "Open VMware Workstation", Command: []string{"vmware"}}}
+				},
+			},
+			vmRuntime{
+				id:     "docker",
+				name:   "Docker Desktop",
+				dirs:   []string{home(".docker", "desktop", "vms")},
+				single: true,
+				actions: func(name string) []Action {
+					return []Action{
+						{Label: "Show usage", Command: []string{"docker", "system", "df"}},
+						{Label: "Prune unused data", Command: []string{"docker", "system", "prune", "--all"}},
+					}
+				},
+			},
+			vmRuntime{
+				id:    "libvirt",
+				name:  "libvirt",
+				dirs:  []string{"/var/lib/libvirt/images"},
+				files: true,
+				actions: func(name string) []Action {
+					return []Action{{Label: "Delete volume", Command: []string{"virsh", "vol-delete", "--pool", "default", name}}}
+				},
+			},
+		)
+	}
+	return runtimes, nil
+}
+
+// Runtimes returns the runtimes with at least one existing directory
+func Runtimes() ([]Runtime, error) {
+	runtimes, err := knownRuntimes()
+	if err != nil {
+		return nil, err
+	}
+	var result []Runtime
+	for _, rt := range runtimes {
+		var dirs []string
+		for _, dir := range rt.dirs {
+			if info, err := os.Stat(scan.LocalPath(dir)); err == nil && info.IsDir() {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) > 0 {
+			result = append(result, Runtime{ID: rt.id, Name: rt.name, Dirs: dirs})
+		}
+	}
+	return result, nil
+}
+
+// List returns the VMs of all runtimes found on this machine
+func List() ([]VM, error) {
+	runtimes, err := knownRuntimes()
+	if err != nil {
+		return nil, err
+	}
+	var vms []VM
+	for _, rt := range runtimes {
+		for _, dir := range rt.dirs {
+			vms = append(vms, rt.list(dir)...)
+		}
+	}
+	sort.Slice(vms, func(i, j int) bool {
+		if vms[i].Runtime != vms[j].Runtime {
+			return vms[i].Runtime < vms[j].Runtime
+		}
+		return vms[i].Name < vms[j].Name
+	})
+	return vms, nil
+}
+
+func (rt *vmRuntime) list(dir string) []VM {
+	if rt.single {
+		if info, err := os.Stat(scan.LocalPath(dir)); err != nil || !info.IsDir() {
+			return nil
+		}
+		return []VM{rt.vm(dir, rt.name)}
+	}
+	entries, err := os.ReadDir(scan.LocalPath(dir))
+	if err != nil {
+		return nil
+	}
+	var vms []VM
+	for _, e := range entries {
+		// lima keeps its caches and config in _-prefixed directories
+		if strings.HasPrefix(e.Name(), ".") || strings.HasPrefix(e.Name(), "_") {
+			continue
+		}
+		if rt.files == e.IsDir() {
+			continue
+		}
+		if rt.ext != "" && !strings.EqualFold(filepath.Ext(e.Name()), rt.ext) {
+			continue
+		}
+		if rt.files && Kind(e.Name()) == "" && !isRawImage(e.Name()) {
+			continue
+		}
+		vms = append(vms, rt.vm(filepath.Join(dir, e.Name()), e.Name()))
+	}
+	return vms
+}
+
+func (rt *vmRuntime) vm(path string, entry string) VM {
+	name := strings.TrimSuffix(entry, rt.ext)
+	if rt.vmName != nil {
+		name = rt.vmName(entry)
+	}
+	return VM{Runtime: rt.id, Name: name, Path: path, Actions: rt.actions(name)}
+}
+
+// isRawImage accepts raw images inside the directories of a runtime
+func isRawImage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".raw" || ext == ".img"
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/vm"
+)
+
+type VMUsage struct {
+	vm.VM
+	// Size is the logical size, DiskSize what is allocated: VM disks are
+	// usually sparse and grow, but rarely shrink, as the guest writes data
+	Size     int64 `json:"size"`
+	DiskSize int64 `json:"diskSize"`
+}
+
+type VMsResponse struct {
+	VMs      []VMUsage    `json:"vms"` // largest first
+	Runtimes []vm.Runtime `json:"runtimes"`
+	Size     int64        `json:"size"`
+	DiskSize int64        `json:"diskSize"`
+}
+
+// handleVMs lists the virtual machines and container runtime VMs found
+// in the well-known locations, with the commands that manage them
+func handleVMs(w http.ResponseWriter, r *http.Request) {
+	vms, err := vm.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	runtimes, err := vm.Runtimes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := VMsResponse{VMs: make([]VMUsage, 0, len(vms)), Runtimes: runtimes}
+	if resp.Runtimes == nil {
+		resp.Runtimes = []vm.Runtime{}
+	}
+	for _, v := range vms {
+		usage := VMUsage{VM: v}
+		info, err := os.Lstat(scan.LocalPath(v.Path))
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			usage.Size, _ = getDirSizeWithCache(r.Context(), v.Path, func(int64, int64) {})
+			if entry := GlobalCache.GetEntry(v.Path); entry != nil {
+				usage.DiskSize = entryStats(entry).DiskSize
+			}
+		} else {
+			usage.Size = info.Size()
+			usage.DiskSize = fileDiskSize(info)
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+		resp.VMs = append(resp.VMs, usage)
+		resp.Size += usage.Size
+		resp.DiskSize += usage.DiskSize
+	}
+	sort.Slice(resp.VMs, func(i, j int) bool {
+		return resp.VMs[i].DiskSize > resp.VMs[j].DiskSize
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"disk-usage-analyser/server/disk"
+	"disk-usage-analyser/server/forecast"
+)
+
+const (
+	// defaultHistoryDays is the period /volume/history covers by default,
+	// maxHistoryDays is what the space history keeps
+	defaultHistoryDays = 30
+	maxHistoryDays     = 180
+	// defaultMinDrop is how much free space must be lost between two
+	// samples to be a drop
+	defaultMinDrop = 1 << 30
+	// maxDropGap is how far apart two samples may be for the space lost
+	// between them to be sudden, the server may not have run in between
+	maxDropGap = 2 * spaceSampleInterval
+)
+
+// VolumeHistory is the free space of a volume over time
+type VolumeHistory struct {
+	MountPoint string `json:"mountPoint"`
+	// Mounted is false for a volume with samples that is not mounted now,
+	// the last sample of a mounted one is its current space
+	Mounted bool              `json:"mounted"`
+	Samples []VolumeSpace     `json:"samples"`
+	Drops   []VolumeSpaceDrop `json:"drops"`
+}
+
+// VolumeSpace is a sample of a volume
+type VolumeSpace struct {
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+	Used int64     `json:"used"`
+	Free int64     `json:"free"`
+}
+
+// VolumeSpaceDrop is free space lost between two consecutive samples
+type VolumeSpaceDrop struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Bytes int64     `json:"bytes"`
+	// Before and After are the free space at From and To
+	Before int64 `json:"before"`
+	After  int64 `json:"after"`
+}
+
+// volumeHistory returns the samples of mountPoint since since followed by
+// current if not nil, daily keeps the last one of each day
+func volumeHistory(history *forecast.History, mountPoint string, current *forecast.Sample, since time.Time, daily bool, minDrop int64) VolumeHistory {
+	vh := VolumeHistory{MountPoint: mountPoint, Mounted: current != nil, Samples: []VolumeSpace{}, Drops: []VolumeSpaceDrop{}}
+	samples := history.Samples(mountPoint)
+	if current != nil {
+		samples = append(samples, *current)
+	}
+	free := freeSpace(samples)
+	var prev *forecast.Sample
+	for _, s := range samples {
+		if s.Time.Before(since) {
+			continue
+		}
+		if prev != nil && s.Time.Sub(prev.Time) <= maxDropGap {
+			if lost := free(*prev) - free(s); lost >= minDrop {
+				vh.Drops = append(vh.Drops, VolumeSpaceDrop{From: prev.Time, To: s.Time, Bytes: lost, Before: free(*prev), After: free(s)})
+			}
+		}
+		p := s
+		prev = &p
+
+		space := VolumeSpace{Time: s.Time, Size: s.Size, Used: s.Used, Free: free(s)}
+		if n := len(vh.Samples); daily && n > 0 && sameDay(vh.Samples[n-1].Time, s.Time) {
+			vh.Samples[n-1] = space
+			continue
+		}
+		vh.Samples = append(vh.Samples, space)
+	}
+	return vh
+}
+
+// freeSpace returns the free space of a sample of samples. Samples
+// recorded before the available space was have what is not used minus
+// what the last one with it had reserved, for root or as purgeable, so
+// that the free space does not jump where they end.
+func freeSpace(samples []forecast.Sample) func(forecast.Sample) int64 {
+	var reserved int64
+	for i := len(samples) - 1; i >= 0; i-- {
+		if s := samples[i]; s.Available > 0 {
+			reserved = max(s.Size-s.Used-s.Available, 0)
+			break
+		}
+	}
+	return func(s forecast.Sample) int64 {
+		if s.Available > 0 {
+			return s.Available
+		}
+		return max(s.Size-s.Used-reserved, 0)
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Local().Date()
+	by, bm, bd := b.Local().Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// handleVolumeHistory responds with the free space of each volume over the
+// last days, or of mountPoint only, with the drops of at least minDrop
+// bytes between two samples. interval=day keeps a sample per day.
+func handleVolumeHistory(w http.ResponseWriter, r *http.Request) {
+	history := spaceHistory.history
+	if history == nil {
+		http.Error(w, "space history is not recorded", http.StatusServiceUnavailable)
+		return
+	}
+	query := r.URL.Query()
+	days := defaultHistoryDays
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHistoryDays {
+			http.Error(w, "days must be from 1 to "+strconv.Itoa(maxHistoryDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	var daily bool
+	switch query.Get("interval") {
+	case "", "hour":
+	case "day":
+		daily = true
+	default:
+		http.Error(w, "interval must be hour or day", http.StatusBadRequest)
+		return
+	}
+	minDrop := int64(defaultMinDrop)
+	if v := query.Get("minDrop"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid minDrop", http.StatusBadRequest)
+			return
+		}
+		minDrop = n
+	}
+	mountPoint := query.Get("mountPoint")
+
+	mounted := make(map[string]disk.Volume)
+	volumes, err := disk.ListVolumes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, v := range volumes {
+		mounted[v.MountPoint] = v
+	}
+
+	mountPoints := history.MountPoints()
+	for _, v := range volumes {
+		if !slices.Contains(mountPoints, v.MountPoint) {
+			mountPoints = append(mountPoints, v.MountPoint)
+		}
+	}
+	if mountPoint != "" {
+		mountPoints = []string{mountPoint}
+	}
+	now := time.Now()
+	since := now.Add(-time.Duration(days) * 24 * time.Hour)
+	list := make([]VolumeHistory, 0, len(mountPoints))
+	for _, mp := range mountPoints {
+		// the current space counts even between recorded samples
+		var current *forecast.Sample
+		if v, ok := mounted[mp]; ok {
+			current = &forecast.Sample{Time: now, Used: v.Used, Size: v.Size, Available: v.Available}
+		}
+		vh := volumeHistory(history, mp, current, since, daily, minDrop)
+		if len(vh.Samples) == 0 {
+			if mountPoint != "" {
+				http.Error(w, "no history of "+mountPoint, http.StatusNotFound)
+				return
+			}
+			continue
+		}
+		list = append(list, vh)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/disk"
+)
+
+const (
+	// volumeCheckInterval is how often volumes are checked for new mounts
+	// and finished scans to save
+	volumeCheckInterval = 10 * time.Second
+	// volumeRecheckAfter is how old a check may be when a path that is not
+	// cached is about to be scanned
+	volumeRecheckAfter = 2 * time.Second
+)
+
+// volumeUUIDName is what a UUID may look like to name a snapshot file
+var volumeUUIDName = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// volumeSnapshot is the last finished scan of a volume, saved in
+// volumes/<uuid>.json.gz. It is restored wherever the volume is mounted
+// next, unless its used space changed meanwhile.
+type volumeSnapshot struct {
+	UUID       string    `json:"uuid"`
+	MountPoint string    `json:"mountPoint"`
+	Used       int64     `json:"used"`
+	SavedAt    time.Time `json:"savedAt"`
+	// Ignore is the fingerprint of the ignore list the scan left out
+	Ignore string             `json:"ignore,omitempty"`
+	Dirs   []scan.SnapshotDir `json:"dirs"`
+}
+
+// mountedVolume is a volume seen by checkVolumes, uuid is empty for those
+// that cannot be identified and are not saved
+type mountedVolume struct {
+	uuid string
+	used int64
+	// savedGen is the Generation of the mount point when last saved
+	savedGen uint64
+}
+
+var volumeScans = struct {
+	sync.Mutex
+	checkedAt time.Time
+	mounted   map[string]*mountedVolume // by mount point
+	// rootChecked is set once the case sensitivity of / is known
+	rootChecked bool
+}{
+	mounted: make(map[string]*mountedVolume),
+}
+
+// volumeIO serializes the checks and saves of volumes. They list, probe
+// and read or write snapshots of volumes holding it rather than
+// volumeScans.Mutex, that requests take.
+var volumeIO sync.Mutex
+
+// volumeCheckPending is set while a check started by a request runs
+var volumeCheckPending atomic.Bool
+
+func volumeSnapshotFile(uuid string) string {
+	return filepath.Join(configPath("volumes"), uuid+".json.gz")
+}
+
+// StartVolumeScans restores the saved scans of mounted volumes and keeps
+// saving the finished scans of whole volumes, so that a drive plugged in
+// again shows its sizes right away, at whatever mount point
+func StartVolumeScans() {
+	go func() {
+		for {
+			checkVolumes()
+			saveVolumeScans()
+			time.Sleep(volumeCheckInterval)
+		}
+	}()
+}
+
+// restoreVolumeScans checks for newly mounted volumes in the background
+// when path is about to be scanned, unless it is cached or volumes were
+// just checked. A scan restored meanwhile is not overwritten by the one
+// of the request, nor the other way round, see scan.Scanner.Restore.
+func restoreVolumeScans(path string) {
+	if GlobalCache.GetEntry(path) != nil {
+		return
+	}
+	volumeScans.Lock()
+	recent := time.Since(volumeScans.checkedAt) < volumeRecheckAfter
+	volumeScans.Unlock()
+	if !recent && volumeCheckPending.CompareAndSwap(false, true) {
+		go func() {
+			defer volumeCheckPending.Store(false)
+			checkVolumes()
+		}()
+	}
+}
+
+// checkVolumes restores the saved scans of the volumes mounted since the
+// last check, after detecting whether they ignore the case or the Unicode
+// form of names, and drops the cached sizes of those unmounted. The UUIDs
+// of the volumes already known are read again, a drive swapped for
+// another at the same mount point is treated as unmounted and mounted.
+func checkVolumes() {
+	volumeIO.Lock()
+	defer volumeIO.Unlock()
+	volumes, err := disk.ListVolumes()
+	if err != nil {
+		return
+	}
+	volumeScans.Lock()
+	volumeScans.checkedAt = time.Now()
+	rootChecked := volumeScans.rootChecked
+	known := maps.Clone(volumeScans.mounted)
+	volumeScans.Unlock()
+
+	mounted := make(map[string]*mountedVolume, len(volumes))
+	used := make(map[string]int64, len(volumes))
+	for _, v := range volumes {
+		// the system volume changes all the time, it is scanned anew
+		if v.MountPoint == "/" {
+			if !rootChecked {
+				rootChecked = setNameForms(v)
+			}
+			continue
+		}
+		uuid, err := v.UUID()
+		if err == nil && !volumeUUIDName.MatchString(uuid) {
+			uuid = ""
+		}
+		m := known[v.MountPoint]
+		// a UUID that cannot be read now keeps the volume known
+		if m != nil && err == nil && uuid != m.uuid {
+			log.Printf("Another volume is mounted at %s, scanning it again", v.MountPoint)
+			invalidateCaches(v.MountPoint)
+			m = nil
+		}
+		if m == nil {
+			setNameForms(v)
+			m = &mountedVolume{}
+			if err == nil && uuid != "" {
+				m.uuid = uuid
+				restoreVolumeScan(v, uuid)
+			}
+			m.savedGen = GlobalCache.Generation(v.MountPoint)
+		}
+		mounted[v.MountPoint] = m
+		used[v.MountPoint] = v.Used
+	}
+
+	volumeScans.Lock()
+	volumeScans.rootChecked = rootChecked
+	volumeScans.mounted = mounted
+	for mountPoint, m := range mounted {
+		m.used = used[mountPoint]
+	}
+	volumeScans.Unlock()
+	for mountPoint := range known {
+		if mounted[mountPoint] == nil {
+			// another drive may be mounted there next, this one was saved
+			invalidateCaches(mountPoint)
+		}
+	}
+}
+
+// setNameForms makes the caches of local paths key the directories of v
+// by their case folded names if v ignores case, so that /Users/Me and
+// /Users/me are one entry, and by their NFC names if v ignores Unicode
+// normalization. It returns false if neither could be detected, the
+// directories keep the defaults of the system then.
+func setNameForms(v disk.Volume) bool {
+	changed := false
+	insensitive, caseOK := v.CaseInsensitive()
+	if caseOK {
+		for _, ps := range profileScanners {
+			if ps.scanner.Cache().SetCaseInsensitive(v.MountPoint, insensitive) {
+				changed = true
+			}
+		}
+	}
+	nfc, nfcOK := v.NormalizationInsensitive()
+	if nfcOK && scan.SetNormalizationInsensitive(v.MountPoint, nfc) {
+		changed = true
+	}
+	if changed {
+		// scanned again keyed the new way, e.g. for the pins
+		invalidateCaches(v.MountPoint)
+	}
+	return caseOK || nfcOK
+}
+
+// restoreVolumeScan fills the cache below the mount point of v with its
+// saved scan, callers hold volumeIO
+func restoreVolumeScan(v disk.Volume, uuid string) {
+	file := volumeSnapshotFile(uuid)
+	snapshot, err := loadVolumeSnapshot(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error loading volume scan %s: %v", file, err)
+		}
+		return
+	}
+	if snapshot.Used != v.Used {
+		log.Printf("Volume %s changed since its scan of %s, scanning it again", v.MountPoint, snapshot.SavedAt.Format(time.RFC3339))
+		return
+	}
+	if snapshot.Ignore != ignoreFingerprint() {
+		log.Printf("The ignore list changed since the scan of %s of %s, scanning it again", v.MountPoint, snapshot.SavedAt.Format(time.RFC3339))
+		return
+	}
+	if scanner.Restore(v.MountPoint, snapshot.Dirs) {
+		log.Printf("Restored the scan of %s from %s (last mounted at %s)", v.MountPoint, snapshot.SavedAt.Format(time.RFC3339), snapshot.MountPoint)
+	}
+}
+
+// saveVolumeScans saves the scans of the mounted volumes that finished or
+// changed since they were last saved
+func saveVolumeScans() {
+	volumeIO.Lock()
+	defer volumeIO.Unlock()
+	type pending struct {
+		mountPoint string
+		m          *mountedVolume
+		snapshot   volumeSnapshot
+		gen        uint64
+	}
+	var saves []pending
+	volumeScans.Lock()
+	for mountPoint, m := range volumeScans.mounted {
+		if m.uuid == "" {
+			continue
+		}
+		if gen := GlobalCache.Generation(mountPoint); gen != m.savedGen {
+			saves = append(saves, pending{mountPoint: mountPoint, m: m, snapshot: volumeSnapshot{UUID: m.uuid, MountPoint: mountPoint, Used: m.used}, gen: gen})
+		}
+	}
+	volumeScans.Unlock()
+
+	for _, p := range saves {
+		dirs := scanner.Snapshot(p.mountPoint)
+		if dirs == nil {
+			// not scanned as a whole, or still scanning
+			continue
+		}
+		p.snapshot.SavedAt, p.snapshot.Ignore, p.snapshot.Dirs = time.Now(), ignoreFingerprint(), dirs
+		if err := saveVolumeSnapshot(volumeSnapshotFile(p.m.uuid), &p.snapshot); err != nil {
+			log.Printf("Error saving the scan of %s: %v", p.mountPoint, err)
+			continue
+		}
+		volumeScans.Lock()
+		p.m.savedGen = p.gen
+		volumeScans.Unlock()
+	}
+}
+
+func loadVolumeSnapshot(file string) (*volumeSnapshot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot volumeSnapshot
+	if err := json.NewDecoder(gz).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// saveVolumeSnapshot writes a snapshot compressed, the file of a large
+// drive lists millions of files, through a temporary file so that an
+// interrupted save keeps the previous one
+func saveVolumeSnapshot(file string, snapshot *volumeSnapshot) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	err = json.NewEncoder(gz).Encode(snapshot)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
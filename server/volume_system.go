@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"disk-usage-analyser/scan"
+	"disk-usage-analyser/server/fsstat"
+)
+
+// unreadableSystemName is the item standing for the bytes of a volume
+// that its unreadable system directories are estimated to hold
+const unreadableSystemName = "« unreadable system data »"
+
+// volumeSystemDirs are directories the OS keeps at the root of each volume,
+// they are hidden and often only readable by root
+var volumeSystemDirs = map[string]string{
+	".Spotlight-V100":           "Spotlight index",
+	".fseventsd":                "File system events log",
+	".Trashes":                  "Trash",
+	".DocumentRevisions-V100":   "Document versions",
+	".TemporaryItems":           "Temporary items",
+	".MobileBackups":            "Local snapshots",
+	"lost+found":                "Recovered files",
+	"$RECYCLE.BIN":              "Recycle Bin",
+	"System Volume Information": "System Volume Information",
+}
+
+// isVolumeRoot reports whether path is the mount point of a volume:
+// the root, or a directory on another device than its parent
+func isVolumeRoot(path string) bool {
+	parent := filepath.Dir(path)
+	if parent == path {
+		return true
+	}
+	dirInfo, err1 := os.Lstat(scan.LocalPath(path))
+	parentInfo, err2 := os.Lstat(scan.LocalPath(parent))
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	dirStat, ok1 := fsstat.Of(dirInfo)
+	parentStat, ok2 := fsstat.Of(parentInfo)
+	return ok1 && ok2 && dirStat.Dev != 0 && dirStat.Dev != parentStat.Dev
+}
+
+// markVolumeSystemDir describes item if it is a system directory of the
+// volume rooted at dirPath, and flags it when it cannot be read
+func markVolumeSystemDir(item *FileInfo, dirPath string) {
+	item.SystemDir = volumeSystemDirs[item.Name]
+	if item.SystemDir == "" {
+		return
+	}
+	// readDir goes through the privileged helper when it runs
+	if _, err := readDir(filepath.Join(dirPath, item.Name)); errors.Is(err, fs.ErrPermission) {
+		item.Unreadable = true
+	}
+}
+
+// estimateUnreadable is the used space of the volume at dirPath not
+// accounted for by items, which unreadable system directories hold at most
+func estimateUnreadable(dirPath string, items []FileInfo) (int64, bool) {
+	total, free, err := volumeSpace(dirPath)
+	if err != nil {
+		return 0, false
+	}
+	used := int64(total - free)
+	for _, item := range items {
+		// used space is allocated space
+		if item.DiskSize > 0 {
+			used -= item.DiskSize
+		} else {
+			used -= item.Size
+		}
+	}
+	return used, used > 0
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+const (
+	launchdLabel = "com.xhd2015.disk-usage-analyser"
+	systemdUnit  = "disk-usage-analyser.service"
+)
+
+// Options configures the installed service
+type Options struct {
+	Host           string // host to listen on, e.g. "127.0.0.1"
+	Port           int
+	InitialDir     string
+	RescanInterval string // e.g. "6h", empty disables scheduled scans
+}
+
+// args are the command line arguments the service runs the executable with
+func (o Options) args() []string {
+	args := []string{"--host", o.Host, "--port", strconv.Itoa(o.Port), "--strict-port", "--no-open-browser"}
+	if o.RescanInterval != "" {
+		args = append(args, "--rescan-interval", o.RescanInterval)
+	}
+	if o.InitialDir != "" {
+		args = append(args, o.InitialDir)
+	}
+	return args
+}
+
+func Install(opts Options) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %v", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return fmt.Errorf("failed to resolve executable: %v", err)
+	}
+	file, content, err := unitFile(exe, opts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", file, err)
+	}
+	fmt.Printf("Wrote %s\n", file)
+
+	if runtime.GOOS == "linux" {
+		if err := cmd.Debug().Run("systemctl", "--user", "daemon-reload"); err != nil {
+			return err
+		}
+		return cmd.Debug().Run("systemctl", "--user", "enable", "--now", systemdUnit)
+	}
+	return Start()
+}
+
+func Uninstall() error {
+	file, err := unitPath()
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "linux" {
+		if err := cmd.Debug().Run("systemctl", "--user", "disable", "--now", systemdUnit); err != nil {
+			return err
+		}
+	} else {
+		// not loaded is fine
+		cmd.Debug().Run("launchctl", "bootout", launchdDomain()+"/"+launchdLabel)
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Printf("Removed %s\n", file)
+	return nil
+}
+
+func Start() error {
+	file, err := unitPath()
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "linux" {
+		return cmd.Debug().Run("systemctl", "--user", "start", systemdUnit)
+	}
+	return cmd.Debug().Run("launchctl", "bootstrap", launchdDomain(), file)
+}
+
+func Stop() error {
+	if _, err := unitPath(); err != nil {
+		return err
+	}
+	if runtime.GOOS == "linux" {
+		return cmd.Debug().Run("systemctl", "--user", "stop", systemdUnit)
+	}
+	return cmd.Debug().Run("launchctl", "bootout", launchdDomain()+"/"+launchdLabel)
+}
+
+func launchdDomain() string {
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+// unitPath returns the launchd plist or systemd user unit path
+func unitPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home dir: %v", err)
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(homeDir, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+	case "linux":
+		return filepath.Join(homeDir, ".config", "systemd", "user", systemdUnit), nil
+	default:
+		return "", fmt.Errorf("service mode is not supported on %s", runtime.GOOS)
+	}
+}
+
+// systemdQuote quotes arg for ExecStart, where % starts a specifier such
+// as %h and $ a variable, unless doubled
+func systemdQuote(arg string) string {
+	return strings.NewReplacer("%", "%%", "$", "$$").Replace(strconv.Quote(arg))
+}
+
+func unitFile(exe string, opts Options) (file string, content string, err error) {
+	file, err = unitPath()
+	if err != nil {
+		return "", "", err
+	}
+	args := append([]string{exe}, opts.args()...)
+	if runtime.GOOS == "linux" {
+		quoted := make([]string, 0, len(args))
+		for _, arg := range args {
+			quoted = append(quoted, systemdQuote(arg))
+		}
+		return file, fmt.Sprintf(`[Unit]
+Description=Disk Usage Analyser
+
+[Service]
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`, strings.Join(quoted, " ")), nil
+	}
+
+	var programArgs strings.Builder
+	for _, arg := range args {
+		fmt.Fprintf(&programArgs, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	logFile := filepath.Join(filepath.Dir(filepath.Dir(file)), "Logs", "disk-usage-analyser.log")
+	return file, fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, programArgs.String(), xmlEscape(logFile), xmlEscape(logFile)), nil
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
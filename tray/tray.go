@@ -0,0 +1,44 @@
+package tray
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+type Options struct {
+	URL        string // URL of the web UI
+	RescanRoot string // directory rescanned by the Rescan menu item
+	// AlertPercent notifies when a volume's free space drops below this percentage, 0 disables alerts
+	AlertPercent float64
+}
+
+// notify shows a desktop notification, errors are only logged
+func notify(title string, message string) {
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		err = cmd.Run("osascript", "-e", script)
+	case "linux":
+		err = cmd.Run("notify-send", title, message)
+	}
+	if err != nil {
+		log.Printf("Error sending notification: %v", err)
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
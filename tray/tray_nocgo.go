@@ -0,0 +1,14 @@
+//go:build !cgo && darwin
+
+package tray
+
+import "log"
+
+// Supported is false in builds without cgo on macOS, where the tray icon
+// cannot be built: CGO_ENABLED=0 builds the server without it
+const Supported = false
+
+// Run only logs that there is no tray icon
+func Run(opts Options) {
+	log.Printf("The tray icon is not available in this build, open %s", opts.URL)
+}
@@ -0,0 +1,97 @@
+//go:build cgo || !darwin
+
+// fyne.io/systray is pure Go on Linux and Windows, on macOS it needs cgo
+
+package tray
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"disk-usage-analyser/server"
+	"disk-usage-analyser/server/disk"
+
+	"fyne.io/systray"
+	"github.com/xhd2015/kool/pkgs/web"
+)
+
+const refreshInterval = time.Minute
+
+// Supported is whether the tray icon is built in, see tray_nocgo.go
+const Supported = true
+
+// Run shows the tray icon until Quit is clicked, it must be called from the main goroutine
+func Run(opts Options) {
+	systray.Run(func() { onReady(opts) }, func() {})
+}
+
+func onReady(opts Options) {
+	systray.SetTitle("Disk")
+	systray.SetTooltip("Disk Usage Analyser")
+
+	openItem := systray.AddMenuItem("Open Disk Usage Analyser", "Open the web UI")
+	rescanItem := systray.AddMenuItem("Rescan", "Rescan "+opts.RescanRoot)
+	systray.AddSeparator()
+	volumesItem := systray.AddMenuItem("Volumes", "Free space per volume")
+	systray.AddSeparator()
+	quitItem := systray.AddMenuItem("Quit", "Quit Disk Usage Analyser")
+
+	volumeItems := make(map[string]*systray.MenuItem)
+	alerted := make(map[string]bool)
+
+	refresh := func() {
+		volumes, err := disk.ListVolumes()
+		if err != nil {
+			log.Printf("Error listing volumes: %v", err)
+			return
+		}
+		for _, v := range volumes {
+			title := fmt.Sprintf("%s: %s free of %s", v.MountPoint, formatBytes(v.Available), formatBytes(v.Size))
+			item, ok := volumeItems[v.MountPoint]
+			if !ok {
+				item = volumesItem.AddSubMenuItem(title, v.Filesystem)
+				item.Disable()
+				volumeItems[v.MountPoint] = item
+			} else {
+				item.SetTitle(title)
+			}
+			if v.MountPoint == "/" {
+				systray.SetTitle(formatBytes(v.Available) + " free")
+			}
+
+			if opts.AlertPercent <= 0 || v.Size == 0 {
+				continue
+			}
+			freePercent := float64(v.Available) * 100 / float64(v.Size)
+			if freePercent < opts.AlertPercent {
+				// alert once per crossing
+				if !alerted[v.MountPoint] {
+					alerted[v.MountPoint] = true
+					notify("Low disk space", fmt.Sprintf("%s has only %s (%.1f%%) free", v.MountPoint, formatBytes(v.Available), freePercent))
+				}
+			} else {
+				alerted[v.MountPoint] = false
+			}
+		}
+	}
+	refresh()
+
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-openItem.ClickedCh:
+				web.OpenBrowser(opts.URL)
+			case <-rescanItem.ClickedCh:
+				server.Rescan(opts.RescanRoot)
+			case <-quitItem.ClickedCh:
+				systray.Quit()
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+}
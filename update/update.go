@@ -0,0 +1,279 @@
+// Package update replaces the running executable with a release from GitHub.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	repo = "xhd2015/disk-usage-analyser"
+	// checksumsAsset lists "<sha256>  <asset name>" for every binary of a release
+	checksumsAsset = "checksums.txt"
+	// signatureAsset is the base64 ed25519 signature of checksumsAsset
+	signatureAsset = checksumsAsset + ".sig"
+)
+
+// PublicKey is the base64 ed25519 key releases are signed with, built in
+// with -ldflags "-X disk-usage-analyser/update.PublicKey=...". A binary
+// built without it does not update itself: the checksums alone would only
+// tell that a download is what the release page offers, not that the
+// release is genuine.
+var PublicKey = ""
+
+var client = &http.Client{Timeout: 5 * time.Minute}
+
+// Options configures Run
+type Options struct {
+	// CurrentVersion is the version of the running binary, "dev" for local builds
+	CurrentVersion string
+	// Version is the release tag to install, the latest release when empty
+	Version string
+	// Check only reports whether an update is available
+	Check bool
+	// Force installs even when the version is current or a dev build
+	Force bool
+}
+
+type release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []asset `json:"assets"`
+}
+
+type asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// AssetName is the name of the release binary for goos/goarch
+func AssetName(goos string, goarch string) string {
+	name := fmt.Sprintf("disk-usage-analyser-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func Run(opts Options) error {
+	rel, err := fetchRelease(opts.Version)
+	if err != nil {
+		return err
+	}
+	if rel.TagName == opts.CurrentVersion && !opts.Force {
+		fmt.Printf("Already up to date: %s\n", rel.TagName)
+		return nil
+	}
+	if opts.Check {
+		fmt.Printf("Update available: %s -> %s\n", opts.CurrentVersion, rel.TagName)
+		return nil
+	}
+	if opts.CurrentVersion == "dev" && !opts.Force {
+		return fmt.Errorf("this is a development build, use --force to replace it with %s", rel.TagName)
+	}
+
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	binary := rel.find(name)
+	if binary == nil {
+		return fmt.Errorf("release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	key, err := publicKey()
+	if err != nil {
+		return err
+	}
+	checksums := rel.find(checksumsAsset)
+	signature := rel.find(signatureAsset)
+	if checksums == nil || signature == nil {
+		return fmt.Errorf("release %s has no signed %s, refusing to install an unverified binary", rel.TagName, checksumsAsset)
+	}
+	list, err := fetchChecksums(checksums.URL, signature.URL, key)
+	if err != nil {
+		return err
+	}
+	want, err := findChecksum(list, name)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %v", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return fmt.Errorf("failed to resolve executable: %v", err)
+	}
+
+	fmt.Printf("Downloading %s %s\n", name, rel.TagName)
+	// download next to the executable, so that the rename stays on one file system
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".disk-usage-analyser-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	got, err := download(binary.URL, tmp)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	if err := replace(exe, tmp.Name()); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s to %s\n", exe, rel.TagName)
+	return nil
+}
+
+func (r *release) find(name string) *asset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+func fetchRelease(version string) (*release, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
+	if version != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", repo, version)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check releases: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to check releases: %s", resp.Status)
+	}
+	var rel release
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("invalid release: %v", err)
+	}
+	return &rel, nil
+}
+
+func publicKey() (ed25519.PublicKey, error) {
+	if PublicKey == "" {
+		return nil, fmt.Errorf("this build has no release signing key, download the release from https://github.com/%s/releases instead", repo)
+	}
+	key, err := base64.StdEncoding.DecodeString(PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid release signing key of this build")
+	}
+	return key, nil
+}
+
+// fetchChecksums downloads the checksums of a release and checks their
+// signature against key
+func fetchChecksums(url string, sigURL string, key ed25519.PublicKey) ([]byte, error) {
+	list, err := fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := fetch(sigURL)
+	if err != nil {
+		return nil, err
+	}
+	sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", signatureAsset, err)
+	}
+	if !ed25519.Verify(key, list, sig) {
+		return nil, fmt.Errorf("%s is not signed by the release key, refusing to install", checksumsAsset)
+	}
+	return list, nil
+}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	// checksums and signatures are a few lines
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	return b, nil
+}
+
+// findChecksum finds the sha256 of name in a sha256sum style list
+func findChecksum(list []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// binary mode entries are prefixed with *
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", checksumsAsset, name)
+}
+
+// download writes url to w and returns its sha256
+func download(url string, w io.Writer) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), resp.Body); err != nil {
+		return "", fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replace moves file over exe. A running executable cannot be
+// overwritten on windows, but it can be renamed out of the way.
+func replace(exe string, file string) error {
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return fmt.Errorf("failed to move %s: %v", exe, err)
+		}
+		if err := os.Rename(file, exe); err != nil {
+			os.Rename(old, exe)
+			return fmt.Errorf("failed to replace %s: %v", exe, err)
+		}
+		return nil
+	}
+	if err := os.Rename(file, exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", exe, err)
+	}
+	return nil
+}